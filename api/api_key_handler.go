@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	"gopkg.in/pg.v5/types"
+)
+
+// ListAPIKeysHandler is the method called when a get to /apikeys is called
+func (a *Application) ListAPIKeysHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "apiKeyHandler"),
+		zap.String("operation", "listAPIKeys"),
+	)
+	apiKeys := []model.APIKey{}
+	err := WithSegment("db-select", c, func() error {
+		return a.DB.Model(&apiKeys).Order("created_at DESC").Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list api keys.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, apiKeys)
+}
+
+// CreateAPIKeyHandler is the method called when a post to /apikeys is called,
+// the key is only returned in this response
+func (a *Application) CreateAPIKeyHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "apiKeyHandler"),
+		zap.String("operation", "createAPIKey"),
+	)
+	apiKey := &model.APIKey{
+		ID:        uuid.NewV4(),
+		CreatedBy: c.Get("user-email").(string),
+		CreatedAt: time.Now().UnixNano(),
+	}
+	err := WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, apiKey)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: apiKey})
+	}
+	err = apiKey.Generate()
+	if err == nil {
+		err = WithSegment("db-insert", c, func() error {
+			return a.DB.Insert(apiKey)
+		})
+	}
+	if err != nil {
+		log.E(l, "Failed to create api key.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.I(l, "Created api key successfully.", func(cm log.CM) {
+		cm.Write(zap.String("id", apiKey.ID.String()), zap.String("email", apiKey.Email))
+	})
+	return c.JSON(http.StatusCreated, apiKey)
+}
+
+// RevokeAPIKeyHandler is the method called when a delete to /apikeys/:kid is called,
+// revoked keys are kept so their use can still be audited
+func (a *Application) RevokeAPIKeyHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "apiKeyHandler"),
+		zap.String("operation", "revokeAPIKey"),
+		zap.String("apiKeyId", c.Param("kid")),
+	)
+	id, err := uuid.FromString(c.Param("kid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	var res *types.Result
+	err = WithSegment("db-update", c, func() error {
+		res, err = a.DB.Exec(
+			"UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+			time.Now().UnixNano(),
+			id,
+		)
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to revoke api key.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	if res.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	log.I(l, "Revoked api key successfully.")
+	return c.JSON(http.StatusNoContent, "")
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
 )
@@ -75,6 +76,9 @@ func (a *Application) PostAppHandler(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: app})
 	}
+	if app.MessageFormat == "" {
+		app.MessageFormat = messages.FormatLegacy
+	}
 	err = WithSegment("db-insert", c, func() error {
 		return a.DB.Insert(&app)
 	})
@@ -141,13 +145,16 @@ func (a *Application) PutAppHandler(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: app})
 	}
+	if app.MessageFormat == "" {
+		app.MessageFormat = messages.FormatLegacy
+	}
 	id, err := uuid.FromString(c.Param("aid"))
 	if err != nil {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
 	}
 	app.ID = id
 	err = WithSegment("db-update", c, func() error {
-		_, err = a.DB.Model(&app).Column("name").Column("bundle_id").Column("updated_at").Returning("*").Update()
+		_, err = a.DB.Model(&app).Column("name").Column("bundle_id").Column("message_format").Column("default_locale").Column("locale_fallbacks").Column("updated_at").Returning("*").Update()
 		return err
 	})
 	if err != nil {
@@ -138,6 +138,66 @@ var _ = Describe("App Handler", func() {
 				Expect(dbApp.BundleID).To(Equal(payload["bundleId"]))
 				Expect(dbApp.CreatedBy).To(Equal("test@test.com"))
 			})
+
+			It("should return 201 and the app with the legacy message format by default", func() {
+				payload := GetAppPayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, "/apps", string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["messageFormat"]).To(Equal("legacy"))
+			})
+
+			It("should return 201 and the app with the normalized locales", func() {
+				payload := GetAppPayload()
+				payload["defaultLocale"] = "PT_br"
+				payload["localeFallbacks"] = map[string]interface{}{
+					"pt_BR": []string{"PT", "es"},
+				}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, "/apps", string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var response model.App
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.DefaultLocale).To(Equal("pt-br"))
+				Expect(response.LocaleFallbacks).To(Equal(model.LocaleFallbacks{"pt-br": {"pt", "es"}}))
+
+				dbApp := &model.App{ID: response.ID}
+				err = app.DB.Select(dbApp)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbApp.DefaultLocale).To(Equal("pt-br"))
+				Expect(dbApp.LocaleFallbacks).To(Equal(response.LocaleFallbacks))
+			})
+
+			It("should return 201 and the app with the en default locale by default", func() {
+				payload := GetAppPayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, "/apps", string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["defaultLocale"]).To(Equal("en"))
+			})
+
+			It("should return 201 and the app with the v1 message format", func() {
+				payload := GetAppPayload()
+				payload["messageFormat"] = "v1"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, "/apps", string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["messageFormat"]).To(Equal("v1"))
+			})
 		})
 
 		Describe("Unsuccessfully", func() {
@@ -222,6 +282,19 @@ var _ = Describe("App Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(response["reason"]).To(Equal("invalid bundleId"))
 			})
+
+			It("should return 422 if invalid messageFormat", func() {
+				payload := GetAppPayload()
+				payload["messageFormat"] = "v2"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, "/apps", string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid messageFormat"))
+			})
 		})
 	})
 
@@ -246,9 +319,9 @@ var _ = Describe("App Handler", func() {
 				Expect(response["updatedAt"]).ToNot(Equal(0))
 			})
 
-			It("should return 200 and the requested app if not admin but allowed", func() {
+			It("should return 200 and the requested app if not admin but viewer of the app", func() {
 				existingApp := CreateTestApp(app.DB)
-				CreateTestUser(app.DB, map[string]interface{}{"email": "test2@test.com", "isAdmin": false, "allowedApps": []uuid.UUID{existingApp.ID}})
+				CreateTestUser(app.DB, map[string]interface{}{"email": "test2@test.com", "isAdmin": false, "roles": model.AppRoles{existingApp.ID.String(): []string{model.RoleViewer}}})
 				status, body := Get(app, fmt.Sprintf("/apps/%s", existingApp.ID), "test2@test.com")
 				Expect(status).To(Equal(http.StatusOK))
 
@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// auditMaxResponseSize is the biggest response body stored as the state after a request
+const auditMaxResponseSize = 64 * 1024
+
+// auditResources maps the route collections to the audited resource types and their models
+var auditResources = map[string]struct {
+	Type  string
+	Model func(id uuid.UUID) interface{}
+}{
+	"apps":      {"app", func(id uuid.UUID) interface{} { return &model.App{ID: id} }},
+	"templates": {"template", func(id uuid.UUID) interface{} { return &model.Template{ID: id} }},
+	"jobs":      {"job", func(id uuid.UUID) interface{} { return &model.Job{ID: id} }},
+	"users":     {"user", func(id uuid.UUID) interface{} { return &model.User{ID: id} }},
+	"apikeys":   {"apikey", func(id uuid.UUID) interface{} { return &model.APIKey{ID: id} }},
+	"webhooks":  {"webhook", func(id uuid.UUID) interface{} { return &model.Webhook{ID: id} }},
+}
+
+// auditResource returns the collection of the resource changed by the route and the name of
+// its id param, the resource is the last collection in the route, /apps/:aid/jobs/:jid/stop
+// changes a job and /apps/:aid/templates creates a template
+func auditResource(route string) (string, string) {
+	collection := ""
+	param := ""
+	segments := strings.Split(strings.Trim(route, "/"), "/")
+	for i, segment := range segments {
+		if _, ok := auditResources[segment]; !ok {
+			continue
+		}
+		collection = segment
+		param = ""
+		if i+1 < len(segments) && strings.HasPrefix(segments[i+1], ":") {
+			param = segments[i+1][1:]
+		}
+	}
+	return collection, param
+}
+
+// auditResponseWriter keeps a copy of the response body to store it as the state after the request
+type auditResponseWriter struct {
+	http.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if !w.truncated && w.body.Len()+len(b) <= auditMaxResponseSize {
+		w.body.Write(b)
+	} else {
+		w.truncated = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// AuditMiddleware records the actor, route, resource and its changes of every mutating request
+type AuditMiddleware struct {
+	App *Application
+}
+
+// NewAuditMiddleware returns a configured audit middleware
+func NewAuditMiddleware(app *Application) *AuditMiddleware {
+	return &AuditMiddleware{
+		App: app,
+	}
+}
+
+// Serve serves the middleware
+func (a *AuditMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		method := c.Request().Method
+		if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+			return next(c)
+		}
+
+		auditLog := &model.AuditLog{
+			ID:     uuid.NewV4(),
+			Method: method,
+			Route:  c.Path(),
+			Path:   c.Request().URL.Path,
+		}
+		collection, param := auditResource(c.Path())
+		if collection != "" {
+			auditLog.ResourceType = auditResources[collection].Type
+		}
+		if param != "" {
+			auditLog.ResourceID = c.Param(param)
+			if id, err := uuid.FromString(auditLog.ResourceID); err == nil {
+				resource := auditResources[collection].Model(id)
+				if err := a.App.DB.Select(resource); err == nil {
+					auditLog.Before = model.AuditState(resource)
+				}
+			}
+		}
+		if aid, err := uuid.FromString(c.Param("aid")); err == nil {
+			auditLog.AppID = aid
+		}
+
+		writer := &auditResponseWriter{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = writer
+		err := next(c)
+		c.Response().Writer = writer.ResponseWriter
+
+		auditLog.Status = c.Response().Status
+		auditLog.CreatedAt = time.Now().UnixNano()
+		if email, ok := c.Get("user-email").(string); ok {
+			auditLog.Actor = email
+		}
+		if auditLog.Status < http.StatusBadRequest {
+			if method != http.MethodDelete && !writer.truncated {
+				auditLog.After = model.AuditStateFromJSON(writer.body.Bytes())
+			}
+			if auditLog.ResourceID == "" && auditLog.After != nil {
+				if id, ok := auditLog.After["id"].(string); ok {
+					auditLog.ResourceID = id
+				}
+			}
+			auditLog.SetChanges()
+		}
+
+		if _, insertErr := a.App.DB.Model(auditLog).Insert(); insertErr != nil {
+			log.E(a.App.Logger, "Failed to record audit log.", func(cm log.CM) {
+				cm.Write(zap.String("route", auditLog.Route), zap.Error(insertErr))
+			})
+		}
+		return err
+	}
+}
+
+// ListAuditLogsHandler is the method called when a get to /audit is called,
+// the logs are filtered by the query params and returned newest first
+func (a *Application) ListAuditLogsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "auditHandler"),
+		zap.String("operation", "listAuditLogs"),
+	)
+	page, err := intQueryParam(c, "page", 1)
+	if err != nil || page < 1 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "page must be a positive integer"})
+	}
+	perPage, err := intQueryParam(c, "perPage", 50)
+	if err != nil || perPage < 1 || perPage > 500 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "perPage must be an integer between 1 and 500"})
+	}
+
+	auditLogs := []model.AuditLog{}
+	query := a.DB.Model(&auditLogs)
+	for param, column := range map[string]string{
+		"actor":        "actor",
+		"method":       "method",
+		"route":        "route",
+		"resourceType": "resource_type",
+		"resourceId":   "resource_id",
+	} {
+		if value := c.QueryParam(param); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if value := c.QueryParam("appId"); value != "" {
+		aid, err := uuid.FromString(value)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+		}
+		query = query.Where("app_id = ?", aid)
+	}
+	for param, operator := range map[string]string{"from": ">=", "to": "<"} {
+		value, err := intQueryParam(c, param, 0)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: param + " must be a timestamp in nanoseconds"})
+		}
+		if value > 0 {
+			query = query.Where("created_at "+operator+" ?", value)
+		}
+	}
+
+	var total int
+	err = WithSegment("db-select", c, func() error {
+		var err error
+		total, err = query.Order("created_at DESC").Limit(int(perPage)).Offset(int((page - 1) * perPage)).SelectAndCount()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to list audit logs.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"auditLogs": auditLogs,
+		"page":      page,
+		"perPage":   perPage,
+		"total":     total,
+	})
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Audit", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var existingApp *model.App
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		app.DB.Exec("DELETE FROM audit_logs;")
+		CreateTestUser(app.DB, map[string]interface{}{"email": "test@test.com", "isAdmin": true})
+		existingApp = CreateTestApp(app.DB)
+	})
+
+	listAuditLogs := func(query string) map[string]interface{} {
+		status, body := Get(app, fmt.Sprintf("/audit%s", query), "test@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+		var response map[string]interface{}
+		err := json.Unmarshal([]byte(body), &response)
+		Expect(err).NotTo(HaveOccurred())
+		return response
+	}
+
+	auditLogs := func(query string) []interface{} {
+		return listAuditLogs(query)["auditLogs"].([]interface{})
+	}
+
+	changedPaths := func(auditLog map[string]interface{}) []string {
+		paths := []string{}
+		for _, change := range auditLog["changes"].([]interface{}) {
+			paths = append(paths, change.(map[string]interface{})["path"].(string))
+		}
+		return paths
+	}
+
+	Describe("Middleware", func() {
+		It("should record the creation of a resource with its id and state after the request", func() {
+			pl, _ := json.Marshal(GetTemplatePayload())
+			status, body := Post(app, fmt.Sprintf("/apps/%s/templates", existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+			var template map[string]interface{}
+			err := json.Unmarshal([]byte(body), &template)
+			Expect(err).NotTo(HaveOccurred())
+
+			logs := auditLogs("")
+			Expect(logs).To(HaveLen(1))
+			auditLog := logs[0].(map[string]interface{})
+			Expect(auditLog["actor"]).To(Equal("test@test.com"))
+			Expect(auditLog["method"]).To(Equal("POST"))
+			Expect(auditLog["route"]).To(Equal("/apps/:aid/templates"))
+			Expect(auditLog["path"]).To(Equal(fmt.Sprintf("/apps/%s/templates", existingApp.ID)))
+			Expect(auditLog["status"]).To(BeEquivalentTo(http.StatusCreated))
+			Expect(auditLog["resourceType"]).To(Equal("template"))
+			Expect(auditLog["resourceId"]).To(Equal(template["id"]))
+			Expect(auditLog["appId"]).To(Equal(existingApp.ID.String()))
+			Expect(auditLog["before"]).To(BeNil())
+			Expect(auditLog["after"].(map[string]interface{})["name"]).To(Equal(template["name"]))
+			Expect(changedPaths(auditLog)).To(ContainElement("name"))
+			Expect(auditLog["createdAt"]).NotTo(BeZero())
+		})
+
+		It("should record the state before and after an update and their changes", func() {
+			payload := GetAppPayload()
+			pl, _ := json.Marshal(payload)
+			status, _ := Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			logs := auditLogs("")
+			Expect(logs).To(HaveLen(1))
+			auditLog := logs[0].(map[string]interface{})
+			Expect(auditLog["resourceType"]).To(Equal("app"))
+			Expect(auditLog["resourceId"]).To(Equal(existingApp.ID.String()))
+			Expect(auditLog["before"].(map[string]interface{})["name"]).To(Equal(existingApp.Name))
+			Expect(auditLog["after"].(map[string]interface{})["name"]).To(Equal(payload["name"]))
+			Expect(changedPaths(auditLog)).To(ContainElement("name"))
+			Expect(changedPaths(auditLog)).To(ContainElement("bundleId"))
+			Expect(changedPaths(auditLog)).NotTo(ContainElement("createdBy"))
+		})
+
+		It("should record the state before a deletion", func() {
+			template := CreateTestTemplate(app.DB, existingApp.ID)
+			status, _ := Delete(app, fmt.Sprintf("/apps/%s/templates/%s", existingApp.ID, template.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+
+			logs := auditLogs("")
+			Expect(logs).To(HaveLen(1))
+			auditLog := logs[0].(map[string]interface{})
+			Expect(auditLog["resourceType"]).To(Equal("template"))
+			Expect(auditLog["resourceId"]).To(Equal(template.ID.String()))
+			Expect(auditLog["before"].(map[string]interface{})["name"]).To(Equal(template.Name))
+			Expect(auditLog["after"]).To(BeNil())
+		})
+
+		It("should record the sub-resource actions on the resource", func() {
+			template := CreateTestTemplate(app.DB, existingApp.ID)
+			job := CreateTestJob(app.DB, existingApp.ID, template.Name)
+			status, _ := Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, job.ID), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			logs := auditLogs("?resourceType=job")
+			Expect(logs).To(HaveLen(1))
+			auditLog := logs[0].(map[string]interface{})
+			Expect(auditLog["route"]).To(Equal("/apps/:aid/jobs/:jid/stop"))
+			Expect(auditLog["resourceId"]).To(Equal(job.ID.String()))
+			Expect(changedPaths(auditLog)).To(ContainElement("status"))
+		})
+
+		It("should record failed requests without their state after", func() {
+			status, _ := Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), "{}", "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+			logs := auditLogs("")
+			Expect(logs).To(HaveLen(1))
+			auditLog := logs[0].(map[string]interface{})
+			Expect(auditLog["status"]).To(BeEquivalentTo(http.StatusUnprocessableEntity))
+			Expect(auditLog["after"]).To(BeNil())
+			Expect(auditLog["changes"]).To(BeNil())
+		})
+
+		It("should not record requests that do not change data", func() {
+			status, _ := Get(app, fmt.Sprintf("/apps/%s", existingApp.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(auditLogs("")).To(BeEmpty())
+		})
+
+		It("should redact the plain api keys", func() {
+			pl, _ := json.Marshal(map[string]interface{}{"name": "scheduler", "email": "test@test.com"})
+			status, body := Post(app, "/apikeys", string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+			var apiKey map[string]interface{}
+			err := json.Unmarshal([]byte(body), &apiKey)
+			Expect(err).NotTo(HaveOccurred())
+
+			logs := auditLogs("?resourceType=apikey")
+			Expect(logs).To(HaveLen(1))
+			auditLog := logs[0].(map[string]interface{})
+			Expect(auditLog["resourceId"]).To(Equal(apiKey["id"]))
+			Expect(auditLog["after"].(map[string]interface{})["key"]).To(Equal(model.AuditRedacted))
+			Expect(body).NotTo(ContainSubstring(model.AuditRedacted))
+		})
+	})
+
+	Describe("Get /audit", func() {
+		BeforeEach(func() {
+			CreateTestUser(app.DB, map[string]interface{}{"email": "other@test.com", "isAdmin": true})
+			for i := 0; i < 3; i++ {
+				pl, _ := json.Marshal(GetTemplatePayload())
+				status, _ := Post(app, fmt.Sprintf("/apps/%s/templates", existingApp.ID), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+			}
+			pl, _ := json.Marshal(GetAppPayload())
+			status, _ := Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), string(pl), "other@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		It("should return the audit logs newest first", func() {
+			response := listAuditLogs("")
+			Expect(response["total"]).To(BeEquivalentTo(4))
+			logs := response["auditLogs"].([]interface{})
+			Expect(logs).To(HaveLen(4))
+			Expect(logs[0].(map[string]interface{})["actor"]).To(Equal("other@test.com"))
+		})
+
+		It("should filter the audit logs", func() {
+			Expect(auditLogs("?actor=test@test.com")).To(HaveLen(3))
+			Expect(auditLogs("?resourceType=app")).To(HaveLen(1))
+			Expect(auditLogs(fmt.Sprintf("?resourceId=%s", existingApp.ID))).To(HaveLen(1))
+			Expect(auditLogs(fmt.Sprintf("?appId=%s&method=POST", existingApp.ID))).To(HaveLen(3))
+			Expect(auditLogs("?route=/apps/:aid/templates")).To(HaveLen(3))
+
+			first := auditLogs("")[3].(map[string]interface{})
+			Expect(auditLogs(fmt.Sprintf("?to=%d", int64(first["createdAt"].(float64))+1))).To(HaveLen(1))
+			Expect(auditLogs(fmt.Sprintf("?from=%d", int64(first["createdAt"].(float64))+1))).To(HaveLen(3))
+		})
+
+		It("should paginate the audit logs", func() {
+			response := listAuditLogs("?perPage=3&page=2")
+			Expect(response["total"]).To(BeEquivalentTo(4))
+			Expect(response["page"]).To(BeEquivalentTo(2))
+			Expect(response["perPage"]).To(BeEquivalentTo(3))
+			Expect(response["auditLogs"]).To(HaveLen(1))
+		})
+
+		It("should return 422 if the pagination is invalid", func() {
+			status, _ := Get(app, "/audit?perPage=1000", "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			status, _ = Get(app, "/audit?page=0", "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should return 403 if the user is not admin", func() {
+			CreateTestUser(app.DB, map[string]interface{}{"email": "user@test.com", "isAdmin": false})
+			status, _ := Get(app, "/audit", "user@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+	})
+})
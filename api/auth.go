@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// ErrNoCredentials is returned by an authenticator when the request has no credentials for it
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator identifies the user that sent a request
+type Authenticator interface {
+	// Authenticate returns the email of the user that sent the request, ErrNoCredentials if the
+	// request has no credentials for the authenticator or another error if they are not valid
+	Authenticate(c echo.Context) (string, error)
+}
+
+// AuthenticatorBuilder builds an authenticator with the application configuration
+type AuthenticatorBuilder func(a *Application) (Authenticator, error)
+
+var (
+	authenticatorsMutex sync.RWMutex
+	authenticators      = map[string]AuthenticatorBuilder{}
+)
+
+func init() {
+	RegisterAuthenticator("header", newHeaderAuthenticator)
+	RegisterAuthenticator("apikey", newAPIKeyAuthenticator)
+	RegisterAuthenticator("jwt", newJWTAuthenticator)
+}
+
+// RegisterAuthenticator registers the builder of the authenticator selected by name in
+// api.auth.authenticators, registering an existing name replaces its builder
+func RegisterAuthenticator(name string, builder AuthenticatorBuilder) {
+	authenticatorsMutex.Lock()
+	defer authenticatorsMutex.Unlock()
+	authenticators[name] = builder
+}
+
+func (a *Application) configureAuthenticators() error {
+	a.Config.SetDefault("api.auth.authenticators", []string{"header"})
+	names := a.Config.GetStringSlice("api.auth.authenticators")
+	if len(names) == 0 {
+		return fmt.Errorf("api.auth.authenticators must have at least one authenticator")
+	}
+
+	authenticatorsMutex.RLock()
+	defer authenticatorsMutex.RUnlock()
+	a.Authenticators = make([]Authenticator, len(names))
+	for i, name := range names {
+		builder, ok := authenticators[name]
+		if !ok {
+			return fmt.Errorf("there is no authenticator registered with name %s", name)
+		}
+		authenticator, err := builder(a)
+		if err != nil {
+			return fmt.Errorf("cannot configure %s authenticator: %s", name, err.Error())
+		}
+		a.Authenticators[i] = authenticator
+	}
+	log.I(a.Logger, "Configured authenticators.", func(cm log.CM) {
+		cm.Write(zap.String("authenticators", strings.Join(names, ",")))
+	})
+	return nil
+}
+
+// authenticate returns the email given by the first authenticator that finds credentials in the request
+func (a *Application) authenticate(c echo.Context) (string, error) {
+	for _, authenticator := range a.Authenticators {
+		email, err := authenticator.Authenticate(c)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return email, err
+	}
+	return "", ErrNoCredentials
+}
+
+// authenticatedUser authenticates the request and returns its user, or writes the error response and returns nil
+func (a *Application) authenticatedUser(c echo.Context) (*model.User, error) {
+	email, err := a.authenticate(c)
+	if err != nil {
+		if err != ErrNoCredentials {
+			log.W(a.Logger, "Invalid credentials.", func(cm log.CM) {
+				cm.Write(zap.String("path", c.Path()), zap.Error(err))
+			})
+		}
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
+	}
+	c.Set("user-email", email)
+	user := &model.User{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&user).Column("*").Where("email = ?", email).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
+		}
+		return nil, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return user, nil
+}
+
+// HeaderAuthenticator trusts the email in a header set by an authenticating proxy,
+// it must only be used when the api cannot be reached without the proxy
+type HeaderAuthenticator struct {
+	Header string
+}
+
+func newHeaderAuthenticator(a *Application) (Authenticator, error) {
+	a.Config.SetDefault("api.auth.header.name", "x-forwarded-email")
+	return &HeaderAuthenticator{Header: a.Config.GetString("api.auth.header.name")}, nil
+}
+
+// Authenticate returns the email in the header
+func (h *HeaderAuthenticator) Authenticate(c echo.Context) (string, error) {
+	email := c.Request().Header.Get(h.Header)
+	if email == "" {
+		return "", ErrNoCredentials
+	}
+	return email, nil
+}
+
+// APIKeyHeader is the header with the api key of a request
+const APIKeyHeader = "x-api-key"
+
+// APIKeyAuthenticator authenticates requests with the api keys stored hashed in the database
+type APIKeyAuthenticator struct {
+	App *Application
+}
+
+func newAPIKeyAuthenticator(a *Application) (Authenticator, error) {
+	return &APIKeyAuthenticator{App: a}, nil
+}
+
+// Authenticate returns the email of the api key in the request, revoked keys are not valid
+func (k *APIKeyAuthenticator) Authenticate(c echo.Context) (string, error) {
+	key := c.Request().Header.Get(APIKeyHeader)
+	if key == "" {
+		return "", ErrNoCredentials
+	}
+	apiKey := &model.APIKey{}
+	err := WithSegment("db-select", c, func() error {
+		return k.App.DB.Model(apiKey).Column("api_key.*").Where(
+			"api_key.key_hash = ? AND api_key.revoked_at IS NULL",
+			model.HashAPIKey(key),
+		).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return "", fmt.Errorf("invalid api key")
+		}
+		return "", err
+	}
+	_, err = k.App.DB.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", time.Now().UnixNano(), apiKey.ID)
+	if err != nil {
+		log.W(k.App.Logger, "Failed to update api key last use.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+	}
+	return apiKey.Email, nil
+}
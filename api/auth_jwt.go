@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+// JWTAuthenticator authenticates requests with signed JWT/OIDC bearer tokens, the signature
+// is verified with the keys of a local JWKS file and the user is the email claim of the token.
+// Leeway is the clock skew allowed when checking the exp, nbf and iat claims
+type JWTAuthenticator struct {
+	Keys       map[string]interface{}
+	Issuer     string
+	Audience   string
+	EmailClaim string
+	Leeway     time.Duration
+	parser     *jwt.Parser
+}
+
+// JWK is a key of a JWKS file, only RSA and EC public keys are supported
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWTAuthenticator(a *Application) (Authenticator, error) {
+	a.Config.SetDefault("api.auth.jwt.emailClaim", "email")
+	a.Config.SetDefault("api.auth.jwt.leeway", "30s")
+	keys, err := LoadJWKS(a.Config.GetString("api.auth.jwt.jwksFile"))
+	if err != nil {
+		return nil, err
+	}
+	j := NewJWTAuthenticator(
+		keys,
+		a.Config.GetString("api.auth.jwt.issuer"),
+		a.Config.GetString("api.auth.jwt.audience"),
+		a.Config.GetString("api.auth.jwt.emailClaim"),
+	)
+	j.Leeway = a.Config.GetDuration("api.auth.jwt.leeway")
+	return j, nil
+}
+
+// NewJWTAuthenticator returns a JWT authenticator with the keys by kid, issuer and audience are only verified if set
+func NewJWTAuthenticator(keys map[string]interface{}, issuer, audience, emailClaim string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		Keys:       keys,
+		Issuer:     issuer,
+		Audience:   audience,
+		EmailClaim: emailClaim,
+		parser: &jwt.Parser{
+			ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+			// the time claims are verified by Authenticate, with the leeway and requiring exp
+			SkipClaimsValidation: true,
+		},
+	}
+}
+
+// LoadJWKS returns the public keys by kid of the JWKS file in path
+func LoadJWKS(path string) (map[string]interface{}, error) {
+	if path == "" {
+		return nil, fmt.Errorf("api.auth.jwt.jwksFile must be set")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid jwks file: %s", err.Error())
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %s", jwk.Kid, err.Error())
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks file %s has no signing keys", path)
+	}
+	return keys, nil
+}
+
+// PublicKey returns the RSA or EC public key of the JWK
+func (k *JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Authenticate returns the email claim of the bearer token in the authorization header
+func (j *JWTAuthenticator) Authenticate(c echo.Context) (string, error) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", ErrNoCredentials
+	}
+	claims := jwt.MapClaims{}
+	_, err := j.parser.ParseWithClaims(strings.TrimPrefix(header, "Bearer "), claims, j.key)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	leeway := int64(j.Leeway / time.Second)
+	if !claims.VerifyExpiresAt(now-leeway, true) {
+		return "", fmt.Errorf("token is expired or has no exp claim")
+	}
+	if !claims.VerifyNotBefore(now+leeway, false) {
+		return "", fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now+leeway, false) {
+		return "", fmt.Errorf("token was issued in the future")
+	}
+	if j.Issuer != "" && !claims.VerifyIssuer(j.Issuer, true) {
+		return "", fmt.Errorf("invalid issuer")
+	}
+	if j.Audience != "" && !hasAudience(claims["aud"], j.Audience) {
+		return "", fmt.Errorf("invalid audience")
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return "", fmt.Errorf("email is not verified")
+	}
+	email, _ := claims[j.EmailClaim].(string)
+	if email == "" {
+		return "", fmt.Errorf("token has no %s claim", j.EmailClaim)
+	}
+	return email, nil
+}
+
+// key returns the key of the kid of the token, or the only key if the token has no kid
+func (j *JWTAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(j.Keys) == 1 {
+		for _, key := range j.Keys {
+			return key, nil
+		}
+	}
+	key, ok := j.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	return key, nil
+}
+
+// hasAudience returns true if the aud claim, a string or a list of strings, has the audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
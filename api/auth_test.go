@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/api"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+func encodeJWKInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+var _ = Describe("Authentication", func() {
+	var rsaKey *rsa.PrivateKey
+	var ecKey *ecdsa.PrivateKey
+	var jwksPath string
+	var authenticator *api.JWTAuthenticator
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		jwks := map[string]interface{}{
+			"keys": []map[string]interface{}{
+				{
+					"kid": "rsa-key",
+					"kty": "RSA",
+					"use": "sig",
+					"n":   encodeJWKInt(rsaKey.N),
+					"e":   encodeJWKInt(big.NewInt(int64(rsaKey.E))),
+				},
+				{
+					"kid": "ec-key",
+					"kty": "EC",
+					"crv": "P-256",
+					"x":   encodeJWKInt(ecKey.X),
+					"y":   encodeJWKInt(ecKey.Y),
+				},
+			},
+		}
+		dir, err := ioutil.TempDir("", "marathon-jwks")
+		Expect(err).NotTo(HaveOccurred())
+		jwksPath = filepath.Join(dir, "jwks.json")
+		b, err := json.Marshal(jwks)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(jwksPath, b, 0600)).To(Succeed())
+
+		keys, err := api.LoadJWKS(jwksPath)
+		Expect(err).NotTo(HaveOccurred())
+		authenticator = api.NewJWTAuthenticator(keys, "https://auth.example.com", "marathon", "email")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(filepath.Dir(jwksPath))
+	})
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		Expect(err).NotTo(HaveOccurred())
+		return signed
+	}
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   "https://auth.example.com",
+			"aud":   "marathon",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"email": "jwt@test.com",
+		}
+	}
+
+	authenticate := func(authorization string) (string, error) {
+		req := httptest.NewRequest("GET", "/apps", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		return authenticator.Authenticate(c)
+	}
+
+	Describe("JWT authenticator", func() {
+		It("should return the email of a token signed with a rsa key", func() {
+			email, err := authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, validClaims()))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(email).To(Equal("jwt@test.com"))
+		})
+
+		It("should return the email of a token signed with an ec key and a list of audiences", func() {
+			claims := validClaims()
+			claims["aud"] = []string{"other", "marathon"}
+			email, err := authenticate("Bearer " + sign(jwt.SigningMethodES256, "ec-key", ecKey, claims))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(email).To(Equal("jwt@test.com"))
+		})
+
+		It("should return ErrNoCredentials if there is no bearer token", func() {
+			_, err := authenticate("")
+			Expect(err).To(Equal(api.ErrNoCredentials))
+			_, err = authenticate("Basic dXNlcjpwYXNz")
+			Expect(err).To(Equal(api.ErrNoCredentials))
+		})
+
+		It("should return error if the token expired", func() {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			_, err := authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if the token has no exp claim", func() {
+			claims := validClaims()
+			delete(claims, "exp")
+			_, err := authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should allow the leeway of clock skew in the exp, nbf and iat claims", func() {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+			claims["nbf"] = time.Now().Add(10 * time.Second).Unix()
+			claims["iat"] = time.Now().Add(10 * time.Second).Unix()
+			token := "Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims)
+			_, err := authenticate(token)
+			Expect(err).To(HaveOccurred())
+
+			authenticator.Leeway = 30 * time.Second
+			email, err := authenticate(token)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(email).To(Equal("jwt@test.com"))
+		})
+
+		It("should return error if the token is signed with another key", func() {
+			otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", otherKey, validClaims()))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if the kid is unknown", func() {
+			_, err := authenticate("Bearer " + sign(jwt.SigningMethodRS256, "other-key", rsaKey, validClaims()))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unknown key other-key"))
+		})
+
+		It("should return error if the token is signed with a shared secret", func() {
+			_, err := authenticate("Bearer " + sign(jwt.SigningMethodHS256, "rsa-key", []byte("secret"), validClaims()))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if the issuer or the audience are not valid", func() {
+			claims := validClaims()
+			claims["iss"] = "https://other.example.com"
+			_, err := authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims))
+			Expect(err).To(MatchError("invalid issuer"))
+
+			claims = validClaims()
+			claims["aud"] = "other"
+			_, err = authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims))
+			Expect(err).To(MatchError("invalid audience"))
+		})
+
+		It("should return error if the email is missing or not verified", func() {
+			claims := validClaims()
+			delete(claims, "email")
+			_, err := authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims))
+			Expect(err).To(MatchError("token has no email claim"))
+
+			claims = validClaims()
+			claims["email_verified"] = false
+			_, err = authenticate("Bearer " + sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims))
+			Expect(err).To(MatchError("email is not verified"))
+		})
+
+		It("should return error if the jwks file does not exist or has no keys", func() {
+			_, err := api.LoadJWKS(filepath.Join(filepath.Dir(jwksPath), "missing.json"))
+			Expect(err).To(HaveOccurred())
+
+			Expect(ioutil.WriteFile(jwksPath, []byte(`{"keys": []}`), 0600)).To(Succeed())
+			_, err = api.LoadJWKS(jwksPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Middlewares", func() {
+		logger := zap.New(
+			zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+			zap.FatalLevel,
+		)
+		app := GetDefaultTestApp(logger)
+		var defaultAuthenticators []api.Authenticator
+
+		BeforeEach(func() {
+			app.DB.Exec("DELETE FROM users;")
+			app.DB.Exec("DELETE FROM api_keys;")
+			CreateTestUser(app.DB, map[string]interface{}{"email": "admin@test.com", "isAdmin": true})
+			CreateTestUser(app.DB, map[string]interface{}{"email": "jwt@test.com", "isAdmin": true})
+			CreateTestUser(app.DB, map[string]interface{}{"email": "service@test.com", "isAdmin": true})
+			defaultAuthenticators = app.Authenticators
+		})
+
+		AfterEach(func() {
+			app.Authenticators = defaultAuthenticators
+		})
+
+		createAPIKey := func() (string, string) {
+			pl, _ := json.Marshal(map[string]interface{}{"name": "scheduler", "email": "service@test.com"})
+			status, body := Post(app, "/apikeys", string(pl), "admin@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+			var response map[string]interface{}
+			Expect(json.Unmarshal([]byte(body), &response)).To(Succeed())
+			return response["id"].(string), response["key"].(string)
+		}
+
+		It("should authenticate requests with the headers of the configured authenticators only", func() {
+			apiKeyAuthenticator := &api.APIKeyAuthenticator{App: app}
+			app.Authenticators = []api.Authenticator{authenticator, apiKeyAuthenticator}
+			token := sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, validClaims())
+
+			status, _ := DoRequest(app, "GET", "/apps", "", map[string]string{"Authorization": "Bearer " + token})
+			Expect(status).To(Equal(http.StatusOK))
+
+			status, _ = Get(app, "/apps", "admin@test.com")
+			Expect(status).To(Equal(http.StatusUnauthorized))
+
+			status, _ = DoRequest(app, "GET", "/apps", "", map[string]string{"Authorization": "Bearer invalid"})
+			Expect(status).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should return 401 if the user of a valid token does not exist", func() {
+			app.Authenticators = []api.Authenticator{authenticator}
+			claims := validClaims()
+			claims["email"] = "unknown@test.com"
+			token := sign(jwt.SigningMethodRS256, "rsa-key", rsaKey, claims)
+
+			status, _ := DoRequest(app, "GET", "/apps", "", map[string]string{"Authorization": "Bearer " + token})
+			Expect(status).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should create an api key that authenticates as its email until it is revoked", func() {
+			apiKeyAuthenticator := &api.APIKeyAuthenticator{App: app}
+			id, key := createAPIKey()
+			Expect(key).To(HavePrefix(model.APIKeyPrefix))
+
+			dbKey := &model.APIKey{}
+			err := app.DB.Model(dbKey).Where("id = ?", id).Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbKey.KeyHash).To(Equal(model.HashAPIKey(key)))
+			Expect(dbKey.KeyHash).NotTo(ContainSubstring(key))
+
+			app.Authenticators = []api.Authenticator{apiKeyAuthenticator}
+			status, _ := DoRequest(app, "GET", "/apps", "", map[string]string{"x-api-key": key})
+			Expect(status).To(Equal(http.StatusOK))
+			status, _ = DoRequest(app, "GET", "/apps", "", map[string]string{"x-api-key": key + "x"})
+			Expect(status).To(Equal(http.StatusUnauthorized))
+
+			app.Authenticators = defaultAuthenticators
+			status, _ = Delete(app, fmt.Sprintf("/apikeys/%s", id), "admin@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+
+			app.Authenticators = []api.Authenticator{apiKeyAuthenticator}
+			status, _ = DoRequest(app, "GET", "/apps", "", map[string]string{"x-api-key": key})
+			Expect(status).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should list the api keys without their keys", func() {
+			createAPIKey()
+			status, body := Get(app, "/apikeys", "admin@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var keys []map[string]interface{}
+			Expect(json.Unmarshal([]byte(body), &keys)).To(Succeed())
+			Expect(keys).To(HaveLen(1))
+			Expect(keys[0]["email"]).To(Equal("service@test.com"))
+			Expect(keys[0]["prefix"]).To(HavePrefix(model.APIKeyPrefix))
+			Expect(keys[0]).NotTo(HaveKey("key"))
+			Expect(keys[0]).NotTo(HaveKey("keyHash"))
+		})
+
+		It("should return 403 if a user that is not admin creates an api key", func() {
+			CreateTestUser(app.DB, map[string]interface{}{"email": "user@test.com", "isAdmin": false})
+			pl, _ := json.Marshal(map[string]interface{}{"name": "scheduler", "email": "service@test.com"})
+			status, _ := Post(app, "/apikeys", string(pl), "user@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+
+		It("should return 404 if the api key does not exist or was already revoked", func() {
+			id, _ := createAPIKey()
+			status, _ := Delete(app, fmt.Sprintf("/apikeys/%s", id), "admin@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+			status, _ = Delete(app, fmt.Sprintf("/apikeys/%s", id), "admin@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
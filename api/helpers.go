@@ -23,8 +23,12 @@
 package api
 
 import (
+	"strconv"
+
 	"github.com/labstack/echo"
 	newrelic "github.com/newrelic/go-agent"
+	"github.com/topfreegames/marathon/interfaces"
+	pg "gopkg.in/pg.v5"
 )
 
 // RecordNotFoundString is the string returned when a record is not found
@@ -56,3 +60,26 @@ func WithSegment(name string, c echo.Context, f func() error) error {
 	defer segment.End()
 	return f()
 }
+
+//InTransaction runs f in a transaction of db, the transaction is rolled back if f fails
+func InTransaction(db interfaces.DB, f func(tx *pg.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//intQueryParam returns the integer query param name of the request or defaultValue if it is not set
+func intQueryParam(c echo.Context, name string, defaultValue int64) (int64, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
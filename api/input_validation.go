@@ -24,6 +24,7 @@ package api
 
 import (
 	"encoding/json"
+	"io/ioutil"
 
 	"github.com/labstack/echo"
 
@@ -56,3 +57,15 @@ func decodeAndValidate(c echo.Context, v InputValidation) error {
 	}
 	return v.Validate(c)
 }
+
+func decodeAndValidateTemplateBundle(c echo.Context, format string, v *model.TemplateBundle) error {
+	defer c.Request().Body.Close()
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	if err := model.UnmarshalTemplateBundle(data, format, v); err != nil {
+		return err
+	}
+	return v.Validate(c)
+}
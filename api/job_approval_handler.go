@@ -0,0 +1,345 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/email"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+	pg "gopkg.in/pg.v5"
+)
+
+var errJobApprovalDecided = fmt.Errorf("the job was already approved or rejected")
+
+// requiresApproval returns true if the jobs of the request must be approved by another user
+// before being sent, that is, if their app requires approval or their audience is too big,
+// and their estimated audience
+func (a *Application) requiresApproval(app *model.App, jobs []*model.Job) (bool, int) {
+	a.Config.SetDefault("approval.minAudience", 0)
+	a.Config.SetDefault("approval.apps", []string{})
+	appRequiresApproval := false
+	for _, appID := range a.Config.GetStringSlice("approval.apps") {
+		if appID == app.ID.String() || appID == app.Name {
+			appRequiresApproval = true
+		}
+	}
+	minAudience := a.Config.GetInt("approval.minAudience")
+	if !appRequiresApproval && minAudience <= 0 {
+		return false, model.UnknownAudience
+	}
+
+	audience := 0
+	for _, job := range jobs {
+		jobAudience := a.estimateAudience(job)
+		if jobAudience == model.UnknownAudience {
+			audience = model.UnknownAudience
+			break
+		}
+		audience += jobAudience
+	}
+	if appRequiresApproval {
+		return true, audience
+	}
+	// jobs whose audience cannot be estimated are treated as big ones
+	return audience == model.UnknownAudience || audience >= minAudience, audience
+}
+
+// estimateAudience returns the number of users the planner estimates the job filters match
+// in the push db, the audience of csv jobs is unknown until their csv is read
+func (a *Application) estimateAudience(job *model.Job) int {
+	l := a.Logger.With(
+		zap.String("source", "jobApprovalHandler"),
+		zap.String("operation", "estimateAudience"),
+		zap.String("service", job.Service),
+	)
+	if job.CSVPath != "" {
+		return model.UnknownAudience
+	}
+	query := fmt.Sprintf("EXPLAIN (FORMAT JSON) SELECT 1 FROM %s", worker.GetPushDBTableName(job.App.Name, job.Service))
+	if where := worker.GetWhereClauseFromFilters(job.Filters); where != "" {
+		query = fmt.Sprintf("%s WHERE %s", query, where)
+	}
+	var plan string
+	_, err := a.PushDB.QueryOne(pg.Scan(&plan), query)
+	if err != nil {
+		log.W(l, "Failed to estimate job audience.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return model.UnknownAudience
+	}
+	var plans []struct {
+		Plan struct {
+			Rows int `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &plans); err != nil || len(plans) == 0 {
+		return model.UnknownAudience
+	}
+	return plans[0].Plan.Rows
+}
+
+// notifyApprovers sends the approval request of the jobs to the approvers and admins of the app
+// and to the addressees in approval.notify, except to who created the jobs
+func (a *Application) notifyApprovers(l zap.Logger, app *model.App, jobs []*model.Job, audience int) {
+	if a.SendgridClient == nil {
+		return
+	}
+	users := []model.User{}
+	err := a.DB.Model(&users).Where(
+		`roles->? @> '["approver"]'::jsonb OR roles->? @> '["admin"]'::jsonb`,
+		app.ID.String(), app.ID.String(),
+	).Select()
+	if err != nil {
+		log.E(l, "Failed to retrieve approvers.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+	}
+	approvers := a.Config.GetStringSlice("approval.notify")
+	for _, user := range users {
+		approvers = append(approvers, user.Email)
+	}
+
+	notified := map[string]bool{}
+	for _, approver := range approvers {
+		if notified[approver] || approver == jobs[0].CreatedBy {
+			continue
+		}
+		notified[approver] = true
+		for _, job := range jobs {
+			err := email.SendApprovalRequestedEmail(a.SendgridClient, job, app.Name, audience, approver)
+			if err != nil {
+				log.E(l, "Failed to send email with approval request.", func(cm log.CM) {
+					cm.Write(zap.Error(err))
+				})
+			}
+		}
+	}
+}
+
+// ApproveJobHandler is the method called when a put to /apps/:aid/jobs/:jid/approve is called,
+// it approves and starts all pending jobs of the job group, jobs cannot be approved by who created them
+func (a *Application) ApproveJobHandler(c echo.Context) error {
+	return a.decideJobApproval(c, model.JobApprovalApproved)
+}
+
+// RejectJobHandler is the method called when a put to /apps/:aid/jobs/:jid/reject is called,
+// it rejects all pending jobs of the job group, a reason is required
+func (a *Application) RejectJobHandler(c echo.Context) error {
+	return a.decideJobApproval(c, model.JobApprovalRejected)
+}
+
+func (a *Application) decideJobApproval(c echo.Context, action string) error {
+	l := a.Logger.With(
+		zap.String("source", "jobApprovalHandler"),
+		zap.String("operation", action),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	jid, err := uuid.FromString(c.Param("jid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	decision := &model.JobApprovalDecision{}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, decision)
+	})
+	if err != nil && err != io.EOF {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: decision})
+	}
+	if action == model.JobApprovalRejected && decision.Reason == "" {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "reason is required to reject a job", Value: decision})
+	}
+
+	job := &model.Job{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(job).Column("job.*", "App").Where("job.id = ? AND job.app_id = ?", jid, aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	verb := "approve"
+	status := model.JobStatusScheduled
+	if action == model.JobApprovalRejected {
+		verb = "reject"
+		status = model.JobStatusRejected
+	}
+	if job.Status != model.JobStatusPendingApproval {
+		return c.JSON(http.StatusForbidden, &Error{Reason: fmt.Sprintf("cannot %s job with status %s", verb, job.Status)})
+	}
+	userEmail := c.Get("user-email").(string)
+	if action == model.JobApprovalApproved && userEmail == job.CreatedBy {
+		return c.JSON(http.StatusForbidden, &Error{Reason: "jobs must be approved by another user than the one who created them"})
+	}
+
+	message := fmt.Sprintf("%s by %s", action, userEmail)
+	if decision.Reason != "" {
+		message = fmt.Sprintf("%s: %s", message, decision.Reason)
+	}
+	jobs := []*model.Job{}
+	err = WithSegment("db-update", c, func() error {
+		return InTransaction(a.DB, func(tx *pg.Tx) error {
+			now := time.Now().UnixNano()
+			err := tx.Model(&jobs).Where("job_group_id = ? AND status = ?", job.JobGroupID, model.JobStatusPendingApproval).Select()
+			if err != nil {
+				return err
+			}
+			if len(jobs) == 0 {
+				return errJobApprovalDecided
+			}
+			for _, j := range jobs {
+				err = j.Transition(tx, status, message)
+				if model.IsInvalidJobTransition(err) {
+					return errJobApprovalDecided
+				}
+				if err != nil {
+					return err
+				}
+			}
+			audience := model.UnknownAudience
+			_, err = tx.QueryOne(
+				pg.Scan(&audience),
+				"SELECT audience FROM job_approvals WHERE job_group_id = ? AND action = ? ORDER BY created_at DESC LIMIT 1",
+				job.JobGroupID, model.JobApprovalRequested,
+			)
+			if err != nil && err.Error() != RecordNotFoundString {
+				return err
+			}
+			return tx.Insert(&model.JobApproval{
+				ID:         uuid.NewV4(),
+				JobGroupID: job.JobGroupID,
+				AppID:      aid,
+				Action:     action,
+				Audience:   audience,
+				Reason:     decision.Reason,
+				CreatedBy:  userEmail,
+				CreatedAt:  now,
+			})
+		})
+	})
+	if err != nil {
+		if err == errJobApprovalDecided {
+			return c.JSON(http.StatusForbidden, &Error{Reason: err.Error()})
+		}
+		log.E(l, fmt.Sprintf("Failed to %s job.", verb), func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.I(l, fmt.Sprintf("Job group %s.", action), func(cm log.CM) {
+		cm.Write(
+			zap.String("jobGroupId", job.JobGroupID.String()),
+			zap.Int("jobs", len(jobs)),
+			zap.String("decidedBy", userEmail),
+		)
+	})
+
+	services := map[string]bool{}
+	for _, j := range jobs {
+		if action == model.JobApprovalApproved {
+			err := a.createJobWorkers(j, c)
+			if err != nil {
+				log.E(l, "Failed to create job workers.", func(cm log.CM) {
+					cm.Write(zap.String("jobId", j.ID.String()), zap.Error(err))
+				})
+			}
+		}
+		if a.SendgridClient == nil || services[j.Service] {
+			continue
+		}
+		services[j.Service] = true
+		if action == model.JobApprovalApproved {
+			err = email.SendJobApprovedEmail(a.SendgridClient, j, job.App.Name, userEmail)
+		} else {
+			err = email.SendJobRejectedEmail(a.SendgridClient, j, job.App.Name, userEmail, decision.Reason)
+		}
+		if err != nil {
+			log.E(l, fmt.Sprintf("Failed to send email with %s job info.", action), func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+	}
+
+	if len(jobs) > 1 {
+		jobGroup := &model.JobGroup{ID: job.JobGroupID, AppID: aid, Jobs: jobs}
+		jobGroup.AggregateProgress()
+		return c.JSON(http.StatusOK, jobGroup)
+	}
+	return c.JSON(http.StatusOK, jobs[0])
+}
+
+// ListJobApprovalsHandler is the method called when a get to /apps/:aid/jobs/:jid/approvals is called,
+// it returns the approval records of the job group, oldest first
+func (a *Application) ListJobApprovalsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobApprovalHandler"),
+		zap.String("operation", "listJobApprovals"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	jid, err := uuid.FromString(c.Param("jid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	job := &model.Job{}
+	approvals := []model.JobApproval{}
+	err = WithSegment("db-select", c, func() error {
+		err := a.DB.Model(job).Column("job.*").Where("job.id = ? AND job.app_id = ?", jid, aid).Select()
+		if err != nil {
+			return err
+		}
+		return a.DB.Model(&approvals).Where("job_group_id = ?", job.JobGroupID).Order("created_at ASC").Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to list job approvals.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, approvals)
+}
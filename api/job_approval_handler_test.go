@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Job Approval Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var existingApp *model.App
+	var existingTemplate *model.Template
+	var baseRoute string
+
+	w := worker.NewWorker(logger, GetConfPath())
+	w.S3Client = &FakeS3{}
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		w.RedisClient.FlushAll()
+
+		existingApp = CreateTestApp(app.DB)
+		existingTemplate = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+			"locale": "en",
+		})
+		baseRoute = fmt.Sprintf("/apps/%s/jobs", existingApp.ID)
+		CreateTestUser(app.DB, map[string]interface{}{"email": "creator@test.com", "isAdmin": true})
+		CreateTestUser(app.DB, map[string]interface{}{
+			"email":   "approver@test.com",
+			"isAdmin": false,
+			"roles":   model.AppRoles{existingApp.ID.String(): []string{model.RoleApprover}},
+		})
+		CreateTestUser(app.DB, map[string]interface{}{
+			"email":   "sender@test.com",
+			"isAdmin": false,
+			"roles":   model.AppRoles{existingApp.ID.String(): []string{model.RoleSender}},
+		})
+		app.Config.Set("approval.apps", []string{existingApp.ID.String()})
+	})
+
+	AfterEach(func() {
+		app.Config.Set("approval.apps", []string{})
+		app.Config.Set("approval.minAudience", 0)
+	})
+
+	createJob := func(services ...string) map[string]interface{} {
+		payload := GetJobPayload()
+		payload["csvPath"] = "bucket/somecsv"
+		payload["filters"] = map[string]interface{}{}
+		if len(services) > 0 {
+			payload["service"] = services
+		}
+		delete(payload, "startsAt")
+		pl, _ := json.Marshal(payload)
+		status, body := Post(app, fmt.Sprintf("%s?template=%s", baseRoute, existingTemplate.Name), string(pl), "creator@test.com")
+		Expect(status).To(Equal(http.StatusCreated))
+
+		var response map[string]interface{}
+		err := json.Unmarshal([]byte(body), &response)
+		Expect(err).NotTo(HaveOccurred())
+		return response
+	}
+
+	queueLength := func() int64 {
+		res, err := w.RedisClient.LLen("queue:csv_split_worker").Result()
+		Expect(err).NotTo(HaveOccurred())
+		return res
+	}
+
+	listApprovals := func(jobID string) []map[string]interface{} {
+		status, body := Get(app, fmt.Sprintf("%s/%s/approvals", baseRoute, jobID), "approver@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+		var approvals []map[string]interface{}
+		err := json.Unmarshal([]byte(body), &approvals)
+		Expect(err).NotTo(HaveOccurred())
+		return approvals
+	}
+
+	Describe("Post /apps/:aid/jobs", func() {
+		It("should create the job pending approval without enqueueing it if the app requires approval", func() {
+			job := createJob()
+			Expect(job["status"]).To(Equal(model.JobStatusPendingApproval))
+			Expect(queueLength()).To(BeEquivalentTo(0))
+
+			approvals := listApprovals(job["id"].(string))
+			Expect(approvals).To(HaveLen(1))
+			Expect(approvals[0]["action"]).To(Equal(model.JobApprovalRequested))
+			Expect(approvals[0]["createdBy"]).To(Equal("creator@test.com"))
+			Expect(approvals[0]["audience"]).To(BeEquivalentTo(model.UnknownAudience))
+		})
+
+		It("should create the job pending approval if its audience is unknown and there is a minimum audience", func() {
+			app.Config.Set("approval.apps", []string{})
+			app.Config.Set("approval.minAudience", 1000000)
+			job := createJob()
+			Expect(job["status"]).To(Equal(model.JobStatusPendingApproval))
+			Expect(queueLength()).To(BeEquivalentTo(0))
+		})
+
+		It("should start the job if approval is not required", func() {
+			app.Config.Set("approval.apps", []string{})
+			job := createJob()
+			Expect(job["status"]).To(Equal(model.JobStatusScheduled))
+			Expect(queueLength()).To(BeEquivalentTo(1))
+			Expect(listApprovals(job["id"].(string))).To(BeEmpty())
+		})
+	})
+
+	Describe("Put /apps/:aid/jobs/:jid/approve", func() {
+		It("should start the job and record the approval", func() {
+			job := createJob()
+			status, body := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, job["id"]), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["status"]).To(Equal(model.JobStatusScheduled))
+			Expect(queueLength()).To(BeEquivalentTo(1))
+
+			approvals := listApprovals(job["id"].(string))
+			Expect(approvals).To(HaveLen(2))
+			Expect(approvals[1]["action"]).To(Equal(model.JobApprovalApproved))
+			Expect(approvals[1]["createdBy"]).To(Equal("approver@test.com"))
+		})
+
+		It("should approve all jobs of the job group", func() {
+			group := createJob("apns", "gcm")
+			jobs := group["jobs"].([]interface{})
+			Expect(jobs).To(HaveLen(2))
+			jobID := jobs[0].(map[string]interface{})["id"]
+
+			status, body := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, jobID), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["jobs"]).To(HaveLen(2))
+			Expect(response["progress"].(map[string]interface{})["status"]).To(Equal(model.JobStatusScheduled))
+			Expect(queueLength()).To(BeEquivalentTo(2))
+		})
+
+		It("should return 403 if the user created the job", func() {
+			job := createJob()
+			status, body := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, job["id"]), "", "creator@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+			Expect(body).To(ContainSubstring("jobs must be approved by another user"))
+			Expect(queueLength()).To(BeEquivalentTo(0))
+		})
+
+		It("should return 403 if the user is not an approver of the app", func() {
+			job := createJob()
+			status, _ := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, job["id"]), "", "sender@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+
+		It("should return 403 if the job is not pending approval", func() {
+			job := createJob()
+			status, _ := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, job["id"]), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			status, body := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, job["id"]), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+			Expect(body).To(ContainSubstring("cannot approve job with status"))
+			Expect(queueLength()).To(BeEquivalentTo(1))
+		})
+
+		It("should return 404 if the job does not exist", func() {
+			status, _ := Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, uuid.NewV4()), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Put /apps/:aid/jobs/:jid/reject", func() {
+		It("should reject the job and record the reason", func() {
+			job := createJob()
+			pl, _ := json.Marshal(map[string]interface{}{"reason": "wrong audience"})
+			status, body := Put(app, fmt.Sprintf("%s/%s/reject", baseRoute, job["id"]), string(pl), "approver@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["status"]).To(Equal(model.JobStatusRejected))
+			Expect(queueLength()).To(BeEquivalentTo(0))
+
+			approvals := listApprovals(job["id"].(string))
+			Expect(approvals).To(HaveLen(2))
+			Expect(approvals[1]["action"]).To(Equal(model.JobApprovalRejected))
+			Expect(approvals[1]["reason"]).To(Equal("wrong audience"))
+
+			status, _ = Put(app, fmt.Sprintf("%s/%s/approve", baseRoute, job["id"]), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+
+		It("should return 422 if there is no reason", func() {
+			job := createJob()
+			status, body := Put(app, fmt.Sprintf("%s/%s/reject", baseRoute, job["id"]), "", "approver@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("reason is required to reject a job"))
+		})
+	})
+})
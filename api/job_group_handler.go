@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// GetJobGroupHandler is the method called when a get to /apps/:aid/jobgroups/:gid is called
+func (a *Application) GetJobGroupHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobGroupHandler"),
+		zap.String("operation", "getJobGroup"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobGroupId", c.Param("gid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	gid, err := uuid.FromString(c.Param("gid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	jobGroup := &model.JobGroup{
+		ID:    gid,
+		AppID: aid,
+	}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(jobGroup).Where("id = ?", gid).Where("app_id = ?", aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, jobGroup)
+		}
+		log.E(l, "Failed to retrieve job group.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	jobGroup.Jobs = []*model.Job{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&jobGroup.Jobs).Column("job.*").Where("job.job_group_id = ?", gid).Order("job.created_at ASC").Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to retrieve job group jobs.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	jobGroup.AggregateProgress()
+
+	log.D(l, "Retrieved job group successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("jobGroup", jobGroup))
+	})
+	return c.JSON(http.StatusOK, jobGroup)
+}
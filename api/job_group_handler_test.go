@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Job Group Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	faultyDb := GetFaultyTestDB(app)
+	var existingApp *model.App
+	var existingTemplate *model.Template
+	var jobGroup *model.JobGroup
+	var baseRoute string
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		CreateTestUser(app.DB, map[string]interface{}{"email": "test@test.com", "isAdmin": true})
+
+		existingApp = CreateTestApp(app.DB)
+		existingTemplate = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+			"locale": "en",
+		})
+		jobGroup = &model.JobGroup{
+			ID:    uuid.NewV4(),
+			AppID: existingApp.ID,
+		}
+		err := app.DB.Insert(jobGroup)
+		Expect(err).NotTo(HaveOccurred())
+
+		feedbacks := []map[string]interface{}{
+			{"ack": 10, "BAD_REGISTRATION": 1},
+			{"ack": 5, "Unregistered": 2},
+		}
+		for idx, service := range []string{"apns", "gcm"} {
+			job := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+				"service": service,
+			})
+			_, err = app.DB.Model(&model.Job{}).
+				Set("job_group_id = ?", jobGroup.ID).
+				Set("total_batches = ?", 4).
+				Set("completed_batches = ?", idx+1).
+				Set("total_tokens = ?", 100).
+				Set("completed_tokens = ?", 50).
+				Set("feedbacks = ?", feedbacks[idx]).
+				Where("id = ?", job.ID).
+				Update()
+			Expect(err).NotTo(HaveOccurred())
+		}
+		baseRoute = fmt.Sprintf("/apps/%s/jobgroups", existingApp.ID)
+	})
+
+	Describe("Get /apps/:id/jobgroups/:gid", func() {
+		Describe("Sucesfully", func() {
+			It("should return 200 and the job group with the combined progress", func() {
+				status, body := Get(app, fmt.Sprintf("%s/%s", baseRoute, jobGroup.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["id"]).To(Equal(jobGroup.ID.String()))
+				Expect(response["appId"]).To(Equal(existingApp.ID.String()))
+				Expect(response["jobs"]).To(HaveLen(2))
+
+				progress := response["progress"].(map[string]interface{})
+				Expect(progress["services"]).To(ConsistOf("apns", "gcm"))
+				Expect(progress["totalBatches"]).To(BeEquivalentTo(8))
+				Expect(progress["completedBatches"]).To(BeEquivalentTo(3))
+				Expect(progress["totalTokens"]).To(BeEquivalentTo(200))
+				Expect(progress["completedTokens"]).To(BeEquivalentTo(100))
+				Expect(progress["feedbacks"]).To(BeEquivalentTo(map[string]interface{}{
+					"ack":              float64(15),
+					"BAD_REGISTRATION": float64(1),
+					"Unregistered":     float64(2),
+				}))
+			})
+		})
+
+		Describe("Unsucesfully", func() {
+			It("should return 401 if no authenticated user", func() {
+				status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, jobGroup.ID), "")
+				Expect(status).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return 500 if some error occured", func() {
+				goodDB := app.DB
+				app.DB = faultyDb
+				status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, jobGroup.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusInternalServerError))
+				app.DB = goodDB
+			})
+
+			It("should return 404 if the job group does not exist", func() {
+				status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4().String()), "test@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+
+			It("should return 404 if the job group is from another app", func() {
+				anotherApp := CreateTestApp(app.DB, map[string]interface{}{"name": "anotherapp"})
+				status, _ := Get(app, fmt.Sprintf("/apps/%s/jobgroups/%s", anotherApp.ID, jobGroup.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+			})
+
+			It("should return 422 if job group id is not UUID", func() {
+				status, body := Get(app, fmt.Sprintf("%s/not-uuid", baseRoute), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("uuid: incorrect UUID length: not-uuid"))
+			})
+		})
+	})
+})
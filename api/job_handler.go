@@ -28,8 +28,6 @@ import (
 	"strings"
 	"time"
 
-	"gopkg.in/pg.v5/types"
-
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/email"
@@ -121,14 +119,27 @@ func (a *Application) PostJobHandler(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: job})
 	}
 
-	skip, err := a.checkFilters(job, c)
+	job.NormalizeFilters()
+	jobs := job.SplitByService()
+
+	skip, err := a.checkTemplateName(templateName, job, c)
 	if err != nil || skip {
 		return err
 	}
 
-	skip, err = a.checkTemplateName(templateName, job, c)
-	if err != nil || skip {
+	var templateVersions model.TemplateVersions
+	err = WithSegment("db-select", c, func() error {
+		templateVersions, err = model.GetTemplateVersions(a.DB, aid, templateName)
 		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to retrieve template versions.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	for _, j := range jobs {
+		j.TemplateVersions = templateVersions
 	}
 
 	if job.StartsAt == 0 && job.Localized {
@@ -136,53 +147,48 @@ func (a *Application) PostJobHandler(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: localeErr, Value: job})
 	}
 
-	err = WithSegment("create-job", c, func() error {
-		scheduleJob := job.StartsAt
-
-		jobGroup := model.JobGroup{
-			ID:    uuid.NewV4(),
-			AppID: app.ID,
+	pendingApproval, audience := a.requiresApproval(app, jobs)
+	for _, j := range jobs {
+		j.Status = model.JobStatusScheduled
+		if pendingApproval {
+			j.Status = model.JobStatusPendingApproval
 		}
+	}
+
+	jobGroup := &model.JobGroup{
+		ID:    uuid.NewV4(),
+		AppID: app.ID,
+		Jobs:  jobs,
+	}
+	err = WithSegment("create-job", c, func() error {
 		err := WithSegment("create-group", c, func() error {
-			return a.DB.Insert(&jobGroup)
+			return a.DB.Insert(jobGroup)
 		})
 		if err != nil {
 			return err
 		}
-		job.JobGroupID = jobGroup.ID
-
-		if scheduleJob == 0 || !job.Localized {
-			log.I(l, "Create a simple job.")
-			return a.createJob(job, c)
-		}
-
-		// create a job for each tz
-		for i := -12; i <= 14; i++ {
-			tzs := []string{
-				fmt.Sprintf("%+.4d", i*100-55), // 100 - 55 = 45
-				fmt.Sprintf("%+.4d", i*100),
-				fmt.Sprintf("%+.4d", i*100+15),
-				fmt.Sprintf("%+.4d", i*100+30),
-			}
-			sendTime := time.Unix(0, scheduleJob).Add(time.Duration(i) * time.Hour)
-			if sendTime.Before(time.Now()) {
-				if job.PastTimeStrategy == "skip" {
-					continue
-				}
-				sendTime = sendTime.Add(time.Duration(24) * time.Hour)
-			}
-
-			job.StartsAt = sendTime.UnixNano()
-			job.Filters["tz"] = strings.Join(tzs, ",")
-			job.ID = uuid.NewV4()
-			log.I(l, "Create a timezone job.")
 
-			err = a.createJob(job, c)
+		for _, j := range jobs {
+			j.JobGroupID = jobGroup.ID
+			err = a.createServiceJob(j, c)
 			if err != nil {
 				return err
 			}
 		}
-		return nil
+		if !pendingApproval {
+			return nil
+		}
+		return WithSegment("db-insert", c, func() error {
+			return a.DB.Insert(&model.JobApproval{
+				ID:         uuid.NewV4(),
+				JobGroupID: jobGroup.ID,
+				AppID:      app.ID,
+				Action:     model.JobApprovalRequested,
+				Audience:   audience,
+				CreatedBy:  userEmail,
+				CreatedAt:  time.Now().UnixNano(),
+			})
+		})
 	})
 
 	if err != nil {
@@ -197,79 +203,73 @@ func (a *Application) PostJobHandler(c echo.Context) error {
 		app := &model.App{ID: aid}
 		a.DB.Select(&app)
 
-		err := email.SendCreatedJobEmail(a.SendgridClient, job, app)
-		if err != nil {
-			log.E(l, "Failed to send email with job info.", func(cm log.CM) {
-				cm.Write(zap.Error(err))
-			})
+		for _, j := range jobs {
+			err := email.SendCreatedJobEmail(a.SendgridClient, j, app)
+			if err != nil {
+				log.E(l, "Failed to send email with job info.", func(cm log.CM) {
+					cm.Write(zap.Error(err))
+				})
+			}
 		}
 		log.I(l, "Successfully sent email with job info.")
 	}
-	return c.JSON(http.StatusCreated, job)
-}
-
-func (a *Application) checkFilters(job *model.Job, c echo.Context) (bool, error) {
-	if job.Filters["region"] != nil || job.Filters["NOTregion"] != nil || job.Filters["locale"] != nil || job.Filters["NOTlocale"] != nil {
-		var users []worker.User
-		query := fmt.Sprintf("SELECT locale, region FROM %s WHERE locale is not NULL AND region is not NULL LIMIT 1;", worker.GetPushDBTableName(job.App.Name, job.Service))
-		a.PushDB.Query(&users, query)
-		if len(users) != 1 {
-			return true, c.JSON(http.StatusInternalServerError, &Error{Reason: "Failed to check filters in Push DB"})
-		}
-		locale := users[0].Locale
-		region := users[0].Region
-
-		localeSettings := map[string]bool{
-			"isUpperCase": strings.ToUpper(locale) == locale,
-			"isLowerCase": strings.ToLower(locale) == locale,
-		}
+	if pendingApproval {
+		log.I(l, "Job waiting for approval.", func(cm log.CM) {
+			cm.Write(zap.String("jobGroupId", jobGroup.ID.String()), zap.Int("audience", audience))
+		})
+		a.notifyApprovers(l, app, jobs, audience)
+	}
+	for _, j := range jobs {
+		a.Worker.NotifyJobEvent(j, model.WebhookEventCreated, "", "")
+	}
 
-		regionSettings := map[string]bool{
-			"isUpperCase": strings.ToUpper(region) == region,
-			"isLowerCase": strings.ToLower(region) == region,
-		}
+	if len(jobs) > 1 {
+		jobGroup.AggregateProgress()
+		return c.JSON(http.StatusCreated, jobGroup)
+	}
+	return c.JSON(http.StatusCreated, jobs[0])
+}
 
-		if job.Filters["locale"] != nil {
-			if localeSettings["isUpperCase"] && !localeSettings["isLowerCase"] {
-				job.Filters["locale"] = strings.ToUpper(job.Filters["locale"].(string))
-			} else if localeSettings["isLowerCase"] && !localeSettings["isUpperCase"] {
-				job.Filters["locale"] = strings.ToLower(job.Filters["locale"].(string))
-			} else {
-				return true, c.JSON(http.StatusInternalServerError, &Error{Reason: "Locale case check failed in Push DB"})
-			}
+// createServiceJob creates the job of one service, localized jobs are split in one job per timezone
+func (a *Application) createServiceJob(job *model.Job, c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobHandler"),
+		zap.String("operation", "createServiceJob"),
+		zap.String("service", job.Service),
+	)
+	scheduleJob := job.StartsAt
+	if scheduleJob == 0 || !job.Localized {
+		log.I(l, "Create a simple job.")
+		return a.createJob(job, c)
+	}
+
+	// create a job for each tz
+	for i := -12; i <= 14; i++ {
+		tzs := []string{
+			fmt.Sprintf("%+.4d", i*100-55), // 100 - 55 = 45
+			fmt.Sprintf("%+.4d", i*100),
+			fmt.Sprintf("%+.4d", i*100+15),
+			fmt.Sprintf("%+.4d", i*100+30),
 		}
-
-		if job.Filters["NOTlocale"] != nil {
-			if localeSettings["isUpperCase"] && !localeSettings["isLowerCase"] {
-				job.Filters["NOTlocale"] = strings.ToUpper(job.Filters["NOTlocale"].(string))
-			} else if localeSettings["isLowerCase"] && !localeSettings["isUpperCase"] {
-				job.Filters["NOTlocale"] = strings.ToLower(job.Filters["NOTlocale"].(string))
-			} else {
-				return true, c.JSON(http.StatusInternalServerError, &Error{Reason: "Locale case check failed in Push DB"})
+		sendTime := time.Unix(0, scheduleJob).Add(time.Duration(i) * time.Hour)
+		if sendTime.Before(time.Now()) {
+			if job.PastTimeStrategy == "skip" {
+				continue
 			}
+			sendTime = sendTime.Add(time.Duration(24) * time.Hour)
 		}
 
-		if job.Filters["region"] != nil {
-			if regionSettings["isUpperCase"] && !regionSettings["isLowerCase"] {
-				job.Filters["region"] = strings.ToUpper(job.Filters["region"].(string))
-			} else if regionSettings["isLowerCase"] && !regionSettings["isUpperCase"] {
-				job.Filters["region"] = strings.ToLower(job.Filters["region"].(string))
-			} else {
-				return true, c.JSON(http.StatusInternalServerError, &Error{Reason: "Region case check failed in Push DB"})
-			}
-		}
+		job.StartsAt = sendTime.UnixNano()
+		job.Filters["tz"] = strings.Join(tzs, ",")
+		job.ID = uuid.NewV4()
+		log.I(l, "Create a timezone job.")
 
-		if job.Filters["NOTregion"] != nil {
-			if regionSettings["isUpperCase"] && !regionSettings["isLowerCase"] {
-				job.Filters["NOTregion"] = strings.ToUpper(job.Filters["NOTregion"].(string))
-			} else if regionSettings["isLowerCase"] && !regionSettings["isUpperCase"] {
-				job.Filters["NOTregion"] = strings.ToLower(job.Filters["NOTregion"].(string))
-			} else {
-				return true, c.JSON(http.StatusInternalServerError, &Error{Reason: "Region case check failed in Push DB"})
-			}
+		err := a.createJob(job, c)
+		if err != nil {
+			return err
 		}
 	}
-	return false, nil
+	return nil
 }
 
 func (a *Application) checkTemplateName(templateName string, job *model.Job, c echo.Context) (bool, error) {
@@ -285,12 +285,13 @@ func (a *Application) checkTemplateName(templateName string, job *model.Job, c e
 			return true, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 		}
 
+		defaultLocale := job.App.GetDefaultLocale()
 		err = WithSegment("db-select", c, func() error {
-			return a.DB.Model(&template).Column("template.*").Where("template.app_id = ?", job.AppID).Where("template.name = ? AND template.locale='en'", tpl).First()
+			return a.DB.Model(&template).Column("template.*").Where("template.app_id = ?", job.AppID).Where("template.name = ? AND lower(replace(template.locale, '_', '-')) = ?", tpl, defaultLocale).First()
 		})
 		if err != nil {
 			if err.Error() == RecordNotFoundString {
-				localeErr := "Cannot create job if there is no template for locale 'en'."
+				localeErr := fmt.Sprintf("Cannot create job if there is no template for locale '%s'.", defaultLocale)
 				return true, c.JSON(http.StatusUnprocessableEntity, &Error{Reason: localeErr, Value: job})
 			}
 			return true, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
@@ -299,7 +300,12 @@ func (a *Application) checkTemplateName(templateName string, job *model.Job, c e
 	return false, nil
 }
 
+// createJobWorkers enqueues or schedules the first worker of the job, jobs pending approval
+// are only enqueued when they are approved
 func (a *Application) createJobWorkers(job *model.Job, c echo.Context) error {
+	if job.Status == model.JobStatusPendingApproval {
+		return nil
+	}
 	var err error
 	if job.StartsAt != 0 {
 		if len(job.CSVPath) > 0 {
@@ -357,7 +363,7 @@ func (a *Application) GetJobHandler(c echo.Context) error {
 		AppID: aid,
 	}
 	err = WithSegment("db-select", c, func() error {
-		return a.DB.Model(&job).Column("job.*", "App").Where("job.id = ?", job.ID).Select()
+		return a.DB.Model(&job).Column("job.*", "App").Where("job.id = ? AND job.app_id = ?", job.ID, job.AppID).Select()
 	})
 	a.DB.Model(&job.StatusEvents).Where("job_id = ?", job.ID).Column("status.*", "Events").Select()
 	if err != nil {
@@ -369,6 +375,19 @@ func (a *Application) GetJobHandler(c echo.Context) error {
 		})
 		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
+	err = WithSegment("redis-stages", c, func() error {
+		stages, err := worker.GetJobStagesProgress(a.Worker.RedisClient, job.ID.String())
+		if err != nil {
+			return err
+		}
+		job.Stages = model.BuildStageTree(stages)
+		return nil
+	})
+	if err != nil {
+		log.W(l, "Failed to retrieve job stages.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+	}
 	log.D(l, "Retrieved job successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("job", job))
 	})
@@ -393,33 +412,19 @@ func (a *Application) PauseJobHandler(c echo.Context) error {
 	}
 	userEmail := c.Get("user-email").(string)
 	job := &model.Job{
-		ID:        jid,
-		AppID:     aid,
-		CreatedBy: userEmail,
-		Status:    "paused",
-		UpdatedAt: time.Now().UnixNano(),
+		ID:    jid,
+		AppID: aid,
 	}
-	prevJob := &model.Job{}
-	err = WithSegment("db-select", c, func() error {
-		return a.DB.Model(&prevJob).Column("job.*", "App").Where("job.id = ?", job.ID).Select()
+	err = WithSegment("db-update", c, func() error {
+		return model.TransitionJob(a.DB, job, model.JobStatusPaused, fmt.Sprintf("paused by %s", userEmail))
 	})
 	if err != nil {
 		if err.Error() == RecordNotFoundString {
 			return c.JSON(http.StatusNotFound, job)
 		}
-		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
-			cm.Write(zap.Error(err))
-		})
-		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: prevJob})
-	}
-	if prevJob.Status != "" {
-		return c.JSON(http.StatusForbidden, &Error{Reason: fmt.Sprintf("cannot pause %s job", prevJob.Status)})
-	}
-	err = WithSegment("db-update", c, func() error {
-		_, err = a.DB.Model(&job).Column("status").Column("updated_at").Returning("*").Update()
-		return err
-	})
-	if err != nil {
+		if model.IsInvalidJobTransition(err) {
+			return c.JSON(http.StatusForbidden, &Error{Reason: fmt.Sprintf("cannot pause %s job", job.Status)})
+		}
 		log.E(l, "Failed to pause job.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
@@ -443,6 +448,8 @@ func (a *Application) PauseJobHandler(c echo.Context) error {
 		}
 		log.I(l, "Successfully sent email with paused job info.")
 	}
+	a.Worker.NotifyJobEvent(job, model.WebhookEventPaused, "", "")
+	a.Worker.PublishJobProgress(model.NewJobCountersProgress(job))
 	return c.JSON(http.StatusOK, job)
 }
 
@@ -464,26 +471,24 @@ func (a *Application) StopJobHandler(c echo.Context) error {
 	}
 	userEmail := c.Get("user-email").(string)
 	job := &model.Job{
-		ID:        jid,
-		AppID:     aid,
-		CreatedBy: userEmail,
-		Status:    "stopped",
-		UpdatedAt: time.Now().UnixNano(),
+		ID:    jid,
+		AppID: aid,
 	}
-	var values *types.Result
 	err = WithSegment("db-update", c, func() error {
-		values, err = a.DB.Model(&job).Column("status").Column("updated_at").Returning("*").Update()
-		return err
+		return model.TransitionJob(a.DB, job, model.JobStatusStopped, fmt.Sprintf("stopped by %s", userEmail))
 	})
 	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		if model.IsInvalidJobTransition(err) {
+			return c.JSON(http.StatusForbidden, &Error{Reason: fmt.Sprintf("cannot stop %s job", job.Status)})
+		}
 		log.E(l, "Failed to stop job.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
 		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
-	if values.RowsAffected() == 0 {
-		return c.JSON(http.StatusNotFound, map[string]string{})
-	}
 	log.D(l, "Updated job successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("job", job))
 	})
@@ -501,6 +506,8 @@ func (a *Application) StopJobHandler(c echo.Context) error {
 		}
 		log.I(l, "Successfully sent email with stopped job info.")
 	}
+	a.Worker.NotifyJobEvent(job, model.WebhookEventStopped, "", "")
+	a.Worker.PublishJobProgress(model.NewJobCountersProgress(job))
 	return c.JSON(http.StatusOK, job)
 }
 
@@ -521,26 +528,45 @@ func (a *Application) ResumeJobHandler(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
 	}
 	userEmail := c.Get("user-email").(string)
-	prevJob := &model.Job{}
+	job := &model.Job{
+		ID:    jid,
+		AppID: aid,
+	}
 	err = WithSegment("db-select", c, func() error {
-		return a.DB.Model(&prevJob).Column("job.*", "App").Where("job.id = ?", jid).Select()
+		return a.DB.Model(&job).Column("job.*", "App").Where("job.id = ? AND job.app_id = ?", jid, aid).Select()
 	})
 	if err != nil {
 		if err.Error() == RecordNotFoundString {
-			return c.JSON(http.StatusNotFound, prevJob)
+			return c.JSON(http.StatusNotFound, job)
 		}
 		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
 			cm.Write(zap.Error(err))
 		})
-		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: prevJob})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
+	}
+	errResume := &Error{Reason: "cannot resume job with status other than paused/circuitbreak"}
+	if job.Status != model.JobStatusPaused && job.Status != model.JobStatusCircuitBreak {
+		return c.JSON(http.StatusForbidden, errResume)
 	}
-	if prevJob.Status != "paused" && prevJob.Status != "circuitbreak" {
-		return c.JSON(http.StatusForbidden, &Error{Reason: "cannot resume job with status other than paused/circuitbreak"})
+	err = WithSegment("db-update", c, func() error {
+		return model.TransitionJob(a.DB, job, model.JobStatusRunning, fmt.Sprintf("resumed by %s", userEmail))
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		if model.IsInvalidJobTransition(err) {
+			return c.JSON(http.StatusForbidden, errResume)
+		}
+		log.E(l, "Failed to resume job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
 	}
 
 	var wJobID string
 	err = WithSegment("resume-job", c, func() error {
-		wJobID, err = a.Worker.CreateResumeJob(&[]string{prevJob.ID.String()})
+		wJobID, err = a.Worker.CreateResumeJob(&[]string{job.ID.String()})
 		return err
 	})
 
@@ -554,26 +580,9 @@ func (a *Application) ResumeJobHandler(c echo.Context) error {
 	log.I(l, "Job successfully sent to resume_job_worker", func(cm log.CM) {
 		cm.Write(zap.String("workerJobId", wJobID))
 	})
-
-	job := &model.Job{
-		ID:        jid,
-		AppID:     aid,
-		CreatedBy: userEmail,
-		Status:    "",
-		UpdatedAt: time.Now().UnixNano(),
-	}
-	err = WithSegment("db-update", c, func() error {
-		_, err = a.DB.Model(&job).Column("status").Column("updated_at").Returning("*").Update()
-		return err
-	})
-	if err != nil {
-		log.E(l, "Failed to resume job.", func(cm log.CM) {
-			cm.Write(zap.Error(err))
-		})
-		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: job})
-	}
 	log.D(l, "Resumed job successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("job", job))
 	})
+	a.Worker.PublishJobProgress(model.NewJobCountersProgress(job))
 	return c.JSON(http.StatusOK, job)
 }
@@ -260,12 +260,12 @@ var _ = Describe("Job Handler", func() {
 				}
 			})
 
-			It("should return 201 and the created job with filter converting filters to the correct case", func() {
+			It("should return 201 and the created job with the locale and region filters normalized", func() {
 				payload := GetJobPayload()
 				payload["service"] = "gcm"
 				payload["filters"] = map[string]interface{}{
 					"region": "US,CA",
-					"locale": "en,fr",
+					"locale": "EN,pt_BR",
 				}
 				delete(payload, "csvPath")
 				pl, _ := json.Marshal(payload)
@@ -282,7 +282,7 @@ var _ = Describe("Job Handler", func() {
 
 				tempFilters := job["filters"].(map[string]interface{})
 				Expect(tempFilters["region"]).To(Equal("us,ca"))
-				Expect(tempFilters["locale"]).To(Equal("EN,FR"))
+				Expect(tempFilters["locale"]).To(Equal("en,pt-br"))
 
 				id, err := uuid.FromString(job["id"].(string))
 				Expect(err).NotTo(HaveOccurred())
@@ -300,6 +300,89 @@ var _ = Describe("Job Handler", func() {
 				}
 			})
 
+			It("should return 201 and a job group with one job per service", func() {
+				payload := GetJobPayload()
+				payload["service"] = []string{"apns", "gcm"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var group map[string]interface{}
+				err := json.Unmarshal([]byte(body), &group)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(group["id"]).ToNot(BeNil())
+				Expect(group["appId"]).To(Equal(existingApp.ID.String()))
+
+				jobs := group["jobs"].([]interface{})
+				Expect(jobs).To(HaveLen(2))
+				services := []string{}
+				for _, j := range jobs {
+					job := j.(map[string]interface{})
+					services = append(services, job["service"].(string))
+					Expect(job["jobGroupId"]).To(Equal(group["id"]))
+					Expect(job["templateName"]).To(Equal(existingTemplate.Name))
+					Expect(job["startsAt"]).To(BeNumerically("==", payload["startsAt"]))
+					Expect(job["context"]).To(BeEquivalentTo(payload["context"]))
+				}
+				Expect(services).To(ConsistOf("apns", "gcm"))
+
+				progress := group["progress"].(map[string]interface{})
+				Expect(progress["services"]).To(ConsistOf("apns", "gcm"))
+
+				dbJobs := []model.Job{}
+				err = app.DB.Model(&dbJobs).Where("job_group_id = ?", group["id"]).Select()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJobs).To(HaveLen(2))
+				Expect(dbJobs[0].ID).NotTo(Equal(dbJobs[1].ID))
+			})
+
+			It("should return 201 and a job group with every service if service is all", func() {
+				payload := GetJobPayload()
+				payload["service"] = "all"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var group map[string]interface{}
+				err := json.Unmarshal([]byte(body), &group)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(group["jobs"]).To(HaveLen(len(model.AllServices)))
+			})
+
+			It("should return 201 and a single job if service is a list with one service", func() {
+				payload := GetJobPayload()
+				payload["service"] = []string{"gcm"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job map[string]interface{}
+				err := json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job["service"]).To(Equal("gcm"))
+			})
+
+			It("should return 201 and the created job with the current template versions pinned", func() {
+				_, err := app.DB.Exec("UPDATE templates SET version = 3 WHERE id = ?", existingTemplate.ID)
+				Expect(err).NotTo(HaveOccurred())
+				payload := GetJobPayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var job model.Job
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.TemplateVersions).To(Equal(model.TemplateVersions{
+					existingTemplate.Name: {existingTemplate.Locale: 3},
+				}))
+
+				dbJob := &model.Job{ID: job.ID}
+				err = app.DB.Select(dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.TemplateVersions).To(Equal(job.TemplateVersions))
+			})
+
 			It("should return 201 and the created job with localized set to false by default", func() {
 				payload := GetJobPayload()
 				delete(payload, "csvPath")
@@ -842,6 +925,30 @@ var _ = Describe("Job Handler", func() {
 				Expect(response["reason"]).To(Equal("Cannot create job if there is no template for locale 'en'."))
 			})
 
+			It("should return 422 if template with given name does not have the app default locale", func() {
+				ptApp := CreateTestApp(app.DB, map[string]interface{}{"defaultLocale": "pt"})
+				enTemplate := CreateTestTemplate(app.DB, ptApp.ID, map[string]interface{}{"locale": "en"})
+				payload := GetJobPayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, fmt.Sprintf("/apps/%s/jobs?template=%s", ptApp.ID, enTemplate.Name), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("Cannot create job if there is no template for locale 'pt'."))
+			})
+
+			It("should return 201 if the template has only the app default locale", func() {
+				ptApp := CreateTestApp(app.DB, map[string]interface{}{"defaultLocale": "pt"})
+				ptTemplate := CreateTestTemplate(app.DB, ptApp.ID, map[string]interface{}{"locale": "pt"})
+				payload := GetJobPayload()
+				delete(payload, "filters")
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, fmt.Sprintf("/apps/%s/jobs?template=%s", ptApp.ID, ptTemplate.Name), string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+			})
+
 			It("should return 422 if template is not specified", func() {
 				payload := GetJobPayload()
 				pl, _ := json.Marshal(payload)
@@ -906,6 +1013,22 @@ var _ = Describe("Job Handler", func() {
 				Expect(response["reason"]).To(Equal("invalid service"))
 			})
 
+			It("should return 422 if invalid pushOptions", func() {
+				payload := GetJobPayload()
+				payload["pushOptions"] = map[string]interface{}{
+					"format": "v1",
+					"apns":   map[string]interface{}{"priority": 1},
+				}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid pushOptions: apns priority must be 5 or 10"))
+			})
+
 			It("should return 422 if invalid context", func() {
 				payload := GetJobPayload()
 				payload["context"] = "not-json"
@@ -932,6 +1055,32 @@ var _ = Describe("Job Handler", func() {
 				Expect(response["reason"]).To(Equal("invalid service"))
 			})
 
+			It("should return 422 if a service is repeated", func() {
+				payload := GetJobPayload()
+				payload["service"] = []string{"apns", "apns"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid service"))
+			})
+
+			It("should return 422 if one of the services is invalid", func() {
+				payload := GetJobPayload()
+				payload["service"] = []string{"apns", "blabla"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid service"))
+			})
+
 			It("should return 422 if invalid filters", func() {
 				payload := GetJobPayload()
 				payload["filters"] = "not-json"
@@ -1040,6 +1189,39 @@ var _ = Describe("Job Handler", func() {
 				for key := range plMetadata {
 					Expect(tempMetadata[key]).To(Equal(plMetadata[key]))
 				}
+				Expect(job).NotTo(HaveKey("stages"))
+			})
+
+			It("should return the stage tree of the job", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				jobID := existingJob.ID.String()
+				s2, err := worker.GetStageStatus(app.Worker.RedisClient, jobID, worker.StageCreateBatches, "create batches", 2)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s2.IncrProgress()).To(Succeed())
+				s2_1, err := s2.GetSubStage(1, "create batches of part 1", 4)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s2_1.SetProgress(4, 4)).To(Succeed())
+				_, err = worker.NewStageStatus(app.Worker.RedisClient, jobID, worker.StageSplitCSV, "split csv", 1)
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Get(app, fmt.Sprintf("%s/%s", baseRouteWithoutTemplate, existingJob.ID), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				var job model.Job
+				err = json.Unmarshal([]byte(body), &job)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.Stages).To(HaveLen(2))
+				Expect(job.Stages[0].Stage).To(Equal("1"))
+				Expect(job.Stages[0].Percent).To(Equal(float64(0)))
+				Expect(job.Stages[0].SubStages).To(BeEmpty())
+				Expect(job.Stages[1].Stage).To(Equal("2"))
+				Expect(job.Stages[1].Description).To(Equal("create batches"))
+				Expect(job.Stages[1].Percent).To(Equal(float64(50)))
+				Expect(job.Stages[1].SubStages).To(HaveLen(1))
+				Expect(job.Stages[1].SubStages[0].Stage).To(Equal("2.1"))
+				Expect(job.Stages[1].SubStages[0].Percent).To(Equal(float64(100)))
+				Expect(job.Stages[1].SubStages[0].CompletedAt).To(BeNumerically(">", 0))
+				Expect(job.Stages[1].SubStages[0].ETA).To(Equal(job.Stages[1].SubStages[0].CompletedAt))
 			})
 		})
 
@@ -1123,6 +1305,21 @@ var _ = Describe("Job Handler", func() {
 				Expect(dbJob.ID).To(Equal(existingJob.ID))
 				Expect(dbJob.Status).To(Equal("paused"))
 			})
+
+			It("should record the transition in the job status events", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				status, _ := Put(app, fmt.Sprintf("%s/%s/pause", baseRouteWithoutTemplate, existingJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+
+				statuses := []*model.Status{}
+				err := app.DB.Model(&statuses).Where("job_id = ?", existingJob.ID).Column("status.*", "Events").Select()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(statuses).To(HaveLen(1))
+				Expect(statuses[0].Name).To(Equal(model.JobStateStatusName))
+				Expect(statuses[0].Events).To(HaveLen(1))
+				Expect(statuses[0].Events[0].Message).To(Equal("scheduled -> paused: paused by success@test.com"))
+				Expect(statuses[0].Events[0].State).To(Equal("running"))
+			})
 		})
 
 		Describe("Unsucesfully", func() {
@@ -1148,7 +1345,20 @@ var _ = Describe("Job Handler", func() {
 				Expect(status).To(Equal(http.StatusNotFound))
 			})
 
-			It("should return 403 if job status is not empty", func() {
+			It("should return 404 if the job belongs to another app", func() {
+				otherApp := CreateTestApp(app.DB)
+				otherTemplate := CreateTestTemplate(app.DB, otherApp.ID)
+				otherJob := CreateTestJob(app.DB, otherApp.ID, otherTemplate.Name)
+				status, _ := Put(app, fmt.Sprintf("%s/%s/pause", baseRouteWithoutTemplate, otherJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+
+				dbJob := &model.Job{ID: otherJob.ID}
+				err := app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Status).To(Equal(model.JobStatusScheduled))
+			})
+
+			It("should return 403 if the job cannot be paused", func() {
 				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
 				_, err := app.DB.Model(&model.Job{}).Set("status = 'stopped'").Where("id = ?", existingJob.ID).Update()
 				Expect(err).NotTo(HaveOccurred())
@@ -1217,10 +1427,41 @@ var _ = Describe("Job Handler", func() {
 				app.DB = goodDB
 			})
 
+			It("should return 403 if the job is completed", func() {
+				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				_, err := app.DB.Model(&model.Job{}).Set("status = 'completed'").Where("id = ?", existingJob.ID).Update()
+				Expect(err).NotTo(HaveOccurred())
+				status, body := Put(app, fmt.Sprintf("%s/%s/stop", baseRouteWithoutTemplate, existingJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusForbidden))
+
+				var response map[string]interface{}
+				err = json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("cannot stop completed job"))
+
+				dbJob := &model.Job{ID: existingJob.ID}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Status).To(Equal(model.JobStatusCompleted))
+			})
+
 			It("should return 404 if the job does not exist", func() {
 				status, _ := Put(app, fmt.Sprintf("%s/%s/stop", baseRouteWithoutTemplate, uuid.NewV4().String()), "", "test@test.com")
 				Expect(status).To(Equal(http.StatusNotFound))
 			})
+
+			It("should return 404 if the job belongs to another app", func() {
+				otherApp := CreateTestApp(app.DB)
+				otherTemplate := CreateTestTemplate(app.DB, otherApp.ID)
+				otherJob := CreateTestJob(app.DB, otherApp.ID, otherTemplate.Name)
+				status, _ := Put(app, fmt.Sprintf("%s/%s/stop", baseRouteWithoutTemplate, otherJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+
+				dbJob := &model.Job{ID: otherJob.ID}
+				err := app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Status).To(Equal(model.JobStatusScheduled))
+			})
 		})
 	})
 
@@ -1240,7 +1481,7 @@ var _ = Describe("Job Handler", func() {
 
 				Expect(job["id"]).ToNot(BeNil())
 				Expect(job["appId"]).To(Equal(existingApp.ID.String()))
-				Expect(job["status"]).To(Equal(""))
+				Expect(job["status"]).To(Equal(model.JobStatusRunning))
 
 				id, err := uuid.FromString(job["id"].(string))
 				Expect(err).NotTo(HaveOccurred())
@@ -1251,7 +1492,7 @@ var _ = Describe("Job Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(dbJob.ID).ToNot(BeNil())
 				Expect(dbJob.AppID).To(Equal(existingApp.ID))
-				Expect(dbJob.Status).To(Equal(""))
+				Expect(dbJob.Status).To(Equal(model.JobStatusRunning))
 
 				res, err := w.RedisClient.LLen("queue:resume_job_worker").Result()
 				Expect(err).NotTo(HaveOccurred())
@@ -1289,6 +1530,21 @@ var _ = Describe("Job Handler", func() {
 				Expect(status).To(Equal(http.StatusNotFound))
 			})
 
+			It("should return 404 if the job belongs to another app", func() {
+				otherApp := CreateTestApp(app.DB)
+				otherTemplate := CreateTestTemplate(app.DB, otherApp.ID)
+				otherJob := CreateTestJob(app.DB, otherApp.ID, otherTemplate.Name)
+				_, err := app.DB.Model(&model.Job{}).Set("status = 'paused'").Where("id = ?", otherJob.ID).Update()
+				Expect(err).NotTo(HaveOccurred())
+				status, _ := Put(app, fmt.Sprintf("%s/%s/resume", baseRouteWithoutTemplate, otherJob.ID), "", "success@test.com")
+				Expect(status).To(Equal(http.StatusNotFound))
+
+				dbJob := &model.Job{ID: otherJob.ID}
+				err = app.DB.Select(&dbJob)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbJob.Status).To(Equal(model.JobStatusPaused))
+			})
+
 			It("should return 403 if job status is not paused/circuitbreak", func() {
 				existingJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
 				_, err := app.DB.Model(&model.Job{}).Set("status = 'stopped'").Where("id = ?", existingJob.ID).Update()
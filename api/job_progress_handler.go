@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+// defaultProgressStreamHeartbeat is the interval of the keep alive comments when
+// api.progressStream.heartbeat is not set
+const defaultProgressStreamHeartbeat = 15 * time.Second
+
+// StreamJobProgressHandler is the method called when a get to /apps/:aid/jobs/:jid/progress/stream
+// is called. It sends the job progress as server-sent events, the first event has the whole progress
+// and the next ones the progress updates published by the workers to the job progress channel
+func (a *Application) StreamJobProgressHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "jobProgressHandler"),
+		zap.String("operation", "streamJobProgress"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("jobId", c.Param("jid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	jid, err := uuid.FromString(c.Param("jid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+
+	// subscribe before reading the job so no update between the read and the subscription is lost
+	pubsub, err := a.Worker.RedisClient.Subscribe(model.JobProgressChannel(jid.String()))
+	if err != nil {
+		log.E(l, "Failed to subscribe to job progress.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	defer pubsub.Close()
+
+	job := &model.Job{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(job).Where("id = ? AND app_id = ?", jid, aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve job.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	progress := model.NewJobCountersProgress(job)
+	progress.Feedbacks = job.Feedbacks
+	progress.Stages, err = worker.GetJobStagesProgress(a.Worker.RedisClient, jid.String())
+	if err != nil {
+		log.E(l, "Failed to retrieve job stages.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	snapshot, err := json.Marshal(progress)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if err := writeServerSentEvent(res, "progress", string(snapshot)); err != nil {
+		return nil
+	}
+	log.D(l, "Streaming job progress.")
+
+	done := make(chan struct{})
+	defer close(done)
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		for {
+			msg, err := pubsub.ReceiveMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- msg.Payload:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	heartbeat := a.Config.GetDuration("api.progressStream.heartbeat")
+	if heartbeat <= 0 {
+		heartbeat = defaultProgressStreamHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			log.D(l, "Client closed the job progress stream.")
+			return nil
+		case payload, ok := <-messages:
+			if !ok {
+				log.W(l, "Job progress subscription closed.")
+				return nil
+			}
+			if err := writeServerSentEvent(res, "progress", payload); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := res.Write([]byte(": keep-alive\n\n")); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// writeServerSentEvent writes one event to the stream and flushes it to the client
+func writeServerSentEvent(res *echo.Response, event, data string) error {
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
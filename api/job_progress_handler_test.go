@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Job Progress Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var existingApp *model.App
+	var existingJob *model.Job
+	var server *httptest.Server
+
+	w := worker.NewWorker(logger, GetConfPath())
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		w.RedisClient.FlushAll()
+		CreateTestUser(app.DB, map[string]interface{}{"email": "test@test.com", "isAdmin": true})
+		existingApp = CreateTestApp(app.DB)
+		template := CreateTestTemplate(app.DB, existingApp.ID)
+		existingJob = CreateTestJob(app.DB, existingApp.ID, template.Name)
+		server = httptest.NewServer(app.API)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	openStream := func(jobID uuid.UUID) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/apps/%s/jobs/%s/progress/stream", server.URL, existingApp.ID, jobID), nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Header.Add("x-forwarded-email", "test@test.com")
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return res, bufio.NewReader(res.Body)
+	}
+
+	readEvent := func(reader *bufio.Reader) (string, map[string]interface{}) {
+		var event, data string
+		for {
+			line, err := reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "" && data != "":
+				var progress map[string]interface{}
+				Expect(json.Unmarshal([]byte(data), &progress)).To(Succeed())
+				return event, progress
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+
+	It("should send the current progress and then the published updates", func() {
+		_, err := app.DB.Exec(
+			"UPDATE jobs SET total_batches = 10, completed_batches = 3, completed_tokens = 300, feedbacks = '{\"ack\": 250}' WHERE id = ?",
+			existingJob.ID,
+		)
+		Expect(err).NotTo(HaveOccurred())
+		stage, err := worker.NewStageStatus(w.RedisClient, existingJob.ID.String(), "1", "create batches", 4)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stage.IncrProgress()).To(Succeed())
+
+		res, reader := openStream(existingJob.ID)
+		defer res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+
+		event, progress := readEvent(reader)
+		Expect(event).To(Equal("progress"))
+		Expect(progress["jobId"]).To(Equal(existingJob.ID.String()))
+		counters := progress["counters"].(map[string]interface{})
+		Expect(counters["totalBatches"]).To(BeEquivalentTo(10))
+		Expect(counters["completedBatches"]).To(BeEquivalentTo(3))
+		Expect(counters["completedTokens"]).To(BeEquivalentTo(300))
+		Expect(progress["feedbacks"]).To(Equal(map[string]interface{}{"ack": float64(250)}))
+		Expect(progress["stages"]).To(Equal(map[string]interface{}{
+			"1": map[string]interface{}{"description": "create batches", "current": float64(1), "max": float64(4)},
+		}))
+
+		Expect(stage.IncrProgress()).To(Succeed())
+		_, progress = readEvent(reader)
+		Expect(progress).NotTo(HaveKey("counters"))
+		Expect(progress["stages"].(map[string]interface{})["1"]).To(HaveKeyWithValue("current", float64(2)))
+
+		existingJob.CompletedBatches = 4
+		w.PublishJobProgress(model.NewJobCountersProgress(existingJob))
+		_, progress = readEvent(reader)
+		Expect(progress["counters"].(map[string]interface{})["completedBatches"]).To(BeEquivalentTo(4))
+	})
+
+	It("should send the status of paused jobs", func() {
+		res, reader := openStream(existingJob.ID)
+		defer res.Body.Close()
+		readEvent(reader)
+
+		status, _ := Put(app, fmt.Sprintf("/apps/%s/jobs/%s/pause", existingApp.ID, existingJob.ID), "", "test@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+		_, progress := readEvent(reader)
+		Expect(progress["counters"].(map[string]interface{})["status"]).To(Equal("paused"))
+	})
+
+	It("should return 404 if the job does not exist", func() {
+		status, _ := Get(app, fmt.Sprintf("/apps/%s/jobs/%s/progress/stream", existingApp.ID, uuid.NewV4()), "test@test.com")
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+
+	It("should return 404 if the job is of another app", func() {
+		otherApp := CreateTestApp(app.DB)
+		status, _ := Get(app, fmt.Sprintf("/apps/%s/jobs/%s/progress/stream", otherApp.ID, existingJob.ID), "test@test.com")
+		Expect(status).To(Equal(http.StatusNotFound))
+	})
+})
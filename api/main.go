@@ -54,6 +54,7 @@ type Application struct {
 	Worker         *worker.Worker
 	S3Client       interfaces.S3
 	SendgridClient *extensions.SendgridClient
+	Authenticators []Authenticator
 }
 
 // GetApplication returns a configured api
@@ -109,6 +110,11 @@ func (a *Application) configure() error {
 		return err
 	}
 
+	err = a.configureAuthenticators()
+	if err != nil {
+		return err
+	}
+
 	a.configureApplication()
 	a.configureWorker()
 	a.configureSentry()
@@ -188,6 +194,7 @@ func (a *Application) configureApplication() {
 	appGroup.Use(NewVersionMiddleware().Serve)
 	appGroup.Use(NewSentryMiddleware(a).Serve)
 	appGroup.Use(NewNewRelicMiddleware(a, a.Logger).Serve)
+	appGroup.Use(NewAuditMiddleware(a).Serve)
 
 	// Apps Routes
 	appGroup.POST("", a.PostAppHandler)
@@ -199,9 +206,17 @@ func (a *Application) configureApplication() {
 	// Templates Routes
 	appGroup.POST("/:aid/templates", a.PostTemplateHandler)
 	appGroup.GET("/:aid/templates", a.ListTemplatesHandler)
+	appGroup.GET("/:aid/templates/export", a.ExportTemplatesHandler)
+	appGroup.POST("/:aid/templates/import", a.ImportTemplatesHandler)
 	appGroup.GET("/:aid/templates/:tid", a.GetTemplateHandler)
 	appGroup.PUT("/:aid/templates/:tid", a.PutTemplateHandler)
 	appGroup.DELETE("/:aid/templates/:tid", a.DeleteTemplateHandler)
+	appGroup.POST("/:aid/templates/:tid/preview", a.PreviewTemplateHandler)
+	appGroup.GET("/:aid/templates/:tid/jobs", a.TemplateJobsHandler)
+	appGroup.GET("/:aid/templates/:tid/versions", a.ListTemplateVersionsHandler)
+	appGroup.GET("/:aid/templates/:tid/versions/:version", a.GetTemplateVersionHandler)
+	appGroup.POST("/:aid/templates/:tid/versions/:version/rollback", a.RollbackTemplateHandler)
+	appGroup.GET("/:aid/templates/:tid/diff", a.DiffTemplateVersionsHandler)
 
 	// Jobs Routes
 	appGroup.POST("/:aid/jobs", a.PostJobHandler)
@@ -210,6 +225,19 @@ func (a *Application) configureApplication() {
 	appGroup.PUT("/:aid/jobs/:jid/pause", a.PauseJobHandler)
 	appGroup.PUT("/:aid/jobs/:jid/stop", a.StopJobHandler)
 	appGroup.PUT("/:aid/jobs/:jid/resume", a.ResumeJobHandler)
+	appGroup.PUT("/:aid/jobs/:jid/approve", a.ApproveJobHandler)
+	appGroup.PUT("/:aid/jobs/:jid/reject", a.RejectJobHandler)
+	appGroup.GET("/:aid/jobs/:jid/approvals", a.ListJobApprovalsHandler)
+	appGroup.GET("/:aid/jobs/:jid/progress/stream", a.StreamJobProgressHandler)
+	appGroup.GET("/:aid/jobgroups/:gid", a.GetJobGroupHandler)
+
+	// Webhook Routes
+	appGroup.GET("/:aid/webhooks", a.ListWebhooksHandler)
+	appGroup.POST("/:aid/webhooks", a.PostWebhookHandler)
+	appGroup.GET("/:aid/webhooks/:wid", a.GetWebhookHandler)
+	appGroup.PUT("/:aid/webhooks/:wid", a.PutWebhookHandler)
+	appGroup.DELETE("/:aid/webhooks/:wid", a.DeleteWebhookHandler)
+	appGroup.GET("/:aid/webhooks/:wid/deliveries", a.ListWebhookDeliveriesHandler)
 
 	userGroup := e.Group("/users")
 	// AuthMiddleware MUST be the first middleware
@@ -219,6 +247,7 @@ func (a *Application) configureApplication() {
 	userGroup.Use(NewVersionMiddleware().Serve)
 	userGroup.Use(NewSentryMiddleware(a).Serve)
 	userGroup.Use(NewNewRelicMiddleware(a, a.Logger).Serve)
+	userGroup.Use(NewAuditMiddleware(a).Serve)
 
 	// User Routes
 	userGroup.GET("", a.ListUsersHandler)
@@ -226,6 +255,36 @@ func (a *Application) configureApplication() {
 	userGroup.GET("/:uid", a.GetUserHandler)
 	userGroup.PUT("/:uid", a.UpdateUserHandler)
 	userGroup.DELETE("/:uid", a.DeleteUserHandler)
+	userGroup.GET("/:uid/roles", a.GetUserRolesHandler)
+	userGroup.PUT("/:uid/roles/:aid", a.PutUserRolesHandler)
+	userGroup.DELETE("/:uid/roles/:aid", a.DeleteUserRolesHandler)
+
+	apiKeyGroup := e.Group("/apikeys")
+	// AuthMiddleware MUST be the first middleware
+	apiKeyGroup.Use(NewUserAuthMiddleware(a).Serve)
+	apiKeyGroup.Use(NewLoggerMiddleware(a.Logger).Serve)
+	apiKeyGroup.Use(NewRecoveryMiddleware(a.OnErrorHandler).Serve)
+	apiKeyGroup.Use(NewVersionMiddleware().Serve)
+	apiKeyGroup.Use(NewSentryMiddleware(a).Serve)
+	apiKeyGroup.Use(NewNewRelicMiddleware(a, a.Logger).Serve)
+	apiKeyGroup.Use(NewAuditMiddleware(a).Serve)
+
+	// API Key Routes
+	apiKeyGroup.GET("", a.ListAPIKeysHandler)
+	apiKeyGroup.POST("", a.CreateAPIKeyHandler)
+	apiKeyGroup.DELETE("/:kid", a.RevokeAPIKeyHandler)
+
+	auditGroup := e.Group("/audit")
+	// AuthMiddleware MUST be the first middleware
+	auditGroup.Use(NewUserAuthMiddleware(a).Serve)
+	auditGroup.Use(NewLoggerMiddleware(a.Logger).Serve)
+	auditGroup.Use(NewRecoveryMiddleware(a.OnErrorHandler).Serve)
+	auditGroup.Use(NewVersionMiddleware().Serve)
+	auditGroup.Use(NewSentryMiddleware(a).Serve)
+	auditGroup.Use(NewNewRelicMiddleware(a, a.Logger).Serve)
+
+	// Audit Routes
+	auditGroup.GET("", a.ListAuditLogsHandler)
 
 	a.API = e
 }
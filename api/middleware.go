@@ -34,8 +34,6 @@ import (
 	uuid "github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
 	"github.com/uber-go/zap"
-
-	"github.com/topfreegames/marathon/model"
 )
 
 //NewVersionMiddleware with API version
@@ -257,7 +255,7 @@ func (nr *NewRelicMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-//AppAuthMiddleware automatically adds a version header to response
+//AppAuthMiddleware validates that the user has the permission required by the route in the app
 type AppAuthMiddleware struct {
 	App *Application
 }
@@ -265,21 +263,11 @@ type AppAuthMiddleware struct {
 //Serve Validate that a user exists
 func (a AppAuthMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		userEmail := c.Request().Header.Get("x-forwarded-email")
-		if userEmail == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
-		}
-		c.Set("user-email", userEmail)
-		user := &model.User{}
-		err := WithSegment("db-select", c, func() error {
-			return a.App.DB.Model(&user).Column("*").Where("email = ?", userEmail).Select()
-		})
-		if err != nil {
-			if err.Error() == RecordNotFoundString {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
-			}
-			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		user, err := a.App.authenticatedUser(c)
+		if user == nil {
+			return err
 		}
+		c.Set("user", user)
 		path := c.Path()
 		if path == "/apps" {
 			return next(c)
@@ -291,10 +279,8 @@ func (a AppAuthMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
 		if err != nil {
 			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
 		}
-		for _, appID := range user.AllowedApps {
-			if appID == aid {
-				return next(c)
-			}
+		if user.Can(aid, routePermission(c.Request().Method, path)) {
+			return next(c)
 		}
 		return c.JSON(http.StatusForbidden, map[string]string{"status": "Forbidden."})
 	}
@@ -315,20 +301,9 @@ type UserAuthMiddleware struct {
 //Serve Validate that a user exists
 func (a UserAuthMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		userEmail := c.Request().Header.Get("x-forwarded-email")
-		if userEmail == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
-		}
-		c.Set("user-email", userEmail)
-		user := &model.User{}
-		err := WithSegment("db-select", c, func() error {
-			return a.App.DB.Model(&user).Column("*").Where("email = ?", userEmail).Select()
-		})
-		if err != nil {
-			if err.Error() == RecordNotFoundString {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
-			}
-			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		user, err := a.App.authenticatedUser(c)
+		if user == nil {
+			return err
 		}
 		path := c.Path()
 		if path == "/users" {
@@ -356,20 +331,9 @@ type UploadAuthMiddleware struct {
 //Serve Validate that a user exists
 func (a UploadAuthMiddleware) Serve(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		userEmail := c.Request().Header.Get("x-forwarded-email")
-		if userEmail == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
-		}
-		c.Set("user-email", userEmail)
-		user := &model.User{}
-		err := WithSegment("db-select", c, func() error {
-			return a.App.DB.Model(&user).Column("*").Where("email = ?", userEmail).Select()
-		})
-		if err != nil {
-			if err.Error() == RecordNotFoundString {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"status": "Unauthorized."})
-			}
-			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		user, err := a.App.authenticatedUser(c)
+		if user == nil {
+			return err
 		}
 		return next(c)
 	}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/topfreegames/marathon/model"
+)
+
+// routePermissions maps the app routes to the permission they require, other GET routes
+// require model.PermissionView and other routes model.PermissionManageApp
+var routePermissions = map[string]string{
+	"PUT /apps/:aid":    model.PermissionManageApp,
+	"DELETE /apps/:aid": model.PermissionManageApp,
+
+	"POST /apps/:aid/templates":                                 model.PermissionEditTemplates,
+	"POST /apps/:aid/templates/import":                          model.PermissionEditTemplates,
+	"PUT /apps/:aid/templates/:tid":                             model.PermissionEditTemplates,
+	"DELETE /apps/:aid/templates/:tid":                          model.PermissionEditTemplates,
+	"POST /apps/:aid/templates/:tid/versions/:version/rollback": model.PermissionEditTemplates,
+	"POST /apps/:aid/templates/:tid/preview":                    model.PermissionView,
+
+	"POST /apps/:aid/jobs":            model.PermissionSendJobs,
+	"PUT /apps/:aid/jobs/:jid/pause":  model.PermissionSendJobs,
+	"PUT /apps/:aid/jobs/:jid/stop":   model.PermissionSendJobs,
+	"PUT /apps/:aid/jobs/:jid/resume": model.PermissionSendJobs,
+
+	"PUT /apps/:aid/jobs/:jid/approve": model.PermissionApproveJobs,
+	"PUT /apps/:aid/jobs/:jid/reject":  model.PermissionApproveJobs,
+
+	"GET /apps/:aid/webhooks":                 model.PermissionManageApp,
+	"GET /apps/:aid/webhooks/:wid":            model.PermissionManageApp,
+	"GET /apps/:aid/webhooks/:wid/deliveries": model.PermissionManageApp,
+}
+
+// routePermission returns the permission required to call the route
+func routePermission(method, path string) string {
+	if permission, ok := routePermissions[fmt.Sprintf("%s %s", method, path)]; ok {
+		return permission
+	}
+	if method == http.MethodGet {
+		return model.PermissionView
+	}
+	return model.PermissionManageApp
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Permissions", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var existingApp *model.App
+	var existingTemplate *model.Template
+	var existingJob *model.Job
+
+	createUserWithRoles := func(email string, roles ...string) {
+		CreateTestUser(app.DB, map[string]interface{}{
+			"email":   email,
+			"isAdmin": false,
+			"roles":   model.AppRoles{existingApp.ID.String(): roles},
+		})
+	}
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM jobs;")
+		app.DB.Exec("DELETE FROM users;")
+		existingApp = CreateTestApp(app.DB)
+		existingTemplate = CreateTestTemplate(app.DB, existingApp.ID)
+		existingJob = CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+	})
+
+	It("should allow viewers to read the app but not to change it", func() {
+		createUserWithRoles("viewer@test.com", model.RoleViewer)
+
+		status, _ := Get(app, fmt.Sprintf("/apps/%s/templates", existingApp.ID), "viewer@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = Get(app, fmt.Sprintf("/apps/%s/jobs/%s", existingApp.ID, existingJob.ID), "viewer@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+
+		pl, _ := json.Marshal(GetTemplatePayload())
+		status, _ = Post(app, fmt.Sprintf("/apps/%s/templates", existingApp.ID), string(pl), "viewer@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+		status, _ = Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, existingJob.ID), "", "viewer@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+		status, _ = Delete(app, fmt.Sprintf("/apps/%s", existingApp.ID), "viewer@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+
+	It("should allow editors to change templates but not to send jobs", func() {
+		createUserWithRoles("editor@test.com", model.RoleEditor)
+
+		pl, _ := json.Marshal(GetTemplatePayload())
+		status, _ := Post(app, fmt.Sprintf("/apps/%s/templates", existingApp.ID), string(pl), "editor@test.com")
+		Expect(status).To(Equal(http.StatusCreated))
+
+		status, _ = Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, existingJob.ID), "", "editor@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+		status, _ = Put(app, fmt.Sprintf("/apps/%s", existingApp.ID), "{}", "editor@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+
+	It("should allow senders to manage jobs but not to change templates", func() {
+		createUserWithRoles("sender@test.com", model.RoleSender)
+
+		status, _ := Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, existingJob.ID), "", "sender@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+
+		status, _ = Delete(app, fmt.Sprintf("/apps/%s/templates/%s", existingApp.ID, existingTemplate.ID), "sender@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+
+	It("should combine the permissions of all roles of the user", func() {
+		createUserWithRoles("both@test.com", model.RoleEditor, model.RoleSender)
+
+		status, _ := Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, existingJob.ID), "", "both@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+		status, _ = Delete(app, fmt.Sprintf("/apps/%s/templates/%s", existingApp.ID, existingTemplate.ID), "both@test.com")
+		Expect(status).To(Equal(http.StatusNoContent))
+	})
+
+	It("should allow app admins to manage the app", func() {
+		createUserWithRoles("appadmin@test.com", model.RoleAdmin)
+
+		status, _ := Delete(app, fmt.Sprintf("/apps/%s", existingApp.ID), "appadmin@test.com")
+		Expect(status).To(Equal(http.StatusNoContent))
+	})
+
+	It("should return 403 if the user has no roles in the app", func() {
+		CreateTestUser(app.DB, map[string]interface{}{
+			"email":   "other@test.com",
+			"isAdmin": false,
+			"roles":   model.AppRoles{uuid.NewV4().String(): []string{model.RoleAdmin}},
+		})
+
+		status, _ := Get(app, fmt.Sprintf("/apps/%s", existingApp.ID), "other@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+
+	It("should return 403 if the user cannot view the app templates are copied from", func() {
+		createUserWithRoles("editor@test.com", model.RoleEditor)
+		otherApp := CreateTestApp(app.DB)
+
+		status, _ := Post(app, fmt.Sprintf("/apps/%s/templates/import?from=%s", existingApp.ID, otherApp.ID), "", "editor@test.com")
+		Expect(status).To(Equal(http.StatusForbidden))
+	})
+})
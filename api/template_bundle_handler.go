@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	pg "gopkg.in/pg.v5"
+)
+
+// getAppTemplates returns the app with id aid and its templates
+func (a *Application) getAppTemplates(c echo.Context, aid uuid.UUID) (*model.App, []model.Template, error) {
+	app := &model.App{ID: aid}
+	templates := []model.Template{}
+	err := WithSegment("db-select", c, func() error {
+		err := a.DB.Select(app)
+		if err != nil {
+			return err
+		}
+		return a.DB.Model(&templates).Column("template.*").Where("template.app_id = ?", aid).Select()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return app, templates, nil
+}
+
+// bundleFormat returns the format query param or, if not set, yaml if the request content type is yaml
+func bundleFormat(c echo.Context) string {
+	format := c.QueryParam("format")
+	if format == "" && strings.Contains(c.Request().Header.Get(echo.HeaderContentType), "yaml") {
+		return model.BundleFormatYAML
+	}
+	return format
+}
+
+// ExportTemplatesHandler is the method called when a get to /apps/:aid/templates/export is called
+func (a *Application) ExportTemplatesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateBundleHandler"),
+		zap.String("operation", "exportTemplates"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	format := c.QueryParam("format")
+	if !model.IsValidBundleFormat(format) {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: model.InvalidField("format").Error()})
+	}
+	app, templates, err := a.getAppTemplates(c, aid)
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	bundle := model.NewTemplateBundle(app, templates, time.Now().UnixNano())
+	data, err := bundle.Marshal(format)
+	if err != nil {
+		log.E(l, "Failed to encode templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Exported templates successfully.", func(cm log.CM) {
+		cm.Write(zap.Int("templates", len(bundle.Templates)))
+	})
+	if format == model.BundleFormatYAML {
+		return c.Blob(http.StatusOK, "application/x-yaml", data)
+	}
+	return c.JSONBlob(http.StatusOK, data)
+}
+
+// ImportTemplatesHandler is the method called when a post to /apps/:aid/templates/import is called,
+// the bundle is the request body or the templates of the app in the from query param
+func (a *Application) ImportTemplatesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateBundleHandler"),
+		zap.String("operation", "importTemplates"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("from", c.QueryParam("from")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	_, templates, err := a.getAppTemplates(c, aid)
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	bundle := &model.TemplateBundle{}
+	if from := c.QueryParam("from"); from != "" {
+		fromID, err := uuid.FromString(from)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+		}
+		// the templates of another app are only copied for users that can view them
+		user, ok := c.Get("user").(*model.User)
+		if !ok || !user.Can(fromID, model.PermissionView) {
+			return c.JSON(http.StatusForbidden, map[string]string{"status": "Forbidden."})
+		}
+		fromApp, fromTemplates, err := a.getAppTemplates(c, fromID)
+		if err != nil {
+			if err.Error() == RecordNotFoundString {
+				return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given from id."})
+			}
+			log.E(l, "Failed to retrieve templates.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+			return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+		}
+		bundle = model.NewTemplateBundle(fromApp, fromTemplates, time.Now().UnixNano())
+		err = bundle.Validate(c)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: bundle})
+		}
+	} else {
+		format := bundleFormat(c)
+		if !model.IsValidBundleFormat(format) {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: model.InvalidField("format").Error()})
+		}
+		err = WithSegment("decodeAndValidate", c, func() error {
+			return decodeAndValidateTemplateBundle(c, format, bundle)
+		})
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+		}
+	}
+
+	plan := model.PlanTemplateImport(bundle, templates, c.QueryParam("upsert") == "true")
+	plan.DryRun = c.QueryParam("dryRun") == "true"
+	if plan.DryRun {
+		return c.JSON(http.StatusOK, plan)
+	}
+	if len(plan.Conflicts) > 0 {
+		return c.JSON(http.StatusConflict, plan)
+	}
+
+	email := c.Get("user-email").(string)
+	now := time.Now().UnixNano()
+	for _, t := range plan.ToCreate {
+		t.ID = uuid.NewV4()
+		t.AppID = aid
+		t.CreatedBy = email
+		t.CreatedAt = now
+		t.UpdatedAt = now
+	}
+	for _, t := range plan.ToUpdate {
+		t.CreatedBy = email
+		t.UpdatedAt = now
+	}
+	err = WithSegment("db-import", c, func() error {
+		return InTransaction(a.DB, func(tx *pg.Tx) error {
+			for _, t := range plan.ToCreate {
+				if err := insertTemplate(tx, t); err != nil {
+					return err
+				}
+			}
+			for _, t := range plan.ToUpdate {
+				if _, err := updateTemplate(tx, t, "defaults", "body", "engine", "updated_at"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return c.JSON(http.StatusConflict, &Error{Reason: err.Error()})
+		}
+		log.E(l, "Failed to import templates.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.D(l, "Imported templates successfully.", func(cm log.CM) {
+		cm.Write(
+			zap.Int("created", len(plan.Created)),
+			zap.Int("updated", len(plan.Updated)),
+		)
+	})
+	return c.JSON(http.StatusOK, plan)
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Template Bundle Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var sourceApp *model.App
+	var targetApp *model.App
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		CreateTestUser(app.DB, map[string]interface{}{"email": "success@test.com", "isAdmin": true})
+		sourceApp = CreateTestApp(app.DB)
+		targetApp = CreateTestApp(app.DB)
+		CreateTestTemplate(app.DB, sourceApp.ID, map[string]interface{}{
+			"name":     "welcome",
+			"locale":   "pt",
+			"defaults": map[string]interface{}{"name": "amigo"},
+			"body":     map[string]interface{}{"alert": "Olá {{name}}"},
+		})
+		CreateTestTemplate(app.DB, sourceApp.ID, map[string]interface{}{
+			"name":     "welcome",
+			"locale":   "en",
+			"defaults": map[string]interface{}{"name": "friend"},
+			"body":     map[string]interface{}{"alert": "Hello {{name}}"},
+		})
+	})
+
+	importRoute := func(query string) string {
+		return fmt.Sprintf("/apps/%s/templates/import?%s", targetApp.ID, query)
+	}
+
+	Describe("Get /apps/:aid/templates/export", func() {
+		It("should return 200 and the templates of the app as json", func() {
+			status, body := Get(app, fmt.Sprintf("/apps/%s/templates/export", sourceApp.ID), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var bundle model.TemplateBundle
+			err := json.Unmarshal([]byte(body), &bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bundle.BundleID).To(Equal(sourceApp.BundleID))
+			Expect(bundle.ExportedAt).To(BeNumerically(">", 0))
+			Expect(bundle.Templates).To(HaveLen(2))
+			Expect(bundle.Templates[0].Name).To(Equal("welcome"))
+			Expect(bundle.Templates[0].Locale).To(Equal("en"))
+			Expect(bundle.Templates[0].Engine).To(Equal("legacy"))
+			Expect(bundle.Templates[0].Defaults).To(Equal(map[string]interface{}{"name": "friend"}))
+			Expect(bundle.Templates[0].Body).To(Equal(map[string]interface{}{"alert": "Hello {{name}}"}))
+			Expect(bundle.Templates[1].Locale).To(Equal("pt"))
+		})
+
+		It("should return 200 and the templates of the app as yaml", func() {
+			status, body := Get(app, fmt.Sprintf("/apps/%s/templates/export?format=yaml", sourceApp.ID), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var bundle map[string]interface{}
+			err := yaml.Unmarshal([]byte(body), &bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bundle["bundleId"]).To(Equal(sourceApp.BundleID))
+			Expect(bundle["templates"]).To(HaveLen(2))
+		})
+
+		It("should return 200 and an empty bundle if the app has no templates", func() {
+			status, body := Get(app, fmt.Sprintf("/apps/%s/templates/export", targetApp.ID), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var bundle model.TemplateBundle
+			err := json.Unmarshal([]byte(body), &bundle)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bundle.Templates).To(BeEmpty())
+		})
+
+		It("should return 422 if the format is invalid", func() {
+			status, _ := Get(app, fmt.Sprintf("/apps/%s/templates/export?format=xml", sourceApp.ID), "success@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should return 404 if the app does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("/apps/%s/templates/export", "00000000-0000-0000-0000-000000000001"), "success@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Post /apps/:aid/templates/import", func() {
+		var exported string
+
+		BeforeEach(func() {
+			var status int
+			status, exported = Get(app, fmt.Sprintf("/apps/%s/templates/export", sourceApp.ID), "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+		})
+
+		It("should return 200 and create the templates of the bundle", func() {
+			status, body := Post(app, importRoute(""), exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var plan model.TemplateImport
+			err := json.Unmarshal([]byte(body), &plan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Created).To(HaveLen(2))
+			Expect(plan.Updated).To(BeEmpty())
+
+			var templates []model.Template
+			err = app.DB.Model(&templates).Where("app_id = ?", targetApp.ID).Order("locale").Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templates).To(HaveLen(2))
+			Expect(templates[0].Locale).To(Equal("en"))
+			Expect(templates[0].Body).To(Equal(map[string]interface{}{"alert": "Hello {{name}}"}))
+			Expect(templates[0].CreatedBy).To(Equal("success@test.com"))
+			Expect(templates[0].Version).To(Equal(1))
+			Expect(templates[1].Locale).To(Equal("pt"))
+		})
+
+		It("should return 200 and import a yaml bundle", func() {
+			bundle := `
+templates:
+- name: goodbye
+  locale: EN
+  defaults:
+    name: friend
+  body:
+    alert: Bye {{name}}
+    badge: 1
+`
+			status, _ := Post(app, importRoute("format=yaml"), bundle, "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			template := &model.Template{}
+			err := app.DB.Model(template).Where("app_id = ? AND name = ?", targetApp.ID, "goodbye").Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(template.Locale).To(Equal("en"))
+			Expect(template.Body).To(Equal(map[string]interface{}{"alert": "Bye {{name}}", "badge": float64(1)}))
+		})
+
+		It("should return 200 and the plan without changing the templates in a dry run", func() {
+			CreateTestTemplate(app.DB, targetApp.ID, map[string]interface{}{
+				"name":     "welcome",
+				"locale":   "en",
+				"defaults": map[string]interface{}{"name": "friend"},
+				"body":     map[string]interface{}{"alert": "Hi {{name}}"},
+			})
+			status, body := Post(app, importRoute("dryRun=true&upsert=true"), exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var plan model.TemplateImport
+			err := json.Unmarshal([]byte(body), &plan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.DryRun).To(BeTrue())
+			Expect(plan.Created).To(Equal([]model.TemplateImportItem{{Name: "welcome", Locale: "pt"}}))
+			Expect(plan.Updated).To(HaveLen(1))
+			Expect(plan.Updated[0].Locale).To(Equal("en"))
+			Expect(plan.Updated[0].Changes).To(Equal([]model.Change{{
+				Path: "body.alert",
+				Type: model.ChangeChanged,
+				From: "Hi {{name}}",
+				To:   "Hello {{name}}",
+			}}))
+
+			count, err := app.DB.Model(&model.Template{}).Where("app_id = ?", targetApp.ID).Count()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		It("should return 409 and the conflicts if a template differs and upsert is not set", func() {
+			CreateTestTemplate(app.DB, targetApp.ID, map[string]interface{}{
+				"name":     "welcome",
+				"locale":   "en",
+				"defaults": map[string]interface{}{"name": "friend"},
+				"body":     map[string]interface{}{"alert": "Hi {{name}}"},
+			})
+			status, body := Post(app, importRoute(""), exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusConflict))
+
+			var plan model.TemplateImport
+			err := json.Unmarshal([]byte(body), &plan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Conflicts).To(HaveLen(1))
+			Expect(plan.Conflicts[0].Locale).To(Equal("en"))
+
+			count, err := app.DB.Model(&model.Template{}).Where("app_id = ?", targetApp.ID).Count()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		It("should return 200 and update the templates that differ as a new version if upsert is set", func() {
+			existing := CreateTestTemplate(app.DB, targetApp.ID, map[string]interface{}{
+				"name":     "welcome",
+				"locale":   "en",
+				"defaults": map[string]interface{}{"name": "friend"},
+				"body":     map[string]interface{}{"alert": "Hi {{name}}"},
+			})
+			status, body := Post(app, importRoute("upsert=true"), exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var plan model.TemplateImport
+			err := json.Unmarshal([]byte(body), &plan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Created).To(HaveLen(1))
+			Expect(plan.Updated).To(HaveLen(1))
+
+			template := &model.Template{ID: existing.ID}
+			err = app.DB.Select(template)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(template.Body).To(Equal(map[string]interface{}{"alert": "Hello {{name}}"}))
+
+			versions, err := template.ListVersions(app.DB)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(1))
+			Expect(versions[0].Version).To(Equal(template.Version))
+		})
+
+		It("should return 200 and report the templates that did not change", func() {
+			status, _ := Post(app, importRoute(""), exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			status, body := Post(app, importRoute(""), exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var plan model.TemplateImport
+			err := json.Unmarshal([]byte(body), &plan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Created).To(BeEmpty())
+			Expect(plan.Unchanged).To(HaveLen(2))
+		})
+
+		It("should return 200 and copy the templates of the app in the from query param", func() {
+			status, body := Post(app, importRoute(fmt.Sprintf("from=%s", sourceApp.ID)), "", "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var plan model.TemplateImport
+			err := json.Unmarshal([]byte(body), &plan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Created).To(HaveLen(2))
+
+			count, err := app.DB.Model(&model.Template{}).Where("app_id = ?", targetApp.ID).Count()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(2))
+		})
+
+		It("should return 422 if the app in the from query param does not exist", func() {
+			status, _ := Post(app, importRoute("from=00000000-0000-0000-0000-000000000001"), "", "success@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should return 422 if the bundle has an invalid template", func() {
+			bundle := `{"templates": [{"name": "welcome", "locale": "en", "body": {}}]}`
+			status, body := Post(app, importRoute(""), bundle, "success@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("invalid template welcome (en)"))
+		})
+
+		It("should return 422 if the bundle has the same template twice", func() {
+			bundle := `{"templates": [
+				{"name": "welcome", "locale": "en", "body": {"alert": "a"}},
+				{"name": "welcome", "locale": "EN", "body": {"alert": "b"}}
+			]}`
+			status, body := Post(app, importRoute(""), bundle, "success@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("duplicate template welcome (en)"))
+		})
+
+		It("should return 404 if the app does not exist", func() {
+			status, _ := Post(app, "/apps/00000000-0000-0000-0000-000000000001/templates/import", exported, "success@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
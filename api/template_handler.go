@@ -23,6 +23,7 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -33,6 +34,7 @@ import (
 	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/worker"
 	"github.com/uber-go/zap"
 )
 
@@ -91,7 +93,7 @@ func (a *Application) PostTemplateHandler(c echo.Context) error {
 			t.UpdatedAt = time.Now().UnixNano()
 		}
 		err = WithSegment("db-insert", c, func() error {
-			return a.DB.Insert(&templates)
+			return a.insertTemplates(templates...)
 		})
 		if err != nil {
 			if strings.Contains(err.Error(), "duplicate key") {
@@ -121,7 +123,7 @@ func (a *Application) PostTemplateHandler(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: template})
 	}
 	err = WithSegment("db-insert", c, func() error {
-		return a.DB.Insert(&template)
+		return a.insertTemplates(template)
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") {
@@ -201,13 +203,25 @@ func (a *Application) PutTemplateHandler(c echo.Context) error {
 	}
 	template.ID = tid
 	template.AppID = aid
+	current, err := a.getTemplate(c, l)
+	if current == nil {
+		return err
+	}
+	if len(template.Defaults) == 0 {
+		template.Defaults = current.Defaults
+	}
+	if template.Engine == "" {
+		template.Engine = current.Engine
+	}
+	if template.Name != current.Name || template.Locale != model.NormalizeLocale(current.Locale) {
+		ok, err := a.checkTemplateUsage(c, l, current, "rename")
+		if !ok {
+			return err
+		}
+	}
 	var values *types.Result
 	err = WithSegment("db-update", c, func() error {
-		updating := a.DB.Model(&template).Column("name").Column("locale").Column("body").Column("updated_at")
-		if template.Defaults != nil && len(template.Defaults) > 0 {
-			updating = updating.Column("defaults")
-		}
-		values, err = updating.Returning("*").Update()
+		values, err = a.updateTemplate(template, "name", "locale", "body", "defaults", "engine", "updated_at")
 		return err
 	})
 	if err != nil {
@@ -228,6 +242,57 @@ func (a *Application) PutTemplateHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, template)
 }
 
+// PreviewTemplateHandler is the method called when a post to /apps/:aid/templates/:tid/preview is called
+func (a *Application) PreviewTemplateHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "previewTemplate"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+	)
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
+	}
+	app := &model.App{ID: template.AppID}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(app)
+	})
+	if err != nil {
+		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	input := &model.TemplatePreview{}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, input)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: input})
+	}
+
+	job := &model.Job{
+		TemplateName: template.Name,
+		Context:      input.Context,
+		Metadata:     input.Metadata,
+		PushOptions:  input.PushOptions,
+		App:          *app,
+		AppID:        app.ID,
+		ExpiresAt:    time.Now().Add(time.Hour).UnixNano(),
+	}
+	user := &worker.User{
+		UserID: "preview",
+		Locale: template.Locale,
+		Fields: input.Fields,
+	}
+	preview := worker.PreviewTemplate(*template, job, user)
+	log.D(l, "Previewed template successfully.", func(cm log.CM) {
+		cm.Write(zap.Bool("valid", preview.Valid))
+	})
+	return c.JSON(http.StatusOK, preview)
+}
+
 // DeleteTemplateHandler is the method called when a delete to /apps/:aid/templates/:tid is called
 func (a *Application) DeleteTemplateHandler(c echo.Context) error {
 	l := a.Logger.With(
@@ -236,18 +301,17 @@ func (a *Application) DeleteTemplateHandler(c echo.Context) error {
 		zap.String("appId", c.Param("aid")),
 		zap.String("templateId", c.Param("tid")),
 	)
-	aid, err := uuid.FromString(c.Param("aid"))
-	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
 	}
-	tid, err := uuid.FromString(c.Param("tid"))
-	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	ok, err := a.checkTemplateUsage(c, l, template, "delete")
+	if !ok {
+		return err
 	}
-	template := &model.Template{}
 	var res *types.Result
 	err = WithSegment("db-delete", c, func() error {
-		res, err = a.DB.Model(&template).Where("id = ? AND app_id = ?", tid, aid).Delete()
+		res, err = a.DB.Model(template).Where("id = ? AND app_id = ?", template.ID, template.AppID).Delete()
 		return err
 	})
 	if err != nil {
@@ -264,3 +328,64 @@ func (a *Application) DeleteTemplateHandler(c echo.Context) error {
 	})
 	return c.JSON(http.StatusNoContent, "")
 }
+
+// TemplateJobsHandler is the method called when a get to /apps/:aid/templates/:tid/jobs is called,
+// it returns the jobs that send the template name, only the active ones if the active query param is true
+func (a *Application) TemplateJobsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateHandler"),
+		zap.String("operation", "templateJobs"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+	)
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
+	}
+	var jobs []model.Job
+	err = WithSegment("db-select", c, func() error {
+		jobs, err = template.Jobs(a.DB, c.QueryParam("active") == "true")
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to list template jobs.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// checkTemplateUsage returns true if no active job sends the template or the force query param is true,
+// otherwise it writes a conflict response with the ids of the active jobs and returns false
+func (a *Application) checkTemplateUsage(c echo.Context, l zap.Logger, template *model.Template, action string) (bool, error) {
+	var jobs []model.Job
+	err := WithSegment("db-select", c, func() error {
+		var err error
+		jobs, err = template.Jobs(a.DB, true)
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to list template jobs.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return false, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: template})
+	}
+	if len(jobs) == 0 {
+		return true, nil
+	}
+	if c.QueryParam("force") == "true" {
+		log.W(l, fmt.Sprintf("Forced %s of template used by active jobs.", action), func(cm log.CM) {
+			cm.Write(zap.Int("activeJobs", len(jobs)))
+		})
+		return true, nil
+	}
+	ids := make([]uuid.UUID, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return false, c.JSON(http.StatusConflict, map[string]interface{}{
+		"reason": fmt.Sprintf("template is used by %d active jobs, use force=true to %s it anyway", len(jobs), action),
+		"jobs":   ids,
+	})
+}
@@ -27,13 +27,16 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	uuid "github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/api"
+	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
 	"github.com/uber-go/zap"
 )
 
@@ -194,6 +197,50 @@ var _ = Describe("Template Handler", func() {
 				}
 			})
 
+			It("should return 201 and the created rich template", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "rich"
+				payload["body"] = map[string]interface{}{"alert": "{{ .user_name | default \"friend\" | upper }}"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var template map[string]interface{}
+				err := json.Unmarshal([]byte(body), &template)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template["engine"]).To(Equal("rich"))
+			})
+
+			It("should return 201 and the created template with rich content", func() {
+				payload := GetTemplatePayload()
+				payload["body"] = map[string]interface{}{
+					"alert": "{{user_name}} sent you a gift",
+					"rich": map[string]interface{}{
+						"image":    "{{gift_image}}",
+						"url":      "game://gifts",
+						"category": "GIFT",
+						"actions": []map[string]interface{}{
+							{"id": "open", "title": "Open"},
+						},
+					},
+				}
+				pl, _ := json.Marshal(payload)
+				status, _ := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+			})
+
+			It("should return 201 and the legacy engine if no engine is sent", func() {
+				payload := GetTemplatePayload()
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusCreated))
+
+				var template map[string]interface{}
+				err := json.Unmarshal([]byte(body), &template)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template["engine"]).To(Equal("legacy"))
+			})
+
 			It("should return 201 and the created templates when with flag multiple", func() {
 				payload := GetTemplatePayloads(3)
 				pl, _ := json.Marshal(payload)
@@ -429,6 +476,49 @@ var _ = Describe("Template Handler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(response["reason"]).To(ContainSubstring("cannot unmarshal string into Go struct"))
 			})
+
+			It("should return 422 if invalid engine", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "mustache"
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid engine"))
+			})
+
+			It("should return 422 if the body has invalid rich content", func() {
+				payload := GetTemplatePayload()
+				payload["body"] = map[string]interface{}{
+					"alert": "hello",
+					"rich":  map[string]interface{}{"image": "http://cdn.example.com/gift.png"},
+				}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(Equal("invalid body: rich image must be an https url"))
+			})
+
+			It("should return 422 if the body is not a valid rich template", func() {
+				payload := GetTemplatePayload()
+				payload["engine"] = "rich"
+				payload["body"] = map[string]interface{}{"alert": "{{ if .vip }}VIP"}
+				pl, _ := json.Marshal(payload)
+				status, body := Post(app, baseRoute, string(pl), "test@test.com")
+				Expect(status).To(Equal(http.StatusUnprocessableEntity))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(HavePrefix("invalid body: alert: "))
+			})
 		})
 	})
 
@@ -513,6 +603,27 @@ var _ = Describe("Template Handler", func() {
 
 	Describe("Put /apps/:id/templates/:tid", func() {
 		Describe("Successfully", func() {
+			It("should return 200 if the template is used by an active job and is not renamed", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				payload := GetTemplatePayload(map[string]interface{}{
+					"name":   existingTemplate.Name,
+					"locale": existingTemplate.Locale,
+				})
+				pl, _ := json.Marshal(payload)
+				status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+			})
+
+			It("should return 200 if the template is used by an active job, is renamed and force is true", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				payload := GetTemplatePayload()
+				pl, _ := json.Marshal(payload)
+				status, _ := Put(app, fmt.Sprintf("%s/%s?force=true", baseRoute, existingTemplate.ID), string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusOK))
+			})
+
 			It("should return 200 and the updated template", func() {
 				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
 				payload := GetTemplatePayload()
@@ -583,6 +694,25 @@ var _ = Describe("Template Handler", func() {
 		})
 
 		Describe("Unsucesfully", func() {
+			It("should return 409 if the template is used by an active job and is renamed", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				job := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				payload := GetTemplatePayload(map[string]interface{}{"locale": existingTemplate.Locale})
+				pl, _ := json.Marshal(payload)
+				status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), string(pl), "success@test.com")
+				Expect(status).To(Equal(http.StatusConflict))
+
+				var response map[string]interface{}
+				err := json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["jobs"]).To(ConsistOf(job.ID.String()))
+
+				dbTemplate := &model.Template{ID: existingTemplate.ID}
+				err = app.DB.Select(dbTemplate)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dbTemplate.Name).To(Equal(existingTemplate.Name))
+			})
+
 			It("should return 401 if no authenticated user", func() {
 				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
 				status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), "", "")
@@ -751,6 +881,152 @@ var _ = Describe("Template Handler", func() {
 		})
 	})
 
+	Describe("Post /apps/:id/templates/:tid/preview", func() {
+		var previewRoute string
+		var existingTemplate *model.Template
+		BeforeEach(func() {
+			existingTemplate = CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+				"defaults": map[string]interface{}{"user_name": "Someone"},
+				"body":     map[string]interface{}{"alert": "{{user_name}} sent you {{gift}}!"},
+			})
+			previewRoute = fmt.Sprintf("%s/%s/preview", baseRoute, existingTemplate.ID)
+		})
+
+		It("should return 200 and the rendered apns and gcm messages", func() {
+			pl, _ := json.Marshal(map[string]interface{}{
+				"context":  map[string]interface{}{"user_name": "Camila"},
+				"fields":   map[string]string{"gift": "100 gems"},
+				"metadata": map[string]interface{}{"meta": "data"},
+			})
+			status, body := Post(app, previewRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var preview worker.Preview
+			err := json.Unmarshal([]byte(body), &preview)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Valid).To(BeTrue())
+			Expect(preview.Errors).To(BeEmpty())
+			Expect(preview.MissingVariables).To(BeEmpty())
+
+			var apnsMessage messages.APNSMessage
+			err = json.Unmarshal(preview.APNS, &apnsMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apnsMessage.Payload.Aps["alert"]).To(Equal("Camila sent you 100 gems!"))
+			Expect(apnsMessage.Payload.M["meta"]).To(Equal("data"))
+			Expect(apnsMessage.Payload.TemplateName).To(Equal(existingTemplate.Name))
+			Expect(preview.APNSPayloadSize).To(BeNumerically(">", 0))
+
+			var gcmMessage messages.GCMMessage
+			err = json.Unmarshal(preview.GCM, &gcmMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gcmMessage.Data["alert"]).To(Equal("Camila sent you 100 gems!"))
+		})
+
+		It("should report the missing variables", func() {
+			status, body := Post(app, previewRoute, "{}", "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var preview worker.Preview
+			err := json.Unmarshal([]byte(body), &preview)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Valid).To(BeFalse())
+			Expect(preview.MissingVariables).To(Equal([]string{"gift"}))
+		})
+
+		It("should report apns payloads bigger than the limit", func() {
+			pl, _ := json.Marshal(map[string]interface{}{
+				"context": map[string]interface{}{"gift": strings.Repeat("a", worker.APNSMaxPayloadSize)},
+			})
+			status, body := Post(app, previewRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var preview worker.Preview
+			err := json.Unmarshal([]byte(body), &preview)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Valid).To(BeFalse())
+			Expect(preview.APNSPayloadSize).To(BeNumerically(">", worker.APNSMaxPayloadSize))
+			Expect(preview.Errors).To(HaveLen(1))
+			Expect(preview.Errors[0]).To(ContainSubstring("apns payload has"))
+		})
+
+		It("should report rich templates that fail to render", func() {
+			richTemplate := CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{
+				"engine": "rich",
+				"body":   map[string]interface{}{"alert": "{{ index .items 3 }}"},
+			})
+			pl, _ := json.Marshal(map[string]interface{}{
+				"context": map[string]interface{}{"items": []string{"a"}},
+			})
+			status, body := Post(app, fmt.Sprintf("%s/%s/preview", baseRoute, richTemplate.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var preview worker.Preview
+			err := json.Unmarshal([]byte(body), &preview)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(preview.Valid).To(BeFalse())
+			Expect(preview.Errors).To(HaveLen(1))
+			Expect(preview.Errors[0]).To(ContainSubstring("failed to render template"))
+			Expect(preview.APNS).To(BeEmpty())
+		})
+
+		It("should return 404 if the template does not exist", func() {
+			status, _ := Post(app, fmt.Sprintf("%s/%s/preview", baseRoute, uuid.NewV4()), "{}", "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return 422 if the push options are invalid", func() {
+			pl, _ := json.Marshal(map[string]interface{}{
+				"pushOptions": map[string]interface{}{"format": "v2"},
+			})
+			status, _ := Post(app, previewRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Describe("Get /apps/:id/templates/:tid/jobs", func() {
+		It("should return 200 and the jobs that use the template name, newest first", func() {
+			existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+			CreateTestTemplate(app.DB, existingApp.ID, map[string]interface{}{"name": existingTemplate.Name, "locale": "zz"})
+			job := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+			jobWithManyTemplates := CreateTestJob(app.DB, existingApp.ID, fmt.Sprintf("%s,other", existingTemplate.Name))
+			CreateTestJob(app.DB, existingApp.ID, "other")
+			_, err := app.DB.Exec("UPDATE jobs SET created_at = ? WHERE id = ?", time.Now().Add(-time.Hour).UnixNano(), job.ID)
+			Expect(err).NotTo(HaveOccurred())
+
+			status, body := Get(app, fmt.Sprintf("%s/%s/jobs", baseRoute, existingTemplate.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var jobs []model.Job
+			err = json.Unmarshal([]byte(body), &jobs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobs).To(HaveLen(2))
+			Expect(jobs[0].ID).To(Equal(jobWithManyTemplates.ID))
+			Expect(jobs[1].ID).To(Equal(job.ID))
+		})
+
+		It("should return 200 and only the active jobs if active is true", func() {
+			existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+			activeJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+			stoppedJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+			_, err := app.DB.Exec("UPDATE jobs SET status = 'stopped' WHERE id = ?", stoppedJob.ID)
+			Expect(err).NotTo(HaveOccurred())
+
+			status, body := Get(app, fmt.Sprintf("%s/%s/jobs?active=true", baseRoute, existingTemplate.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var jobs []model.Job
+			err = json.Unmarshal([]byte(body), &jobs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs[0].ID).To(Equal(activeJob.ID))
+		})
+
+		It("should return 404 if the template does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/%s/jobs", baseRoute, uuid.NewV4().String()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
 	Describe("Delete /apps/:id/templates/:tid", func() {
 		Describe("Sucesfully", func() {
 			It("should return 204 ", func() {
@@ -765,9 +1041,53 @@ var _ = Describe("Template Handler", func() {
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal(api.RecordNotFoundString))
 			})
+
+			It("should return 204 if the jobs that use the template are completed, stopped or expired", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				completedJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				stoppedJob := CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+				CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name, map[string]interface{}{
+					"expiresAt": time.Now().Add(-time.Hour).UnixNano(),
+				})
+				_, err := app.DB.Exec("UPDATE jobs SET completed_at = ? WHERE id = ?", time.Now().UnixNano(), completedJob.ID)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = app.DB.Exec("UPDATE jobs SET status = 'stopped' WHERE id = ?", stoppedJob.ID)
+				Expect(err).NotTo(HaveOccurred())
+
+				status, _ := Delete(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusNoContent))
+			})
+
+			It("should return 204 if the template is used by an active job and force is true", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				CreateTestJob(app.DB, existingApp.ID, existingTemplate.Name)
+
+				status, _ := Delete(app, fmt.Sprintf("%s/%s?force=true", baseRoute, existingTemplate.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusNoContent))
+			})
 		})
 
 		Describe("Unsucesfully", func() {
+			It("should return 409 and the active jobs if the template is used by an active job", func() {
+				existingTemplate := CreateTestTemplate(app.DB, existingApp.ID)
+				job := CreateTestJob(app.DB, existingApp.ID, fmt.Sprintf("other,%s", existingTemplate.Name))
+				_, err := app.DB.Exec("UPDATE jobs SET status = 'paused' WHERE id = ?", job.ID)
+				Expect(err).NotTo(HaveOccurred())
+
+				status, body := Delete(app, fmt.Sprintf("%s/%s", baseRoute, existingTemplate.ID), "test@test.com")
+				Expect(status).To(Equal(http.StatusConflict))
+
+				var response map[string]interface{}
+				err = json.Unmarshal([]byte(body), &response)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response["reason"]).To(ContainSubstring("template is used by 1 active jobs"))
+				Expect(response["jobs"]).To(ConsistOf(job.ID.String()))
+
+				dbTemplate := &model.Template{ID: existingTemplate.ID}
+				err = app.DB.Select(dbTemplate)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
 			It("should return 401 if no authenticated user", func() {
 				status, _ := Delete(app, "/apps/1234/templates/5678", "")
 
@@ -0,0 +1,262 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	pg "gopkg.in/pg.v5"
+	"gopkg.in/pg.v5/types"
+)
+
+// getTemplate returns the template with id tid of the app aid, or writes the error response and returns nil
+func (a *Application) getTemplate(c echo.Context, l zap.Logger) (*model.Template, error) {
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return nil, c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	tid, err := uuid.FromString(c.Param("tid"))
+	if err != nil {
+		return nil, c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	template := &model.Template{ID: tid, AppID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(template).Column("template.*").Where("template.id = ? AND template.app_id = ?", tid, aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, c.JSON(http.StatusNotFound, template)
+		}
+		log.E(l, "Failed to retrieve template.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return nil, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: template})
+	}
+	return template, nil
+}
+
+// getTemplateVersion returns the version of the template in the param or query param name,
+// or writes the error response and returns nil
+func (a *Application) getTemplateVersion(c echo.Context, l zap.Logger, template *model.Template, value string) (*model.TemplateVersion, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return nil, c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "invalid version"})
+	}
+	var templateVersion *model.TemplateVersion
+	err = WithSegment("db-select", c, func() error {
+		templateVersion, err = template.GetVersion(a.DB, version)
+		return err
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, c.JSON(http.StatusNotFound, &Error{Reason: "template version not found"})
+		}
+		log.E(l, "Failed to retrieve template version.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return nil, c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return templateVersion, nil
+}
+
+// insertTemplates inserts the templates and stores each one as the next version of its name and locale
+func (a *Application) insertTemplates(templates ...*model.Template) error {
+	return InTransaction(a.DB, func(tx *pg.Tx) error {
+		for _, template := range templates {
+			err := insertTemplate(tx, template)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateTemplate updates the columns and the version of the template and stores
+// it as the next version of its name and locale
+func (a *Application) updateTemplate(template *model.Template, columns ...string) (*types.Result, error) {
+	var res *types.Result
+	err := InTransaction(a.DB, func(tx *pg.Tx) error {
+		var err error
+		res, err = updateTemplate(tx, template, columns...)
+		return err
+	})
+	return res, err
+}
+
+func insertTemplate(tx *pg.Tx, template *model.Template) error {
+	err := template.NextVersion(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Insert(template)
+	if err != nil {
+		return err
+	}
+	_, err = template.SaveVersion(tx)
+	return err
+}
+
+func updateTemplate(tx *pg.Tx, template *model.Template, columns ...string) (*types.Result, error) {
+	err := template.NextVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = template.SaveVersion(tx)
+	if err != nil {
+		return nil, err
+	}
+	columns = append(columns, "version")
+	return tx.Model(template).Column(columns...).Returning("*").Update()
+}
+
+// ListTemplateVersionsHandler is the method called when a get to /apps/:aid/templates/:tid/versions is called
+func (a *Application) ListTemplateVersionsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateVersionHandler"),
+		zap.String("operation", "listTemplateVersions"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+	)
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
+	}
+	var versions []model.TemplateVersion
+	err = WithSegment("db-select", c, func() error {
+		versions, err = template.ListVersions(a.DB)
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to list template versions.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// GetTemplateVersionHandler is the method called when a get to /apps/:aid/templates/:tid/versions/:version is called
+func (a *Application) GetTemplateVersionHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateVersionHandler"),
+		zap.String("operation", "getTemplateVersion"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+		zap.String("version", c.Param("version")),
+	)
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
+	}
+	version, err := a.getTemplateVersion(c, l, template, c.Param("version"))
+	if version == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, version)
+}
+
+// DiffTemplateVersionsHandler is the method called when a get to /apps/:aid/templates/:tid/diff is called,
+// the to query param defaults to the current version and from defaults to the version before to
+func (a *Application) DiffTemplateVersionsHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateVersionHandler"),
+		zap.String("operation", "diffTemplateVersions"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+		zap.String("from", c.QueryParam("from")),
+		zap.String("to", c.QueryParam("to")),
+	)
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
+	}
+	toParam := c.QueryParam("to")
+	if toParam == "" {
+		toParam = strconv.Itoa(template.Version)
+	}
+	to, err := a.getTemplateVersion(c, l, template, toParam)
+	if to == nil {
+		return err
+	}
+	fromParam := c.QueryParam("from")
+	if fromParam == "" {
+		fromParam = strconv.Itoa(to.Version - 1)
+	}
+	from, err := a.getTemplateVersion(c, l, template, fromParam)
+	if from == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, from.Diff(to))
+}
+
+// RollbackTemplateHandler is the method called when a post to /apps/:aid/templates/:tid/versions/:version/rollback
+// is called, the template gets the defaults, body and engine of the version as a new version
+func (a *Application) RollbackTemplateHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "templateVersionHandler"),
+		zap.String("operation", "rollbackTemplate"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("templateId", c.Param("tid")),
+		zap.String("version", c.Param("version")),
+	)
+	template, err := a.getTemplate(c, l)
+	if template == nil {
+		return err
+	}
+	version, err := a.getTemplateVersion(c, l, template, c.Param("version"))
+	if version == nil {
+		return err
+	}
+
+	template.Defaults = version.Defaults
+	template.Body = version.Body
+	template.Engine = version.Engine
+	template.CreatedBy = c.Get("user-email").(string)
+	template.UpdatedAt = time.Now().UnixNano()
+	err = WithSegment("db-update", c, func() error {
+		_, err = a.updateTemplate(template, "defaults", "body", "engine", "updated_at")
+		return err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return c.JSON(http.StatusConflict, &Error{Reason: err.Error(), Value: template})
+		}
+		log.E(l, "Failed to rollback template.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error(), Value: template})
+	}
+	log.D(l, "Rolled back template successfully.", func(cm log.CM) {
+		cm.Write(zap.Object("template", template))
+	})
+	return c.JSON(http.StatusOK, template)
+}
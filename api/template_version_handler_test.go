@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Template Version Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var existingApp *model.App
+	var baseRoute string
+	var templateRoute string
+	var payload map[string]interface{}
+
+	updateTemplate := func(alert string) {
+		payload["body"] = map[string]interface{}{"alert": alert}
+		pl, _ := json.Marshal(payload)
+		status, _ := Put(app, templateRoute, string(pl), "success@test.com")
+		Expect(status).To(Equal(http.StatusOK))
+	}
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		CreateTestUser(app.DB, map[string]interface{}{"email": "test@test.com", "isAdmin": true})
+		CreateTestUser(app.DB, map[string]interface{}{"email": "success@test.com", "isAdmin": true})
+		existingApp = CreateTestApp(app.DB)
+		baseRoute = fmt.Sprintf("/apps/%s/templates", existingApp.ID)
+
+		payload = GetTemplatePayload(map[string]interface{}{
+			"body": map[string]interface{}{"alert": "first"},
+		})
+		pl, _ := json.Marshal(payload)
+		status, body := Post(app, baseRoute, string(pl), "test@test.com")
+		Expect(status).To(Equal(http.StatusCreated))
+		var template map[string]interface{}
+		err := json.Unmarshal([]byte(body), &template)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(template["version"]).To(BeEquivalentTo(1))
+		templateRoute = fmt.Sprintf("%s/%s", baseRoute, template["id"])
+	})
+
+	Describe("Put /apps/:id/templates/:tid", func() {
+		It("should store the updated template as a new version", func() {
+			updateTemplate("second")
+
+			status, body := Get(app, templateRoute, "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var template map[string]interface{}
+			err := json.Unmarshal([]byte(body), &template)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(template["version"]).To(BeEquivalentTo(2))
+
+			var versions []model.TemplateVersion
+			_, err = app.DB.Query(&versions, "SELECT * FROM template_versions WHERE app_id = ? ORDER BY version", existingApp.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(2))
+			Expect(versions[0].Body["alert"]).To(Equal("first"))
+			Expect(versions[0].CreatedBy).To(Equal("test@test.com"))
+			Expect(versions[1].Body["alert"]).To(Equal("second"))
+			Expect(versions[1].CreatedBy).To(Equal("success@test.com"))
+		})
+
+		It("should not store a version if the template can not be updated", func() {
+			other := CreateTestTemplate(app.DB, existingApp.ID)
+			payload["name"] = other.Name
+			payload["locale"] = other.Locale
+			pl, _ := json.Marshal(payload)
+			status, _ := Put(app, templateRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusConflict))
+
+			var versions []model.TemplateVersion
+			_, err := app.DB.Query(&versions, "SELECT * FROM template_versions WHERE app_id = ?", existingApp.ID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(1))
+		})
+	})
+
+	Describe("Get /apps/:id/templates/:tid/versions", func() {
+		It("should return 200 and the versions, newest first", func() {
+			updateTemplate("second")
+			updateTemplate("third")
+
+			status, body := Get(app, fmt.Sprintf("%s/versions", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var versions []map[string]interface{}
+			err := json.Unmarshal([]byte(body), &versions)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(versions).To(HaveLen(3))
+			for i, alert := range []string{"third", "second", "first"} {
+				Expect(versions[i]["version"]).To(BeEquivalentTo(3 - i))
+				Expect(versions[i]["body"].(map[string]interface{})["alert"]).To(Equal(alert))
+			}
+		})
+
+		It("should return 404 if the template does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/%s/versions", baseRoute, uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Get /apps/:id/templates/:tid/versions/:version", func() {
+		It("should return 200 and the version", func() {
+			updateTemplate("second")
+
+			status, body := Get(app, fmt.Sprintf("%s/versions/1", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var version map[string]interface{}
+			err := json.Unmarshal([]byte(body), &version)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version["version"]).To(BeEquivalentTo(1))
+			Expect(version["body"].(map[string]interface{})["alert"]).To(Equal("first"))
+		})
+
+		It("should return 404 if the version does not exist", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/versions/5", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return 422 if the version is not a number", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/versions/first", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Describe("Get /apps/:id/templates/:tid/diff", func() {
+		It("should return 200 and the changes from the previous version", func() {
+			updateTemplate("second")
+
+			status, body := Get(app, fmt.Sprintf("%s/diff", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var diff model.TemplateDiff
+			err := json.Unmarshal([]byte(body), &diff)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff.From).To(Equal(1))
+			Expect(diff.To).To(Equal(2))
+			Expect(diff.Changes).To(Equal([]model.Change{{
+				Path: "body.alert",
+				Type: model.ChangeChanged,
+				From: "first",
+				To:   "second",
+			}}))
+		})
+
+		It("should return 200 and the changes between the given versions", func() {
+			updateTemplate("second")
+			updateTemplate("third")
+
+			status, body := Get(app, fmt.Sprintf("%s/diff?from=3&to=1", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var diff model.TemplateDiff
+			err := json.Unmarshal([]byte(body), &diff)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff.From).To(Equal(3))
+			Expect(diff.To).To(Equal(1))
+			Expect(diff.Changes).To(HaveLen(1))
+			Expect(diff.Changes[0].From).To(Equal("third"))
+			Expect(diff.Changes[0].To).To(Equal("first"))
+		})
+
+		It("should return 404 if there is no previous version", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/diff", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Post /apps/:id/templates/:tid/versions/:version/rollback", func() {
+		It("should return 200 and restore the version as a new version", func() {
+			updateTemplate("second")
+
+			status, body := Post(app, fmt.Sprintf("%s/versions/1/rollback", templateRoute), "", "success@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var template map[string]interface{}
+			err := json.Unmarshal([]byte(body), &template)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(template["version"]).To(BeEquivalentTo(3))
+			Expect(template["body"].(map[string]interface{})["alert"]).To(Equal("first"))
+			Expect(template["createdBy"]).To(Equal("test@test.com"))
+
+			status, body = Get(app, fmt.Sprintf("%s/versions/3", templateRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var version map[string]interface{}
+			err = json.Unmarshal([]byte(body), &version)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version["body"].(map[string]interface{})["alert"]).To(Equal("first"))
+			Expect(version["createdBy"]).To(Equal("success@test.com"))
+		})
+
+		It("should return 404 if the version does not exist", func() {
+			status, _ := Post(app, fmt.Sprintf("%s/versions/2/rollback", templateRoute), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+})
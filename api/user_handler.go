@@ -23,6 +23,7 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	"strings"
 	"time"
@@ -32,6 +33,7 @@ import (
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
+	"gopkg.in/pg.v5/types"
 )
 
 // ListUsersHandler is the method called when a get to /users is called
@@ -148,11 +150,9 @@ func (a *Application) UpdateUserHandler(c echo.Context) error {
 	}
 	user.ID = id
 	err = WithSegment("db-update", c, func() error {
-		_, err = a.DB.Model(&user).Column("is_admin").Column("allowed_apps").Column("updated_at").Returning("*").Update()
+		_, err = a.DB.Model(&user).Column("is_admin").Column("roles").Column("updated_at").Returning("*").Update()
 		return err
 	})
-	// FIXME: Ugly fix to remove duplicate elements returned by update
-	user.AllowedApps = user.AllowedApps[0 : len(user.AllowedApps)/2]
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") {
 			return c.JSON(http.StatusConflict, &Error{Reason: err.Error(), Value: user})
@@ -197,3 +197,125 @@ func (a *Application) DeleteUserHandler(c echo.Context) error {
 	})
 	return c.JSON(http.StatusNoContent, "")
 }
+
+//GetUserRolesHandler is the method called when a get to /users/:uid/roles is called
+func (a *Application) GetUserRolesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "userHandler"),
+		zap.String("operation", "getUserRoles"),
+		zap.String("userId", c.Param("uid")),
+	)
+	id, err := uuid.FromString(c.Param("uid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	user := &model.User{ID: id}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(&user)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusNotFound, map[string]string{})
+		}
+		log.E(l, "Failed to retrieve user roles.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	if user.Roles == nil {
+		user.Roles = model.AppRoles{}
+	}
+	return c.JSON(http.StatusOK, user.Roles)
+}
+
+//PutUserRolesHandler is the method called when a put to /users/:uid/roles/:aid is called,
+//it replaces the roles of the user in the app
+func (a *Application) PutUserRolesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "userHandler"),
+		zap.String("operation", "putUserRoles"),
+		zap.String("userId", c.Param("uid")),
+		zap.String("appId", c.Param("aid")),
+	)
+	id, err := uuid.FromString(c.Param("uid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	roles := &model.UserAppRoles{}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, roles)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: roles})
+	}
+	app := &model.App{ID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(&app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given id."})
+		}
+		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	appRoles, err := json.Marshal(model.AppRoles{aid.String(): roles.Roles})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return a.updateUserRoles(c, l, id, "roles = roles || ?::jsonb", string(appRoles))
+}
+
+//DeleteUserRolesHandler is the method called when a delete to /users/:uid/roles/:aid is called,
+//it removes all roles of the user in the app
+func (a *Application) DeleteUserRolesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "userHandler"),
+		zap.String("operation", "deleteUserRoles"),
+		zap.String("userId", c.Param("uid")),
+		zap.String("appId", c.Param("aid")),
+	)
+	id, err := uuid.FromString(c.Param("uid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	return a.updateUserRoles(c, l, id, "roles = roles - ?", aid.String())
+}
+
+func (a *Application) updateUserRoles(c echo.Context, l zap.Logger, id uuid.UUID, set string, param string) error {
+	user := &model.User{}
+	var res *types.Result
+	err := WithSegment("db-update", c, func() error {
+		var err error
+		res, err = a.DB.Model(user).
+			Set(set, param).
+			Set("updated_at = ?", time.Now().UnixNano()).
+			Where("id = ?", id).
+			Returning("*").
+			Update()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to update user roles.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	if res.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	log.I(l, "Updated user roles.", func(cm log.CM) {
+		cm.Write(zap.String("email", user.Email), zap.String("updatedBy", c.Get("user-email").(string)))
+	})
+	return c.JSON(http.StatusOK, user)
+}
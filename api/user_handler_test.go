@@ -77,7 +77,7 @@ var _ = Describe("User Handler", func() {
 					Expect(user["id"]).ToNot(BeNil())
 					Expect(user["email"]).To(Equal(testUsers[idx].Email))
 					Expect(user["isAdmin"]).To(Equal(testUsers[idx].IsAdmin))
-					Expect(user["allowedApps"]).To(HaveLen(len(testUsers[idx].AllowedApps)))
+					Expect(user["roles"]).To(HaveLen(len(testUsers[idx].Roles)))
 					Expect(user["createdBy"]).To(Equal(testUsers[idx].CreatedBy))
 					Expect(user["createdAt"]).ToNot(BeNil())
 					Expect(user["createdAt"]).ToNot(Equal(0))
@@ -204,7 +204,7 @@ var _ = Describe("User Handler", func() {
 				Expect(response["id"]).To(Equal(existingUser.ID.String()))
 				Expect(response["email"]).To(Equal(existingUser.Email))
 				Expect(response["isAdmin"]).To(Equal(existingUser.IsAdmin))
-				Expect(response["allowedApps"]).To(HaveLen(len(existingUser.AllowedApps)))
+				Expect(response["roles"]).To(HaveLen(len(existingUser.Roles)))
 				Expect(response["createdBy"]).To(Equal(existingUser.CreatedBy))
 				Expect(response["createdAt"]).ToNot(BeNil())
 				Expect(response["createdAt"]).ToNot(Equal(0))
@@ -252,7 +252,10 @@ var _ = Describe("User Handler", func() {
 		Describe("successfully", func() {
 			It("should return 200 and the updated user without updating createdBy", func() {
 				existingUser := CreateTestUser(app.DB)
-				payload := GetUserPayload(map[string]interface{}{"isAdmin": false, "allowedApps": []uuid.UUID{uuid.NewV4(), uuid.NewV4()}})
+				payload := GetUserPayload(map[string]interface{}{"isAdmin": false, "roles": model.AppRoles{
+					uuid.NewV4().String(): []string{model.RoleEditor},
+					uuid.NewV4().String(): []string{model.RoleSender, model.RoleApprover},
+				}})
 				pl, _ := json.Marshal(payload)
 				status, body := Put(app, fmt.Sprintf("/users/%s", existingUser.ID), string(pl), "test@test.com")
 				Expect(status).To(Equal(http.StatusOK))
@@ -266,7 +269,7 @@ var _ = Describe("User Handler", func() {
 				Expect(response["createdBy"]).To(Equal(existingUser.CreatedBy))
 				Expect(int64(response["createdAt"].(float64))).To(Equal(existingUser.CreatedAt))
 				Expect(response["updatedAt"]).ToNot(Equal(existingUser.UpdatedAt))
-				Expect(response["allowedApps"]).To(HaveLen(2))
+				Expect(response["roles"]).To(HaveLen(2))
 
 				id, err := uuid.FromString(response["id"].(string))
 				Expect(err).NotTo(HaveOccurred())
@@ -279,7 +282,7 @@ var _ = Describe("User Handler", func() {
 				Expect(dbUser.Email).To(Equal(existingUser.Email))
 				Expect(dbUser.IsAdmin).To(Equal(payload["isAdmin"]))
 				Expect(dbUser.CreatedBy).To(Equal(existingUser.CreatedBy))
-				Expect(dbUser.AllowedApps).To(HaveLen(2))
+				Expect(dbUser.Roles).To(Equal(payload["roles"]))
 			})
 		})
 
@@ -370,4 +373,100 @@ var _ = Describe("User Handler", func() {
 			})
 		})
 	})
+
+	Describe("User roles", func() {
+		var existingApp *model.App
+		var existingUser *model.User
+		BeforeEach(func() {
+			existingApp = CreateTestApp(app.DB)
+			existingUser = CreateTestUser(app.DB, map[string]interface{}{"isAdmin": false, "roles": model.AppRoles{}})
+		})
+
+		It("should set, list and remove the roles of a user in an app", func() {
+			pl, _ := json.Marshal(map[string]interface{}{"roles": []string{model.RoleEditor, model.RoleSender}})
+			status, body := Put(app, fmt.Sprintf("/users/%s/roles/%s", existingUser.ID, existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["roles"]).To(HaveKeyWithValue(existingApp.ID.String(), []interface{}{"editor", "sender"}))
+
+			status, body = Get(app, fmt.Sprintf("/users/%s/roles", existingUser.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var roles model.AppRoles
+			err = json.Unmarshal([]byte(body), &roles)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(roles).To(Equal(model.AppRoles{existingApp.ID.String(): []string{"editor", "sender"}}))
+
+			status, _ = Delete(app, fmt.Sprintf("/users/%s/roles/%s", existingUser.ID, existingApp.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			dbUser := &model.User{ID: existingUser.ID}
+			err = app.DB.Select(dbUser)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbUser.Roles).To(BeEmpty())
+		})
+
+		It("should replace the roles of the user in the app only", func() {
+			otherAppID := uuid.NewV4().String()
+			user := CreateTestUser(app.DB, map[string]interface{}{"isAdmin": false, "roles": model.AppRoles{
+				existingApp.ID.String(): []string{model.RoleAdmin},
+				otherAppID:              []string{model.RoleViewer},
+			}})
+			pl, _ := json.Marshal(map[string]interface{}{"roles": []string{model.RoleApprover}})
+			status, _ := Put(app, fmt.Sprintf("/users/%s/roles/%s", user.ID, existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			dbUser := &model.User{ID: user.ID}
+			err := app.DB.Select(dbUser)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbUser.Roles).To(Equal(model.AppRoles{
+				existingApp.ID.String(): []string{model.RoleApprover},
+				otherAppID:              []string{model.RoleViewer},
+			}))
+		})
+
+		It("should return 422 if a role does not exist or there are no roles", func() {
+			pl, _ := json.Marshal(map[string]interface{}{"roles": []string{"owner"}})
+			status, body := Put(app, fmt.Sprintf("/users/%s/roles/%s", existingUser.ID, existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("invalid role owner"))
+
+			pl, _ = json.Marshal(map[string]interface{}{"roles": []string{}})
+			status, _ = Put(app, fmt.Sprintf("/users/%s/roles/%s", existingUser.ID, existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should return 422 if the app does not exist", func() {
+			pl, _ := json.Marshal(map[string]interface{}{"roles": []string{model.RoleViewer}})
+			status, body := Put(app, fmt.Sprintf("/users/%s/roles/%s", existingUser.ID, uuid.NewV4()), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("App not found with given id."))
+		})
+
+		It("should return 404 if the user does not exist", func() {
+			pl, _ := json.Marshal(map[string]interface{}{"roles": []string{model.RoleViewer}})
+			status, _ := Put(app, fmt.Sprintf("/users/%s/roles/%s", uuid.NewV4(), existingApp.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+
+			status, _ = Get(app, fmt.Sprintf("/users/%s/roles", uuid.NewV4()), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return 403 if user is not admin", func() {
+			CreateTestUser(app.DB, map[string]interface{}{"email": "test2@test.com", "isAdmin": false, "roles": model.AppRoles{
+				existingApp.ID.String(): []string{model.RoleAdmin},
+			}})
+			pl, _ := json.Marshal(map[string]interface{}{"roles": []string{model.RoleAdmin}})
+			status, _ := Put(app, fmt.Sprintf("/users/%s/roles/%s", existingUser.ID, existingApp.ID), string(pl), "test2@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+
+		It("should return 422 if a role in the user payload does not exist", func() {
+			payload := GetUserPayload(map[string]interface{}{"roles": model.AppRoles{uuid.NewV4().String(): []string{"owner"}}})
+			pl, _ := json.Marshal(payload)
+			status, _ := Post(app, "/users", string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
 })
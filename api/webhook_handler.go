@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	"gopkg.in/pg.v5/types"
+)
+
+// ListWebhooksHandler is the method called when a get to /apps/:aid/webhooks is called
+func (a *Application) ListWebhooksHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "webhookHandler"),
+		zap.String("operation", "listWebhooks"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	webhooks := []model.Webhook{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(&webhooks).Where("app_id = ?", aid).Order("created_at ASC").Select()
+	})
+	if err != nil {
+		log.E(l, "Failed to list webhooks.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// PostWebhookHandler is the method called when a post to /apps/:aid/webhooks is called,
+// the secret is generated when it is not given and only returned in this response
+func (a *Application) PostWebhookHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "webhookHandler"),
+		zap.String("operation", "createWebhook"),
+		zap.String("appId", c.Param("aid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	webhook := &model.Webhook{Enabled: true}
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, webhook)
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: webhook})
+	}
+	webhook.ID = uuid.NewV4()
+	webhook.AppID = aid
+	webhook.CreatedBy = c.Get("user-email").(string)
+	webhook.CreatedAt = time.Now().UnixNano()
+	webhook.UpdatedAt = webhook.CreatedAt
+
+	app := &model.App{ID: aid}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Select(app)
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "App not found with given id."})
+		}
+		log.E(l, "Failed to retrieve app.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+
+	if webhook.Secret == "" {
+		err = webhook.GenerateSecret()
+	}
+	if err == nil {
+		err = WithSegment("db-insert", c, func() error {
+			return a.DB.Insert(webhook)
+		})
+	}
+	if err != nil {
+		log.E(l, "Failed to create webhook.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.I(l, "Created webhook successfully.", func(cm log.CM) {
+		cm.Write(zap.String("id", webhook.ID.String()), zap.String("url", webhook.URL))
+	})
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+// GetWebhookHandler is the method called when a get to /apps/:aid/webhooks/:wid is called
+func (a *Application) GetWebhookHandler(c echo.Context) error {
+	webhook, status, err := a.getWebhook(c)
+	if err != nil {
+		return c.JSON(status, &Error{Reason: err.Error()})
+	}
+	if webhook == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	webhook.Secret = ""
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// PutWebhookHandler is the method called when a put to /apps/:aid/webhooks/:wid is called,
+// the fields missing in the payload keep their values
+func (a *Application) PutWebhookHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "webhookHandler"),
+		zap.String("operation", "updateWebhook"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("webhookId", c.Param("wid")),
+	)
+	webhook, status, err := a.getWebhook(c)
+	if err != nil {
+		return c.JSON(status, &Error{Reason: err.Error()})
+	}
+	if webhook == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	id, aid, createdBy, createdAt := webhook.ID, webhook.AppID, webhook.CreatedBy, webhook.CreatedAt
+	err = WithSegment("decodeAndValidate", c, func() error {
+		return decodeAndValidate(c, webhook)
+	})
+	if err != nil {
+		webhook.Secret = ""
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error(), Value: webhook})
+	}
+	webhook.ID, webhook.AppID, webhook.CreatedBy, webhook.CreatedAt = id, aid, createdBy, createdAt
+	webhook.UpdatedAt = time.Now().UnixNano()
+	if webhook.Secret == "" {
+		err = webhook.GenerateSecret()
+	}
+	if err == nil {
+		err = WithSegment("db-update", c, func() error {
+			_, err := a.DB.Model(webhook).Column("url", "events", "secret", "enabled", "updated_at").Update()
+			return err
+		})
+	}
+	if err != nil {
+		log.E(l, "Failed to update webhook.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	log.I(l, "Updated webhook successfully.")
+	webhook.Secret = ""
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhookHandler is the method called when a delete to /apps/:aid/webhooks/:wid is called,
+// the deliveries of the webhook are deleted with it
+func (a *Application) DeleteWebhookHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "webhookHandler"),
+		zap.String("operation", "deleteWebhook"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("webhookId", c.Param("wid")),
+	)
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	wid, err := uuid.FromString(c.Param("wid"))
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+	}
+	var res *types.Result
+	err = WithSegment("db-delete", c, func() error {
+		res, err = a.DB.Model(&model.Webhook{}).Where("id = ? AND app_id = ?", wid, aid).Delete()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to delete webhook.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	if res.RowsAffected() == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	log.I(l, "Deleted webhook successfully.")
+	return c.JSON(http.StatusNoContent, "")
+}
+
+// ListWebhookDeliveriesHandler is the method called when a get to /apps/:aid/webhooks/:wid/deliveries
+// is called, the deliveries are filtered by the status and jobId query params and returned newest first
+func (a *Application) ListWebhookDeliveriesHandler(c echo.Context) error {
+	l := a.Logger.With(
+		zap.String("source", "webhookHandler"),
+		zap.String("operation", "listWebhookDeliveries"),
+		zap.String("appId", c.Param("aid")),
+		zap.String("webhookId", c.Param("wid")),
+	)
+	webhook, status, err := a.getWebhook(c)
+	if err != nil {
+		return c.JSON(status, &Error{Reason: err.Error()})
+	}
+	if webhook == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{})
+	}
+	page, err := intQueryParam(c, "page", 1)
+	if err != nil || page < 1 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "page must be a positive integer"})
+	}
+	perPage, err := intQueryParam(c, "perPage", 50)
+	if err != nil || perPage < 1 || perPage > 500 {
+		return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: "perPage must be an integer between 1 and 500"})
+	}
+
+	deliveries := []model.WebhookDelivery{}
+	query := a.DB.Model(&deliveries).Where("webhook_id = ?", webhook.ID)
+	if value := c.QueryParam("status"); value != "" {
+		query = query.Where("status = ?", value)
+	}
+	if value := c.QueryParam("jobId"); value != "" {
+		jid, err := uuid.FromString(value)
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, &Error{Reason: err.Error()})
+		}
+		query = query.Where("job_id = ?", jid)
+	}
+
+	var total int
+	err = WithSegment("db-select", c, func() error {
+		var err error
+		total, err = query.Order("created_at DESC").Limit(int(perPage)).Offset(int((page - 1) * perPage)).SelectAndCount()
+		return err
+	})
+	if err != nil {
+		log.E(l, "Failed to list webhook deliveries.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return c.JSON(http.StatusInternalServerError, &Error{Reason: err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deliveries": deliveries,
+		"page":       page,
+		"perPage":    perPage,
+		"total":      total,
+	})
+}
+
+// getWebhook returns the webhook :wid of the app :aid, or nil if it does not exist. The
+// returned status is the response status of the error
+func (a *Application) getWebhook(c echo.Context) (*model.Webhook, int, error) {
+	aid, err := uuid.FromString(c.Param("aid"))
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	wid, err := uuid.FromString(c.Param("wid"))
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+	webhook := &model.Webhook{}
+	err = WithSegment("db-select", c, func() error {
+		return a.DB.Model(webhook).Where("id = ? AND app_id = ?", wid, aid).Select()
+	})
+	if err != nil {
+		if err.Error() == RecordNotFoundString {
+			return nil, http.StatusNotFound, nil
+		}
+		log.E(a.Logger, "Failed to retrieve webhook.", func(cm log.CM) {
+			cm.Write(zap.String("webhookId", wid.String()), zap.Error(err))
+		})
+		return nil, http.StatusInternalServerError, err
+	}
+	return webhook, http.StatusOK, nil
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Webhook Handler", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	app := GetDefaultTestApp(logger)
+	var existingApp *model.App
+	var baseRoute string
+
+	w := worker.NewWorker(logger, GetConfPath())
+
+	BeforeEach(func() {
+		app.DB.Exec("DELETE FROM apps;")
+		app.DB.Exec("DELETE FROM templates;")
+		app.DB.Exec("DELETE FROM users;")
+		app.DB.Exec("DELETE FROM audit_logs;")
+		w.RedisClient.FlushAll()
+
+		existingApp = CreateTestApp(app.DB)
+		baseRoute = fmt.Sprintf("/apps/%s/webhooks", existingApp.ID)
+		CreateTestUser(app.DB, map[string]interface{}{"email": "test@test.com", "isAdmin": true})
+		CreateTestUser(app.DB, map[string]interface{}{
+			"email":   "sender@test.com",
+			"isAdmin": false,
+			"roles":   model.AppRoles{existingApp.ID.String(): []string{model.RoleSender}},
+		})
+	})
+
+	Describe("Post /apps/:aid/webhooks", func() {
+		It("should create a webhook and return its generated secret once", func() {
+			pl, _ := json.Marshal(GetWebhookPayload())
+			status, body := Post(app, baseRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response["appId"]).To(Equal(existingApp.ID.String()))
+			Expect(response["url"]).To(Equal("http://localhost:8080/webhook"))
+			Expect(response["events"]).To(ConsistOf(model.WebhookEventCompleted, model.WebhookEventCircuitBreak))
+			Expect(response["enabled"]).To(BeTrue())
+			Expect(response["createdBy"]).To(Equal("test@test.com"))
+			Expect(response["secret"]).To(HaveLen(64))
+
+			webhook := &model.Webhook{ID: uuid.FromStringOrNil(response["id"].(string))}
+			err = app.DB.Select(webhook)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhook.Secret).To(Equal(response["secret"]))
+
+			status, body = Get(app, fmt.Sprintf("%s/%s", baseRoute, webhook.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).NotTo(ContainSubstring(webhook.Secret))
+		})
+
+		It("should keep the given secret", func() {
+			payload := GetWebhookPayload()
+			payload["secret"] = "my-secret"
+			pl, _ := json.Marshal(payload)
+			status, body := Post(app, baseRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+			Expect(body).To(ContainSubstring(`"secret":"my-secret"`))
+		})
+
+		It("should return 422 if the url is invalid", func() {
+			pl, _ := json.Marshal(GetWebhookPayload(map[string]interface{}{"url": "ftp://example.com"}))
+			status, body := Post(app, baseRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("url"))
+		})
+
+		It("should return 422 if an event is invalid", func() {
+			pl, _ := json.Marshal(GetWebhookPayload(map[string]interface{}{"events": []string{"exploded"}}))
+			status, body := Post(app, baseRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+			Expect(body).To(ContainSubstring("events"))
+		})
+
+		It("should return 422 if there are no events", func() {
+			pl, _ := json.Marshal(GetWebhookPayload(map[string]interface{}{"events": []string{}}))
+			status, _ := Post(app, baseRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should return 403 if the user cannot manage the app", func() {
+			pl, _ := json.Marshal(GetWebhookPayload())
+			status, _ := Post(app, baseRoute, string(pl), "sender@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+
+		It("should not store the secret in the audit log", func() {
+			payload := GetWebhookPayload()
+			payload["secret"] = "my-secret"
+			pl, _ := json.Marshal(payload)
+			status, _ := Post(app, baseRoute, string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusCreated))
+
+			auditLogs := []model.AuditLog{}
+			err := app.DB.Model(&auditLogs).Where("resource_type = 'webhook'").Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(auditLogs).To(HaveLen(1))
+			Expect(auditLogs[0].After["secret"]).To(Equal(model.AuditRedacted))
+		})
+	})
+
+	Describe("Get /apps/:aid/webhooks", func() {
+		It("should list the webhooks of the app without their secrets", func() {
+			webhooks := []*model.Webhook{
+				CreateTestWebhook(app.DB, existingApp.ID),
+				CreateTestWebhook(app.DB, existingApp.ID),
+			}
+			CreateTestWebhook(app.DB, CreateTestApp(app.DB).ID)
+
+			status, body := Get(app, baseRoute, "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var response []map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response).To(HaveLen(2))
+			for i, webhook := range response {
+				Expect(webhook["id"]).To(Equal(webhooks[i].ID.String()))
+				Expect(webhook).NotTo(HaveKey("secret"))
+			}
+		})
+
+		It("should return 403 if the user cannot manage the app", func() {
+			status, _ := Get(app, baseRoute, "sender@test.com")
+			Expect(status).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Describe("Get /apps/:aid/webhooks/:wid", func() {
+		It("should return 404 if the webhook is of another app", func() {
+			webhook := CreateTestWebhook(app.DB, CreateTestApp(app.DB).ID)
+			status, _ := Get(app, fmt.Sprintf("%s/%s", baseRoute, webhook.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return 422 if the webhook id is invalid", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/not-uuid", baseRoute), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Describe("Put /apps/:aid/webhooks/:wid", func() {
+		It("should update the given fields and keep the secret", func() {
+			webhook := CreateTestWebhook(app.DB, existingApp.ID)
+			pl, _ := json.Marshal(map[string]interface{}{
+				"events":  []string{model.WebhookEventFailed},
+				"enabled": false,
+			})
+			status, body := Put(app, fmt.Sprintf("%s/%s", baseRoute, webhook.ID), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).NotTo(ContainSubstring(webhook.Secret))
+
+			updated := &model.Webhook{ID: webhook.ID}
+			err := app.DB.Select(updated)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.URL).To(Equal(webhook.URL))
+			Expect(updated.Events).To(Equal([]string{model.WebhookEventFailed}))
+			Expect(updated.Enabled).To(BeFalse())
+			Expect(updated.Secret).To(Equal(webhook.Secret))
+			Expect(updated.CreatedBy).To(Equal(webhook.CreatedBy))
+		})
+
+		It("should return 404 if the webhook does not exist", func() {
+			pl, _ := json.Marshal(GetWebhookPayload())
+			status, _ := Put(app, fmt.Sprintf("%s/%s", baseRoute, uuid.NewV4()), string(pl), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Delete /apps/:aid/webhooks/:wid", func() {
+		It("should delete the webhook", func() {
+			webhook := CreateTestWebhook(app.DB, existingApp.ID)
+			status, _ := Delete(app, fmt.Sprintf("%s/%s", baseRoute, webhook.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNoContent))
+
+			status, _ = Delete(app, fmt.Sprintf("%s/%s", baseRoute, webhook.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("Job events", func() {
+		var existingJob *model.Job
+		var webhook *model.Webhook
+
+		BeforeEach(func() {
+			template := CreateTestTemplate(app.DB, existingApp.ID)
+			existingJob = CreateTestJob(app.DB, existingApp.ID, template.Name)
+			webhook = CreateTestWebhook(app.DB, existingApp.ID, map[string]interface{}{
+				"events": []string{model.WebhookEventPaused, model.WebhookEventStopped},
+			})
+			CreateTestWebhook(app.DB, existingApp.ID, map[string]interface{}{
+				"events": []string{model.WebhookEventCompleted},
+			})
+			CreateTestWebhook(app.DB, existingApp.ID, map[string]interface{}{
+				"events":  []string{model.WebhookEventPaused},
+				"enabled": false,
+			})
+		})
+
+		listDeliveries := func(query string) map[string]interface{} {
+			status, body := Get(app, fmt.Sprintf("%s/%s/deliveries%s", baseRoute, webhook.ID, query), "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			var response map[string]interface{}
+			err := json.Unmarshal([]byte(body), &response)
+			Expect(err).NotTo(HaveOccurred())
+			return response
+		}
+
+		It("should deliver the paused and stopped events to the subscribed webhooks", func() {
+			status, _ := Put(app, fmt.Sprintf("/apps/%s/jobs/%s/pause", existingApp.ID, existingJob.ID), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+			time.Sleep(time.Millisecond)
+			status, _ = Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, existingJob.ID), "", "test@test.com")
+			Expect(status).To(Equal(http.StatusOK))
+
+			queued, err := w.RedisClient.LLen("queue:webhook_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queued).To(BeEquivalentTo(2))
+
+			response := listDeliveries("")
+			Expect(response["total"]).To(BeEquivalentTo(2))
+			deliveries := response["deliveries"].([]interface{})
+			stopped := deliveries[0].(map[string]interface{})
+			Expect(stopped["event"]).To(Equal(model.WebhookEventStopped))
+			Expect(stopped["status"]).To(Equal(model.WebhookDeliveryPending))
+			Expect(stopped["jobId"]).To(Equal(existingJob.ID.String()))
+			payload := stopped["payload"].(map[string]interface{})
+			Expect(payload["event"]).To(Equal(model.WebhookEventStopped))
+			Expect(payload["id"]).To(Equal(stopped["id"]))
+			Expect(payload["job"].(map[string]interface{})["status"]).To(Equal("stopped"))
+			Expect(deliveries[1].(map[string]interface{})["event"]).To(Equal(model.WebhookEventPaused))
+		})
+
+		It("should filter and paginate the deliveries", func() {
+			Put(app, fmt.Sprintf("/apps/%s/jobs/%s/pause", existingApp.ID, existingJob.ID), "", "test@test.com")
+			Put(app, fmt.Sprintf("/apps/%s/jobs/%s/stop", existingApp.ID, existingJob.ID), "", "test@test.com")
+
+			response := listDeliveries("?perPage=1&page=2")
+			Expect(response["total"]).To(BeEquivalentTo(2))
+			Expect(response["deliveries"]).To(HaveLen(1))
+
+			response = listDeliveries(fmt.Sprintf("?jobId=%s", uuid.NewV4()))
+			Expect(response["total"]).To(BeEquivalentTo(0))
+
+			response = listDeliveries("?status=success")
+			Expect(response["total"]).To(BeEquivalentTo(0))
+		})
+
+		It("should return 422 if perPage is invalid", func() {
+			status, _ := Get(app, fmt.Sprintf("%s/%s/deliveries?perPage=1000", baseRoute, webhook.ID), "test@test.com")
+			Expect(status).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+})
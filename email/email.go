@@ -33,16 +33,18 @@ import (
 	"github.com/topfreegames/marathon/model"
 )
 
+var platformsByService = map[string]string{
+	"apns":    "iOS",
+	"gcm":     "Android",
+	"webpush": "Web",
+	"email":   "Email",
+}
+
 func getPlatformFromService(service string) string {
-	var platform string
-	if service == "apns" {
-		platform = "iOS"
-	} else if service == "gcm" {
-		platform = "Android"
-	} else {
-		platform = fmt.Sprintf("Unknown platform for service %s", service)
+	if platform, ok := platformsByService[service]; ok {
+		return platform
 	}
-	return platform
+	return fmt.Sprintf("Unknown platform for service %s", service)
 }
 
 //SendCreatedJobEmail builds a created job email message and sends it with sendgrid
@@ -219,3 +221,72 @@ Sent from: %s
 `, appName, job.TemplateName, platform, job.ID, job.CreatedBy, stats, host)
 	return sendgridClient.SendgridSendEmail(job.CreatedBy, subject, message, false)
 }
+
+func audienceInfo(audience int) string {
+	if audience == model.UnknownAudience {
+		return "unknown"
+	}
+	return fmt.Sprintf("about %d users", audience)
+}
+
+//SendApprovalRequestedEmail builds an email asking the approver to approve or reject a job and sends it with sendgrid
+func SendApprovalRequestedEmail(sendgridClient *extensions.SendgridClient, job *model.Job, appName string, audience int, approver string) error {
+	subject := "Push job waiting for approval"
+	platform := getPlatformFromService(job.Service)
+
+	message := fmt.Sprintf(`
+Hello, a push job needs your approval before being sent.
+
+App: %s
+Template: %s
+Platform: %s
+JobID: %s
+JobGroupID: %s
+CreatedBy: %s
+Audience: %s
+
+Please approve or reject it, it will not be sent until another user approves it.
+`, appName, job.TemplateName, platform, job.ID, job.JobGroupID, job.CreatedBy, audienceInfo(audience))
+	return sendgridClient.SendgridSendEmail(approver, subject, message, false)
+}
+
+//SendJobApprovedEmail builds an approved job email message and sends it with sendgrid
+func SendJobApprovedEmail(sendgridClient *extensions.SendgridClient, job *model.Job, appName, approvedBy string) error {
+	subject := "Push job approved"
+	platform := getPlatformFromService(job.Service)
+
+	message := fmt.Sprintf(`
+Hello, your push job was approved and will be sent.
+
+ApprovedBy: %s
+
+App: %s
+Template: %s
+Platform: %s
+JobID: %s
+CreatedBy: %s
+`, approvedBy, appName, job.TemplateName, platform, job.ID, job.CreatedBy)
+	return sendgridClient.SendgridSendEmail(job.CreatedBy, subject, message, false)
+}
+
+//SendJobRejectedEmail builds a rejected job email message and sends it with sendgrid
+func SendJobRejectedEmail(sendgridClient *extensions.SendgridClient, job *model.Job, appName, rejectedBy, reason string) error {
+	subject := "Push job rejected"
+	platform := getPlatformFromService(job.Service)
+
+	message := fmt.Sprintf(`
+Hello, your push job was rejected and will not be sent.
+
+RejectedBy: %s
+Reason: %s
+
+App: %s
+Template: %s
+Platform: %s
+JobID: %s
+CreatedBy: %s
+
+This action is irreversible, please create a new job if it should still be sent.
+`, rejectedBy, reason, appName, job.TemplateName, platform, job.ID, job.CreatedBy)
+	return sendgridClient.SendgridSendEmail(job.CreatedBy, subject, message, false)
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/uber-go/zap"
+)
+
+// FileProducer is the push producer that writes the messages to a file, one JSON per line.
+// It is meant for local development, use "stdout" as the path to print the messages.
+type FileProducer struct {
+	Config *viper.Viper
+	Logger zap.Logger
+	Path   string
+	Writer io.Writer
+	Statsd *statsd.Client
+	file   *os.File
+	mutex  sync.Mutex
+}
+
+// FileMessage is the line written to the file for each message
+type FileMessage struct {
+	Topic   string          `json:"topic"`
+	Service string          `json:"service"`
+	Message json.RawMessage `json:"message"`
+}
+
+// NewFileProducer creates a new file producer
+func NewFileProducer(config *viper.Viper, logger zap.Logger, statsd *statsd.Client) (*FileProducer, error) {
+	l := logger.With(
+		zap.String("source", "FileExtension"),
+	)
+	client := &FileProducer{
+		Config: config,
+		Logger: l,
+		Statsd: statsd,
+	}
+
+	client.loadConfigurationDefaults()
+	err := client.configure()
+	if err != nil {
+		return nil, err
+	}
+	l.Info("configured file producer", zap.String("path", client.Path))
+	return client, nil
+}
+
+func (c *FileProducer) loadConfigurationDefaults() {
+	c.Config.SetDefault("pushProducer.file.path", "stdout")
+}
+
+func (c *FileProducer) configure() error {
+	c.Path = c.Config.GetString("pushProducer.file.path")
+	if c.Path == "stdout" || c.Path == "-" {
+		c.Writer = os.Stdout
+		return nil
+	}
+	file, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	c.file = file
+	c.Writer = file
+	return nil
+}
+
+// Close the file
+func (c *FileProducer) Close() {
+	if c.file != nil {
+		c.file.Close()
+	}
+}
+
+// Send builds the message with the channel of its service and writes it to the file
+func (c *FileProducer) Send(ctx context.Context, msg *messages.PushMessage) error {
+	message, err := messages.Build(msg)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(&FileMessage{
+		Topic:   msg.Topic,
+		Service: msg.Service,
+		Message: json.RawMessage(message),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	_, err = c.Writer.Write(append(line, '\n'))
+	c.mutex.Unlock()
+
+	// delivery errors are only reported to statsd, as the kafka producer does
+	if err != nil {
+		c.Statsd.Incr("send_message_return", []string{"error:true"}, 1)
+		log.E(c.Logger, "Failed to write message to file.", func(cm log.CM) {
+			cm.Write(zap.Error(err), zap.String("path", c.Path))
+		})
+		return nil
+	}
+	c.Statsd.Incr("send_message_return", []string{"error:false"}, 1)
+	log.D(c.Logger, "Sent message", func(cm log.CM) {
+		cm.Write(zap.String("topic", msg.Topic))
+	})
+	return nil
+}
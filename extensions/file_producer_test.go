@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDog/datadog-go/statsd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("File Extension", func() {
+	var logger zap.Logger
+	var config *viper.Viper
+	var statsdClient *statsd.Client
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		logger = zap.New(
+			zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+			zap.FatalLevel,
+		)
+		var err error
+		dir, err = ioutil.TempDir("", "marathon")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "messages.jsonl")
+
+		config = viper.New()
+		config.Set("pushProducer.file.path", path)
+		statsdClient, err = statsd.New("localhost:1234")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Creating new client", func() {
+		It("should write to stdout by default", func() {
+			file, err := extensions.NewFileProducer(viper.New(), logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			Expect(file.Path).To(Equal("stdout"))
+			Expect(file.Writer).To(Equal(os.Stdout))
+		})
+
+		It("should return an error if the file can not be opened", func() {
+			config.Set("pushProducer.file.path", filepath.Join(dir, "invalid", "messages.jsonl"))
+			_, err := extensions.NewFileProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Send Message", func() {
+		It("should write one line per message", func() {
+			file, err := extensions.NewFileProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, service := range []string{"apns", "gcm"} {
+				err = file.Send(context.Background(), &messages.PushMessage{
+					Topic:   "consumer",
+					Service: service,
+					Token:   "device-token",
+					Payload: map[string]interface{}{"x": 1},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			file.Close()
+
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+			Expect(lines).To(HaveLen(2))
+
+			var fileMessage extensions.FileMessage
+			err = json.Unmarshal([]byte(lines[0]), &fileMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileMessage.Topic).To(Equal("consumer"))
+			Expect(fileMessage.Service).To(Equal("apns"))
+
+			var apnsMessage messages.APNSMessage
+			err = json.Unmarshal(fileMessage.Message, &apnsMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apnsMessage.DeviceToken).To(Equal("device-token"))
+		})
+	})
+
+	Describe("Creating push producer", func() {
+		It("should create the producer of pushProducer.type", func() {
+			config.Set("pushProducer.type", "file")
+			producer, err := extensions.NewPushProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer producer.Close()
+			Expect(producer).To(BeAssignableToTypeOf(&extensions.FileProducer{}))
+		})
+
+		It("should return an error if pushProducer.type is invalid", func() {
+			config.Set("pushProducer.type", "invalid")
+			_, err := extensions.NewPushProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid push producer type: invalid"))
+		})
+	})
+})
@@ -23,12 +23,17 @@
 package extensions
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/Shopify/sarama"
 	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/interfaces"
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/messages"
 	"github.com/uber-go/zap"
@@ -46,8 +51,53 @@ type KafkaProducer struct {
 	Statsd           *statsd.Client
 	MaxMessageBytes  int
 	Retries          int
+	MessageKey       string
+	Partitioner      string
+	Headers          bool
+	Version          sarama.KafkaVersion
+	ClientID         string
+	RequiredAcks     sarama.RequiredAcks
+	Compression      sarama.CompressionCodec
+	Idempotent       bool
+	TLS              *tls.Config
+	SASL             *KafkaSASL
+	DeliveryReporter interfaces.DeliveryReporter
+
+	// returns waits for the goroutines that read the delivery results of the producer
+	returns sync.WaitGroup
+}
+
+// Kafka message keys, messages with the same key are sent to the same partition by the hash partitioner
+const (
+	MessageKeyNone   = ""
+	MessageKeyUserID = "userId"
+	MessageKeyToken  = "token"
+	MessageKeyJobID  = "jobId"
+)
+
+var partitioners = map[string]sarama.PartitionerConstructor{
+	"hash":       sarama.NewHashPartitioner,
+	"random":     sarama.NewRandomPartitioner,
+	"roundrobin": sarama.NewRoundRobinPartitioner,
+}
+
+var requiredAcks = map[string]sarama.RequiredAcks{
+	"none":  sarama.NoResponse,
+	"local": sarama.WaitForLocal,
+	"all":   sarama.WaitForAll,
+}
+
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
 }
 
+// headerKeys are the push metadata keys sent as kafka message headers
+var headerKeys = []string{"jobId", "muid", "templateName"}
+
 // NewKafkaProducer creates a new kafka producer
 func NewKafkaProducer(config *viper.Viper, logger zap.Logger, statsd *statsd.Client) (*KafkaProducer, error) {
 	l := logger.With(
@@ -60,9 +110,16 @@ func NewKafkaProducer(config *viper.Viper, logger zap.Logger, statsd *statsd.Cli
 	}
 
 	client.loadConfigurationDefaults()
-	client.configure()
+	err := client.configure()
+	if err != nil {
+		return nil, err
+	}
 
-	client.connectToKafka()
+	err = client.connectToKafka()
+	if err != nil {
+		l.Error("could not connect to kafka", zap.String("brokers", client.BootstrapBrokers), zap.Error(err))
+		return nil, fmt.Errorf("could not connect to kafka brokers %s: %s", client.BootstrapBrokers, err.Error())
+	}
 	l.Info("configured kafka producer")
 	return client, nil
 }
@@ -73,14 +130,83 @@ func (c *KafkaProducer) loadConfigurationDefaults() {
 	c.Config.SetDefault("kafka.flushFrequency", 10)
 	c.Config.SetDefault("kafka.maxMessageBytes", 1000000)
 	c.Config.SetDefault("kafka.retries", 10)
+	c.Config.SetDefault("kafka.messageKey", MessageKeyUserID)
+	c.Config.SetDefault("kafka.partitioner", "hash")
+	c.Config.SetDefault("kafka.headers", true)
+	c.Config.SetDefault("kafka.version", "0.11.0.0")
+	c.Config.SetDefault("kafka.clientId", "marathon")
+	c.Config.SetDefault("kafka.requiredAcks", "local")
+	c.Config.SetDefault("kafka.compression", "none")
+	c.Config.SetDefault("kafka.idempotent", false)
+	c.Config.SetDefault("kafka.tls.enabled", false)
+	c.Config.SetDefault("kafka.sasl.enabled", false)
+	c.Config.SetDefault("kafka.sasl.mechanism", sarama.SASLTypePlaintext)
 }
 
-func (c *KafkaProducer) configure() {
+func (c *KafkaProducer) configure() error {
 	c.BootstrapBrokers = c.Config.GetString("kafka.bootstrapServers")
 	c.FlushMaxMessages = c.Config.GetInt("kafka.flushMaxMessages")
 	c.FlushFrequency = c.Config.GetInt("kafka.flushFrequency")
 	c.MaxMessageBytes = c.Config.GetInt("kafka.maxMessageBytes")
 	c.Retries = c.Config.GetInt("kafka.retries")
+	c.MessageKey = c.Config.GetString("kafka.messageKey")
+	c.Partitioner = c.Config.GetString("kafka.partitioner")
+	c.Headers = c.Config.GetBool("kafka.headers")
+
+	switch c.MessageKey {
+	case MessageKeyNone, MessageKeyUserID, MessageKeyToken, MessageKeyJobID:
+	default:
+		return fmt.Errorf("invalid kafka message key: %s", c.MessageKey)
+	}
+	if _, ok := partitioners[c.Partitioner]; !ok {
+		return fmt.Errorf("invalid kafka partitioner: %s", c.Partitioner)
+	}
+	version, err := sarama.ParseKafkaVersion(c.Config.GetString("kafka.version"))
+	if err != nil {
+		return err
+	}
+	if c.Headers && !version.IsAtLeast(sarama.V0_11_0_0) {
+		return fmt.Errorf("kafka headers require kafka.version 0.11.0.0 or newer")
+	}
+	c.Version = version
+
+	c.ClientID = c.Config.GetString("kafka.clientId")
+	acks, ok := requiredAcks[c.Config.GetString("kafka.requiredAcks")]
+	if !ok {
+		return fmt.Errorf("invalid kafka required acks: %s", c.Config.GetString("kafka.requiredAcks"))
+	}
+	c.RequiredAcks = acks
+	codec, ok := compressionCodecs[c.Config.GetString("kafka.compression")]
+	if !ok {
+		return fmt.Errorf("invalid kafka compression: %s", c.Config.GetString("kafka.compression"))
+	}
+	if codec == sarama.CompressionZSTD && !version.IsAtLeast(sarama.V2_1_0_0) {
+		return fmt.Errorf("kafka zstd compression requires kafka.version 2.1.0.0 or newer")
+	}
+	c.Compression = codec
+	c.Idempotent = c.Config.GetBool("kafka.idempotent")
+	if c.Idempotent {
+		if c.RequiredAcks != sarama.WaitForAll {
+			return fmt.Errorf("kafka idempotent producer requires kafka.requiredAcks all")
+		}
+		if !version.IsAtLeast(sarama.V0_11_0_0) {
+			return fmt.Errorf("kafka idempotent producer requires kafka.version 0.11.0.0 or newer")
+		}
+	}
+
+	if c.Config.GetBool("kafka.tls.enabled") {
+		c.TLS, err = NewKafkaTLSConfig(c.Config)
+		if err != nil {
+			return err
+		}
+	}
+	if c.Config.GetBool("kafka.sasl.enabled") {
+		c.SASL, err = NewKafkaSASL(c.Config)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //ConnectToKafka connects with the Kafka from the broker
@@ -90,11 +216,27 @@ func (c *KafkaProducer) connectToKafka() error {
 	config.Producer.Flush.MaxMessages = c.FlushMaxMessages
 	config.Producer.Flush.Frequency = time.Duration(c.FlushFrequency) * time.Millisecond
 
-	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.ClientID = c.ClientID
+	config.Producer.RequiredAcks = c.RequiredAcks
+	config.Producer.Compression = c.Compression
 	config.Producer.Retry.Max = c.Retries
 	config.Producer.Return.Errors = true
 	config.Producer.Return.Successes = true
 	config.Producer.MaxMessageBytes = c.MaxMessageBytes
+	config.Producer.Partitioner = partitioners[c.Partitioner]
+	config.Version = c.Version
+	if c.Idempotent {
+		config.Producer.Idempotent = true
+		// the idempotent producer only keeps the ordering with one in flight request
+		config.Net.MaxOpenRequests = 1
+	}
+	if c.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = c.TLS
+	}
+	if c.SASL != nil {
+		c.SASL.Apply(config)
+	}
 
 	hosts := strings.Split(c.BootstrapBrokers, ",")
 	producer, err := sarama.NewAsyncProducer(hosts, config)
@@ -103,82 +245,133 @@ func (c *KafkaProducer) connectToKafka() error {
 	}
 	c.Producer = producer
 
+	c.returns.Add(2)
 	go func() {
-		for range producer.Successes() {
+		defer c.returns.Done()
+		for msg := range producer.Successes() {
 			c.Statsd.Incr("send_message_return", []string{"error:false"}, 1)
+			c.reportDelivery(msg, nil)
 		}
 	}()
 
 	go func() {
-		for range producer.Errors() {
+		defer c.returns.Done()
+		for perr := range producer.Errors() {
 			c.Statsd.Incr("send_message_return", []string{"error:true"}, 1)
+			log.E(c.Logger, "Failed to deliver message to Kafka.", func(cm log.CM) {
+				cm.Write(zap.Error(perr.Err), zap.String("topic", perr.Msg.Topic))
+			})
+			c.reportDelivery(perr.Msg, perr.Err)
 		}
 	}()
 
 	return nil
 }
 
-//Close the connections to kafka
+//Close the connections to kafka, it returns after the buffered messages are flushed and their deliveries reported
 func (c *KafkaProducer) Close() {
 	c.Producer.AsyncClose()
+	c.returns.Wait()
 }
 
-//SendAPNSPush notification to Kafka
-func (c *KafkaProducer) SendAPNSPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string) error {
-	msg := messages.NewAPNSMessage(
-		deviceToken,
-		pushExpiry,
-		payload,
-		messageMetadata,
-		pushMetadata,
-		templateName,
-	)
-
-	if val, ok := pushMetadata["dryRun"]; ok {
-		if dryRun, _ := val.(bool); dryRun {
-			msg.DeviceToken = GenerateFakeID(64)
-		}
-	}
-
-	message, err := msg.ToJSON()
+// Send builds the message with the channel of its service and sends it to Kafka
+func (c *KafkaProducer) Send(ctx context.Context, msg *messages.PushMessage) error {
+	message, err := messages.Build(msg)
 	if err != nil {
 		return err
 	}
-	c.sendPush(messages.NewKafkaMessage(topic, message))
+	c.produce(messages.NewKafkaMessage(msg.Topic, message), c.messageKey(msg), c.messageHeaders(msg), msg.Delivery)
 	return nil
 }
 
-//SendGCMPush notification to Kafka
-func (c *KafkaProducer) SendGCMPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string) error {
-	msg := messages.NewGCMMessage(
-		deviceToken,
-		payload,
-		messageMetadata,
-		pushMetadata,
-		pushExpiry,
-		templateName,
-	)
+func (c *KafkaProducer) messageKey(msg *messages.PushMessage) sarama.Encoder {
+	key := KafkaMessageKey(c.MessageKey, msg)
+	if key == "" {
+		return nil
+	}
+	return sarama.StringEncoder(key)
+}
 
-	if val, ok := pushMetadata["dryRun"]; ok {
-		if dryRun, _ := val.(bool); dryRun {
-			msg.To = GenerateFakeID(152)
-			msg.DryRun = true
+// KafkaMessageKey returns the kafka key of the push for the message key kind, the pushes of users
+// without id are keyed by their token so they still go to the same partition
+func KafkaMessageKey(messageKey string, msg *messages.PushMessage) string {
+	var key string
+	switch messageKey {
+	case MessageKeyToken:
+		key = msg.Token
+	case MessageKeyUserID, MessageKeyJobID:
+		if val, ok := msg.PushMetadata[messageKey]; ok && val != nil {
+			key = fmt.Sprint(val)
+		}
+		if key == "" && messageKey == MessageKeyUserID {
+			key = msg.Token
 		}
 	}
+	return key
+}
 
-	message, err := msg.ToJSON()
-	if err != nil {
-		return err
+func (c *KafkaProducer) messageHeaders(msg *messages.PushMessage) []sarama.RecordHeader {
+	if !c.Headers {
+		return nil
 	}
-	c.sendPush(messages.NewKafkaMessage(topic, message))
-	return nil
+	headers := []sarama.RecordHeader{}
+	for _, key := range headerKeys {
+		if val, ok := msg.PushMetadata[key]; ok && val != nil {
+			headers = append(headers, sarama.RecordHeader{
+				Key:   []byte(key),
+				Value: []byte(fmt.Sprint(val)),
+			})
+		}
+	}
+	return headers
+}
+
+func (c *KafkaProducer) reportDelivery(msg *sarama.ProducerMessage, err error) {
+	if c.DeliveryReporter == nil {
+		return
+	}
+	if info, ok := msg.Metadata.(*messages.DeliveryInfo); ok && info != nil {
+		c.DeliveryReporter.ReportDelivery(info, err)
+	}
+}
+
+//SendAPNSPush notification to Kafka
+func (c *KafkaProducer) SendAPNSPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string, options *messages.PushOptions) error {
+	return c.Send(context.Background(), &messages.PushMessage{
+		Topic:           topic,
+		Service:         "apns",
+		Token:           deviceToken,
+		Payload:         payload,
+		MessageMetadata: messageMetadata,
+		PushMetadata:    pushMetadata,
+		PushExpiry:      pushExpiry,
+		TemplateName:    templateName,
+		Options:         options,
+	})
+}
+
+//SendGCMPush notification to Kafka
+func (c *KafkaProducer) SendGCMPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string, options *messages.PushOptions) error {
+	return c.Send(context.Background(), &messages.PushMessage{
+		Topic:           topic,
+		Service:         "gcm",
+		Token:           deviceToken,
+		Payload:         payload,
+		MessageMetadata: messageMetadata,
+		PushMetadata:    pushMetadata,
+		PushExpiry:      pushExpiry,
+		TemplateName:    templateName,
+		Options:         options,
+	})
 }
 
-//SendPush notification to Kafka
-func (c *KafkaProducer) sendPush(msg *messages.KafkaMessage) {
+func (c *KafkaProducer) produce(msg *messages.KafkaMessage, key sarama.Encoder, headers []sarama.RecordHeader, delivery *messages.DeliveryInfo) {
 	message := &sarama.ProducerMessage{
-		Topic: msg.Topic,
-		Value: sarama.StringEncoder(msg.Message),
+		Topic:    msg.Topic,
+		Key:      key,
+		Value:    sarama.StringEncoder(msg.Message),
+		Headers:  headers,
+		Metadata: delivery,
 	}
 	c.Producer.Input() <- message
 	log.D(c.Logger, "Sent message", func(cm log.CM) {
@@ -23,10 +23,12 @@
 package extensions_test
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
+	"github.com/Shopify/sarama"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -115,6 +117,138 @@ var _ = Describe("Kafka Extension", func() {
 			Expect(kafka.BootstrapBrokers).NotTo(BeNil())
 			Expect(kafka.Producer).NotTo(BeNil())
 		})
+
+		It("should return connected client with compression and idempotence", func() {
+			config.Set("kafka.compression", "snappy")
+			config.Set("kafka.requiredAcks", "all")
+			config.Set("kafka.idempotent", true)
+			kafka, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer kafka.Close()
+
+			Expect(kafka.Compression).To(Equal(sarama.CompressionSnappy))
+			Expect(kafka.RequiredAcks).To(Equal(sarama.WaitForAll))
+			Expect(kafka.Idempotent).To(BeTrue())
+		})
+	})
+
+	Describe("Creating new client with invalid config", func() {
+		It("should return an error if the message key is invalid", func() {
+			config.Set("kafka.messageKey", "invalid")
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid kafka message key: invalid"))
+		})
+
+		It("should return an error if the partitioner is invalid", func() {
+			config.Set("kafka.partitioner", "invalid")
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid kafka partitioner: invalid"))
+		})
+
+		It("should return an error if the required acks are invalid", func() {
+			config.Set("kafka.requiredAcks", "some")
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid kafka required acks: some"))
+		})
+
+		It("should return an error if the compression is invalid", func() {
+			config.Set("kafka.compression", "brotli")
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid kafka compression: brotli"))
+		})
+
+		It("should return an error if the idempotent producer does not wait for all acks", func() {
+			config.Set("kafka.idempotent", true)
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error if the brokers are unreachable", func() {
+			config.Set("kafka.bootstrapServers", "localhost:1")
+			config.Set("kafka.retries", 0)
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("could not connect to kafka brokers localhost:1"))
+		})
+
+		It("should return an error if headers are enabled with a kafka version older than 0.11", func() {
+			config.Set("kafka.version", "0.10.2.0")
+			_, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Send Message", func() {
+		It("should send the message with the user id key and the job headers", func() {
+			kafka, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer kafka.Close()
+
+			err = kafka.Send(context.Background(), &messages.PushMessage{
+				Topic:   "consumer",
+				Service: "gcm",
+				Token:   "device-token",
+				Payload: map[string]interface{}{"x": 1},
+				PushMetadata: map[string]interface{}{
+					"userId":       "user-id",
+					"jobId":        "job-id",
+					"muid":         "muid",
+					"templateName": "template",
+				},
+				TemplateName: "template",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := getNextMessageFrom(testConsumer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg).NotTo(BeNil())
+
+			Expect(string(msg.Key)).To(Equal("user-id"))
+			headers := map[string]string{}
+			for _, header := range msg.Headers {
+				headers[header.Key] = string(header.Value)
+			}
+			Expect(headers).To(Equal(map[string]string{
+				"jobId":        "job-id",
+				"muid":         "muid",
+				"templateName": "template",
+			}))
+		})
+
+		It("should send the message with the token key", func() {
+			config.Set("kafka.messageKey", "token")
+			kafka, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer kafka.Close()
+
+			err = kafka.Send(context.Background(), &messages.PushMessage{
+				Topic:        "consumer",
+				Service:      "apns",
+				Token:        "device-token",
+				Payload:      map[string]interface{}{"x": 1},
+				PushMetadata: map[string]interface{}{"userId": "user-id"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := getNextMessageFrom(testConsumer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg).NotTo(BeNil())
+			Expect(string(msg.Key)).To(Equal("device-token"))
+		})
+	})
+
+	Describe("Message Key", func() {
+		It("should key the pushes of users without id by their token", func() {
+			msg := &messages.PushMessage{
+				Token:        "device-token",
+				PushMetadata: map[string]interface{}{"userId": "", "jobId": "job-id"},
+			}
+			Expect(extensions.KafkaMessageKey(extensions.MessageKeyUserID, msg)).To(Equal("device-token"))
+			Expect(extensions.KafkaMessageKey(extensions.MessageKeyJobID, msg)).To(Equal("job-id"))
+			Expect(extensions.KafkaMessageKey(extensions.MessageKeyNone, msg)).To(Equal(""))
+		})
 	})
 
 	Describe("Send GCM Message", func() {
@@ -126,7 +260,7 @@ var _ = Describe("Kafka Extension", func() {
 			payload := map[string]interface{}{"x": 1}
 			meta := map[string]interface{}{"a": 1}
 			expiry := time.Now().Unix()
-			kafka.SendGCMPush("consumer", "device-token", payload, meta, nil, expiry, "template")
+			kafka.SendGCMPush("consumer", "device-token", payload, meta, nil, expiry, "template", nil)
 			msg, err := getNextMessageFrom(testConsumer)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(msg).NotTo(BeNil())
@@ -141,6 +275,31 @@ var _ = Describe("Kafka Extension", func() {
 		})
 	})
 
+	Describe("Send FCM v1 Message", func() {
+		It("should send FCM v1 message", func() {
+			kafka, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer kafka.Close()
+
+			payload := map[string]interface{}{"x": 1}
+			meta := map[string]interface{}{"a": 1}
+			expiry := time.Now().Add(time.Hour).Unix()
+			options := &messages.PushOptions{Format: messages.FormatV1}
+			kafka.SendGCMPush("consumer", "device-token", payload, meta, nil, expiry, "template", options)
+			msg, err := getNextMessageFrom(testConsumer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg).NotTo(BeNil())
+
+			var fcmMessage messages.FCMMessage
+			err = json.Unmarshal(msg.Value, &fcmMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fcmMessage.Message.Token).To(Equal("device-token"))
+			Expect(fcmMessage.Message.Data["x"]).To(Equal("1"))
+			Expect(fcmMessage.Message.Data["m"]).To(MatchJSON(`{"a":1}`))
+			Expect(fcmMessage.Message.Android).To(HaveKey("ttl"))
+		})
+	})
+
 	Describe("Send APNS Message", func() {
 		It("should send APNS message", func() {
 			kafka, err := extensions.NewKafkaProducer(config, logger, statsdClient)
@@ -150,7 +309,7 @@ var _ = Describe("Kafka Extension", func() {
 			payload := map[string]interface{}{"x": 1}
 			meta := map[string]interface{}{"a": 1}
 			expiry := time.Now().Unix()
-			kafka.SendAPNSPush("consumer", "device-token", payload, meta, nil, expiry, "template")
+			kafka.SendAPNSPush("consumer", "device-token", payload, meta, nil, expiry, "template", nil)
 
 			msg, err := getNextMessageFrom(testConsumer)
 			Expect(err).NotTo(HaveOccurred())
@@ -164,5 +323,31 @@ var _ = Describe("Kafka Extension", func() {
 			Expect(apnsMessage.Payload.Aps["x"]).To(BeEquivalentTo(1))
 			Expect(apnsMessage.Payload.M["a"]).To(BeEquivalentTo(1))
 		})
+
+		It("should send APNS message with headers", func() {
+			kafka, err := extensions.NewKafkaProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer kafka.Close()
+
+			payload := map[string]interface{}{"x": 1}
+			expiry := time.Now().Unix()
+			options := &messages.PushOptions{
+				Format: messages.FormatV1,
+				APNS:   &messages.APNSOptions{Priority: 5, CollapseID: "collapse"},
+			}
+			kafka.SendAPNSPush("consumer", "device-token", payload, nil, nil, expiry, "template", options)
+
+			msg, err := getNextMessageFrom(testConsumer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg).NotTo(BeNil())
+
+			var apnsMessage messages.APNSMessage
+			err = json.Unmarshal(msg.Value, &apnsMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apnsMessage.Headers).NotTo(BeNil())
+			Expect(apnsMessage.Headers.Priority).To(Equal(5))
+			Expect(apnsMessage.Headers.CollapseID).To(Equal("collapse"))
+			Expect(apnsMessage.Headers.Expiration).To(BeEquivalentTo(expiry))
+		})
 	})
 })
@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Shopify/sarama"
+	"github.com/spf13/viper"
+	"github.com/xdg-go/scram"
+)
+
+// KafkaSASL is the SASL authentication used to connect to the brokers
+type KafkaSASL struct {
+	Mechanism string
+	User      string
+	Password  string
+}
+
+// NewKafkaTLSConfig builds the TLS config from kafka.tls, the CA is used to verify
+// the brokers and the cert and key are the client certificate, all of them are optional
+func NewKafkaTLSConfig(config *viper.Viper) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.GetBool("kafka.tls.insecureSkipVerify"),
+	}
+
+	caFile := config.GetString("kafka.tls.caFile")
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid kafka tls ca file: %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile := config.GetString("kafka.tls.certFile")
+	keyFile := config.GetString("kafka.tls.keyFile")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// NewKafkaSASL builds the SASL authentication from kafka.sasl, the mechanism is one
+// of PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+func NewKafkaSASL(config *viper.Viper) (*KafkaSASL, error) {
+	s := &KafkaSASL{
+		Mechanism: config.GetString("kafka.sasl.mechanism"),
+		User:      config.GetString("kafka.sasl.user"),
+		Password:  config.GetString("kafka.sasl.password"),
+	}
+	switch s.Mechanism {
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+	default:
+		return nil, fmt.Errorf("invalid kafka sasl mechanism: %s", s.Mechanism)
+	}
+	if s.User == "" {
+		return nil, fmt.Errorf("kafka.sasl.user is required")
+	}
+	return s, nil
+}
+
+// Apply sets the SASL authentication in the sarama config
+func (s *KafkaSASL) Apply(config *sarama.Config) {
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.User = s.User
+	config.Net.SASL.Password = s.Password
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(s.Mechanism)
+	switch s.Mechanism {
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &SCRAMClient{HashGenerator: sha256.New}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &SCRAMClient{HashGenerator: sha512.New}
+		}
+	}
+}
+
+// SCRAMClient is the client side of the SCRAM exchange (RFC 5802) used by sarama, it wraps the
+// conversation of github.com/xdg-go/scram
+type SCRAMClient struct {
+	HashGenerator scram.HashGeneratorFcn
+	// Nonce is used instead of a random nonce when it is set, it is meant for tests
+	Nonce string
+
+	conversation *scram.ClientConversation
+}
+
+// Begin prepares the client for the exchange with the user name and password
+func (c *SCRAMClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	if c.Nonce != "" {
+		client = client.WithNonceGenerator(func() string { return c.Nonce })
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+// Step returns the response to the server challenge
+func (c *SCRAMClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done returns true when the exchange is over
+func (c *SCRAMClient) Done() bool {
+	return c.conversation.Done()
+}
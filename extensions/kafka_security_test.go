@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions_test
+
+import (
+	"crypto/sha256"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/extensions"
+)
+
+var _ = Describe("Kafka Security", func() {
+	Describe("SASL config", func() {
+		It("should return an error if the mechanism is invalid", func() {
+			config := viper.New()
+			config.Set("kafka.sasl.mechanism", "GSSAPI")
+			config.Set("kafka.sasl.user", "user")
+			_, err := extensions.NewKafkaSASL(config)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid kafka sasl mechanism: GSSAPI"))
+		})
+
+		It("should return an error if the user is empty", func() {
+			config := viper.New()
+			config.Set("kafka.sasl.mechanism", "SCRAM-SHA-512")
+			_, err := extensions.NewKafkaSASL(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("TLS config", func() {
+		It("should return an error if the ca file does not exist", func() {
+			config := viper.New()
+			config.Set("kafka.tls.caFile", "/invalid/ca.pem")
+			_, err := extensions.NewKafkaTLSConfig(config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("SCRAM client", func() {
+		// test vector from RFC 7677
+		It("should authenticate with SCRAM-SHA-256", func() {
+			client := &extensions.SCRAMClient{
+				HashGenerator: sha256.New,
+				Nonce:         "rOprNGfwEbeRWgbNEkqO",
+			}
+			err := client.Begin("user", "pencil", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			clientFirst, err := client.Step("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientFirst).To(Equal("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+
+			clientFinal, err := client.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientFinal).To(Equal("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="))
+			Expect(client.Done()).To(BeFalse())
+
+			_, err = client.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Done()).To(BeTrue())
+		})
+
+		It("should return an error if the server signature is invalid", func() {
+			client := &extensions.SCRAMClient{
+				HashGenerator: sha256.New,
+				Nonce:         "rOprNGfwEbeRWgbNEkqO",
+			}
+			err := client.Begin("user", "pencil", "")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.Step("")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.Step("v=aW52YWxpZA==")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error if the server nonce does not extend the client nonce", func() {
+			client := &extensions.SCRAMClient{HashGenerator: sha256.New}
+			err := client.Begin("user", "pencil", "")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.Step("")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.Step("r=other,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
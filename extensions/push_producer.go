@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"fmt"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/interfaces"
+	"github.com/uber-go/zap"
+)
+
+// NewPushProducer creates the push producer chosen by pushProducer.type, one of kafka, webhook or file
+func NewPushProducer(config *viper.Viper, logger zap.Logger, statsd *statsd.Client) (interfaces.PushProducer, error) {
+	config.SetDefault("pushProducer.type", "kafka")
+	producerType := config.GetString("pushProducer.type")
+	var producer interfaces.PushProducer
+	var err error
+	switch producerType {
+	case "kafka":
+		producer, err = NewKafkaProducer(config, logger, statsd)
+	case "webhook":
+		producer, err = NewWebhookProducer(config, logger, statsd)
+	case "file":
+		producer, err = NewFileProducer(config, logger, statsd)
+	default:
+		return nil, fmt.Errorf("invalid push producer type: %s", producerType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return producer, nil
+}
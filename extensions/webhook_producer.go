@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/uber-go/zap"
+)
+
+// WebhookProducer is the push producer that posts the messages to an HTTP endpoint
+type WebhookProducer struct {
+	Config  *viper.Viper
+	Logger  zap.Logger
+	URL     string
+	Headers map[string]string
+	Timeout time.Duration
+	Client  *http.Client
+	Statsd  *statsd.Client
+}
+
+// WebhookMessage is the body posted to the webhook for each message
+type WebhookMessage struct {
+	Topic   string          `json:"topic"`
+	Service string          `json:"service"`
+	Message json.RawMessage `json:"message"`
+}
+
+// NewWebhookProducer creates a new webhook producer
+func NewWebhookProducer(config *viper.Viper, logger zap.Logger, statsd *statsd.Client) (*WebhookProducer, error) {
+	l := logger.With(
+		zap.String("source", "WebhookExtension"),
+	)
+	client := &WebhookProducer{
+		Config: config,
+		Logger: l,
+		Statsd: statsd,
+	}
+
+	client.loadConfigurationDefaults()
+	client.configure()
+
+	if client.URL == "" {
+		return nil, fmt.Errorf("pushProducer.webhook.url is required")
+	}
+	l.Info("configured webhook producer", zap.String("url", client.URL))
+	return client, nil
+}
+
+func (c *WebhookProducer) loadConfigurationDefaults() {
+	c.Config.SetDefault("pushProducer.webhook.timeout", 5000)
+}
+
+func (c *WebhookProducer) configure() {
+	c.URL = c.Config.GetString("pushProducer.webhook.url")
+	c.Headers = c.Config.GetStringMapString("pushProducer.webhook.headers")
+	c.Timeout = time.Duration(c.Config.GetInt("pushProducer.webhook.timeout")) * time.Millisecond
+	c.Client = &http.Client{Timeout: c.Timeout}
+}
+
+// Close the webhook producer
+func (c *WebhookProducer) Close() {}
+
+// Send builds the message with the channel of its service and posts it to the webhook
+func (c *WebhookProducer) Send(ctx context.Context, msg *messages.PushMessage) error {
+	message, err := messages.Build(msg)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&WebhookMessage{
+		Topic:   msg.Topic,
+		Service: msg.Service,
+		Message: json.RawMessage(message),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+
+	// delivery errors are only reported to statsd, as the kafka producer does
+	err = c.post(req)
+	if err != nil {
+		c.Statsd.Incr("send_message_return", []string{"error:true"}, 1)
+		log.E(c.Logger, "Failed to send message to webhook.", func(cm log.CM) {
+			cm.Write(zap.Error(err), zap.String("topic", msg.Topic))
+		})
+		return nil
+	}
+	c.Statsd.Incr("send_message_return", []string{"error:false"}, 1)
+	log.D(c.Logger, "Sent message", func(cm log.CM) {
+		cm.Write(zap.String("topic", msg.Topic))
+	})
+	return nil
+}
+
+func (c *WebhookProducer) post(req *http.Request) error {
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	return nil
+}
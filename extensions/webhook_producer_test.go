@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package extensions_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/DataDog/datadog-go/statsd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Webhook Extension", func() {
+	var logger zap.Logger
+	var config *viper.Viper
+	var statsdClient *statsd.Client
+	var server *httptest.Server
+	var requests []*http.Request
+	var bodies [][]byte
+	var statusCode int
+
+	BeforeEach(func() {
+		logger = zap.New(
+			zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+			zap.FatalLevel,
+		)
+		requests = []*http.Request{}
+		bodies = [][]byte{}
+		statusCode = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			w.WriteHeader(statusCode)
+		}))
+
+		config = viper.New()
+		config.Set("pushProducer.webhook.url", server.URL)
+		config.Set("pushProducer.webhook.headers", map[string]string{"authorization": "Bearer token"})
+		var err error
+		statsdClient, err = statsd.New("localhost:1234")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Creating new client", func() {
+		It("should return configured client", func() {
+			webhook, err := extensions.NewWebhookProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+			defer webhook.Close()
+
+			Expect(webhook.URL).To(Equal(server.URL))
+			Expect(webhook.Client).NotTo(BeNil())
+		})
+
+		It("should return an error if no url is configured", func() {
+			_, err := extensions.NewWebhookProducer(viper.New(), logger, statsdClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Send Message", func() {
+		It("should post the message built for its service", func() {
+			webhook, err := extensions.NewWebhookProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = webhook.Send(context.Background(), &messages.PushMessage{
+				Topic:        "consumer",
+				Service:      "gcm",
+				Token:        "device-token",
+				Payload:      map[string]interface{}{"x": 1},
+				TemplateName: "template",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Method).To(Equal("POST"))
+			Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token"))
+
+			var webhookMessage extensions.WebhookMessage
+			err = json.Unmarshal(bodies[0], &webhookMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhookMessage.Topic).To(Equal("consumer"))
+			Expect(webhookMessage.Service).To(Equal("gcm"))
+
+			var gcmMessage messages.GCMMessage
+			err = json.Unmarshal(webhookMessage.Message, &gcmMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gcmMessage.To).To(Equal("device-token"))
+			Expect(gcmMessage.Data["x"]).To(BeEquivalentTo(1))
+		})
+
+		It("should not return delivery errors", func() {
+			statusCode = http.StatusInternalServerError
+			webhook, err := extensions.NewWebhookProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = webhook.Send(context.Background(), &messages.PushMessage{
+				Topic:   "consumer",
+				Service: "apns",
+				Token:   "device-token",
+				Payload: map[string]interface{}{"x": 1},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests).To(HaveLen(1))
+		})
+
+		It("should return an error if the service has no channel", func() {
+			webhook, err := extensions.NewWebhookProducer(config, logger, statsdClient)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = webhook.Send(context.Background(), &messages.PushMessage{
+				Topic:   "consumer",
+				Service: "invalid",
+				Token:   "device-token",
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(requests).To(BeEmpty())
+		})
+	})
+})
@@ -33,7 +33,10 @@ import (
 	"github.com/spf13/viper"
 	"github.com/topfreegames/marathon/extensions"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
+	pg "gopkg.in/pg.v5"
+	redis "gopkg.in/redis.v5"
 )
 
 var feedbackCacheMutex sync.Mutex
@@ -51,6 +54,7 @@ type Handler struct {
 	FeedbackCache     map[string]map[string]int
 	FlushInterval     time.Duration
 	MarathonDB        *extensions.PGClient
+	Redis             *redis.Client
 	Logger            zap.Logger
 	run               bool
 }
@@ -95,6 +99,7 @@ func (h *Handler) configure(DBOrNil ...*extensions.PGClient) error {
 	h.loadConfigurationDefaults()
 	interval := h.Config.GetInt("feedbackListener.flushInterval")
 	h.FlushInterval = time.Duration(interval) * time.Millisecond
+	h.configureRedis()
 	if len(DBOrNil) > 0 {
 		h.MarathonDB = DBOrNil[0]
 		return nil
@@ -107,6 +112,17 @@ func (h *Handler) configure(DBOrNil ...*extensions.PGClient) error {
 	return nil
 }
 
+// configureRedis connects to the workers redis to publish the job feedbacks to the job progress
+// channels, feedbacks are still flushed to the database when it is not available
+func (h *Handler) configureRedis() {
+	client, err := extensions.NewRedis("workers", h.Config, h.Logger)
+	if err != nil {
+		h.Logger.Warn("not publishing job progress, could not connect to redis", zap.Error(err))
+		return
+	}
+	h.Redis = client
+}
+
 func (h *Handler) feedbackService(msg *Message) string {
 	if len(msg.MessageID) > 0 {
 		return GCM
@@ -114,6 +130,18 @@ func (h *Handler) feedbackService(msg *Message) string {
 	return APNS
 }
 
+// feedbackError returns the reported error, apns feedbacks report it in Err.Key
+// while gcm, webpush and email feedbacks report it in error
+func (h *Handler) feedbackError(msg *Message) string {
+	if key, ok := msg.Err["Key"].(string); ok && len(key) > 0 {
+		return key
+	}
+	if len(msg.Error) > 0 {
+		return msg.Error
+	}
+	return "unknown"
+}
+
 func (h *Handler) handleSuccessMessage(jobID string) {
 	feedbackCacheMutex.Lock()
 	if _, ok := h.FeedbackCache[jobID]; ok {
@@ -174,11 +202,7 @@ func (h *Handler) handleMessage(msg []byte) {
 	if len(message.Error) == 0 && (message.Err == nil || len(message.Err) == 0) {
 		h.handleSuccessMessage(message.Metadata["jobId"].(string))
 	} else {
-		if service == APNS {
-			h.handleErrorMessage(message.Metadata["jobId"].(string), message.Err["Key"].(string))
-		} else if service == GCM {
-			h.handleErrorMessage(message.Metadata["jobId"].(string), message.Error)
-		}
+		h.handleErrorMessage(message.Metadata["jobId"].(string), h.feedbackError(&message))
 	}
 
 }
@@ -190,7 +214,7 @@ func (h *Handler) generatePGIncrJSON(jobID string, values map[string]int) string
 		m = append(m, fmt.Sprintf(`"%s":',COALESCE(feedbacks->>'%s','0')::int + %d,'`, k, k, v))
 	}
 	joinedModifiers := strings.Join(m, ",")
-	endQ := fmt.Sprintf(`}')::jsonb WHERE id = '%s';`, jobID)
+	endQ := fmt.Sprintf(`}')::jsonb WHERE id = '%s' RETURNING feedbacks;`, jobID)
 	query := fmt.Sprintf("%s%s%s", q, joinedModifiers, endQ)
 	h.Logger.Debug("will run query", zap.String("query", query))
 	return query
@@ -208,11 +232,13 @@ func (h *Handler) flushFeedbacks() {
 		}
 		for k, v := range h.FeedbackCache {
 			query := h.generatePGIncrJSON(k, v)
-			results, err := h.MarathonDB.DB.ExecOne(query)
+			var feedbacks string
+			results, err := h.MarathonDB.DB.QueryOne(pg.Scan(&feedbacks), query)
 			if err != nil {
 				h.Logger.Error("error updating feedbacks table", zap.Error(err))
 			} else {
 				h.Logger.Debug("successfully updated rows", zap.Int("rows affected", results.RowsAffected()))
+				h.publishFeedbacks(k, feedbacks)
 			}
 			delete(h.FeedbackCache, k)
 		}
@@ -220,6 +246,28 @@ func (h *Handler) flushFeedbacks() {
 	}
 }
 
+// publishFeedbacks publishes the feedbacks of the job to its progress channel
+func (h *Handler) publishFeedbacks(jobID, feedbacks string) {
+	if h.Redis == nil || feedbacks == "" {
+		return
+	}
+	progress := &model.JobProgress{
+		JobID:     jobID,
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	if err := json.Unmarshal([]byte(feedbacks), &progress.Feedbacks); err != nil {
+		h.Logger.Error("error decoding job feedbacks", zap.Error(err))
+		return
+	}
+	b, err := json.Marshal(progress)
+	if err == nil {
+		err = h.Redis.Publish(model.JobProgressChannel(jobID), string(b)).Err()
+	}
+	if err != nil {
+		h.Logger.Error("error publishing job feedbacks", zap.Error(err))
+	}
+}
+
 // HandleMessages get messages from msgChan
 func (h *Handler) HandleMessages(msgChan *chan []byte) {
 	h.run = true
@@ -84,8 +84,8 @@ var _ = Describe("Feedback Handler", func() {
 			}
 			q := handler.generatePGIncrJSON(jobID.String(), m)
 			Expect(q).Should(SatisfyAny(
-				Equal(fmt.Sprintf(`UPDATE jobs SET feedbacks = feedbacks || CONCAT('{"ack":',COALESCE(feedbacks->>'ack','0')::int + 10,',"bad_token":',COALESCE(feedbacks->>'bad_token','0')::int + 20,'}')::jsonb WHERE id = '%s';`, jobID.String())),
-				Equal(fmt.Sprintf(`UPDATE jobs SET feedbacks = feedbacks || CONCAT('{"bad_token":',COALESCE(feedbacks->>'bad_token','0')::int + 20,',"ack":',COALESCE(feedbacks->>'ack','0')::int + 10,'}')::jsonb WHERE id = '%s';`, jobID.String())),
+				Equal(fmt.Sprintf(`UPDATE jobs SET feedbacks = feedbacks || CONCAT('{"ack":',COALESCE(feedbacks->>'ack','0')::int + 10,',"bad_token":',COALESCE(feedbacks->>'bad_token','0')::int + 20,'}')::jsonb WHERE id = '%s' RETURNING feedbacks;`, jobID.String())),
+				Equal(fmt.Sprintf(`UPDATE jobs SET feedbacks = feedbacks || CONCAT('{"bad_token":',COALESCE(feedbacks->>'bad_token','0')::int + 20,',"ack":',COALESCE(feedbacks->>'ack','0')::int + 10,'}')::jsonb WHERE id = '%s' RETURNING feedbacks;`, jobID.String())),
 			))
 		})
 
@@ -94,7 +94,7 @@ var _ = Describe("Feedback Handler", func() {
 				"bad_token": 20,
 			}
 			q := handler.generatePGIncrJSON(jobID.String(), m)
-			Expect(q).To(Equal(fmt.Sprintf(`UPDATE jobs SET feedbacks = feedbacks || CONCAT('{"bad_token":',COALESCE(feedbacks->>'bad_token','0')::int + 20,'}')::jsonb WHERE id = '%s';`, jobID.String())))
+			Expect(q).To(Equal(fmt.Sprintf(`UPDATE jobs SET feedbacks = feedbacks || CONCAT('{"bad_token":',COALESCE(feedbacks->>'bad_token','0')::int + 20,'}')::jsonb WHERE id = '%s' RETURNING feedbacks;`, jobID.String())))
 		})
 	})
 
@@ -123,6 +123,24 @@ var _ = Describe("Feedback Handler", func() {
 			}))
 		})
 
+		It("should handle a apns error message", func() {
+			Expect(len(handler.FeedbackCache)).To(Equal(0))
+			m := fmt.Sprintf("{\"DeviceToken\":\"\",\"ID\":\"\",\"Err\":{\"Key\":\"missing-device-token\"},\"metadata\":{\"jobId\":\"%s\"}}", jobID.String())
+			handler.handleMessage([]byte(m))
+			Expect(handler.FeedbackCache[jobID.String()]).To(BeEquivalentTo(map[string]int{
+				"missing-device-token": 1,
+			}))
+		})
+
+		It("should handle a error message without message id", func() {
+			Expect(len(handler.FeedbackCache)).To(Equal(0))
+			m := fmt.Sprintf("{\"error\":\"subscription_expired\",\"metadata\":{\"jobId\":\"%s\"}}", jobID.String())
+			handler.handleMessage([]byte(m))
+			Expect(handler.FeedbackCache[jobID.String()]).To(BeEquivalentTo(map[string]int{
+				"subscription_expired": 1,
+			}))
+		})
+
 		It("should do nothing if message has no metadata", func() {
 			Expect(len(handler.FeedbackCache)).To(Equal(0))
 			m := "{\"from\":\"F8DIN2OFA0X3IOV897KUVPWU9CR2GNGUIOODWUFFVMJTFGQB45CY0ZEKXV758JOY0Z46P2CUCVL9HMNI3UGE5YXZYDM1AM0DX5ENIEGESOOLOV23YCKXG39ODFJXCU3UZFIW5ZCWLSEGGM1MY7SSGT07\",\"message_id\":\"422fc070-bf0e-4005-86e9-6aafaee9f3dd\",\"message_type\":\"ack\",\"error\": null,\"category\":\"\"}"
@@ -168,7 +186,7 @@ var _ = Describe("Feedback Handler", func() {
 				return len(h.FeedbackCache)
 			}).Should(Equal(0))
 			Eventually(func() int {
-				return len(mockPG.ExecOnes)
+				return len(mockPG.Execs)
 			}).Should(Equal(1))
 		})
 	})
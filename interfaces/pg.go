@@ -28,8 +28,8 @@ import (
 	"gopkg.in/pg.v5/types"
 )
 
-//DB represents the contract for a Postgres DB
-type DB interface {
+//Queryer represents the queries shared by a Postgres DB and its transactions
+type Queryer interface {
 	Model(model ...interface{}) *orm.Query
 	Select(model interface{}) error
 	Insert(model ...interface{}) error
@@ -40,6 +40,11 @@ type DB interface {
 	ExecOne(query interface{}, params ...interface{}) (*types.Result, error)
 	Query(coll, query interface{}, params ...interface{}) (*types.Result, error)
 	QueryOne(coll, query interface{}, params ...interface{}) (*types.Result, error)
+}
+
+//DB represents the contract for a Postgres DB
+type DB interface {
+	Queryer
 	Begin() (*pg.Tx, error)
 	Close() error
 }
@@ -22,8 +22,20 @@
 
 package interfaces
 
-// PushProducer interface
+import (
+	"context"
+
+	"github.com/topfreegames/marathon/messages"
+)
+
+// PushProducer is the transport used to deliver push messages to the pushers
 type PushProducer interface {
-	SendAPNSPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string) error
-	SendGCMPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string) error
+	Send(ctx context.Context, msg *messages.PushMessage) error
+	Close()
+}
+
+// DeliveryReporter receives the asynchronous delivery results of the messages
+// sent by a push producer, err is nil if the message was delivered
+type DeliveryReporter interface {
+	ReportDelivery(info *messages.DeliveryInfo, err error)
 }
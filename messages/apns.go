@@ -34,6 +34,15 @@ type APNSMessage struct {
 	Payload     APNSPayloadContent     `json:"Payload"`
 	PushExpiry  int64                  `json:"push_expiry"`
 	Metadata    map[string]interface{} `json:"metadata"`
+	Headers     *APNSHeaders           `json:"headers,omitempty"`
+}
+
+// APNSHeaders stores the APNs HTTP/2 request headers, only sent in the v1 format
+type APNSHeaders struct {
+	Priority   int    `json:"apns-priority,omitempty"`
+	CollapseID string `json:"apns-collapse-id,omitempty"`
+	PushType   string `json:"apns-push-type,omitempty"`
+	Expiration int64  `json:"apns-expiration,omitempty"`
 }
 
 // APNSPayloadContent stores payload content of apns message
@@ -41,6 +50,9 @@ type APNSPayloadContent struct {
 	Aps          map[string]interface{} `json:"aps"`
 	M            map[string]interface{} `json:"m,omitempty"`
 	TemplateName string                 `json:"templateName"`
+	Image        string                 `json:"image,omitempty"`
+	URL          string                 `json:"url,omitempty"`
+	Actions      []RichAction           `json:"actions,omitempty"`
 }
 
 // NewAPNSMessage builds an APNSMessage
@@ -69,6 +81,37 @@ func NewAPNSMessage(deviceToken string, pushExpiry int64, aps, messageMetadata m
 	return msg
 }
 
+// NewAPNSV1Message builds an APNSMessage with the APNs headers and aps fields from opts
+func NewAPNSV1Message(deviceToken string, pushExpiry int64, aps, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, templateName string, opts *APNSOptions) *APNSMessage {
+	msg := NewAPNSMessage(deviceToken, pushExpiry, aps, messageMetadata, pushMetadata, templateName)
+	msg.Headers = &APNSHeaders{
+		Expiration: pushExpiry,
+	}
+	if opts == nil {
+		return msg
+	}
+
+	msg.Headers.Priority = opts.Priority
+	msg.Headers.CollapseID = opts.CollapseID
+	msg.Headers.PushType = opts.PushType
+	if opts.ThreadID != "" {
+		msg.Payload.Aps["thread-id"] = opts.ThreadID
+	}
+	if opts.MutableContent {
+		msg.Payload.Aps["mutable-content"] = 1
+	}
+	return msg
+}
+
+// applyRichContent maps the rich content to the aps dictionary, the image, url
+// and actions are custom keys handled by the app
+func (m *APNSMessage) applyRichContent(rich *RichContent) {
+	rich.applyToAps(m.Payload.Aps)
+	m.Payload.Image = rich.Image
+	m.Payload.URL = rich.URL
+	m.Payload.Actions = rich.Actions
+}
+
 //ToJSON returns the serialized message
 func (m *APNSMessage) ToJSON() (string, error) {
 	b, err := json.Marshal(m)
@@ -62,4 +62,46 @@ var _ = Describe("APNS Message", func() {
 			Expect(msg.Metadata).To(BeEquivalentTo(empty))
 		})
 	})
+
+	Describe("Creating new v1 message", func() {
+		It("should return message with headers", func() {
+			aps := map[string]interface{}{"alert": "hello"}
+			opts := &messages.APNSOptions{
+				Priority:       10,
+				CollapseID:     "collapse",
+				PushType:       "alert",
+				ThreadID:       "thread",
+				MutableContent: true,
+			}
+			msg := messages.NewAPNSV1Message("deviceToken", 357, aps, nil, nil, "tplname", opts)
+
+			Expect(msg).NotTo(BeNil())
+			Expect(msg.DeviceToken).To(Equal("deviceToken"))
+			Expect(msg.Headers).NotTo(BeNil())
+			Expect(msg.Headers.Priority).To(Equal(10))
+			Expect(msg.Headers.CollapseID).To(Equal("collapse"))
+			Expect(msg.Headers.PushType).To(Equal("alert"))
+			Expect(msg.Headers.Expiration).To(BeEquivalentTo(357))
+			Expect(msg.Payload.Aps["alert"]).To(Equal("hello"))
+			Expect(msg.Payload.Aps["thread-id"]).To(Equal("thread"))
+			Expect(msg.Payload.Aps["mutable-content"]).To(Equal(1))
+		})
+
+		It("should return message with only the expiration header if no options", func() {
+			msg := messages.NewAPNSV1Message("deviceToken", 357, nil, nil, nil, "tplname", nil)
+
+			Expect(msg.Headers).NotTo(BeNil())
+			Expect(msg.Headers.Expiration).To(BeEquivalentTo(357))
+			Expect(msg.Headers.Priority).To(Equal(0))
+			Expect(msg.Payload.Aps).NotTo(HaveKey("thread-id"))
+		})
+
+		It("should not serialize headers in the legacy format", func() {
+			msg := messages.NewAPNSMessage("deviceToken", 357, nil, nil, nil, "tplname")
+			str, err := msg.ToJSON()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(str).NotTo(ContainSubstring("headers"))
+		})
+	})
 })
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Builder serializes a PushMessage to the message sent to the channel topic
+type Builder func(msg *PushMessage) (string, error)
+
+var (
+	channelsMutex sync.RWMutex
+	channels      = map[string]Builder{}
+)
+
+func init() {
+	RegisterChannel("apns", BuildAPNSMessage)
+	RegisterChannel("gcm", BuildGCMMessage)
+	RegisterChannel("webpush", BuildWebPushMessage)
+	RegisterChannel("email", BuildEmailMessage)
+}
+
+// RegisterChannel registers the builder used by jobs with the given service,
+// registering an existing service replaces its builder
+func RegisterChannel(service string, builder Builder) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+	channels[service] = builder
+}
+
+// GetChannel returns the builder registered for the given service
+func GetChannel(service string) (Builder, error) {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+	builder, ok := channels[service]
+	if !ok {
+		return nil, fmt.Errorf("there is no channel registered for service %s", service)
+	}
+	return builder, nil
+}
+
+// HasChannel returns true if there is a channel registered for the given service
+func HasChannel(service string) bool {
+	_, err := GetChannel(service)
+	return err == nil
+}
+
+// Channels returns the sorted names of the registered services
+func Channels() []string {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+	services := make([]string, 0, len(channels))
+	for service := range channels {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// Build serializes the message with the builder registered for its service
+func Build(msg *PushMessage) (string, error) {
+	builder, err := GetChannel(msg.Service)
+	if err != nil {
+		return "", err
+	}
+	return builder(msg)
+}
+
+// BuildAPNSMessage builds the serialized APNS message in the format selected by the message options
+func BuildAPNSMessage(m *PushMessage) (string, error) {
+	payload, rich, err := splitRichContent(m.Payload)
+	if err != nil {
+		return "", err
+	}
+	var msg *APNSMessage
+	if m.Options.IsV1() {
+		msg = NewAPNSV1Message(m.Token, m.PushExpiry, payload, m.MessageMetadata, m.PushMetadata, m.TemplateName, m.Options.APNS)
+	} else {
+		msg = NewAPNSMessage(m.Token, m.PushExpiry, payload, m.MessageMetadata, m.PushMetadata, m.TemplateName)
+	}
+	if rich != nil {
+		msg.applyRichContent(rich)
+	}
+
+	if m.IsDryRun() {
+		msg.DeviceToken = GenerateFakeID(64)
+	}
+	return msg.ToJSON()
+}
+
+// BuildGCMMessage builds the serialized GCM message, or the FCM HTTP v1 message if the options select the v1 format
+func BuildGCMMessage(m *PushMessage) (string, error) {
+	payload, rich, err := splitRichContent(m.Payload)
+	if err != nil {
+		return "", err
+	}
+	if m.Options.IsV1() {
+		msg := NewFCMMessage(m.Token, payload, m.MessageMetadata, m.PushMetadata, m.PushExpiry, m.TemplateName, m.Options)
+		if rich != nil {
+			msg.applyRichContent(rich)
+		}
+		if m.IsDryRun() {
+			msg.Message.Token = GenerateFakeID(152)
+			msg.ValidateOnly = true
+		}
+		return msg.ToJSON()
+	}
+
+	msg := NewGCMMessage(m.Token, payload, m.MessageMetadata, m.PushMetadata, m.PushExpiry, m.TemplateName)
+	if rich != nil {
+		msg.applyRichContent(rich)
+	}
+	if m.IsDryRun() {
+		msg.To = GenerateFakeID(152)
+		msg.DryRun = true
+	}
+	return msg.ToJSON()
+}
+
+// BuildWebPushMessage builds the serialized web push message
+func BuildWebPushMessage(m *PushMessage) (string, error) {
+	payload, rich, err := splitRichContent(m.Payload)
+	if err != nil {
+		return "", err
+	}
+	var webpushOptions map[string]interface{}
+	if m.Options != nil {
+		webpushOptions = m.Options.Webpush
+	}
+	msg, err := NewWebPushMessage(m.Token, payload, m.MessageMetadata, m.PushMetadata, m.PushExpiry, m.TemplateName, webpushOptions)
+	if err != nil {
+		return "", err
+	}
+	if rich != nil {
+		msg.applyRichContent(rich)
+	}
+	if m.IsDryRun() {
+		msg.Subscription.Endpoint = GenerateFakeID(64)
+		msg.DryRun = true
+	}
+	return msg.ToJSON()
+}
+
+// BuildEmailMessage builds the serialized email message
+func BuildEmailMessage(m *PushMessage) (string, error) {
+	msg := NewEmailMessage(m.Token, m.Payload, m.MessageMetadata, m.PushMetadata, m.TemplateName)
+	if m.IsDryRun() {
+		msg.To = fmt.Sprintf("%s@dry-run.invalid", GenerateFakeID(32))
+		msg.DryRun = true
+	}
+	return msg.ToJSON()
+}
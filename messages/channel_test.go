@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/messages"
+)
+
+var _ = Describe("Channels", func() {
+	Describe("Registry", func() {
+		It("should have the default channels registered", func() {
+			Expect(messages.Channels()).To(Equal([]string{"apns", "email", "gcm", "webpush"}))
+			Expect(messages.HasChannel("apns")).To(BeTrue())
+			Expect(messages.HasChannel("gcm")).To(BeTrue())
+			Expect(messages.HasChannel("webpush")).To(BeTrue())
+			Expect(messages.HasChannel("email")).To(BeTrue())
+		})
+
+		It("should return error if the channel is not registered", func() {
+			_, err := messages.GetChannel("sms")
+			Expect(err).To(HaveOccurred())
+			Expect(messages.HasChannel("sms")).To(BeFalse())
+
+			_, err = messages.Build(&messages.PushMessage{Service: "sms"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should build messages with a registered channel", func() {
+			messages.RegisterChannel("test-channel", func(msg *messages.PushMessage) (string, error) {
+				return msg.Token, nil
+			})
+			Expect(messages.HasChannel("test-channel")).To(BeTrue())
+
+			str, err := messages.Build(&messages.PushMessage{Service: "test-channel", Token: "token"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(str).To(Equal("token"))
+		})
+	})
+
+	Describe("Build", func() {
+		It("should build apns message", func() {
+			str, err := messages.Build(&messages.PushMessage{
+				Service:      "apns",
+				Token:        "token",
+				Payload:      map[string]interface{}{"alert": "hello"},
+				TemplateName: "tpl",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var msg messages.APNSMessage
+			Expect(json.Unmarshal([]byte(str), &msg)).To(Succeed())
+			Expect(msg.DeviceToken).To(Equal("token"))
+			Expect(msg.Payload.Aps["alert"]).To(Equal("hello"))
+		})
+
+		It("should build gcm dry run message", func() {
+			str, err := messages.Build(&messages.PushMessage{
+				Service:      "gcm",
+				Token:        "token",
+				PushMetadata: map[string]interface{}{"dryRun": true},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var msg messages.GCMMessage
+			Expect(json.Unmarshal([]byte(str), &msg)).To(Succeed())
+			Expect(msg.To).To(HavePrefix("FAKE-"))
+			Expect(msg.DryRun).To(BeTrue())
+		})
+
+		It("should build webpush message from a subscription", func() {
+			str, err := messages.Build(&messages.PushMessage{
+				Service:         "webpush",
+				Token:           `{"endpoint":"https://push.example.com/abc","keys":{"p256dh":"key","auth":"auth"}}`,
+				Payload:         map[string]interface{}{"title": "hello"},
+				MessageMetadata: map[string]interface{}{"a": 1},
+				TemplateName:    "tpl",
+				Options:         &messages.PushOptions{Webpush: map[string]interface{}{"urgency": "high"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var msg messages.WebPushMessage
+			Expect(json.Unmarshal([]byte(str), &msg)).To(Succeed())
+			Expect(msg.Subscription.Endpoint).To(Equal("https://push.example.com/abc"))
+			Expect(msg.Subscription.Keys.P256dh).To(Equal("key"))
+			Expect(msg.Subscription.Keys.Auth).To(Equal("auth"))
+			Expect(msg.Payload["title"]).To(Equal("hello"))
+			Expect(msg.Payload["templateName"]).To(Equal("tpl"))
+			Expect(msg.Payload["m"]).To(BeEquivalentTo(map[string]interface{}{"a": float64(1)}))
+			Expect(msg.Urgency).To(Equal("high"))
+		})
+
+		It("should build webpush message from an endpoint", func() {
+			str, err := messages.Build(&messages.PushMessage{
+				Service: "webpush",
+				Token:   "https://push.example.com/abc",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var msg messages.WebPushMessage
+			Expect(json.Unmarshal([]byte(str), &msg)).To(Succeed())
+			Expect(msg.Subscription.Endpoint).To(Equal("https://push.example.com/abc"))
+		})
+
+		It("should return error if webpush subscription is invalid", func() {
+			_, err := messages.Build(&messages.PushMessage{
+				Service: "webpush",
+				Token:   "{invalid",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should build email message", func() {
+			str, err := messages.Build(&messages.PushMessage{
+				Service:      "email",
+				Token:        "someone@example.com",
+				Payload:      map[string]interface{}{"subject": "Hi", "html": "<b>hello</b>", "button": "play"},
+				PushMetadata: map[string]interface{}{"jobId": "id"},
+				TemplateName: "tpl",
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var msg messages.EmailMessage
+			Expect(json.Unmarshal([]byte(str), &msg)).To(Succeed())
+			Expect(msg.To).To(Equal("someone@example.com"))
+			Expect(msg.Subject).To(Equal("Hi"))
+			Expect(msg.HTML).To(Equal("<b>hello</b>"))
+			Expect(msg.Data).To(Equal(map[string]interface{}{"button": "play", "templateName": "tpl"}))
+			Expect(msg.Metadata["jobId"]).To(Equal("id"))
+			Expect(msg.DryRun).To(BeFalse())
+		})
+	})
+})
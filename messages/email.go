@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages
+
+import "encoding/json"
+
+// EmailMessage is the struct to store an email message
+// The subject, html and text keys of the template body are used as the email content,
+// every other key is sent as data to the email consumer
+type EmailMessage struct {
+	To       string                 `json:"to"`
+	Subject  string                 `json:"subject"`
+	HTML     string                 `json:"html,omitempty"`
+	Text     string                 `json:"text,omitempty"`
+	Data     map[string]interface{} `json:"data"`
+	DryRun   bool                   `json:"dry_run"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// NewEmailMessage builds a new email message
+func NewEmailMessage(to string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, templateName string) *EmailMessage {
+	if pushMetadata == nil {
+		pushMetadata = map[string]interface{}{}
+	}
+
+	msg := &EmailMessage{
+		To:       to,
+		Data:     map[string]interface{}{},
+		Metadata: pushMetadata,
+	}
+	for k, v := range payload {
+		switch k {
+		case "subject":
+			msg.Subject = toDataString(v)
+		case "html":
+			msg.HTML = toDataString(v)
+		case "text":
+			msg.Text = toDataString(v)
+		default:
+			msg.Data[k] = v
+		}
+	}
+
+	msg.Data["templateName"] = templateName
+	if len(messageMetadata) > 0 {
+		msg.Data["m"] = messageMetadata
+	}
+	return msg
+}
+
+// ToJSON returns the serialized message
+func (m *EmailMessage) ToJSON() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FCMMessage is the struct to store a FCM HTTP v1 message
+// For more info on the FCM HTTP v1 format refer to:
+// https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages
+type FCMMessage struct {
+	Message      FCMMessageContent      `json:"message"`
+	ValidateOnly bool                   `json:"validate_only,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata"`
+}
+
+// FCMMessageContent stores the message content of a FCM HTTP v1 message
+type FCMMessageContent struct {
+	Token        string                 `json:"token"`
+	Data         map[string]string      `json:"data,omitempty"`
+	Notification map[string]interface{} `json:"notification,omitempty"`
+	Android      map[string]interface{} `json:"android,omitempty"`
+	APNS         map[string]interface{} `json:"apns,omitempty"`
+	Webpush      map[string]interface{} `json:"webpush,omitempty"`
+}
+
+// NewFCMMessage builds a new FCM HTTP v1 Message
+// The notification, android, apns and webpush keys of data are moved to their own blocks,
+// every other key is sent as data, which FCM requires to be a map of strings
+func NewFCMMessage(token string, data, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string, opts *PushOptions) *FCMMessage {
+	if pushMetadata == nil {
+		pushMetadata = map[string]interface{}{}
+	}
+
+	content := FCMMessageContent{
+		Token: token,
+		Data:  map[string]string{},
+	}
+	for k, v := range data {
+		switch k {
+		case "notification":
+			content.Notification = toMap(v)
+		case "android":
+			content.Android = toMap(v)
+		case "apns":
+			content.APNS = toMap(v)
+		case "webpush":
+			content.Webpush = toMap(v)
+		default:
+			content.Data[k] = toDataString(v)
+		}
+	}
+
+	content.Data["templateName"] = templateName
+	if len(messageMetadata) > 0 {
+		content.Data["m"] = toDataString(messageMetadata)
+	}
+
+	if opts != nil {
+		content.Android = mergeMaps(opts.Android, content.Android)
+		content.Webpush = mergeMaps(opts.Webpush, content.Webpush)
+		if opts.APNS != nil {
+			content.APNS = mergeMaps(fcmAPNSBlock(opts.APNS, pushExpiry), content.APNS)
+		}
+	}
+
+	if ttl := pushExpiry - time.Now().Unix(); pushExpiry > 0 && ttl > 0 {
+		if content.Android == nil {
+			content.Android = map[string]interface{}{}
+		}
+		if _, ok := content.Android["ttl"]; !ok {
+			content.Android["ttl"] = fmt.Sprintf("%ds", ttl)
+		}
+	}
+
+	return &FCMMessage{
+		Message:  content,
+		Metadata: pushMetadata,
+	}
+}
+
+func fcmAPNSBlock(opts *APNSOptions, pushExpiry int64) map[string]interface{} {
+	headers := map[string]interface{}{}
+	if opts.Priority != 0 {
+		headers["apns-priority"] = fmt.Sprintf("%d", opts.Priority)
+	}
+	if opts.CollapseID != "" {
+		headers["apns-collapse-id"] = opts.CollapseID
+	}
+	if opts.PushType != "" {
+		headers["apns-push-type"] = opts.PushType
+	}
+	if pushExpiry > 0 {
+		headers["apns-expiration"] = fmt.Sprintf("%d", pushExpiry)
+	}
+
+	aps := map[string]interface{}{}
+	if opts.ThreadID != "" {
+		aps["thread-id"] = opts.ThreadID
+	}
+	if opts.MutableContent {
+		aps["mutable-content"] = 1
+	}
+
+	if len(headers) == 0 && len(aps) == 0 {
+		return nil
+	}
+	block := map[string]interface{}{}
+	if len(headers) > 0 {
+		block["headers"] = headers
+	}
+	if len(aps) > 0 {
+		block["payload"] = map[string]interface{}{"aps": aps}
+	}
+	return block
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m
+	}
+	return nil
+}
+
+func toDataString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// mergeMaps returns a new map with the keys of base overridden by the keys of override
+func mergeMaps(base, override map[string]interface{}) map[string]interface{} {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := map[string]interface{}{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// applyRichContent maps the rich content to the notification, android and apns blocks and to the data,
+// the blocks of the template body and the push options override the rich content
+func (m *FCMMessage) applyRichContent(rich *RichContent) {
+	content := &m.Message
+	if notification := rich.notification(); len(notification) > 0 {
+		content.Notification = deepMergeMaps(notification, content.Notification)
+	}
+	if notification := rich.fcmAndroidNotification(); len(notification) > 0 {
+		content.Android = deepMergeMaps(map[string]interface{}{"notification": notification}, content.Android)
+	}
+	aps := map[string]interface{}{}
+	rich.applyToAps(aps)
+	if len(aps) > 0 {
+		block := map[string]interface{}{"payload": map[string]interface{}{"aps": aps}}
+		if rich.Image != "" {
+			block["fcm_options"] = map[string]interface{}{"image": rich.Image}
+		}
+		content.APNS = deepMergeMaps(block, content.APNS)
+	}
+	for k, v := range rich.data() {
+		if _, ok := content.Data[k]; !ok {
+			content.Data[k] = toDataString(v)
+		}
+	}
+}
+
+// deepMergeMaps returns a new map with the keys of base overridden by the keys of override,
+// nested maps are merged key by key
+func deepMergeMaps(base, override map[string]interface{}) map[string]interface{} {
+	merged := mergeMaps(base, override)
+	for k, v := range override {
+		baseMap, baseIsMap := base[k].(map[string]interface{})
+		overrideMap, overrideIsMap := v.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[k] = deepMergeMaps(baseMap, overrideMap)
+		}
+	}
+	return merged
+}
+
+// ToJSON returns the serialized message
+func (m *FCMMessage) ToJSON() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
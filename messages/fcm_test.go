@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/messages"
+)
+
+var _ = Describe("FCM Message", func() {
+	Describe("Creating new message", func() {
+		It("should return message with stringified data", func() {
+			data := map[string]interface{}{"x": 1, "y": "z", "w": map[string]interface{}{"a": "b"}}
+			msg := messages.NewFCMMessage("token", data, nil, nil, 0, "my-template", nil)
+			Expect(msg).NotTo(BeNil())
+			Expect(msg.Message.Token).To(Equal("token"))
+			Expect(msg.Message.Data).To(Equal(map[string]string{
+				"x":            "1",
+				"y":            "z",
+				"w":            `{"a":"b"}`,
+				"templateName": "my-template",
+			}))
+			Expect(msg.Message.Android).To(BeNil())
+			Expect(msg.ValidateOnly).To(BeFalse())
+			Expect(msg.Metadata).To(BeEquivalentTo(map[string]interface{}{}))
+		})
+
+		It("should move the platform blocks out of data", func() {
+			data := map[string]interface{}{
+				"notification": map[string]interface{}{"title": "hello"},
+				"android":      map[string]interface{}{"priority": "high"},
+				"webpush":      map[string]interface{}{"headers": map[string]interface{}{"Urgency": "high"}},
+			}
+			msg := messages.NewFCMMessage("token", data, nil, nil, 0, "my-template", nil)
+			Expect(msg.Message.Notification).To(Equal(map[string]interface{}{"title": "hello"}))
+			Expect(msg.Message.Android).To(Equal(map[string]interface{}{"priority": "high"}))
+			Expect(msg.Message.Webpush).To(HaveKey("headers"))
+			Expect(msg.Message.Data).To(Equal(map[string]string{"templateName": "my-template"}))
+		})
+
+		It("should add the message metadata as json", func() {
+			mtd := map[string]interface{}{"a": 1}
+			msg := messages.NewFCMMessage("token", nil, mtd, nil, 0, "my-template", nil)
+			Expect(msg.Message.Data["m"]).To(MatchJSON(`{"a":1}`))
+		})
+
+		It("should set the android ttl from the push expiry", func() {
+			expiry := time.Now().Add(time.Hour).Unix()
+			msg := messages.NewFCMMessage("token", nil, nil, nil, expiry, "my-template", nil)
+			Expect(msg.Message.Android["ttl"]).To(MatchRegexp(`^\d+s$`))
+		})
+
+		It("should merge the push options with the template blocks", func() {
+			data := map[string]interface{}{
+				"android": map[string]interface{}{"priority": "high"},
+			}
+			opts := &messages.PushOptions{
+				Format:  messages.FormatV1,
+				Android: map[string]interface{}{"priority": "normal", "collapse_key": "key"},
+				APNS:    &messages.APNSOptions{Priority: 5, ThreadID: "thread"},
+			}
+			msg := messages.NewFCMMessage("token", data, nil, nil, 0, "my-template", opts)
+			Expect(msg.Message.Android).To(Equal(map[string]interface{}{"priority": "high", "collapse_key": "key"}))
+			Expect(msg.Message.APNS["headers"]).To(Equal(map[string]interface{}{"apns-priority": "5"}))
+			Expect(msg.Message.APNS["payload"]).To(Equal(map[string]interface{}{
+				"aps": map[string]interface{}{"thread-id": "thread"},
+			}))
+		})
+
+		It("should serialize to the v1 format", func() {
+			msg := messages.NewFCMMessage("token", map[string]interface{}{"x": 1}, nil, nil, 0, "my-template", nil)
+			str, err := msg.ToJSON()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(str).To(MatchJSON(`{
+				"message": {"token": "token", "data": {"x": "1", "templateName": "my-template"}},
+				"metadata": {}
+			}`))
+		})
+	})
+})
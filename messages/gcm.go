@@ -37,6 +37,7 @@ type GCMMessage struct {
 	DeliveryReceiptRequest bool                   `json:"delivery_receipt_requested,omitempty"`
 	DryRun                 bool                   `json:"dry_run"`
 	MessageID              string                 `json:"message_id"`
+	Notification           map[string]interface{} `json:"notification,omitempty"`
 	Metadata               map[string]interface{} `json:"metadata"`
 }
 
@@ -65,6 +66,19 @@ func NewGCMMessage(to string, data, messageMetadata map[string]interface{}, push
 	return msg
 }
 
+// applyRichContent maps the rich content to the notification, the image, url
+// and actions are also sent as data unless the data already has them
+func (m *GCMMessage) applyRichContent(rich *RichContent) {
+	if notification := rich.gcmNotification(); len(notification) > 0 {
+		m.Notification = notification
+	}
+	for k, v := range rich.data() {
+		if _, ok := m.Data[k]; !ok {
+			m.Data[k] = v
+		}
+	}
+}
+
 //ToJSON returns the serialized message
 func (m *GCMMessage) ToJSON() (string, error) {
 	b, err := json.Marshal(m)
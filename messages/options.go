@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages
+
+import "fmt"
+
+// FormatLegacy builds messages using the legacy APNS and GCM formats
+const FormatLegacy = "legacy"
+
+// FormatV1 builds messages using the APNs HTTP/2 headers and the FCM HTTP v1 format
+const FormatV1 = "v1"
+
+var apnsPushTypes = map[string]bool{
+	"alert":        true,
+	"background":   true,
+	"voip":         true,
+	"complication": true,
+	"fileprovider": true,
+	"mdm":          true,
+}
+
+// PushOptions stores the platform specific options used to build a message
+type PushOptions struct {
+	Format  string                 `json:"format,omitempty"`
+	APNS    *APNSOptions           `json:"apns,omitempty"`
+	Android map[string]interface{} `json:"android,omitempty"`
+	Webpush map[string]interface{} `json:"webpush,omitempty"`
+}
+
+// APNSOptions stores the APNs headers and the aps fields that are not part of the template
+type APNSOptions struct {
+	Priority       int    `json:"priority,omitempty"`
+	CollapseID     string `json:"collapseId,omitempty"`
+	PushType       string `json:"pushType,omitempty"`
+	ThreadID       string `json:"threadId,omitempty"`
+	MutableContent bool   `json:"mutableContent,omitempty"`
+}
+
+// IsValidFormat returns true if format is a known message format, empty means the default one
+func IsValidFormat(format string) bool {
+	return format == "" || format == FormatLegacy || format == FormatV1
+}
+
+// IsV1 returns true if the options select the v1 message format
+func (o *PushOptions) IsV1() bool {
+	return o != nil && o.Format == FormatV1
+}
+
+// Validate returns an error if the options are not valid
+func (o *PushOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if !IsValidFormat(o.Format) {
+		return fmt.Errorf("format must be one of '%s' or '%s'", FormatLegacy, FormatV1)
+	}
+	if o.APNS != nil {
+		if o.APNS.Priority != 0 && o.APNS.Priority != 5 && o.APNS.Priority != 10 {
+			return fmt.Errorf("apns priority must be 5 or 10")
+		}
+		if o.APNS.PushType != "" && !apnsPushTypes[o.APNS.PushType] {
+			return fmt.Errorf("unknown apns push type %s", o.APNS.PushType)
+		}
+		if len(o.APNS.CollapseID) > 64 {
+			return fmt.Errorf("apns collapse id must have at most 64 bytes")
+		}
+	}
+	return nil
+}
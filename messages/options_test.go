@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/messages"
+)
+
+var _ = Describe("Push Options", func() {
+	Describe("Validate", func() {
+		It("should accept nil options", func() {
+			var opts *messages.PushOptions
+			Expect(opts.Validate()).To(Succeed())
+			Expect(opts.IsV1()).To(BeFalse())
+		})
+
+		It("should accept valid options", func() {
+			opts := &messages.PushOptions{
+				Format: messages.FormatV1,
+				APNS:   &messages.APNSOptions{Priority: 10, PushType: "background", CollapseID: "id"},
+			}
+			Expect(opts.Validate()).To(Succeed())
+			Expect(opts.IsV1()).To(BeTrue())
+		})
+
+		It("should return error if invalid format", func() {
+			opts := &messages.PushOptions{Format: "v2"}
+			Expect(opts.Validate()).To(HaveOccurred())
+		})
+
+		It("should return error if invalid apns priority", func() {
+			opts := &messages.PushOptions{APNS: &messages.APNSOptions{Priority: 1}}
+			Expect(opts.Validate()).To(HaveOccurred())
+		})
+
+		It("should return error if invalid apns push type", func() {
+			opts := &messages.PushOptions{APNS: &messages.APNSOptions{PushType: "invalid"}}
+			Expect(opts.Validate()).To(HaveOccurred())
+		})
+
+		It("should return error if apns collapse id is too long", func() {
+			opts := &messages.PushOptions{APNS: &messages.APNSOptions{CollapseID: string(make([]byte, 65))}}
+			Expect(opts.Validate()).To(HaveOccurred())
+		})
+	})
+})
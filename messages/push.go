@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// PushMessage is the channel independent message sent to a user, each channel
+// builder serializes it to the format expected by the channel topic consumers
+type PushMessage struct {
+	Topic           string
+	Service         string
+	Token           string
+	Payload         map[string]interface{}
+	MessageMetadata map[string]interface{}
+	PushMetadata    map[string]interface{}
+	PushExpiry      int64 // seconds since epoch
+	TemplateName    string
+	Options         *PushOptions
+	Delivery        *DeliveryInfo
+}
+
+// DeliveryInfo correlates a message with the job and batch that sent it, producers
+// that confirm deliveries asynchronously hand it back with the delivery result
+type DeliveryInfo struct {
+	JobID     string
+	BatchID   string
+	BatchSize int
+	AppName   string
+}
+
+// IsDryRun returns true if the push metadata marks the message as a dry run
+func (m *PushMessage) IsDryRun() bool {
+	if val, ok := m.PushMetadata["dryRun"]; ok {
+		if dryRun, _ := val.(bool); dryRun {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateID generates a an id with the given size
+func GenerateID(size int) string {
+	charset := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	id := make([]byte, size)
+	for i := range id {
+		n := rand.Int() % len(charset)
+		id[i] = charset[n]
+	}
+	return string(id)
+}
+
+// GenerateFakeID generates an id with a FAKE- prefix
+func GenerateFakeID(size int) string {
+	return fmt.Sprintf("FAKE-%s", GenerateID(size))
+}
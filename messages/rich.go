@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RichContentKey is the key of the template body that holds the rich content of a push
+const RichContentKey = "rich"
+
+// MaxRichActions is the maximum number of action buttons, the limit of Android notifications
+const MaxRichActions = 3
+
+// RichContent is the structured content of a push with media and actions. The builders
+// remove it from the payload and map it to the fields of each platform
+type RichContent struct {
+	Title     string       `json:"title,omitempty"`
+	Subtitle  string       `json:"subtitle,omitempty"`
+	Body      string       `json:"body,omitempty"`
+	Image     string       `json:"image,omitempty"`
+	URL       string       `json:"url,omitempty"`
+	Category  string       `json:"category,omitempty"`
+	Actions   []RichAction `json:"actions,omitempty"`
+	Sound     string       `json:"sound,omitempty"`
+	ChannelID string       `json:"channelId,omitempty"`
+	URLArgs   []string     `json:"urlArgs,omitempty"`
+}
+
+// RichAction is an action button of a push, url is the deep link opened by the action
+type RichAction struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url,omitempty"`
+}
+
+// ParseRichContent decodes the rich content of a template body, unknown fields are not accepted
+func ParseRichContent(v interface{}) (*RichContent, error) {
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%s must be an object", RichContentKey)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	rich := &RichContent{}
+	if err := decoder.Decode(rich); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", RichContentKey, err.Error())
+	}
+	return rich, nil
+}
+
+// Validate returns an error if the rich content is not valid, values with template
+// variables are only checked after they are rendered
+func (r *RichContent) Validate() error {
+	if r.Image != "" && !isTemplated(r.Image) {
+		u, err := url.Parse(r.Image)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%s image must be an https url", RichContentKey)
+		}
+	}
+	if !isValidLink(r.URL) {
+		return fmt.Errorf("%s url must be an absolute url or deep link", RichContentKey)
+	}
+	if len(r.Actions) > MaxRichActions {
+		return fmt.Errorf("%s must have at most %d actions", RichContentKey, MaxRichActions)
+	}
+	if len(r.Actions) > 0 && r.Category == "" {
+		return fmt.Errorf("%s category is required with actions", RichContentKey)
+	}
+	ids := map[string]bool{}
+	for _, action := range r.Actions {
+		if action.ID == "" || action.Title == "" {
+			return fmt.Errorf("%s actions must have an id and a title", RichContentKey)
+		}
+		if ids[action.ID] {
+			return fmt.Errorf("duplicate %s action %s", RichContentKey, action.ID)
+		}
+		ids[action.ID] = true
+		if !isValidLink(action.URL) {
+			return fmt.Errorf("%s action %s url must be an absolute url or deep link", RichContentKey, action.ID)
+		}
+	}
+	return nil
+}
+
+func isTemplated(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+func isValidLink(link string) bool {
+	if link == "" || isTemplated(link) {
+		return true
+	}
+	u, err := url.Parse(link)
+	return err == nil && u.Scheme != ""
+}
+
+// splitRichContent returns a copy of the payload without the rich content and the parsed rich content,
+// which is nil if the payload has none
+func splitRichContent(payload map[string]interface{}) (map[string]interface{}, *RichContent, error) {
+	value, ok := payload[RichContentKey]
+	if !ok {
+		return payload, nil, nil
+	}
+	rich, err := ParseRichContent(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := rich.Validate(); err != nil {
+		return nil, nil, err
+	}
+	rest := make(map[string]interface{}, len(payload)-1)
+	for k, v := range payload {
+		if k != RichContentKey {
+			rest[k] = v
+		}
+	}
+	return rest, rich, nil
+}
+
+// applyToAps sets the alert, category, sound and url args of the aps dictionary, mutable-content is
+// set when there is an image so a notification service extension can download it
+func (r *RichContent) applyToAps(aps map[string]interface{}) {
+	if r.Title != "" || r.Subtitle != "" || r.Body != "" {
+		alert := map[string]interface{}{}
+		switch current := aps["alert"].(type) {
+		case string:
+			alert["body"] = current
+		case map[string]interface{}:
+			for k, v := range current {
+				alert[k] = v
+			}
+		}
+		setIfNotEmpty(alert, "title", r.Title)
+		setIfNotEmpty(alert, "subtitle", r.Subtitle)
+		setIfNotEmpty(alert, "body", r.Body)
+		aps["alert"] = alert
+	}
+	setIfNotEmpty(aps, "category", r.Category)
+	setIfNotEmpty(aps, "sound", r.Sound)
+	if len(r.URLArgs) > 0 {
+		aps["url-args"] = r.URLArgs
+	}
+	if r.Image != "" {
+		aps["mutable-content"] = 1
+	}
+}
+
+// data returns the image, url and actions, sent as custom data for the app to handle
+func (r *RichContent) data() map[string]interface{} {
+	data := map[string]interface{}{}
+	setIfNotEmpty(data, "image", r.Image)
+	setIfNotEmpty(data, "url", r.URL)
+	if len(r.Actions) > 0 {
+		data["actions"] = r.Actions
+	}
+	return data
+}
+
+// notification returns the title, body and image of the notification
+func (r *RichContent) notification() map[string]interface{} {
+	notification := map[string]interface{}{}
+	setIfNotEmpty(notification, "title", r.Title)
+	setIfNotEmpty(notification, "body", r.Body)
+	setIfNotEmpty(notification, "image", r.Image)
+	return notification
+}
+
+// gcmNotification returns the legacy GCM notification, the category is the click action
+func (r *RichContent) gcmNotification() map[string]interface{} {
+	notification := r.notification()
+	setIfNotEmpty(notification, "click_action", r.Category)
+	setIfNotEmpty(notification, "android_channel_id", r.ChannelID)
+	setIfNotEmpty(notification, "sound", r.Sound)
+	return notification
+}
+
+// fcmAndroidNotification returns the FCM HTTP v1 android notification, the category is the click action
+func (r *RichContent) fcmAndroidNotification() map[string]interface{} {
+	notification := map[string]interface{}{}
+	setIfNotEmpty(notification, "image", r.Image)
+	setIfNotEmpty(notification, "click_action", r.Category)
+	setIfNotEmpty(notification, "channel_id", r.ChannelID)
+	setIfNotEmpty(notification, "sound", r.Sound)
+	return notification
+}
+
+// webPushNotification returns the options of the Notification shown by the service worker
+func (r *RichContent) webPushNotification() map[string]interface{} {
+	notification := r.notification()
+	if len(r.Actions) > 0 {
+		actions := make([]map[string]interface{}, len(r.Actions))
+		for i, action := range r.Actions {
+			actions[i] = map[string]interface{}{"action": action.ID, "title": action.Title}
+		}
+		notification["actions"] = actions
+	}
+	if data := r.data(); len(data) > 0 {
+		notification["data"] = data
+	}
+	return notification
+}
+
+func setIfNotEmpty(m map[string]interface{}, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
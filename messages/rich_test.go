@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package messages_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/messages"
+)
+
+var _ = Describe("Rich Content", func() {
+	var rich map[string]interface{}
+
+	BeforeEach(func() {
+		rich = map[string]interface{}{
+			"title":     "New level",
+			"body":      "Level 42 is out",
+			"image":     "https://cdn.example.com/level.png",
+			"url":       "game://levels/42",
+			"category":  "LEVEL",
+			"channelId": "news",
+			"actions": []interface{}{
+				map[string]interface{}{"id": "play", "title": "Play", "url": "game://levels/42/play"},
+				map[string]interface{}{"id": "later", "title": "Later"},
+			},
+		}
+	})
+
+	build := func(service string, options *messages.PushOptions, v interface{}) {
+		str, err := messages.Build(&messages.PushMessage{
+			Service:      service,
+			Token:        "token",
+			Payload:      map[string]interface{}{"alert": "hello", "rich": rich},
+			TemplateName: "tpl",
+			Options:      options,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(json.Unmarshal([]byte(str), v)).To(Succeed())
+	}
+
+	Describe("Parsing", func() {
+		It("should parse and validate the rich content", func() {
+			content, err := messages.ParseRichContent(rich)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content.Validate()).To(Succeed())
+			Expect(content.Image).To(Equal("https://cdn.example.com/level.png"))
+			Expect(content.Actions).To(Equal([]messages.RichAction{
+				{ID: "play", Title: "Play", URL: "game://levels/42/play"},
+				{ID: "later", Title: "Later"},
+			}))
+		})
+
+		It("should return error if the rich content is not an object", func() {
+			_, err := messages.ParseRichContent("image")
+			Expect(err).To(MatchError("rich must be an object"))
+		})
+
+		It("should return error if the rich content has unknown fields", func() {
+			rich["img"] = "https://cdn.example.com/level.png"
+			_, err := messages.ParseRichContent(rich)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("img"))
+		})
+
+		It("should accept values with template variables", func() {
+			rich["image"] = "{{image_url}}"
+			rich["url"] = "{{link}}"
+			content, err := messages.ParseRichContent(rich)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content.Validate()).To(Succeed())
+		})
+
+		It("should return error if the image is not https", func() {
+			rich["image"] = "http://cdn.example.com/level.png"
+			content, err := messages.ParseRichContent(rich)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content.Validate()).To(MatchError("rich image must be an https url"))
+		})
+
+		It("should return error if the url is not absolute", func() {
+			rich["url"] = "levels/42"
+			content, err := messages.ParseRichContent(rich)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content.Validate()).To(MatchError("rich url must be an absolute url or deep link"))
+		})
+
+		It("should return error if there are actions without category", func() {
+			delete(rich, "category")
+			content, err := messages.ParseRichContent(rich)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(content.Validate()).To(MatchError("rich category is required with actions"))
+		})
+
+		It("should return error if there are too many or invalid actions", func() {
+			content, err := messages.ParseRichContent(rich)
+			Expect(err).NotTo(HaveOccurred())
+			content.Actions = append(content.Actions, messages.RichAction{ID: "a", Title: "A"}, messages.RichAction{ID: "b", Title: "B"})
+			Expect(content.Validate()).To(MatchError("rich must have at most 3 actions"))
+
+			content.Actions = []messages.RichAction{{ID: "play"}}
+			Expect(content.Validate()).To(MatchError("rich actions must have an id and a title"))
+
+			content.Actions = []messages.RichAction{{ID: "play", Title: "Play"}, {ID: "play", Title: "Again"}}
+			Expect(content.Validate()).To(MatchError("duplicate rich action play"))
+		})
+	})
+
+	Describe("Building", func() {
+		It("should map the rich content to the apns message", func() {
+			var msg map[string]interface{}
+			build("apns", nil, &msg)
+
+			payload := msg["Payload"].(map[string]interface{})
+			aps := payload["aps"].(map[string]interface{})
+			Expect(aps).NotTo(HaveKey("rich"))
+			Expect(aps["alert"]).To(Equal(map[string]interface{}{"title": "New level", "body": "Level 42 is out"}))
+			Expect(aps["category"]).To(Equal("LEVEL"))
+			Expect(aps["mutable-content"]).To(BeEquivalentTo(1))
+			Expect(payload["image"]).To(Equal("https://cdn.example.com/level.png"))
+			Expect(payload["url"]).To(Equal("game://levels/42"))
+			Expect(payload["actions"]).To(HaveLen(2))
+		})
+
+		It("should keep the alert of the template as the apns alert body if the rich content has no body", func() {
+			delete(rich, "body")
+			var msg messages.APNSMessage
+			build("apns", &messages.PushOptions{Format: messages.FormatV1}, &msg)
+
+			Expect(msg.Payload.Aps["alert"]).To(Equal(map[string]interface{}{"title": "New level", "body": "hello"}))
+			Expect(msg.Headers).NotTo(BeNil())
+		})
+
+		It("should map the rich content to the gcm notification and data", func() {
+			var msg messages.GCMMessage
+			build("gcm", nil, &msg)
+
+			Expect(msg.Data).NotTo(HaveKey("rich"))
+			Expect(msg.Data["alert"]).To(Equal("hello"))
+			Expect(msg.Data["url"]).To(Equal("game://levels/42"))
+			Expect(msg.Data["image"]).To(Equal("https://cdn.example.com/level.png"))
+			Expect(msg.Data["actions"]).To(HaveLen(2))
+			Expect(msg.Notification).To(Equal(map[string]interface{}{
+				"title":              "New level",
+				"body":               "Level 42 is out",
+				"image":              "https://cdn.example.com/level.png",
+				"click_action":       "LEVEL",
+				"android_channel_id": "news",
+			}))
+		})
+
+		It("should map the rich content to the fcm v1 blocks", func() {
+			options := &messages.PushOptions{
+				Format: messages.FormatV1,
+				APNS:   &messages.APNSOptions{ThreadID: "levels"},
+			}
+			var msg messages.FCMMessage
+			build("gcm", options, &msg)
+
+			content := msg.Message
+			Expect(content.Data).NotTo(HaveKey("rich"))
+			Expect(content.Data["url"]).To(Equal("game://levels/42"))
+			Expect(content.Notification).To(Equal(map[string]interface{}{
+				"title": "New level",
+				"body":  "Level 42 is out",
+				"image": "https://cdn.example.com/level.png",
+			}))
+			Expect(content.Android["notification"]).To(Equal(map[string]interface{}{
+				"image":        "https://cdn.example.com/level.png",
+				"click_action": "LEVEL",
+				"channel_id":   "news",
+			}))
+			aps := content.APNS["payload"].(map[string]interface{})["aps"].(map[string]interface{})
+			Expect(aps["category"]).To(Equal("LEVEL"))
+			Expect(aps["thread-id"]).To(Equal("levels"))
+			Expect(aps["mutable-content"]).To(BeEquivalentTo(1))
+			Expect(content.APNS["fcm_options"]).To(Equal(map[string]interface{}{"image": "https://cdn.example.com/level.png"}))
+		})
+
+		It("should map the rich content to the web push notification", func() {
+			var msg messages.WebPushMessage
+			build("webpush", nil, &msg)
+
+			Expect(msg.Payload).NotTo(HaveKey("rich"))
+			notification := msg.Payload["notification"].(map[string]interface{})
+			Expect(notification["title"]).To(Equal("New level"))
+			Expect(notification["image"]).To(Equal("https://cdn.example.com/level.png"))
+			Expect(notification["actions"]).To(Equal([]interface{}{
+				map[string]interface{}{"action": "play", "title": "Play"},
+				map[string]interface{}{"action": "later", "title": "Later"},
+			}))
+			Expect(notification["data"].(map[string]interface{})["url"]).To(Equal("game://levels/42"))
+		})
+
+		It("should return error if the rendered rich content is invalid", func() {
+			rich["image"] = "not a url"
+			_, err := messages.Build(&messages.PushMessage{
+				Service: "apns",
+				Token:   "token",
+				Payload: map[string]interface{}{"rich": rich},
+			})
+			Expect(err).To(MatchError("rich image must be an https url"))
+		})
+	})
+})
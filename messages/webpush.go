@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WebPushMessage is the struct to store a web push message
+// For more info on web push refer to:
+// https://tools.ietf.org/html/rfc8030
+type WebPushMessage struct {
+	Subscription WebPushSubscription    `json:"subscription"`
+	Payload      map[string]interface{} `json:"payload"`
+	TTL          int64                  `json:"ttl,omitempty"`
+	Urgency      string                 `json:"urgency,omitempty"`
+	Topic        string                 `json:"topic,omitempty"`
+	DryRun       bool                   `json:"dry_run"`
+	Metadata     map[string]interface{} `json:"metadata"`
+}
+
+// WebPushSubscription stores the push subscription of a browser
+type WebPushSubscription struct {
+	Endpoint string                  `json:"endpoint"`
+	Keys     WebPushSubscriptionKeys `json:"keys"`
+}
+
+// WebPushSubscriptionKeys stores the keys used to encrypt the web push payload
+type WebPushSubscriptionKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// NewWebPushMessage builds a new web push message
+// The token is either the JSON serialized push subscription or just its endpoint
+func NewWebPushMessage(token string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string, opts map[string]interface{}) (*WebPushMessage, error) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	if pushMetadata == nil {
+		pushMetadata = map[string]interface{}{}
+	}
+
+	subscription := WebPushSubscription{Endpoint: token}
+	if strings.HasPrefix(strings.TrimSpace(token), "{") {
+		if err := json.Unmarshal([]byte(token), &subscription); err != nil {
+			return nil, fmt.Errorf("invalid web push subscription: %s", err.Error())
+		}
+	}
+
+	payload["templateName"] = templateName
+	if len(messageMetadata) > 0 {
+		payload["m"] = messageMetadata
+	}
+
+	msg := &WebPushMessage{
+		Subscription: subscription,
+		Payload:      payload,
+		Metadata:     pushMetadata,
+	}
+	if ttl := pushExpiry - time.Now().Unix(); pushExpiry > 0 && ttl > 0 {
+		msg.TTL = ttl
+	}
+	if urgency, ok := opts["urgency"].(string); ok {
+		msg.Urgency = urgency
+	}
+	if topic, ok := opts["topic"].(string); ok {
+		msg.Topic = topic
+	}
+	return msg, nil
+}
+
+// applyRichContent maps the rich content to the notification key of the payload, which the
+// service worker shows, the notification of the template body overrides the rich content
+func (m *WebPushMessage) applyRichContent(rich *RichContent) {
+	notification, _ := m.Payload["notification"].(map[string]interface{})
+	m.Payload["notification"] = deepMergeMaps(rich.webPushNotification(), notification)
+}
+
+// ToJSON returns the serialized message
+func (m *WebPushMessage) ToJSON() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE "apps" ADD COLUMN message_format text NOT NULL DEFAULT 'legacy';
+ALTER TABLE "jobs" ADD COLUMN push_options JSONB;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE "jobs" DROP COLUMN push_options;
+ALTER TABLE "apps" DROP COLUMN message_format;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE "templates" ADD COLUMN version integer NOT NULL DEFAULT 1;
+
+CREATE TABLE "template_versions" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "template_id" uuid NOT NULL,
+  "app_id" uuid NOT NULL,
+  "name" text NOT NULL,
+  "locale" text NOT NULL,
+  "version" integer NOT NULL,
+  "defaults" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "body" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "engine" text NOT NULL DEFAULT 'legacy',
+  "created_by" text NOT NULL,
+  "created_at" bigint,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX template_versions_name_locale_version ON "template_versions"(app_id, "name", "locale", version);
+
+ALTER TABLE "template_versions"
+ADD CONSTRAINT template_versions_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+INSERT INTO "template_versions" (template_id, app_id, name, locale, version, defaults, body, engine, created_by, created_at)
+SELECT id, app_id, name, locale, 1, defaults, body, engine, created_by, updated_at FROM "templates";
+
+ALTER TABLE "jobs" ADD COLUMN template_versions JSONB;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE "jobs" DROP COLUMN template_versions;
+DROP TABLE "template_versions";
+ALTER TABLE "templates" DROP COLUMN version;
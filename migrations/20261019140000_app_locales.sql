@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE "apps" ADD COLUMN default_locale text NOT NULL DEFAULT 'en';
+ALTER TABLE "apps" ADD COLUMN locale_fallbacks JSONB NOT NULL DEFAULT '{}'::JSONB;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE "apps" DROP COLUMN locale_fallbacks;
+ALTER TABLE "apps" DROP COLUMN default_locale;
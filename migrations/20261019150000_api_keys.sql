@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE "api_keys" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "name" text NOT NULL,
+  "email" text NOT NULL,
+  "prefix" text NOT NULL,
+  "key_hash" text NOT NULL,
+  "created_by" text NOT NULL,
+  "created_at" bigint NOT NULL,
+  "last_used_at" bigint,
+  "revoked_at" bigint,
+  PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX api_keys_key_hash ON "api_keys"(key_hash);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE "api_keys";
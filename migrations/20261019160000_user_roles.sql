@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE "users" ADD COLUMN roles JSONB NOT NULL DEFAULT '{}'::JSONB;
+UPDATE "users" SET roles = (
+  SELECT COALESCE(jsonb_object_agg(app_id::text, '["admin"]'::JSONB), '{}'::JSONB)
+  FROM unnest(allowed_apps) AS app_id
+) WHERE allowed_apps IS NOT NULL;
+ALTER TABLE "users" DROP COLUMN allowed_apps;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE "users" ADD COLUMN allowed_apps uuid[];
+UPDATE "users" SET allowed_apps = ARRAY(SELECT jsonb_object_keys(roles)::uuid);
+ALTER TABLE "users" DROP COLUMN roles;
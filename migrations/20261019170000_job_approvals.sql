@@ -0,0 +1,28 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE "job_approvals" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "job_group_id" uuid NOT NULL,
+  "app_id" uuid NOT NULL,
+  "action" text NOT NULL,
+  "audience" integer NOT NULL DEFAULT -1,
+  "reason" text NOT NULL DEFAULT '',
+  "created_by" text NOT NULL,
+  "created_at" bigint NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+ALTER TABLE "job_approvals"
+ADD CONSTRAINT job_approvals_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+CREATE INDEX job_approvals_job_group_id ON "job_approvals"(job_group_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE "job_approvals";
@@ -0,0 +1,29 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE "audit_logs" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "actor" text NOT NULL,
+  "method" text NOT NULL,
+  "route" text NOT NULL,
+  "path" text NOT NULL,
+  "status" integer NOT NULL,
+  "resource_type" text NOT NULL DEFAULT '',
+  "resource_id" text NOT NULL DEFAULT '',
+  "app_id" uuid,
+  "before" JSONB,
+  "after" JSONB,
+  "changes" JSONB,
+  "created_at" bigint NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+CREATE INDEX audit_logs_created_at ON "audit_logs"(created_at);
+CREATE INDEX audit_logs_actor ON "audit_logs"(actor);
+CREATE INDEX audit_logs_resource ON "audit_logs"(resource_type, resource_id);
+CREATE INDEX audit_logs_app_id ON "audit_logs"(app_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE "audit_logs";
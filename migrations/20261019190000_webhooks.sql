@@ -0,0 +1,56 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE "webhooks" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "app_id" uuid NOT NULL,
+  "url" text NOT NULL,
+  "events" text[] NOT NULL DEFAULT '{}',
+  "secret" text NOT NULL,
+  "enabled" boolean NOT NULL DEFAULT TRUE,
+  "created_by" text NOT NULL,
+  "created_at" bigint NOT NULL,
+  "updated_at" bigint NOT NULL,
+  PRIMARY KEY ("id")
+);
+
+ALTER TABLE "webhooks"
+ADD CONSTRAINT webhooks_app_id_apps_id_foreign
+FOREIGN KEY (app_id)
+REFERENCES apps(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+CREATE INDEX webhooks_app_id ON "webhooks"(app_id);
+
+CREATE TABLE "webhook_deliveries" (
+  "id" uuid DEFAULT uuid_generate_v4(),
+  "webhook_id" uuid NOT NULL,
+  "app_id" uuid NOT NULL,
+  "job_id" uuid NOT NULL,
+  "event" text NOT NULL,
+  "payload" JSONB NOT NULL DEFAULT '{}'::JSONB,
+  "status" text NOT NULL,
+  "attempts" integer NOT NULL DEFAULT 0,
+  "response_status" integer NOT NULL DEFAULT 0,
+  "error" text NOT NULL DEFAULT '',
+  "created_at" bigint NOT NULL,
+  "updated_at" bigint NOT NULL,
+  "delivered_at" bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY ("id")
+);
+
+ALTER TABLE "webhook_deliveries"
+ADD CONSTRAINT webhook_deliveries_webhook_id_webhooks_id_foreign
+FOREIGN KEY (webhook_id)
+REFERENCES webhooks(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+CREATE INDEX webhook_deliveries_webhook_id_created_at ON "webhook_deliveries"(webhook_id, created_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE "webhook_deliveries";
+DROP TABLE "webhooks";
@@ -0,0 +1,27 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+UPDATE "jobs" SET status = CASE
+  WHEN COALESCE(completed_at, 0) > 0 THEN 'completed'
+  WHEN COALESCE(completed_batches, 0) > 0 THEN 'running'
+  ELSE 'scheduled'
+END
+WHERE COALESCE(status, '') = '';
+
+ALTER TABLE "jobs" ALTER COLUMN status SET DEFAULT 'scheduled';
+ALTER TABLE "jobs" ALTER COLUMN status SET NOT NULL;
+ALTER TABLE "jobs" ADD CONSTRAINT jobs_status_check CHECK (status IN (
+  'pending_approval', 'rejected', 'scheduled', 'running', 'paused',
+  'circuitbreak', 'stopped', 'completed', 'failed'
+));
+
+CREATE INDEX jobs_status ON "jobs"(status);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP INDEX jobs_status;
+ALTER TABLE "jobs" DROP CONSTRAINT jobs_status_check;
+ALTER TABLE "jobs" ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE "jobs" ALTER COLUMN status DROP DEFAULT;
+UPDATE "jobs" SET status = NULL WHERE status IN ('scheduled', 'running', 'completed', 'failed');
@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE "job_batches" (
+  "job_id" uuid NOT NULL,
+  "batch_id" text NOT NULL,
+  "created_at" bigint NOT NULL,
+  PRIMARY KEY ("job_id", "batch_id")
+);
+
+ALTER TABLE "job_batches"
+ADD CONSTRAINT job_batches_job_id_jobs_id_foreign
+FOREIGN KEY (job_id)
+REFERENCES jobs(id)
+ON DELETE CASCADE
+ON UPDATE CASCADE;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE "job_batches";
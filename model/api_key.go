@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+)
+
+// APIKeyPrefix starts every api key, so keys are easy to recognize in logs and secret scanners
+const APIKeyPrefix = "mk_"
+
+// APIKey is the api key model struct, keys authenticate services as the user with the key email.
+// Only the hash of the key is stored, the key itself is returned once when it is created
+type APIKey struct {
+	ID         uuid.UUID `sql:",pk" json:"id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	Prefix     string    `json:"prefix"`
+	KeyHash    string    `json:"-"`
+	Key        string    `sql:"-" json:"key,omitempty"`
+	CreatedBy  string    `json:"createdBy"`
+	CreatedAt  int64     `json:"createdAt"`
+	LastUsedAt int64     `json:"lastUsedAt"`
+	RevokedAt  int64     `json:"revokedAt"`
+}
+
+// Validate implementation of the InputValidation interface
+func (k *APIKey) Validate(c echo.Context) error {
+	valid := govalidator.StringLength(k.Name, "1", "255")
+	if !valid {
+		return InvalidField("name")
+	}
+	valid = govalidator.IsEmail(k.Email)
+	if !valid {
+		return InvalidField("email")
+	}
+	return nil
+}
+
+// Generate sets a new random key, its hash and its prefix
+func (k *APIKey) Generate() error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	k.Key = APIKeyPrefix + strings.TrimRight(base64.URLEncoding.EncodeToString(b), "=")
+	k.KeyHash = HashAPIKey(k.Key)
+	k.Prefix = k.Key[:len(APIKeyPrefix)+6]
+	return nil
+}
+
+// HashAPIKey returns the hash stored for the key, keys are random so a plain sha256 is enough
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -26,16 +26,20 @@ import (
 	"github.com/asaskevich/govalidator"
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/messages"
 )
 
 // App is the app model struct
 type App struct {
-	ID        uuid.UUID `sql:",pk" json:"id"`
-	Name      string    `json:"name"`
-	BundleID  string    `json:"bundleId"`
-	CreatedBy string    `json:"createdBy"`
-	CreatedAt int64     `json:"createdAt"`
-	UpdatedAt int64     `json:"updatedAt"`
+	ID              uuid.UUID       `sql:",pk" json:"id"`
+	Name            string          `json:"name"`
+	BundleID        string          `json:"bundleId"`
+	MessageFormat   string          `json:"messageFormat"`
+	DefaultLocale   string          `json:"defaultLocale"`
+	LocaleFallbacks LocaleFallbacks `json:"localeFallbacks"`
+	CreatedBy       string          `json:"createdBy"`
+	CreatedAt       int64           `json:"createdAt"`
+	UpdatedAt       int64           `json:"updatedAt"`
 }
 
 // Validate implementation of the InputValidation interface
@@ -52,5 +56,21 @@ func (a *App) Validate(c echo.Context) error {
 	if !valid {
 		return InvalidField("createdBy")
 	}
+	valid = messages.IsValidFormat(a.MessageFormat)
+	if !valid {
+		return InvalidField("messageFormat")
+	}
+	a.normalizeLocales()
+	valid = govalidator.StringLength(a.DefaultLocale, "1", "10")
+	if !valid {
+		return InvalidField("defaultLocale")
+	}
+	for locale, fallbacks := range a.LocaleFallbacks {
+		for _, l := range append([]string{locale}, fallbacks...) {
+			if !govalidator.StringLength(l, "1", "10") {
+				return InvalidField("localeFallbacks")
+			}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/satori/go.uuid"
+)
+
+// AuditRedacted replaces the values of the audited fields that must not be stored
+const AuditRedacted = "[redacted]"
+
+// auditRedactedFields are the fields that are never stored in the audit log, like the plain api keys
+// and the webhook secrets
+var auditRedactedFields = []string{"key", "keyHash", "secret"}
+
+// AuditLog is the record of a mutating request
+type AuditLog struct {
+	ID           uuid.UUID              `sql:",pk" json:"id"`
+	Actor        string                 `json:"actor"`
+	Method       string                 `json:"method"`
+	Route        string                 `json:"route"`
+	Path         string                 `json:"path"`
+	Status       int                    `json:"status"`
+	ResourceType string                 `json:"resourceType"`
+	ResourceID   string                 `json:"resourceId"`
+	AppID        uuid.UUID              `sql:",null" json:"appId"`
+	Before       map[string]interface{} `json:"before"`
+	After        map[string]interface{} `json:"after"`
+	Changes      []Change               `json:"changes"`
+	CreatedAt    int64                  `json:"createdAt"`
+}
+
+// AuditState returns the JSON object of a resource as it is stored in the audit log,
+// it returns nil if v is not encoded as an object
+func AuditState(v interface{}) map[string]interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return AuditStateFromJSON(b)
+}
+
+// AuditStateFromJSON returns the JSON object in b as it is stored in the audit log,
+// it returns nil if b is not an object
+func AuditStateFromJSON(b []byte) map[string]interface{} {
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil
+	}
+	for _, field := range auditRedactedFields {
+		if _, ok := state[field]; ok {
+			state[field] = AuditRedacted
+		}
+	}
+	return state
+}
+
+// SetChanges sets the changes between the states before and after the request
+func (l *AuditLog) SetChanges() {
+	if l.Before == nil && l.After == nil {
+		return
+	}
+	before := l.Before
+	if before == nil {
+		before = map[string]interface{}{}
+	}
+	after := l.After
+	if after == nil {
+		after = map[string]interface{}{}
+	}
+	l.Changes = DiffJSON(before, after)
+}
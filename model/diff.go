@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Change types returned by DiffJSON
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is a difference between two JSON objects
+type Change struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// DiffJSON returns the changes needed to go from one JSON object to the other,
+// nested objects are compared key by key and their paths are joined by dots
+func DiffJSON(from, to map[string]interface{}) []Change {
+	return diffJSON("", from, to)
+}
+
+func diffJSON(prefix string, from, to map[string]interface{}) []Change {
+	keys := []string{}
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []Change{}
+	for _, k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		fromValue, inFrom := from[k]
+		toValue, inTo := to[k]
+		switch {
+		case !inFrom:
+			changes = append(changes, Change{Path: path, Type: ChangeAdded, To: toValue})
+		case !inTo:
+			changes = append(changes, Change{Path: path, Type: ChangeRemoved, From: fromValue})
+		default:
+			fromMap, fromIsMap := fromValue.(map[string]interface{})
+			toMap, toIsMap := toValue.(map[string]interface{})
+			if fromIsMap && toIsMap {
+				changes = append(changes, diffJSON(path, fromMap, toMap)...)
+			} else if !reflect.DeepEqual(fromValue, toValue) {
+				changes = append(changes, Change{Path: path, Type: ChangeChanged, From: fromValue, To: toValue})
+			}
+		}
+	}
+	return changes
+}
@@ -41,11 +41,14 @@ func (j *Job) GetJobInfoAndApp(db interfaces.DB) error {
 	return db.Model(j).Column("job.*", "App").Where("job.id = ?", j.ID).Select()
 }
 
-// GetJobTemplatesByNameAndLocale ...
+// GetJobTemplatesByNameAndLocale returns the job templates by name and locale, the
+// versions pinned when the job was created or, for older jobs, the current templates
 func (j *Job) GetJobTemplatesByNameAndLocale(db interfaces.DB) (map[string]map[string]Template, error) {
 	var templates []Template
 	var err error
-	if len(strings.Split(j.TemplateName, ",")) > 1 {
+	if len(j.TemplateVersions) > 0 {
+		templates, err = j.getPinnedTemplates(db)
+	} else if len(strings.Split(j.TemplateName, ",")) > 1 {
 		err = db.Model(&templates).Where(
 			"app_id = ? AND name IN (?)",
 			j.App.ID,
@@ -63,11 +66,12 @@ func (j *Job) GetJobTemplatesByNameAndLocale(db interfaces.DB) (map[string]map[s
 	}
 	templateByLocale := make(map[string]map[string]Template)
 	for _, tpl := range templates {
+		locale := NormalizeLocale(tpl.Locale)
 		if templateByLocale[tpl.Name] != nil {
-			templateByLocale[tpl.Name][tpl.Locale] = tpl
+			templateByLocale[tpl.Name][locale] = tpl
 		} else {
 			templateByLocale[tpl.Name] = map[string]Template{
-				tpl.Locale: tpl,
+				locale: tpl,
 			}
 		}
 	}
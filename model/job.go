@@ -23,6 +23,7 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/interfaces"
+	"github.com/topfreegames/marathon/messages"
 )
 
 // Job is the job model struct
@@ -41,6 +43,7 @@ type Job struct {
 	TotalUsers          int                    `json:"totalUsers"`
 	TotalTokens         int                    `json:"totalTokens"`
 	CompletedTokens     int                    `json:"completedTokens"`
+	FailedTokens        int                    `json:"failedTokens"`
 	DBPageSize          int                    `json:"dbPageSize"`
 	Localized           bool                   `json:"localized"`
 	CompletedAt         int64                  `json:"completedAt"`
@@ -48,8 +51,10 @@ type Job struct {
 	StartsAt            int64                  `json:"startsAt"`
 	Context             map[string]interface{} `json:"context"`
 	Service             string                 `json:"service"`
+	Services            []string               `json:"-" sql:"-"`
 	Filters             map[string]interface{} `json:"filters"`
 	Metadata            map[string]interface{} `json:"metadata"`
+	PushOptions         *messages.PushOptions  `json:"pushOptions"`
 	CSVPath             string                 `json:"csvPath"`
 	ControlGroupCSVPath string                 `json:"controlGroupCsvPath"`
 	CreatedBy           string                 `json:"createdBy"`
@@ -57,22 +62,106 @@ type Job struct {
 	AppID               uuid.UUID              `json:"appId"`
 	JobGroupID          uuid.UUID              `json:"jobGroupId" sql:",null"`
 	TemplateName        string                 `json:"templateName"`
+	TemplateVersions    TemplateVersions       `json:"templateVersions"`
 	PastTimeStrategy    string                 `json:"pastTimeStrategy"`
 	Status              string                 `json:"status"`
 	Feedbacks           map[string]interface{} `json:"feedbacks"`
 	CreatedAt           int64                  `json:"createdAt"`
 	UpdatedAt           int64                  `json:"updatedAt"`
 	StatusEvents        []*Status              `json:"statusEvents"`
+	Stages              []*StageNode           `json:"stages,omitempty" sql:"-"`
+}
+
+// AllServices are the services targeted by a job created with service "all"
+var AllServices = []string{"apns", "gcm"}
+
+// UnmarshalJSON decodes a job accepting service as a single service,
+// a list of services or "all", which targets every service in AllServices
+func (j *Job) UnmarshalJSON(b []byte) error {
+	type job Job
+	aux := &struct {
+		*job
+		Service json.RawMessage `json:"service"`
+	}{job: (*job)(j)}
+	if err := json.Unmarshal(b, aux); err != nil {
+		return err
+	}
+	if len(aux.Service) == 0 || string(aux.Service) == "null" {
+		return nil
+	}
+
+	var service string
+	if err := json.Unmarshal(aux.Service, &service); err == nil {
+		if service == "all" {
+			j.Services = append([]string{}, AllServices...)
+			return nil
+		}
+		j.Service = service
+		return nil
+	}
+
+	var services []string
+	if err := json.Unmarshal(aux.Service, &services); err != nil {
+		return InvalidField("service")
+	}
+	if len(services) == 1 {
+		j.Service = services[0]
+		return nil
+	}
+	j.Services = services
+	return nil
+}
+
+// GetServices returns the services targeted by the job
+func (j *Job) GetServices() []string {
+	if len(j.Services) > 0 {
+		return j.Services
+	}
+	return []string{j.Service}
+}
+
+// SplitByService returns one job per service targeted by the job, the jobs
+// share every field but the id, the service and copies of the maps
+func (j *Job) SplitByService() []*Job {
+	if len(j.Services) == 0 {
+		return []*Job{j}
+	}
+	jobs := make([]*Job, 0, len(j.Services))
+	for _, service := range j.Services {
+		job := *j
+		job.ID = uuid.NewV4()
+		job.Service = service
+		job.Services = nil
+		job.Context = copyMap(j.Context)
+		job.Filters = copyMap(j.Filters)
+		job.Metadata = copyMap(j.Metadata)
+		jobs = append(jobs, &job)
+	}
+	return jobs
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
 }
 
 // Validate implementation of the InputValidation interface
 func (j *Job) Validate(c echo.Context) error {
-	valid := govalidator.StringMatches(j.Service, "^(apns|gcm)$")
-	if !valid {
-		return InvalidField("service")
+	seen := map[string]bool{}
+	for _, service := range j.GetServices() {
+		if !messages.HasChannel(service) || seen[service] {
+			return InvalidField("service")
+		}
+		seen[service] = true
 	}
 
-	valid = j.ExpiresAt == 0 || time.Now().UnixNano() < j.ExpiresAt
+	valid := j.ExpiresAt == 0 || time.Now().UnixNano() < j.ExpiresAt
 	if !valid {
 		return InvalidField("expiresAt")
 	}
@@ -100,9 +189,42 @@ func (j *Job) Validate(c echo.Context) error {
 	if !govalidator.IsNull(j.CSVPath) && govalidator.Contains(j.CSVPath, "s3://") {
 		return InvalidField("csvPath: cannot contain s3 protocol, just the bucket path")
 	}
+
+	if err := j.PushOptions.Validate(); err != nil {
+		return InvalidField(fmt.Sprintf("pushOptions: %s", err.Error()))
+	}
 	return nil
 }
 
+// localeFilters are the filters normalized with NormalizeLocales
+var localeFilters = []string{"locale", "NOTlocale", "region", "NOTregion"}
+
+// NormalizeFilters normalizes the locale and region filters, the workers compare
+// them with the normalized locales and regions of the users
+func (j *Job) NormalizeFilters() {
+	for _, filter := range localeFilters {
+		if value, ok := j.Filters[filter].(string); ok {
+			j.Filters[filter] = NormalizeLocales(value)
+		}
+	}
+}
+
+// EffectivePushOptions returns the job push options with the message format resolved,
+// the job format has precedence over the app format and legacy is the default
+func (j *Job) EffectivePushOptions() *messages.PushOptions {
+	opts := &messages.PushOptions{}
+	if j.PushOptions != nil {
+		*opts = *j.PushOptions
+	}
+	if opts.Format == "" {
+		opts.Format = j.App.MessageFormat
+	}
+	if opts.Format == "" {
+		opts.Format = messages.FormatLegacy
+	}
+	return opts
+}
+
 // Labels return the labels for metrics
 func (j *Job) Labels() []string {
 	return []string{
@@ -112,24 +234,7 @@ func (j *Job) Labels() []string {
 }
 
 func (j *Job) tag(db interfaces.DB, name, message, state string) {
-	status := &Status{
-		Name:      name,
-		JobID:     j.ID,
-		ID:        uuid.NewV4(),
-		CreatedAt: time.Now().UnixNano(),
-	}
-	_, err := db.Model(status).OnConflict("(name, job_id) DO UPDATE").Set("name = EXCLUDED.name").Returning("id").Insert()
-	if err != nil {
-		panic(err)
-	}
-	event := &Events{
-		Message:   message,
-		StatusID:  status.ID,
-		State:     state,
-		ID:        uuid.NewV4(),
-		CreatedAt: time.Now().UnixNano(),
-	}
-	_, err = db.Model(event).Insert()
+	err := j.addEvent(db, name, message, state)
 	if err != nil {
 		panic(err)
 	}
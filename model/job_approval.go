@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package model
+
+import (
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+)
+
+// Actions recorded in the approval of a job group
+const (
+	JobApprovalRequested = "requested"
+	JobApprovalApproved  = "approved"
+	JobApprovalRejected  = "rejected"
+)
+
+// UnknownAudience is the audience of the jobs whose audience cannot be estimated when they are created
+const UnknownAudience = -1
+
+// JobApproval is the audit record of a step in the approval of the jobs of a job group
+type JobApproval struct {
+	ID         uuid.UUID `sql:",pk" json:"id"`
+	JobGroupID uuid.UUID `json:"jobGroupId"`
+	AppID      uuid.UUID `json:"appId"`
+	Action     string    `json:"action"`
+	Audience   int       `json:"audience"`
+	Reason     string    `json:"reason"`
+	CreatedBy  string    `json:"createdBy"`
+	CreatedAt  int64     `json:"createdAt"`
+}
+
+// JobApprovalDecision is the payload of the requests that approve or reject jobs
+type JobApprovalDecision struct {
+	Reason string `json:"reason"`
+}
+
+// Validate implementation of the InputValidation interface
+func (d *JobApprovalDecision) Validate(c echo.Context) error {
+	if !govalidator.StringLength(d.Reason, "0", "1000") {
+		return InvalidField("reason")
+	}
+	return nil
+}
@@ -28,7 +28,64 @@ import (
 
 // JobGroup is a collection of jobs
 type JobGroup struct {
-	ID    uuid.UUID `sql:",pk" json:"id"`
-	AppID uuid.UUID `json:"appId"`
-	Jobs  []*Job    `json:"jobs"`
+	ID       uuid.UUID         `sql:",pk" json:"id"`
+	AppID    uuid.UUID         `json:"appId"`
+	Jobs     []*Job            `json:"jobs"`
+	Progress *JobGroupProgress `json:"progress,omitempty" sql:"-"`
+}
+
+// JobGroupProgress is the combined progress and feedbacks of the jobs in a group
+type JobGroupProgress struct {
+	Services         []string       `json:"services"`
+	Status           string         `json:"status"`
+	TotalBatches     int            `json:"totalBatches"`
+	CompletedBatches int            `json:"completedBatches"`
+	TotalUsers       int            `json:"totalUsers"`
+	TotalTokens      int            `json:"totalTokens"`
+	CompletedTokens  int            `json:"completedTokens"`
+	FailedTokens     int            `json:"failedTokens"`
+	Feedbacks        map[string]int `json:"feedbacks"`
+}
+
+// JobGroupStatusMixed is the status of a group whose jobs have different statuses
+const JobGroupStatusMixed = "mixed"
+
+// AggregateProgress sums the progress and feedbacks of the group jobs into g.Progress
+func (g *JobGroup) AggregateProgress() *JobGroupProgress {
+	progress := &JobGroupProgress{
+		Services:  []string{},
+		Feedbacks: map[string]int{},
+	}
+	for i, job := range g.Jobs {
+		if !containsString(progress.Services, job.Service) {
+			progress.Services = append(progress.Services, job.Service)
+		}
+		if i == 0 {
+			progress.Status = job.Status
+		} else if progress.Status != job.Status {
+			progress.Status = JobGroupStatusMixed
+		}
+		progress.TotalBatches += job.TotalBatches
+		progress.CompletedBatches += job.CompletedBatches
+		progress.TotalUsers += job.TotalUsers
+		progress.TotalTokens += job.TotalTokens
+		progress.CompletedTokens += job.CompletedTokens
+		progress.FailedTokens += job.FailedTokens
+		for k, v := range job.Feedbacks {
+			if n, ok := v.(float64); ok {
+				progress.Feedbacks[k] += int(n)
+			}
+		}
+	}
+	g.Progress = progress
+	return progress
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
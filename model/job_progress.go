@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobProgressChannel returns the redis pub/sub channel where the progress of the job is published
+func JobProgressChannel(jobID string) string {
+	return fmt.Sprintf("marathon:job-progress:%s", jobID)
+}
+
+// JobProgress is a progress update of a job, only the parts that changed are set
+type JobProgress struct {
+	JobID     string                   `json:"jobId"`
+	Counters  *JobCounters             `json:"counters,omitempty"`
+	Feedbacks map[string]interface{}   `json:"feedbacks,omitempty"`
+	Stages    map[string]StageProgress `json:"stages,omitempty"`
+	UpdatedAt int64                    `json:"updatedAt"`
+}
+
+// JobCounters are the batch and token counters of a job
+type JobCounters struct {
+	Status           string `json:"status"`
+	TotalBatches     int    `json:"totalBatches"`
+	CompletedBatches int    `json:"completedBatches"`
+	TotalUsers       int    `json:"totalUsers"`
+	TotalTokens      int    `json:"totalTokens"`
+	CompletedTokens  int    `json:"completedTokens"`
+	FailedTokens     int    `json:"failedTokens"`
+	CompletedAt      int64  `json:"completedAt"`
+}
+
+// StageProgress is the progress of a stage of a job
+type StageProgress struct {
+	Description string `json:"description"`
+	Current     int    `json:"current"`
+	Max         int    `json:"max"`
+	StartedAt   int64  `json:"startedAt"`
+	UpdatedAt   int64  `json:"updatedAt"`
+	CompletedAt int64  `json:"completedAt"`
+}
+
+// NewJobCountersProgress returns the progress update with the counters of the job
+func NewJobCountersProgress(job *Job) *JobProgress {
+	return &JobProgress{
+		JobID: job.ID.String(),
+		Counters: &JobCounters{
+			Status:           job.Status,
+			TotalBatches:     job.TotalBatches,
+			CompletedBatches: job.CompletedBatches,
+			TotalUsers:       job.TotalUsers,
+			TotalTokens:      job.TotalTokens,
+			CompletedTokens:  job.CompletedTokens,
+			FailedTokens:     job.FailedTokens,
+			CompletedAt:      job.CompletedAt,
+		},
+		UpdatedAt: time.Now().UnixNano(),
+	}
+}
+
+// StageNode is a stage of a job with its sub stages, percent complete and estimated completion time
+type StageNode struct {
+	Stage       string       `json:"stage"`
+	Description string       `json:"description"`
+	Current     int          `json:"current"`
+	Max         int          `json:"max"`
+	Percent     float64      `json:"percent"`
+	StartedAt   int64        `json:"startedAt"`
+	UpdatedAt   int64        `json:"updatedAt"`
+	CompletedAt int64        `json:"completedAt"`
+	ETA         int64        `json:"eta"`
+	SubStages   []*StageNode `json:"subStages"`
+}
+
+// NewStageNode returns the stage node of the stage progress without sub stages
+func NewStageNode(stage string, progress StageProgress) *StageNode {
+	node := &StageNode{
+		Stage:       stage,
+		Description: progress.Description,
+		Current:     progress.Current,
+		Max:         progress.Max,
+		StartedAt:   progress.StartedAt,
+		UpdatedAt:   progress.UpdatedAt,
+		CompletedAt: progress.CompletedAt,
+		SubStages:   []*StageNode{},
+	}
+	if progress.Max > 0 {
+		node.Percent = 100 * float64(progress.Current) / float64(progress.Max)
+		if node.Percent > 100 {
+			node.Percent = 100
+		}
+	}
+	switch {
+	case progress.CompletedAt > 0:
+		node.ETA = progress.CompletedAt
+	case progress.Current > 0 && progress.Current < progress.Max && progress.UpdatedAt > progress.StartedAt:
+		// the remaining units are estimated to take as long as the completed ones did
+		elapsed := progress.UpdatedAt - progress.StartedAt
+		remaining := int64(float64(elapsed) / float64(progress.Current) * float64(progress.Max-progress.Current))
+		node.ETA = progress.UpdatedAt + remaining
+	}
+	return node
+}
+
+// BuildStageTree returns the stage tree of the stages of a job, sub stages are named after their
+// parent stage, e.g. 2.1 is a sub stage of 2, and stages without a parent are at the root
+func BuildStageTree(stages map[string]StageProgress) []*StageNode {
+	names := make([]string, 0, len(stages))
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return lessStage(names[i], names[j])
+	})
+
+	roots := []*StageNode{}
+	nodes := map[string]*StageNode{}
+	for _, name := range names {
+		node := NewStageNode(name, stages[name])
+		nodes[name] = node
+		parent := stageParent(name, nodes)
+		if parent == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent.SubStages = append(parent.SubStages, node)
+	}
+	return roots
+}
+
+// stageParent returns the nearest ancestor of the stage already in the tree
+func stageParent(name string, nodes map[string]*StageNode) *StageNode {
+	for i := strings.LastIndex(name, "."); i > 0; i = strings.LastIndex(name, ".") {
+		name = name[:i]
+		if node, ok := nodes[name]; ok {
+			return node
+		}
+	}
+	return nil
+}
+
+// lessStage orders stages by their numeric segments, so 2.10 comes after 2.9
+func lessStage(a, b string) bool {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/interfaces"
+	pg "gopkg.in/pg.v5"
+)
+
+// Statuses of a job, the transitions between them are defined in JobTransitions
+const (
+	JobStatusPendingApproval = "pending_approval"
+	JobStatusRejected        = "rejected"
+	JobStatusScheduled       = "scheduled"
+	JobStatusRunning         = "running"
+	JobStatusPaused          = "paused"
+	JobStatusCircuitBreak    = "circuitbreak"
+	JobStatusStopped         = "stopped"
+	JobStatusCompleted       = "completed"
+	JobStatusFailed          = "failed"
+)
+
+// JobStateStatusName is the name of the status that holds the transitions of a job in the status and events tables
+const JobStateStatusName = "job_state"
+
+// JobTransitions are the statuses a job can go to from each status. Jobs fail only when a step can't
+// be retried, so failed jobs are final like completed, stopped and rejected ones and the workers drop
+// the messages left of them
+var JobTransitions = map[string][]string{
+	JobStatusPendingApproval: {JobStatusScheduled, JobStatusRejected},
+	JobStatusScheduled:       {JobStatusRunning, JobStatusPaused, JobStatusStopped, JobStatusFailed},
+	JobStatusRunning:         {JobStatusPaused, JobStatusCircuitBreak, JobStatusStopped, JobStatusCompleted, JobStatusFailed},
+	JobStatusPaused:          {JobStatusRunning, JobStatusStopped},
+	JobStatusCircuitBreak:    {JobStatusRunning, JobStatusStopped},
+	JobStatusFailed:          {},
+	JobStatusCompleted:       {},
+	JobStatusStopped:         {},
+	JobStatusRejected:        {},
+}
+
+// InvalidJobTransitionError is returned when a job cannot go from its status to another
+type InvalidJobTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InvalidJobTransitionError) Error() string {
+	return fmt.Sprintf("cannot change job status from %s to %s", e.From, e.To)
+}
+
+// IsInvalidJobTransition returns true if err is an InvalidJobTransitionError
+func IsInvalidJobTransition(err error) bool {
+	_, ok := err.(*InvalidJobTransitionError)
+	return ok
+}
+
+// CanTransitionJob returns true if a job can go from status from to status to
+func CanTransitionJob(from, to string) bool {
+	for _, status := range JobTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinalJobStatus returns true if a job with the status cannot change anymore
+func IsFinalJobStatus(status string) bool {
+	transitions, ok := JobTransitions[status]
+	return ok && len(transitions) == 0
+}
+
+// Transition changes the status of the job to to if the state machine allows it and records the
+// transition in the status and events tables. The job row is locked while its status is checked and
+// the update only matches the status that was checked, q should be a transaction so the history is
+// committed with the new status, TransitionJob runs it in one. When the job has an AppID only a job of
+// that app matches, a job of another app is not found
+func (j *Job) Transition(q interfaces.Queryer, to, message string) error {
+	filter := "id = ?"
+	params := []interface{}{j.ID}
+	if j.AppID != uuid.Nil {
+		filter = "id = ? AND app_id = ?"
+		params = append(params, j.AppID)
+	}
+	var from string
+	_, err := q.QueryOne(pg.Scan(&from), fmt.Sprintf("SELECT status FROM jobs WHERE %s FOR UPDATE", filter), params...)
+	if err != nil {
+		return err
+	}
+	if !CanTransitionJob(from, to) {
+		j.Status = from
+		return &InvalidJobTransitionError{From: from, To: to}
+	}
+	_, err = q.QueryOne(
+		j,
+		fmt.Sprintf("UPDATE jobs SET status = ?, updated_at = ? WHERE %s AND status = ? RETURNING *", filter),
+		append([]interface{}{to, time.Now().UnixNano()}, append(params, from)...)...,
+	)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return &InvalidJobTransitionError{From: from, To: to}
+		}
+		return err
+	}
+
+	event := fmt.Sprintf("%s -> %s", from, to)
+	if message != "" {
+		event = fmt.Sprintf("%s: %s", event, message)
+	}
+	return j.addEvent(q, JobStateStatusName, event, jobStatusEventState(to))
+}
+
+// TransitionJob runs job.Transition in a transaction of db
+func TransitionJob(db interfaces.DB, job *Job, to, message string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = job.Transition(tx, to, message)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// jobStatusEventState returns the state of the event recorded when a job goes to status
+func jobStatusEventState(status string) string {
+	switch status {
+	case JobStatusFailed:
+		return "fail"
+	case JobStatusCompleted:
+		return "success"
+	default:
+		return "running"
+	}
+}
+
+// addEvent adds an event to the status name of the job, creating the status if it does not exist
+func (j *Job) addEvent(q interfaces.Queryer, name, message, state string) error {
+	status := &Status{
+		Name:      name,
+		JobID:     j.ID,
+		ID:        uuid.NewV4(),
+		CreatedAt: time.Now().UnixNano(),
+	}
+	_, err := q.Model(status).OnConflict("(name, job_id) DO UPDATE").Set("name = EXCLUDED.name").Returning("id").Insert()
+	if err != nil {
+		return err
+	}
+	event := &Events{
+		Message:   message,
+		StatusID:  status.ID,
+		State:     state,
+		ID:        uuid.NewV4(),
+		CreatedAt: time.Now().UnixNano(),
+	}
+	return q.Insert(event)
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"strings"
+)
+
+// DefaultLocale is the default locale of the apps that do not set one
+const DefaultLocale = "en"
+
+// LocaleFallbacks maps a locale to the locales tried, in order, when there is no template for it
+type LocaleFallbacks map[string][]string
+
+// NormalizeLocale returns the locale in lower case with the language and the
+// region separated by a hyphen, pt_BR and PT-br become pt-br
+func NormalizeLocale(locale string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(locale)), "_", "-", -1)
+}
+
+// NormalizeLocales normalizes a comma separated list of locales
+func NormalizeLocales(locales string) string {
+	normalized := strings.Split(locales, ",")
+	for i, locale := range normalized {
+		normalized[i] = NormalizeLocale(locale)
+	}
+	return strings.Join(normalized, ",")
+}
+
+// GetDefaultLocale returns the locale used when there is no template for a user locale or its fallbacks
+func (a *App) GetDefaultLocale() string {
+	if a.DefaultLocale == "" {
+		return DefaultLocale
+	}
+	return a.DefaultLocale
+}
+
+// LocaleChain returns the locales tried, in order, to find the template of a user locale:
+// the locale, its fallbacks or, if it has none, its language, the fallbacks of these
+// locales and finally the app default locale
+func (a *App) LocaleChain(locale string) []string {
+	chain := []string{}
+	visited := map[string]bool{}
+	var visit func(string)
+	visit = func(locale string) {
+		if locale == "" || visited[locale] {
+			return
+		}
+		visited[locale] = true
+		chain = append(chain, locale)
+		if fallbacks, ok := a.LocaleFallbacks[locale]; ok {
+			for _, fallback := range fallbacks {
+				visit(fallback)
+			}
+		} else if i := strings.Index(locale, "-"); i > 0 {
+			visit(locale[:i])
+		}
+	}
+	visit(NormalizeLocale(locale))
+	visit(a.GetDefaultLocale())
+	return chain
+}
+
+// SelectTemplate returns the template of the first locale in the chain of the user locale that has one
+func (a *App) SelectTemplate(templatesByLocale map[string]Template, locale string) (Template, bool) {
+	for _, l := range a.LocaleChain(locale) {
+		if template, ok := templatesByLocale[l]; ok {
+			return template, true
+		}
+	}
+	return Template{}, false
+}
+
+// normalizeLocales normalizes the app default locale and fallbacks, the default locale defaults to DefaultLocale
+func (a *App) normalizeLocales() {
+	a.DefaultLocale = NormalizeLocale(a.DefaultLocale)
+	if a.DefaultLocale == "" {
+		a.DefaultLocale = DefaultLocale
+	}
+	fallbacks := LocaleFallbacks{}
+	for locale, chain := range a.LocaleFallbacks {
+		normalized := make([]string, 0, len(chain))
+		for _, fallback := range chain {
+			normalized = append(normalized, NormalizeLocale(fallback))
+		}
+		fallbacks[NormalizeLocale(locale)] = normalized
+	}
+	a.LocaleFallbacks = fallbacks
+}
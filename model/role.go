@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package model
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+)
+
+// Roles a user can have in an app
+const (
+	RoleViewer   = "viewer"
+	RoleEditor   = "editor"
+	RoleSender   = "sender"
+	RoleApprover = "approver"
+	RoleAdmin    = "admin"
+)
+
+// Permissions granted by the roles
+const (
+	PermissionView          = "view"
+	PermissionEditTemplates = "templates:edit"
+	PermissionSendJobs      = "jobs:send"
+	PermissionApproveJobs   = "jobs:approve"
+	PermissionManageApp     = "app:manage"
+)
+
+// RolePermissions maps each role to the permissions it grants
+var RolePermissions = map[string][]string{
+	RoleViewer:   {PermissionView},
+	RoleEditor:   {PermissionView, PermissionEditTemplates},
+	RoleSender:   {PermissionView, PermissionSendJobs},
+	RoleApprover: {PermissionView, PermissionApproveJobs},
+	RoleAdmin: {
+		PermissionView,
+		PermissionEditTemplates,
+		PermissionSendJobs,
+		PermissionApproveJobs,
+		PermissionManageApp,
+	},
+}
+
+// AppRoles maps an app id to the roles a user has in it
+type AppRoles map[string][]string
+
+// IsValidRole returns true if the role exists
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// ValidateRoles returns an error if a role does not exist
+func ValidateRoles(roles []string) error {
+	for _, role := range roles {
+		if !IsValidRole(role) {
+			return fmt.Errorf("invalid role %s", role)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if an app id is not an uuid or a role does not exist
+func (r AppRoles) Validate() error {
+	for appID, roles := range r {
+		if _, err := uuid.FromString(appID); err != nil {
+			return InvalidField("roles")
+		}
+		if err := ValidateRoles(roles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserAppRoles is the payload that sets the roles of a user in an app
+type UserAppRoles struct {
+	Roles []string `json:"roles"`
+}
+
+// Validate implementation of the InputValidation interface
+func (r *UserAppRoles) Validate(c echo.Context) error {
+	if len(r.Roles) == 0 {
+		return InvalidField("roles")
+	}
+	return ValidateRoles(r.Roles)
+}
+
+// RolesIn returns the roles of the user in the app
+func (u *User) RolesIn(appID uuid.UUID) []string {
+	return u.Roles[appID.String()]
+}
+
+// Can returns true if the user is admin or has a role in the app that grants the permission
+func (u *User) Can(appID uuid.UUID, permission string) bool {
+	if u.IsAdmin {
+		return true
+	}
+	for _, role := range u.RolesIn(appID) {
+		for _, granted := range RolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
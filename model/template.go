@@ -23,9 +23,15 @@
 package model
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/asaskevich/govalidator"
 	"github.com/labstack/echo"
 	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/interfaces"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/topfreegames/marathon/templating"
 )
 
 // Template is the template model struct
@@ -35,6 +41,8 @@ type Template struct {
 	Locale    string                 `json:"locale"`
 	Defaults  map[string]interface{} `json:"defaults"`
 	Body      map[string]interface{} `json:"body"`
+	Engine    string                 `json:"engine"`
+	Version   int                    `json:"version"`
 	CreatedBy string                 `json:"createdBy"`
 	App       App                    `json:"app"`
 	AppID     uuid.UUID              `json:"appId"`
@@ -48,6 +56,7 @@ func (t *Template) Validate(c echo.Context) error {
 	if !valid {
 		return InvalidField("name")
 	}
+	t.Locale = NormalizeLocale(t.Locale)
 	valid = govalidator.StringLength(t.Locale, "1", "10")
 	if !valid {
 		return InvalidField("locale")
@@ -56,5 +65,44 @@ func (t *Template) Validate(c echo.Context) error {
 	if !valid {
 		return InvalidField("body")
 	}
+	if value, ok := t.Body[messages.RichContentKey]; ok {
+		rich, err := messages.ParseRichContent(value)
+		if err != nil {
+			return fmt.Errorf("invalid body: %s", err.Error())
+		}
+		if err := rich.Validate(); err != nil {
+			return fmt.Errorf("invalid body: %s", err.Error())
+		}
+	}
+	valid = templating.IsValidEngine(t.Engine)
+	if !valid {
+		return InvalidField("engine")
+	}
+	if t.Engine == templating.EngineRich {
+		if err := templating.Validate(t.Body); err != nil {
+			return fmt.Errorf("invalid body: %s", err.Error())
+		}
+	}
 	return nil
 }
+
+// activeJobsWhere matches the jobs that were not completed, stopped, rejected or expired,
+// that is, the pending, scheduled, running, paused, circuit broken and failed jobs
+const activeJobsWhere = `COALESCE(job.completed_at, 0) = 0 AND job.status NOT IN ('completed', 'stopped', 'rejected')
+	AND (COALESCE(job.expires_at, 0) = 0 OR job.expires_at > ?)`
+
+// Jobs returns the jobs of the app of the template that send its name, newest first,
+// if active is true only the jobs that were not completed, stopped or expired are returned
+func (t *Template) Jobs(db interfaces.DB, active bool) ([]Job, error) {
+	jobs := []Job{}
+	query := db.Model(&jobs).Column("job.*").Where(
+		"job.app_id = ? AND ? = ANY(string_to_array(job.template_name, ','))",
+		t.AppID,
+		t.Name,
+	)
+	if active {
+		query = query.Where(activeJobsWhere, time.Now().UnixNano())
+	}
+	err := query.Order("job.created_at DESC").Select()
+	return jobs, err
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/marathon/templating"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Template bundle formats
+const (
+	BundleFormatJSON = "json"
+	BundleFormatYAML = "yaml"
+)
+
+// TemplateBundle is a portable set of templates used to copy templates between apps and environments
+type TemplateBundle struct {
+	BundleID   string           `json:"bundleId" yaml:"bundleId"`
+	ExportedAt int64            `json:"exportedAt" yaml:"exportedAt"`
+	Templates  []BundleTemplate `json:"templates" yaml:"templates"`
+}
+
+// BundleTemplate is a template of a bundle, without the fields bound to its app
+type BundleTemplate struct {
+	Name     string                 `json:"name" yaml:"name"`
+	Locale   string                 `json:"locale" yaml:"locale"`
+	Engine   string                 `json:"engine" yaml:"engine"`
+	Defaults map[string]interface{} `json:"defaults" yaml:"defaults"`
+	Body     map[string]interface{} `json:"body" yaml:"body"`
+}
+
+// NewTemplateBundle returns a bundle with the templates of the app sorted by name and locale
+func NewTemplateBundle(app *App, templates []Template, exportedAt int64) *TemplateBundle {
+	bundle := &TemplateBundle{
+		BundleID:   app.BundleID,
+		ExportedAt: exportedAt,
+		Templates:  make([]BundleTemplate, len(templates)),
+	}
+	for i, t := range templates {
+		bundle.Templates[i] = BundleTemplate{
+			Name:     t.Name,
+			Locale:   t.Locale,
+			Engine:   t.Engine,
+			Defaults: t.Defaults,
+			Body:     t.Body,
+		}
+	}
+	sort.Slice(bundle.Templates, func(i, j int) bool {
+		if bundle.Templates[i].Name != bundle.Templates[j].Name {
+			return bundle.Templates[i].Name < bundle.Templates[j].Name
+		}
+		return bundle.Templates[i].Locale < bundle.Templates[j].Locale
+	})
+	return bundle
+}
+
+// IsValidBundleFormat returns true if format is empty (json) or a known bundle format
+func IsValidBundleFormat(format string) bool {
+	return format == "" || format == BundleFormatJSON || format == BundleFormatYAML
+}
+
+// UnmarshalTemplateBundle decodes a bundle in the json or yaml format
+func UnmarshalTemplateBundle(data []byte, format string, bundle *TemplateBundle) error {
+	if format == BundleFormatYAML {
+		var value interface{}
+		err := yaml.Unmarshal(data, &value)
+		if err != nil {
+			return err
+		}
+		value, err = jsonValue(value)
+		if err != nil {
+			return err
+		}
+		data, err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, bundle)
+}
+
+// jsonValue converts the maps decoded from yaml to maps that can be encoded as json
+func jsonValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v: keys must be strings", key)
+			}
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = converted
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = converted
+		}
+		return s, nil
+	default:
+		return v, nil
+	}
+}
+
+// Marshal encodes the bundle in the json or yaml format
+func (b *TemplateBundle) Marshal(format string) ([]byte, error) {
+	if format == BundleFormatYAML {
+		return yaml.Marshal(b)
+	}
+	return json.Marshal(b)
+}
+
+// Validate implementation of the InputValidation interface
+func (b *TemplateBundle) Validate(c echo.Context) error {
+	if len(b.Templates) == 0 {
+		return InvalidField("templates")
+	}
+	seen := map[string]bool{}
+	for i := range b.Templates {
+		bt := &b.Templates[i]
+		t := bt.Template()
+		if err := t.Validate(c); err != nil {
+			return fmt.Errorf("invalid template %s (%s): %s", bt.Name, bt.Locale, err.Error())
+		}
+		bt.Locale = t.Locale
+		if bt.Engine == "" {
+			bt.Engine = templating.EngineLegacy
+		}
+		key := fmt.Sprintf("%s:%s", bt.Name, bt.Locale)
+		if seen[key] {
+			return fmt.Errorf("duplicate template %s (%s)", bt.Name, bt.Locale)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Template returns the bundle template as a template without app
+func (bt *BundleTemplate) Template() Template {
+	return Template{
+		Name:     bt.Name,
+		Locale:   bt.Locale,
+		Engine:   bt.Engine,
+		Defaults: bt.Defaults,
+		Body:     bt.Body,
+	}
+}
+
+// TemplateImportItem is a template of an import and the changes it makes to the existing template
+type TemplateImportItem struct {
+	Name    string   `json:"name"`
+	Locale  string   `json:"locale"`
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// TemplateImport is the plan of an import of a bundle into an app. Templates that
+// already exist and differ from the bundle are updated if upsert is set or are
+// conflicts otherwise
+type TemplateImport struct {
+	DryRun    bool                 `json:"dryRun"`
+	Upsert    bool                 `json:"upsert"`
+	Created   []TemplateImportItem `json:"created"`
+	Updated   []TemplateImportItem `json:"updated"`
+	Unchanged []TemplateImportItem `json:"unchanged"`
+	Conflicts []TemplateImportItem `json:"conflicts"`
+
+	ToCreate []*Template `json:"-"`
+	ToUpdate []*Template `json:"-"`
+}
+
+// PlanTemplateImport compares the bundle with the existing templates of an app and returns the import plan,
+// the templates to create have no id and app and the templates to update are the existing ones with the
+// defaults, body and engine of the bundle
+func PlanTemplateImport(bundle *TemplateBundle, existing []Template, upsert bool) *TemplateImport {
+	plan := &TemplateImport{
+		Upsert:    upsert,
+		Created:   []TemplateImportItem{},
+		Updated:   []TemplateImportItem{},
+		Unchanged: []TemplateImportItem{},
+		Conflicts: []TemplateImportItem{},
+	}
+	existingByKey := map[string]Template{}
+	for _, t := range existing {
+		existingByKey[fmt.Sprintf("%s:%s", t.Name, NormalizeLocale(t.Locale))] = t
+	}
+	for _, bt := range bundle.Templates {
+		item := TemplateImportItem{Name: bt.Name, Locale: bt.Locale}
+		current, ok := existingByKey[fmt.Sprintf("%s:%s", bt.Name, bt.Locale)]
+		if !ok {
+			t := bt.Template()
+			plan.Created = append(plan.Created, item)
+			plan.ToCreate = append(plan.ToCreate, &t)
+			continue
+		}
+		engine := current.Engine
+		if engine == "" {
+			engine = templating.EngineLegacy
+		}
+		item.Changes = DiffJSON(
+			map[string]interface{}{"defaults": current.Defaults, "body": current.Body, "engine": engine},
+			map[string]interface{}{"defaults": bt.Defaults, "body": bt.Body, "engine": bt.Engine},
+		)
+		switch {
+		case len(item.Changes) == 0:
+			item.Changes = nil
+			plan.Unchanged = append(plan.Unchanged, item)
+		case upsert:
+			t := current
+			t.Defaults = bt.Defaults
+			t.Body = bt.Body
+			t.Engine = bt.Engine
+			plan.Updated = append(plan.Updated, item)
+			plan.ToUpdate = append(plan.ToUpdate, &t)
+		default:
+			plan.Conflicts = append(plan.Conflicts, item)
+		}
+	}
+	return plan
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo"
+	"github.com/topfreegames/marathon/messages"
+)
+
+// TemplatePreview is the input of a template preview, the job context, metadata and
+// push options and the fields of the user that receives the message
+type TemplatePreview struct {
+	Context     map[string]interface{} `json:"context"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	PushOptions *messages.PushOptions  `json:"pushOptions"`
+	Fields      map[string]string      `json:"fields"`
+}
+
+// Validate implementation of the InputValidation interface
+func (p *TemplatePreview) Validate(c echo.Context) error {
+	if err := p.PushOptions.Validate(); err != nil {
+		return InvalidField(fmt.Sprintf("pushOptions: %s", err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/interfaces"
+	pg "gopkg.in/pg.v5"
+)
+
+// TemplateVersion is an immutable snapshot of a template, a new version is
+// stored every time a template is created, updated or rolled back. Versions
+// are numbered by app, name and locale and are kept when the template is deleted
+type TemplateVersion struct {
+	ID         uuid.UUID              `sql:",pk" json:"id"`
+	TemplateID uuid.UUID              `json:"templateId"`
+	AppID      uuid.UUID              `json:"appId"`
+	Name       string                 `json:"name"`
+	Locale     string                 `json:"locale"`
+	Version    int                    `json:"version"`
+	Defaults   map[string]interface{} `json:"defaults"`
+	Body       map[string]interface{} `json:"body"`
+	Engine     string                 `json:"engine"`
+	CreatedBy  string                 `json:"createdBy"`
+	CreatedAt  int64                  `json:"createdAt"`
+}
+
+// TemplateVersions are the template versions pinned by a job, by name and locale
+type TemplateVersions map[string]map[string]int
+
+// Template returns the template as it was in the version
+func (v *TemplateVersion) Template() Template {
+	return Template{
+		ID:        v.TemplateID,
+		AppID:     v.AppID,
+		Name:      v.Name,
+		Locale:    v.Locale,
+		Version:   v.Version,
+		Defaults:  v.Defaults,
+		Body:      v.Body,
+		Engine:    v.Engine,
+		CreatedBy: v.CreatedBy,
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.CreatedAt,
+	}
+}
+
+// TemplateDiff is the difference between two versions of a template
+type TemplateDiff struct {
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Changes []Change `json:"changes"`
+}
+
+// Diff returns the changes in the defaults, body and engine from version v to version to
+func (v *TemplateVersion) Diff(to *TemplateVersion) *TemplateDiff {
+	return &TemplateDiff{
+		From: v.Version,
+		To:   to.Version,
+		Changes: DiffJSON(
+			map[string]interface{}{"defaults": v.Defaults, "body": v.Body, "engine": v.Engine},
+			map[string]interface{}{"defaults": to.Defaults, "body": to.Body, "engine": to.Engine},
+		),
+	}
+}
+
+// NextVersion sets t.Version to the number of the next version of the template name and locale
+func (t *Template) NextVersion(db interfaces.Queryer) error {
+	var version int
+	_, err := db.QueryOne(
+		&version,
+		"SELECT COALESCE(MAX(version), 0) + 1 FROM template_versions WHERE app_id = ? AND name = ? AND locale = ?",
+		t.AppID, t.Name, t.Locale,
+	)
+	if err != nil {
+		return err
+	}
+	t.Version = version
+	return nil
+}
+
+// SaveVersion stores the state of the template as the version t.Version
+func (t *Template) SaveVersion(db interfaces.Queryer) (*TemplateVersion, error) {
+	version := &TemplateVersion{
+		ID:         uuid.NewV4(),
+		TemplateID: t.ID,
+		AppID:      t.AppID,
+		Name:       t.Name,
+		Locale:     t.Locale,
+		Version:    t.Version,
+		Defaults:   t.Defaults,
+		Body:       t.Body,
+		Engine:     t.Engine,
+		CreatedBy:  t.CreatedBy,
+		CreatedAt:  time.Now().UnixNano(),
+	}
+	err := db.Insert(version)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// ListVersions returns the versions of the template name and locale, newest first
+func (t *Template) ListVersions(db interfaces.DB) ([]TemplateVersion, error) {
+	versions := []TemplateVersion{}
+	err := db.Model(&versions).Where(
+		"app_id = ? AND name = ? AND locale = ?",
+		t.AppID, t.Name, t.Locale,
+	).Order("version DESC").Select()
+	return versions, err
+}
+
+// GetVersion returns the given version of the template name and locale
+func (t *Template) GetVersion(db interfaces.DB, version int) (*TemplateVersion, error) {
+	templateVersion := &TemplateVersion{}
+	err := db.Model(templateVersion).Where(
+		"app_id = ? AND name = ? AND locale = ? AND version = ?",
+		t.AppID, t.Name, t.Locale, version,
+	).Select()
+	if err != nil {
+		return nil, err
+	}
+	return templateVersion, nil
+}
+
+// GetTemplateVersions returns the current version of each locale of the
+// templates with the given comma separated names
+func GetTemplateVersions(db interfaces.DB, appID uuid.UUID, templateName string) (TemplateVersions, error) {
+	var templates []Template
+	err := db.Model(&templates).Column("name", "locale", "version").Where(
+		"app_id = ? AND name IN (?)",
+		appID,
+		pg.In(strings.Split(templateName, ",")),
+	).Select()
+	if err != nil {
+		return nil, err
+	}
+	versions := TemplateVersions{}
+	for _, tpl := range templates {
+		if versions[tpl.Name] == nil {
+			versions[tpl.Name] = map[string]int{}
+		}
+		versions[tpl.Name][tpl.Locale] = tpl.Version
+	}
+	return versions, nil
+}
+
+// getPinnedTemplates returns the template versions pinned by the job
+func (j *Job) getPinnedTemplates(db interfaces.DB) ([]Template, error) {
+	var versions []TemplateVersion
+	names := make([]string, 0, len(j.TemplateVersions))
+	for name := range j.TemplateVersions {
+		names = append(names, name)
+	}
+	err := db.Model(&versions).Where(
+		"app_id = ? AND name IN (?)",
+		j.App.ID,
+		pg.In(names),
+	).Select()
+	if err != nil {
+		return nil, err
+	}
+	templates := []Template{}
+	for _, version := range versions {
+		if j.TemplateVersions[version.Name][version.Locale] == version.Version {
+			templates = append(templates, version.Template())
+		}
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("No template versions were found with name %s", j.TemplateName)
+	}
+	return templates, nil
+}
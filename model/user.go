@@ -30,13 +30,13 @@ import (
 
 // User is the user model struct
 type User struct {
-	ID          uuid.UUID   `sql:",pk" json:"id"`
-	Email       string      `json:"email"`
-	IsAdmin     bool        `sql:",notnull" json:"isAdmin"`
-	AllowedApps []uuid.UUID `pg:",array" json:"allowedApps"`
-	CreatedBy   string      `json:"createdBy"`
-	CreatedAt   int64       `json:"createdAt"`
-	UpdatedAt   int64       `json:"updatedAt"`
+	ID        uuid.UUID `sql:",pk" json:"id"`
+	Email     string    `json:"email"`
+	IsAdmin   bool      `sql:",notnull" json:"isAdmin"`
+	Roles     AppRoles  `json:"roles"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt int64     `json:"createdAt"`
+	UpdatedAt int64     `json:"updatedAt"`
 }
 
 // Validate implementation of the InputValidation interface
@@ -49,5 +49,11 @@ func (u *User) Validate(c echo.Context) error {
 	if !valid {
 		return InvalidField("createdBy")
 	}
+	if err := u.Roles.Validate(); err != nil {
+		return err
+	}
+	if u.Roles == nil {
+		u.Roles = AppRoles{}
+	}
 	return nil
 }
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/labstack/echo"
+	"github.com/satori/go.uuid"
+)
+
+// Job lifecycle events sent to the webhooks
+const (
+	WebhookEventCreated      = "created"
+	WebhookEventStarted      = "started"
+	WebhookEventPaused       = "paused"
+	WebhookEventCircuitBreak = "circuitbreak"
+	WebhookEventStopped      = "stopped"
+	WebhookEventCompleted    = "completed"
+	WebhookEventFailed       = "failed"
+)
+
+// WebhookEvents are the events a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookEventCreated,
+	WebhookEventStarted,
+	WebhookEventPaused,
+	WebhookEventCircuitBreak,
+	WebhookEventStopped,
+	WebhookEventCompleted,
+	WebhookEventFailed,
+}
+
+// Statuses of the webhook deliveries
+const (
+	WebhookDeliveryPending  = "pending"
+	WebhookDeliveryRetrying = "retrying"
+	WebhookDeliverySuccess  = "success"
+	WebhookDeliveryFailed   = "failed"
+)
+
+// Webhook is the webhook model struct, the job events of the app the webhook subscribes to
+// are posted to its url signed with its secret. The secret is only returned when the webhook is created
+type Webhook struct {
+	ID        uuid.UUID `sql:",pk" json:"id"`
+	AppID     uuid.UUID `json:"appId"`
+	URL       string    `json:"url"`
+	Events    []string  `pg:",array" json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Enabled   bool      `sql:",notnull" json:"enabled"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt int64     `json:"createdAt"`
+	UpdatedAt int64     `json:"updatedAt"`
+}
+
+// Validate implementation of the InputValidation interface
+func (w *Webhook) Validate(c echo.Context) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return InvalidField("url")
+	}
+	if len(w.Events) == 0 {
+		return InvalidField("events")
+	}
+	for _, event := range w.Events {
+		if !IsValidWebhookEvent(event) {
+			return InvalidField("events")
+		}
+	}
+	if !govalidator.StringLength(w.Secret, "0", "255") {
+		return InvalidField("secret")
+	}
+	return nil
+}
+
+// Subscribes returns true if the webhook receives the event
+func (w *Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSecret sets a new random secret to sign the deliveries of the webhook
+func (w *Webhook) GenerateSecret() error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	w.Secret = hex.EncodeToString(b)
+	return nil
+}
+
+// Sign returns the signature of a delivery body sent at timestamp (in seconds), receivers
+// compute the hmac sha256 of "<timestamp>.<body>" with the secret and compare it with the
+// X-Marathon-Signature header
+func (w *Webhook) Sign(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsValidWebhookEvent returns true if the event is one of the WebhookEvents
+func IsValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the log of the delivery of one event to one webhook
+type WebhookDelivery struct {
+	ID             uuid.UUID              `sql:",pk" json:"id"`
+	WebhookID      uuid.UUID              `json:"webhookId"`
+	AppID          uuid.UUID              `json:"appId"`
+	JobID          uuid.UUID              `json:"jobId"`
+	Event          string                 `json:"event"`
+	Payload        map[string]interface{} `json:"payload"`
+	Status         string                 `json:"status"`
+	Attempts       int                    `sql:",notnull" json:"attempts"`
+	ResponseStatus int                    `sql:",notnull" json:"responseStatus"`
+	Error          string                 `sql:",notnull" json:"error"`
+	CreatedAt      int64                  `json:"createdAt"`
+	UpdatedAt      int64                  `json:"updatedAt"`
+	DeliveredAt    int64                  `sql:",notnull" json:"deliveredAt"`
+}
+
+// NewWebhookPayload returns the body posted to the webhooks when the event happens to the job,
+// failed events also have the stage that failed and its error message
+func NewWebhookPayload(deliveryID uuid.UUID, event string, job *Job, stage, message string) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":         deliveryID.String(),
+		"event":      event,
+		"occurredAt": time.Now().UnixNano(),
+		"appId":      job.AppID.String(),
+		"job": map[string]interface{}{
+			"id":               job.ID.String(),
+			"jobGroupId":       job.JobGroupID.String(),
+			"status":           job.Status,
+			"service":          job.Service,
+			"templateName":     job.TemplateName,
+			"totalBatches":     job.TotalBatches,
+			"completedBatches": job.CompletedBatches,
+			"totalUsers":       job.TotalUsers,
+			"totalTokens":      job.TotalTokens,
+			"completedTokens":  job.CompletedTokens,
+			"failedTokens":     job.FailedTokens,
+			"createdBy":        job.CreatedBy,
+			"startsAt":         job.StartsAt,
+			"completedAt":      job.CompletedAt,
+		},
+	}
+	if stage != "" {
+		payload["stage"] = map[string]interface{}{
+			"name":    stage,
+			"message": message,
+		}
+	}
+	return payload
+}
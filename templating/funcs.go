@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package templating
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Funcs are the filters available to the rich templates, the filtered value is the
+// last argument so they can be used in pipelines, e.g. {{ .name | default "friend" | upper }}
+var Funcs = template.FuncMap{
+	"upper":    func(value interface{}) string { return strings.ToUpper(toString(value)) },
+	"lower":    func(value interface{}) string { return strings.ToLower(toString(value)) },
+	"title":    func(value interface{}) string { return strings.Title(toString(value)) },
+	"trim":     func(value interface{}) string { return strings.TrimSpace(toString(value)) },
+	"default":  defaultValue,
+	"date":     date,
+	"number":   number,
+	"plural":   plural,
+	"join":     join,
+	"truncate": truncate,
+}
+
+// defaultValue returns def if value is empty
+func defaultValue(def, value interface{}) interface{} {
+	if isEmpty(value) {
+		return def
+	}
+	return value
+}
+
+// date formats a unix timestamp in seconds, a RFC3339 string or a time with the Go layout
+func date(layout string, value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string:
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", err
+		}
+		t = parsed
+	default:
+		seconds, err := toFloat(value)
+		if err != nil {
+			return "", err
+		}
+		t = time.Unix(int64(seconds), 0).UTC()
+	}
+	return t.Format(layout), nil
+}
+
+// number formats value with the given decimals and comma as the thousands separator
+func number(decimals int, value interface{}) (string, error) {
+	f, err := toFloat(value)
+	if err != nil {
+		return "", err
+	}
+	formatted := strconv.FormatFloat(math.Abs(f), 'f', decimals, 64)
+	integer, fraction := formatted, ""
+	if i := strings.Index(formatted, "."); i >= 0 {
+		integer, fraction = formatted[:i], formatted[i:]
+	}
+	var grouped []string
+	for len(integer) > 3 {
+		grouped = append([]string{integer[len(integer)-3:]}, grouped...)
+		integer = integer[:len(integer)-3]
+	}
+	grouped = append([]string{integer}, grouped...)
+	sign := ""
+	if f < 0 {
+		sign = "-"
+	}
+	return sign + strings.Join(grouped, ",") + fraction, nil
+}
+
+// plural returns singular if count is 1 and plural otherwise
+func plural(singular, pluralForm string, count interface{}) (string, error) {
+	n, err := toFloat(count)
+	if err != nil {
+		return "", err
+	}
+	if n == 1 {
+		return singular, nil
+	}
+	return pluralForm, nil
+}
+
+// join joins the items of a list with sep
+func join(sep string, value interface{}) string {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return toString(value)
+	}
+	items := make([]string, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		items[i] = toString(v.Index(i).Interface())
+	}
+	return strings.Join(items, sep)
+}
+
+// truncate cuts value to length runes
+func truncate(length int, value interface{}) string {
+	runes := []rune(toString(value))
+	if len(runes) <= length {
+		return string(runes)
+	}
+	return string(runes[:length])
+}
+
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", value)
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
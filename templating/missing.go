@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package templating
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/valyala/fasttemplate"
+)
+
+// MissingVariables returns the sorted variables used by a template body that are not in data.
+// In rich templates only the printed variables are checked, the ones used in conditions,
+// with the default filter or inside range and with blocks are allowed to be missing
+func MissingVariables(engine string, body map[string]interface{}, data map[string]interface{}) ([]string, error) {
+	missing := map[string]bool{}
+	var err error
+	if engine == EngineRich {
+		err = walk(body, func(text string) (interface{}, error) {
+			t, err := Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			missingInList(t.Tree.Root, data, missing)
+			return text, nil
+		}, nil)
+	} else {
+		err = missingLegacy(body, data, missing)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make([]string, 0, len(missing))
+	for variable := range missing {
+		variables = append(variables, variable)
+	}
+	sort.Strings(variables)
+	return variables, nil
+}
+
+func missingLegacy(body map[string]interface{}, data map[string]interface{}, missing map[string]bool) error {
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	t, err := fasttemplate.NewTemplate(string(serialized), "{{", "}}")
+	if err != nil {
+		return err
+	}
+	t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		if value, ok := data[tag]; !ok || value == nil {
+			missing[tag] = true
+		}
+		return 0, nil
+	})
+	return nil
+}
+
+func missingInList(list *parse.ListNode, data map[string]interface{}, missing map[string]bool) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			missingInPipe(n.Pipe, data, missing)
+		case *parse.IfNode:
+			missingInList(n.List, data, missing)
+			missingInList(n.ElseList, data, missing)
+		case *parse.RangeNode:
+			missingInPipe(n.Pipe, data, missing)
+			missingInList(n.ElseList, data, missing)
+		case *parse.WithNode:
+			missingInList(n.ElseList, data, missing)
+		}
+	}
+}
+
+func missingInPipe(pipe *parse.PipeNode, data map[string]interface{}, missing map[string]bool) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		if len(cmd.Args) > 0 {
+			if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "default" {
+				return
+			}
+		}
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				if !hasPath(data, a.Ident) {
+					missing[strings.Join(a.Ident, ".")] = true
+				}
+			case *parse.PipeNode:
+				missingInPipe(a, data, missing)
+			}
+		}
+	}
+}
+
+// hasPath returns true if there is a non nil value in the path of nested maps
+func hasPath(data map[string]interface{}, path []string) bool {
+	var value interface{} = data
+	for _, key := range path {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value = m[key]
+		case map[string]string:
+			v, ok := m[key]
+			if !ok {
+				return false
+			}
+			value = v
+		default:
+			return false
+		}
+		if value == nil {
+			return false
+		}
+	}
+	return true
+}
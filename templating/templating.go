@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package templating renders the template bodies of the push messages
+package templating
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/valyala/fasttemplate"
+)
+
+// Template engines, legacy substitutes {{key}} from the defaults and context and
+// rich renders each string of the body with text/template and the filters in Funcs
+const (
+	EngineLegacy = "legacy"
+	EngineRich   = "rich"
+)
+
+// maxCacheSize is the number of parsed templates kept before the cache is reset
+const maxCacheSize = 10000
+
+var cache = struct {
+	sync.RWMutex
+	templates map[string]*template.Template
+}{templates: map[string]*template.Template{}}
+
+// IsValidEngine returns true if engine is empty (legacy) or a known engine
+func IsValidEngine(engine string) bool {
+	return engine == "" || engine == EngineLegacy || engine == EngineRich
+}
+
+// Parse parses a rich template, the parsed templates are cached by their text
+func Parse(text string) (*template.Template, error) {
+	cache.RLock()
+	t, ok := cache.templates[text]
+	cache.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	t, err := template.New("body").Funcs(Funcs).Funcs(template.FuncMap{emptyIfMissing: orEmpty}).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, tpl := range t.Templates() {
+		if tpl.Tree != nil {
+			printMissingAsEmpty(tpl.Tree.Root)
+		}
+	}
+	cache.Lock()
+	if len(cache.templates) >= maxCacheSize {
+		cache.templates = map[string]*template.Template{}
+	}
+	cache.templates[text] = t
+	cache.Unlock()
+	return t, nil
+}
+
+// Validate parses every string of a rich template body
+func Validate(body map[string]interface{}) error {
+	return walk(body, func(text string) (interface{}, error) {
+		_, err := Parse(text)
+		return text, err
+	}, nil)
+}
+
+// Render renders a template body with the engine, data are the variables available
+// to the template, the result is the JSON serialized message
+func Render(engine string, body map[string]interface{}, data map[string]interface{}) (string, error) {
+	if engine == EngineRich {
+		return renderRich(body, data)
+	}
+	return renderLegacy(body, data)
+}
+
+// renderLegacy substitutes the {{key}} tags of the serialized body, the values are
+// escaped so quotes and other special characters can not break the JSON
+func renderLegacy(body map[string]interface{}, data map[string]interface{}) (string, error) {
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	t, err := fasttemplate.NewTemplate(string(serialized), "{{", "}}")
+	if err != nil {
+		return "", err
+	}
+	return t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		value, ok := data[tag]
+		if !ok || value == nil {
+			return 0, nil
+		}
+		escaped, err := json.Marshal(fmt.Sprint(value))
+		if err != nil {
+			return 0, err
+		}
+		return w.Write(escaped[1 : len(escaped)-1])
+	}), nil
+}
+
+// renderRich renders each string of the body, the structure and the types of the
+// other values are kept
+func renderRich(body map[string]interface{}, data map[string]interface{}) (string, error) {
+	var rendered map[string]interface{}
+	err := walk(body, func(text string) (interface{}, error) {
+		return RenderString(text, data)
+	}, &rendered)
+	if err != nil {
+		return "", err
+	}
+	serialized, err := json.Marshal(rendered)
+	if err != nil {
+		return "", err
+	}
+	return string(serialized), nil
+}
+
+// RenderString renders a single rich template string
+func RenderString(text string, data map[string]interface{}) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// emptyIfMissing is the function Parse appends to the printed pipelines
+const emptyIfMissing = "_orEmpty"
+
+// orEmpty returns an empty string for the nil values of missing keys, text/template prints them as <no value>
+func orEmpty(value interface{}) interface{} {
+	if value == nil {
+		return ""
+	}
+	return value
+}
+
+// printMissingAsEmpty pipes every printed action to orEmpty, so missing keys are rendered as empty strings
+func printMissingAsEmpty(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			if len(n.Pipe.Decl) == 0 {
+				n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+					NodeType: parse.NodeCommand,
+					Pos:      n.Pos,
+					Args:     []parse.Node{parse.NewIdentifier(emptyIfMissing).SetPos(n.Pos)},
+				})
+			}
+		case *parse.IfNode:
+			printMissingAsEmpty(n.List)
+			printMissingAsEmpty(n.ElseList)
+		case *parse.RangeNode:
+			printMissingAsEmpty(n.List)
+			printMissingAsEmpty(n.ElseList)
+		case *parse.WithNode:
+			printMissingAsEmpty(n.List)
+			printMissingAsEmpty(n.ElseList)
+		}
+	}
+}
+
+// walk calls render for each string in body and stores the resulting body in result
+func walk(body map[string]interface{}, render func(string) (interface{}, error), result *map[string]interface{}) error {
+	value, err := walkValue(body, render)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		*result = value.(map[string]interface{})
+	}
+	return nil
+}
+
+func walkValue(value interface{}, render func(string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return render(v)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := walkValue(item, render)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err.Error())
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := walkValue(item, render)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", i, err.Error())
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package templating_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/templating"
+)
+
+func render(engine string, body, data map[string]interface{}) map[string]interface{} {
+	msgString, err := templating.Render(engine, body, data)
+	Expect(err).NotTo(HaveOccurred())
+	var msg map[string]interface{}
+	err = json.Unmarshal([]byte(msgString), &msg)
+	Expect(err).NotTo(HaveOccurred())
+	return msg
+}
+
+var _ = Describe("Templating", func() {
+	Describe("Legacy engine", func() {
+		It("should substitute the tags", func() {
+			msg := render(templating.EngineLegacy, map[string]interface{}{
+				"alert": "{{user_name}} just liked your {{object_name}}!",
+			}, map[string]interface{}{
+				"user_name":   "Camila",
+				"object_name": "building",
+			})
+			Expect(msg["alert"]).To(Equal("Camila just liked your building!"))
+		})
+
+		It("should escape values with quotes", func() {
+			msg := render(templating.EngineLegacy, map[string]interface{}{
+				"alert": "{{user_name}} said hi",
+			}, map[string]interface{}{
+				"user_name": `Camila "the builder"`,
+			})
+			Expect(msg["alert"]).To(Equal(`Camila "the builder" said hi`))
+		})
+
+		It("should substitute values that are not strings", func() {
+			msg := render(templating.EngineLegacy, map[string]interface{}{
+				"alert": "you won {{amount}} coins",
+			}, map[string]interface{}{
+				"amount": 10,
+			})
+			Expect(msg["alert"]).To(Equal("you won 10 coins"))
+		})
+
+		It("should be the default engine", func() {
+			msg := render("", map[string]interface{}{
+				"alert": "{{user_name}}",
+			}, map[string]interface{}{
+				"user_name": "Camila",
+			})
+			Expect(msg["alert"]).To(Equal("Camila"))
+		})
+	})
+
+	Describe("Rich engine", func() {
+		It("should render the strings and keep the structure and types", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"alert": "{{ .user_name }} just liked your {{ .object_name }}!",
+				"badge": 1,
+				"sound": true,
+				"aps": map[string]interface{}{
+					"title": "Hi {{ .user_name }}",
+					"lines": []interface{}{"{{ .object_name }}", 2},
+				},
+			}, map[string]interface{}{
+				"user_name":   "Camila",
+				"object_name": "building",
+			})
+			Expect(msg["alert"]).To(Equal("Camila just liked your building!"))
+			Expect(msg["badge"]).To(BeEquivalentTo(1))
+			Expect(msg["sound"]).To(BeTrue())
+			aps := msg["aps"].(map[string]interface{})
+			Expect(aps["title"]).To(Equal("Hi Camila"))
+			Expect(aps["lines"]).To(Equal([]interface{}{"building", float64(2)}))
+		})
+
+		It("should escape values with quotes", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"alert": `{{ .user_name }} said "hi"`,
+			}, map[string]interface{}{
+				"user_name": `Camila "the builder"`,
+			})
+			Expect(msg["alert"]).To(Equal(`Camila "the builder" said "hi"`))
+		})
+
+		It("should support conditionals and loops", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"alert": "{{ if .vip }}Dear VIP{{ else }}Hi{{ end }}, you got {{ range $i, $item := .items }}{{ if $i }}, {{ end }}{{ $item }}{{ end }}",
+			}, map[string]interface{}{
+				"vip":   false,
+				"items": []interface{}{"a sword", "a shield"},
+			})
+			Expect(msg["alert"]).To(Equal("Hi, you got a sword, a shield"))
+		})
+
+		It("should support per-user fields", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"alert": "Hi {{ .user.fields.name }} from {{ .user.region | upper }}",
+			}, map[string]interface{}{
+				"user": map[string]interface{}{
+					"region": "br",
+					"fields": map[string]interface{}{"name": "Camila"},
+				},
+			})
+			Expect(msg["alert"]).To(Equal("Hi Camila from BR"))
+		})
+
+		It("should render missing keys as empty strings", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"alert": "Hi {{ .user_name }}!",
+			}, map[string]interface{}{})
+			Expect(msg["alert"]).To(Equal("Hi !"))
+		})
+
+		It("should render missing keys inside blocks and keep values that look like missing keys", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"alert": "{{ if .show }}{{ .title }}{{ end }}{{ .name }}",
+			}, map[string]interface{}{"show": true, "name": "<no value>"})
+			Expect(msg["alert"]).To(Equal("<no value>"))
+		})
+
+		It("should return an error if the template is invalid", func() {
+			_, err := templating.Render(templating.EngineRich, map[string]interface{}{
+				"alert": "Hi {{ .user_name",
+			}, map[string]interface{}{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("alert: "))
+		})
+	})
+
+	Describe("Filters", func() {
+		It("should format values", func() {
+			msg := render(templating.EngineRich, map[string]interface{}{
+				"upper":    "{{ .name | upper }}",
+				"lower":    "{{ .name | lower }}",
+				"title":    "{{ .lowerName | title }}",
+				"default":  "{{ .missing | default \"friend\" }}",
+				"notEmpty": "{{ .name | default \"friend\" }}",
+				"date":     "{{ .expiresAt | date \"2006-01-02\" }}",
+				"rfc3339":  "{{ .startsAt | date \"02/01/2006\" }}",
+				"number":   "{{ .amount | number 2 }}",
+				"negative": "{{ .debt | number 0 }}",
+				"one":      "{{ .one | plural \"coin\" \"coins\" }}",
+				"many":     "{{ .amount | plural \"coin\" \"coins\" }}",
+				"join":     "{{ .items | join \", \" }}",
+				"truncate": "{{ .name | truncate 3 }}",
+			}, map[string]interface{}{
+				"name":      "Camila",
+				"lowerName": "camila souza",
+				"expiresAt": float64(1500000000),
+				"startsAt":  "2017-07-14T02:40:00Z",
+				"amount":    1234567.891,
+				"debt":      -1500,
+				"one":       1,
+				"items":     []interface{}{"a", "b"},
+			})
+			Expect(msg["upper"]).To(Equal("CAMILA"))
+			Expect(msg["lower"]).To(Equal("camila"))
+			Expect(msg["title"]).To(Equal("Camila Souza"))
+			Expect(msg["default"]).To(Equal("friend"))
+			Expect(msg["notEmpty"]).To(Equal("Camila"))
+			Expect(msg["date"]).To(Equal("2017-07-14"))
+			Expect(msg["rfc3339"]).To(Equal("14/07/2017"))
+			Expect(msg["number"]).To(Equal("1,234,567.89"))
+			Expect(msg["negative"]).To(Equal("-1,500"))
+			Expect(msg["one"]).To(Equal("coin"))
+			Expect(msg["many"]).To(Equal("coins"))
+			Expect(msg["join"]).To(Equal("a, b"))
+			Expect(msg["truncate"]).To(Equal("Cam"))
+		})
+
+		It("should return an error if number gets a value that is not a number", func() {
+			_, err := templating.Render(templating.EngineRich, map[string]interface{}{
+				"alert": "{{ .name | number 2 }}",
+			}, map[string]interface{}{"name": "Camila"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Validate", func() {
+		It("should return an error if any string is not a valid template", func() {
+			err := templating.Validate(map[string]interface{}{
+				"aps": map[string]interface{}{"alert": "{{ if .vip }}VIP"},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error if a filter does not exist", func() {
+			err := templating.Validate(map[string]interface{}{
+				"alert": "{{ .name | reverse }}",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should accept valid templates", func() {
+			err := templating.Validate(map[string]interface{}{
+				"alert": "{{ .name | default \"friend\" | upper }}",
+				"badge": 1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("MissingVariables", func() {
+		It("should return the legacy tags that are not in data", func() {
+			missing, err := templating.MissingVariables(templating.EngineLegacy, map[string]interface{}{
+				"alert": "{{name}} sent you {{gift}} and {{coins}}",
+			}, map[string]interface{}{"name": "Camila"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missing).To(Equal([]string{"coins", "gift"}))
+		})
+
+		It("should return the printed rich variables that are not in data", func() {
+			missing, err := templating.MissingVariables(templating.EngineRich, map[string]interface{}{
+				"aps": map[string]interface{}{
+					"alert": "{{ .name | upper }} won {{ .user.fields.reward }} in {{ .user.region }}",
+				},
+			}, map[string]interface{}{
+				"name": "Camila",
+				"user": map[string]interface{}{"region": "br", "fields": map[string]interface{}{}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missing).To(Equal([]string{"user.fields.reward"}))
+		})
+
+		It("should allow missing variables in conditions, defaults and range blocks", func() {
+			missing, err := templating.MissingVariables(templating.EngineRich, map[string]interface{}{
+				"alert": "{{ if .vip }}VIP{{ end }} {{ .name | default \"friend\" }} {{ range .items }}{{ .title }}{{ end }}",
+			}, map[string]interface{}{"items": []interface{}{}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(missing).To(BeEmpty())
+		})
+
+		It("should return an error if the template is invalid", func() {
+			_, err := templating.MissingVariables(templating.EngineRich, map[string]interface{}{
+				"alert": "{{ if .vip }}VIP",
+			}, map[string]interface{}{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
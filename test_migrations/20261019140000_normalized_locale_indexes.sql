@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE INDEX "testapp_apns_normalized_locale" ON "testapp_apns" (lower(replace("locale", '_', '-')));
+CREATE INDEX "testapp_apns_normalized_region" ON "testapp_apns" (lower(replace("region", '_', '-')));
+CREATE INDEX "testapp_gcm_normalized_locale" ON "testapp_gcm" (lower(replace("locale", '_', '-')));
+CREATE INDEX "testapp_gcm_normalized_region" ON "testapp_gcm" (lower(replace("region", '_', '-')));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP INDEX "testapp_apns_normalized_locale";
+DROP INDEX "testapp_apns_normalized_region";
+DROP INDEX "testapp_gcm_normalized_locale";
+DROP INDEX "testapp_gcm_normalized_region";
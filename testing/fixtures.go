@@ -54,6 +54,8 @@ func CreateTestApp(db interfaces.DB, options ...map[string]interface{}) *model.A
 	app.Name = getOpt(opts, "name", "testapp").(string)
 	app.BundleID = getOpt(opts, "bundleId", fmt.Sprintf("com.app.%s", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 	app.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
+	app.DefaultLocale = getOpt(opts, "defaultLocale", "").(string)
+	app.LocaleFallbacks = getOpt(opts, "localeFallbacks", model.LocaleFallbacks(nil)).(model.LocaleFallbacks)
 
 	err := db.Insert(&app)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -82,7 +84,7 @@ func CreateTestUser(db interfaces.DB, options ...map[string]interface{}) *model.
 	user.ID = getOpt(opts, "id", uuid.NewV4()).(uuid.UUID)
 	user.Email = getOpt(opts, "email", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 	user.IsAdmin = getOpt(opts, "isAdmin", true).(bool)
-	user.AllowedApps = getOpt(opts, "allowedApps", []uuid.UUID{uuid.NewV4()}).([]uuid.UUID)
+	user.Roles = getOpt(opts, "roles", model.AppRoles{uuid.NewV4().String(): []string{model.RoleAdmin}}).(model.AppRoles)
 	user.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 
 	err := db.Insert(&user)
@@ -127,12 +129,12 @@ func GetUserPayload(options ...map[string]interface{}) map[string]interface{} {
 	}
 	email := getOpt(opts, "email", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 	isAdmin := getOpt(opts, "isAdmin", true).(bool)
-	allowedApps := getOpt(opts, "allowedApps", []uuid.UUID{uuid.NewV4()}).([]uuid.UUID)
+	roles := getOpt(opts, "roles", model.AppRoles{uuid.NewV4().String(): []string{model.RoleAdmin}}).(model.AppRoles)
 
 	user := map[string]interface{}{
-		"email":       email,
-		"isAdmin":     isAdmin,
-		"allowedApps": allowedApps,
+		"email":   email,
+		"isAdmin": isAdmin,
+		"roles":   roles,
 	}
 	return user
 }
@@ -154,6 +156,7 @@ func CreateTestTemplate(db interfaces.DB, appID uuid.UUID, options ...map[string
 	template.ID = getOpt(opts, "id", uuid.NewV4()).(uuid.UUID)
 	template.Name = getOpt(opts, "name", uuid.NewV4().String()).(string)
 	template.Locale = getOpt(opts, "locale", strings.Split(uuid.NewV4().String(), "-")[0]).(string)
+	template.Engine = getOpt(opts, "engine", "").(string)
 	template.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 
 	err := db.Insert(&template)
@@ -237,6 +240,7 @@ func CreateTestJob(db interfaces.DB, appID uuid.UUID, templateName string, optio
 	job.ExpiresAt = getOpt(opts, "expiresAt", time.Now().Add(time.Hour).UnixNano()).(int64)
 	job.CreatedBy = getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string)
 	job.StartsAt = getOpt(opts, "startsAt", time.Now().Add(time.Hour).UnixNano()).(int64)
+	job.TemplateVersions = getOpt(opts, "templateVersions", model.TemplateVersions(nil)).(model.TemplateVersions)
 
 	err := db.Insert(&job)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -287,3 +291,39 @@ func GetJobPayload(options ...map[string]interface{}) map[string]interface{} {
 	}
 	return job
 }
+
+//CreateTestWebhook with specified optional values
+func CreateTestWebhook(db interfaces.DB, appID uuid.UUID, options ...map[string]interface{}) *model.Webhook {
+	opts := map[string]interface{}{}
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	webhook := &model.Webhook{
+		ID:        uuid.NewV4(),
+		AppID:     appID,
+		URL:       getOpt(opts, "url", "http://localhost:8080/webhook").(string),
+		Events:    getOpt(opts, "events", model.WebhookEvents).([]string),
+		Secret:    getOpt(opts, "secret", uuid.NewV4().String()).(string),
+		Enabled:   getOpt(opts, "enabled", true).(bool),
+		CreatedBy: getOpt(opts, "createdBy", fmt.Sprintf("%s@test.com", strings.Split(uuid.NewV4().String(), "-")[0])).(string),
+		CreatedAt: time.Now().UnixNano(),
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	err := db.Insert(webhook)
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	return webhook
+}
+
+//GetWebhookPayload with specified optional values
+func GetWebhookPayload(options ...map[string]interface{}) map[string]interface{} {
+	opts := map[string]interface{}{}
+	if len(options) == 1 {
+		opts = options[0]
+	}
+
+	return map[string]interface{}{
+		"url":    getOpt(opts, "url", "http://localhost:8080/webhook").(string),
+		"events": getOpt(opts, "events", []string{model.WebhookEventCompleted, model.WebhookEventCircuitBreak}).([]string),
+	}
+}
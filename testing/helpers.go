@@ -25,6 +25,7 @@ package testing
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -38,85 +39,55 @@ import (
 	"gopkg.in/pg.v5/orm"
 	"gopkg.in/pg.v5/types"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/onsi/gomega"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/marathon/api"
-	"github.com/topfreegames/marathon/extensions"
 	"github.com/topfreegames/marathon/messages"
 	"github.com/uber-go/zap"
 )
 
-// FakeKafkaProducer is a mock producer that implements PushProducer interface
-type FakeKafkaProducer struct {
+// FakePushProducer is a mock producer that implements PushProducer interface
+type FakePushProducer struct {
 	APNSMessages []string
 	GCMMessages  []string
+	Messages     map[string][]string
+	Pushes       []*messages.PushMessage
 }
 
-// NewFakeKafkaProducer creates a new FakeKafkaProducer
-func NewFakeKafkaProducer() *FakeKafkaProducer {
-	return &FakeKafkaProducer{
+// NewFakePushProducer creates a new FakePushProducer
+func NewFakePushProducer() *FakePushProducer {
+	return &FakePushProducer{
 		APNSMessages: []string{},
 		GCMMessages:  []string{},
+		Messages:     map[string][]string{},
+		Pushes:       []*messages.PushMessage{},
 	}
 }
 
-// SendAPNSPush for testing
-func (f *FakeKafkaProducer) SendAPNSPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string) error {
-	msg := messages.NewAPNSMessage(
-		deviceToken,
-		pushExpiry,
-		payload,
-		messageMetadata,
-		pushMetadata,
-		templateName,
-	)
-
-	if val, ok := pushMetadata["dryRun"]; ok {
-		if dryRun, _ := val.(bool); dryRun {
-			msg.DeviceToken = extensions.GenerateFakeID(64)
-		}
-	}
-
-	message, err := msg.ToJSON()
+// Send for testing, apns and gcm messages are also kept in APNSMessages and GCMMessages and the pushes in Pushes
+func (f *FakePushProducer) Send(ctx context.Context, msg *messages.PushMessage) error {
+	message, err := messages.Build(msg)
 	if err != nil {
 		return err
 	}
 
-	f.APNSMessages = append(f.APNSMessages, message)
-
-	return nil
-}
-
-// SendGCMPush for testing
-func (f *FakeKafkaProducer) SendGCMPush(topic, deviceToken string, payload, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, pushExpiry int64, templateName string) error {
-	msg := messages.NewGCMMessage(
-		deviceToken,
-		payload,
-		messageMetadata,
-		pushMetadata,
-		pushExpiry,
-		templateName,
-	)
-
-	if val, ok := pushMetadata["dryRun"]; ok {
-		if dryRun, _ := val.(bool); dryRun {
-			msg.To = extensions.GenerateFakeID(152)
-			msg.DryRun = true
-		}
-	}
-
-	message, err := msg.ToJSON()
-
-	if err != nil {
-		return err
+	switch msg.Service {
+	case "apns":
+		f.APNSMessages = append(f.APNSMessages, message)
+	case "gcm":
+		f.GCMMessages = append(f.GCMMessages, message)
 	}
-
-	f.GCMMessages = append(f.GCMMessages, message)
+	f.Messages[msg.Service] = append(f.Messages[msg.Service], message)
+	f.Pushes = append(f.Pushes, msg)
 
 	return nil
 }
 
+// Close for testing
+func (f *FakePushProducer) Close() {}
+
 //PGMock should be used for tests that need to connect to PG
 type PGMock struct {
 	Execs        [][]interface{}
@@ -353,7 +324,7 @@ func (s *FakeS3) DownloadChunk(start, size int64, path string) (int, *bytes.Buff
 		buf := bytes.NewBuffer(val[start : start+size])
 		return len, buf, nil
 	}
-	return 0, nil, fmt.Errorf("NoSuchKey: The specified key does not exist")
+	return 0, nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist", nil)
 }
 
 // ReadLinesFromIOReader for testing
@@ -454,6 +425,15 @@ func Delete(app *api.Application, url, auth string) (int, string) {
 }
 
 func doRequest(app *api.Application, method, url, body, auth string) (int, string) {
+	headers := map[string]string{}
+	if auth != "" {
+		headers["x-forwarded-email"] = auth
+	}
+	return DoRequest(app, method, url, body, headers)
+}
+
+//DoRequest sends a request with the given headers to the server
+func DoRequest(app *api.Application, method, url, body string, headers map[string]string) (int, string) {
 	ts := httptest.NewServer(app.API)
 	defer ts.Close()
 
@@ -463,8 +443,8 @@ func doRequest(app *api.Application, method, url, body, auth string) (int, strin
 	}
 	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", ts.URL, url), reader)
 	gomega.Expect(err).NotTo(gomega.HaveOccurred())
-	if auth != "" {
-		req.Header.Add("x-forwarded-email", auth)
+	for key, value := range headers {
+		req.Header.Add(key, value)
 	}
 
 	client := &http.Client{}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jrallison/go-workers"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/redis.v5"
+)
+
+// Checkpoint keeps in redis how far the Process call of a message got, a retry of the
+// message resumes from it instead of sending the pushes again
+type Checkpoint struct {
+	Key string
+	// Sent is the number of users the pushes were sent to, including the failed ones
+	Sent   int
+	Failed int
+	// Counted tells whether the job counters were already updated with the message
+	Counted bool
+	// SaveEvery is the number of users Advance records before saving the checkpoint, 1 by default
+	SaveEvery int
+
+	client *redis.Client
+	ttl    time.Duration
+	saved  int
+}
+
+// messageID identifies a message by its args, so it is the same for all the retries of the message
+func messageID(message *workers.Msg) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(message.Args().ToJson())))
+}
+
+// checkpointKey returns the key of the checkpoint of the message, shared by its retries
+func checkpointKey(jobID uuid.UUID, message *workers.Msg) string {
+	return fmt.Sprintf("%s-checkpoint-%s", jobID.String(), messageID(message))
+}
+
+// GetCheckpoint returns the checkpoint of the message, an empty one if it was never processed
+func (w *Worker) GetCheckpoint(jobID uuid.UUID, message *workers.Msg) (*Checkpoint, error) {
+	c := &Checkpoint{
+		Key:       checkpointKey(jobID, message),
+		SaveEvery: 1,
+		client:    w.RedisClient,
+		ttl:       w.Config.GetDuration("workers.checkpointExpiration"),
+	}
+	values, err := w.RedisClient.HGetAll(c.Key).Result()
+	if err != nil {
+		return nil, err
+	}
+	c.Sent, _ = strconv.Atoi(values["sent"])
+	c.Failed, _ = strconv.Atoi(values["failed"])
+	c.Counted = values["counted"] == "1"
+	c.saved = c.Sent
+	return c, nil
+}
+
+// Done tells whether the push of the i-th user of the message was already sent
+func (c *Checkpoint) Done(i int) bool {
+	return i < c.Sent
+}
+
+// Advance records the push of the next user of the message, it is saved once every SaveEvery users
+// so a retry after a crash may send again the pushes of the users recorded since the last save
+func (c *Checkpoint) Advance(failed bool) error {
+	c.Sent++
+	if failed {
+		c.Failed++
+	}
+	if c.Sent-c.saved < c.SaveEvery {
+		return nil
+	}
+	return c.save()
+}
+
+// Flush saves the users recorded by Advance since the last save
+func (c *Checkpoint) Flush() error {
+	if c.Sent == c.saved {
+		return nil
+	}
+	return c.save()
+}
+
+// MarkCounted records that the job counters were updated with the message, the commands queued by
+// with run in the same transaction, so they are not run again by the retries of the message either
+func (c *Checkpoint) MarkCounted(with func(pipe *redis.Pipeline)) error {
+	c.Counted = true
+	_, err := c.client.TxPipelined(func(pipe *redis.Pipeline) error {
+		c.write(pipe)
+		if with != nil {
+			with(pipe)
+		}
+		return nil
+	})
+	if err != nil {
+		c.Counted = false
+		return err
+	}
+	c.saved = c.Sent
+	return nil
+}
+
+func (c *Checkpoint) save() error {
+	_, err := c.client.Pipelined(func(pipe *redis.Pipeline) error {
+		c.write(pipe)
+		return nil
+	})
+	if err == nil {
+		c.saved = c.Sent
+	}
+	return err
+}
+
+func (c *Checkpoint) write(pipe *redis.Pipeline) {
+	counted := "0"
+	if c.Counted {
+		counted = "1"
+	}
+	pipe.HMSet(c.Key, map[string]string{
+		"sent":    strconv.Itoa(c.Sent),
+		"failed":  strconv.Itoa(c.Failed),
+		"counted": counted,
+	})
+	pipe.Expire(c.Key, c.ttl)
+}
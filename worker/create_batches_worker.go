@@ -27,6 +27,7 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"time"
@@ -34,6 +35,7 @@ import (
 	"gopkg.in/pg.v5"
 
 	"github.com/jrallison/go-workers"
+	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
@@ -58,25 +60,103 @@ func NewCreateBatchesWorker(workers *Worker) *CreateBatchesWorker {
 	return b
 }
 
-// ReadFromCSV reads CSV from S3 and return correspondent array of strings
-func (b *CreateBatchesWorker) ReadFromCSV(buffer *[]byte, job *model.Job) []string {
-	for i, b := range *buffer {
-		if b == 0x0D {
-			(*buffer)[i] = 0x0A
+// ReadFromCSV parses the raw CSV records of a part and returns the lines, the first
+// column of each line is the user id and the others are the values of the header fields
+func (b *CreateBatchesWorker) ReadFromCSV(records [][]byte, job *model.Job) [][]string {
+	res := [][]string{}
+	for _, record := range records {
+		line, err := decodeCSVRecord(record)
+		b.checkErr(job, NewPermanentError(err))
+		if len(line) == 0 {
+			continue
 		}
+		res = append(res, line)
 	}
+	return res
+}
 
-	r := csv.NewReader(bytes.NewReader(*buffer))
-	lines, err := r.ReadAll()
-	b.checkErr(job, err)
-	res := []string{}
-	for i, line := range lines {
-		if i == 0 {
+// splitCSVPart splits the raw bytes of a part in records. The parts after the first can start in the
+// middle of a quoted field, they are split as starting inside one if the other way misplaces a quote or
+// leaves the rest of the part in an unclosed field
+func splitCSVPart(data []byte, part, totalParts int) [][]byte {
+	last := part == totalParts-1
+	records, ok := splitCSVRecords(data, false, last)
+	if part == 0 {
+		return records
+	}
+	quotedRecords, quotedOk := splitCSVRecords(data, true, last)
+	if quotedOk && (!ok || len(quotedRecords) > len(records)) {
+		return quotedRecords
+	}
+	return records
+}
+
+// splitCSVRecords splits the raw bytes of a CSV chunk in records without their line breaks and tells if
+// the quotes are well placed, the last chunk of a file can't end inside a quoted field. A record ends with \n, \r\n or a lone \r (Excel for Mac), line breaks inside
+// quoted fields are kept. The last record holds the bytes after the last line break, so it is empty if the
+// chunk ends with one
+func splitCSVRecords(data []byte, quoted, last bool) ([][]byte, bool) {
+	records := [][]byte{}
+	start := 0
+	ok := true
+	fieldStart, closed := !quoted, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if quoted {
+			if c == '"' {
+				quoted, closed = false, true
+			}
+			continue
+		}
+		if c == '"' {
+			// a quote opens a field or escapes a quote right after closing it
+			ok = ok && (fieldStart || closed)
+			quoted, fieldStart, closed = true, false, false
 			continue
 		}
-		res = append(res, line[0])
+		isBreak := c == '\n' || c == '\r'
+		ok = ok && (!closed || c == ',' || isBreak)
+		fieldStart, closed = c == ',', false
+		if isBreak {
+			records = append(records, data[start:i])
+			if c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+				i++
+			}
+			start = i + 1
+			fieldStart = true
+		}
 	}
-	return res
+	return append(records, data[start:]), ok && !(last && quoted)
+}
+
+// decodeCSVRecord parses a single raw record, blank records have no fields
+func decodeCSVRecord(record []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(record))
+	r.FieldsPerRecord = -1
+	line, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return line, err
+}
+
+// getUserFields maps the user ids to the values of the header fields in their lines
+func getUserFields(lines [][]string, header []string) map[string]map[string]string {
+	fields := map[string]map[string]string{}
+	if len(header) < 2 {
+		return fields
+	}
+	for _, line := range lines {
+		if len(line) < 2 {
+			continue
+		}
+		userFields := map[string]string{}
+		for i := 1; i < len(line) && i < len(header); i++ {
+			userFields[header[i]] = line[i]
+		}
+		fields[line[0]] = userFields
+	}
+	return fields
 }
 
 func (b *CreateBatchesWorker) updateTotalBatches(totalBatches int, job *model.Job) {
@@ -96,7 +176,7 @@ func (b *CreateBatchesWorker) updateTotalTokens(totalTokens int, job *model.Job)
 func (b *CreateBatchesWorker) getUserBatchFromPG(userIds *[]string, job *model.Job) *[]User {
 	var users []User
 	start := time.Now()
-	query := fmt.Sprintf("SELECT user_id, token, locale, tz FROM %s WHERE user_id IN (?)", GetPushDBTableName(job.App.Name, job.Service))
+	query := fmt.Sprintf("SELECT user_id, token, locale, region, tz FROM %s WHERE user_id IN (?)", GetPushDBTableName(job.App.Name, job.Service))
 	_, err := b.Workers.PushDB.Query(&users, query, pg.In(*userIds))
 	b.Workers.Statsd.Timing("get_csv_batch_from_pg", time.Now().Sub(start), job.Labels(), 1)
 
@@ -104,13 +184,16 @@ func (b *CreateBatchesWorker) getUserBatchFromPG(userIds *[]string, job *model.J
 	return &users
 }
 
-func (b *CreateBatchesWorker) processBatch(ids *[]string, job *model.Job) {
+func (b *CreateBatchesWorker) processBatch(ids *[]string, job *model.Job, fields map[string]map[string]string) {
 	if len(*ids) == 0 {
 		return
 	}
 	l := b.Logger
 
 	usersFromBatch := b.getUserBatchFromPG(ids, job)
+	for i := range *usersFromBatch {
+		(*usersFromBatch)[i].Fields = fields[(*usersFromBatch)[i].UserID]
+	}
 	numUsersFromBatch := len(*usersFromBatch)
 	log.I(l, "got users from db", func(cm log.CM) {
 		cm.Write(zap.Int("usersInBatch", numUsersFromBatch))
@@ -137,13 +220,29 @@ func (b *CreateBatchesWorker) updateTotalUsers(job *model.Job, totalUsers int) {
 	b.checkErr(job, err)
 }
 
-func (b *CreateBatchesWorker) processIDs(userIds []string, msg *BatchPart) {
+// hasUsers returns true if some part of the csv of the job had users, the parts add them to total_users
+func (b *CreateBatchesWorker) hasUsers(job *model.Job) bool {
+	var totalUsers int
+	_, err := b.Workers.MarathonDB.QueryOne(pg.Scan(&totalUsers), "SELECT coalesce(total_users, 0) FROM jobs WHERE id = ?", job.ID)
+	b.checkErr(job, err)
+	return totalUsers > 0
+}
+
+func (b *CreateBatchesWorker) processLines(lines [][]string, msg *BatchPart) {
+	userIds := make([]string, len(lines))
+	for i, line := range lines {
+		userIds[i] = line[0]
+	}
+	b.processIDs(userIds, getUserFields(lines, msg.Header), msg)
+}
+
+func (b *CreateBatchesWorker) processIDs(userIds []string, fields map[string]map[string]string, msg *BatchPart) {
 	l := b.Logger
 	// create a controll group if needed
 	controlGroupSize := int(math.Ceil(float64(len(userIds)) * msg.Job.ControlGroup))
 	if controlGroupSize > 0 {
 		if controlGroupSize >= len(userIds) {
-			panic("control group size cannot be higher than number of users")
+			b.checkErr(&msg.Job, NewPermanentError(fmt.Errorf("control group size cannot be higher than number of users")))
 		}
 		log.I(l, "this job has a control group!", func(cm log.CM) {
 			cm.Write(
@@ -173,64 +272,89 @@ func (b *CreateBatchesWorker) processIDs(userIds []string, msg *BatchPart) {
 	b.updateTotalUsers(&msg.Job, len(userIds))
 
 	// pull from db and send to kafta
-	b.processBatch(&userIds, &msg.Job)
+	b.processBatch(&userIds, &msg.Job, fields)
 }
 
-// get the list of ids and send to redis the splited ids
-func (b *CreateBatchesWorker) getIDs(buffer *bytes.Buffer, msg *BatchPart) []string {
-	bBystes := buffer.Bytes()
-	lines := b.ReadFromCSV(&bBystes, &msg.Job)
-	totalLines := len(lines)
-	start := 0
-	end := totalLines
+// getLines returns the lines of the part and saves to redis the raw bytes of the lines cut by
+// its boundaries, they are joined and parsed after all the parts are completed
+func (b *CreateBatchesWorker) getLines(buffer *bytes.Buffer, msg *BatchPart) [][]string {
+	records := splitCSVPart(buffer.Bytes(), msg.Part, msg.TotalParts)
 
-	// is not the first part
-	if msg.Part != 0 && totalLines > 0 {
+	// the first part starts with the header, the others with the end of a line cut by the previous part
+	if msg.Part != 0 {
 		str := fmt.Sprintf("%s-INI-%d", msg.Job.ID, msg.Part)
-		b.Workers.RedisClient.Set(str, lines[0], 90*24*time.Hour)
-		start++
+		b.Workers.RedisClient.Set(str, records[0], 90*24*time.Hour)
 	}
+	records = records[1:]
 
 	// is not the last part
-	if msg.Part != msg.TotalParts-1 {
+	if msg.Part != msg.TotalParts-1 && len(records) > 0 {
 		str := fmt.Sprintf("%s-END-%d", msg.Job.ID, msg.Part)
-		b.Workers.RedisClient.Set(str, lines[totalLines-1], 90*24*time.Hour)
-		end--
+		b.Workers.RedisClient.Set(str, records[len(records)-1], 90*24*time.Hour)
+		records = records[:len(records)-1]
 	}
 
-	return lines[start:end]
+	return b.ReadFromCSV(records, &msg.Job)
 }
 
-func (b *CreateBatchesWorker) getSplitedIds(totalParts int, job *model.Job) []string {
-	var ids []string
-	for i := 1; i < totalParts-1; i++ {
-		begin := fmt.Sprintf("%s-INI-%d", job.ID, i)
-		end := fmt.Sprintf("%s-END-%d", job.ID, i+1)
+// getSplitedLines joins the end of each part with the beginning of the next one and parses the lines
+func (b *CreateBatchesWorker) getSplitedLines(totalParts int, job *model.Job) [][]string {
+	var records [][]byte
+	for i := 0; i < totalParts-1; i++ {
+		end := fmt.Sprintf("%s-END-%d", job.ID, i)
+		begin := fmt.Sprintf("%s-INI-%d", job.ID, i+1)
 
-		beginStr, err := b.Workers.RedisClient.Get(begin).Result()
+		endBytes, err := b.Workers.RedisClient.Get(end).Bytes()
 		if err == redis.Nil {
 			continue
 		}
 		b.checkErr(job, err)
-		endStr, err := b.Workers.RedisClient.Get(end).Result()
+		beginBytes, err := b.Workers.RedisClient.Get(begin).Bytes()
 		if err == redis.Nil {
 			continue
 		}
 		b.checkErr(job, err)
 
-		ids = append(ids, beginStr+endStr)
+		records = append(records, append(endBytes, beginBytes...))
 
 		b.Workers.RedisClient.Del(begin)
 		b.Workers.RedisClient.Del(end)
 	}
-	return ids
+	return b.ReadFromCSV(records, job)
 }
 
+// completedPartsKey is the set of the csv parts of the job whose batches were created
+func completedPartsKey(jobID uuid.UUID) string {
+	return fmt.Sprintf("%s-completedparts", jobID.String())
+}
+
+// setAsComplete records the part as completed and returns the number of completed parts, the job id
+// key itself holds the job stages written by StageStatus. Retries of a part don't count it again
 func (b *CreateBatchesWorker) setAsComplete(part int, job *model.Job) int {
-	hash := job.ID.String()
-	count, err := b.Workers.RedisClient.LPush(hash, part).Result()
+	key := completedPartsKey(job.ID)
+	var count *redis.IntCmd
+	_, err := b.Workers.RedisClient.TxPipelined(func(pipe *redis.Pipeline) error {
+		pipe.SAdd(key, part)
+		count = pipe.SCard(key)
+		return nil
+	})
 	b.checkErr(job, err)
-	return int(count)
+	return int(count.Val())
+}
+
+// ownsLastPart returns true if the message joins the lines cut between the parts. Parts that complete at
+// the same time or are retried after the last one may all see every part completed, only the first
+// of them, and its own retries, joins the lines so their users don't get the pushes twice
+func (b *CreateBatchesWorker) ownsLastPart(job *model.Job, owner string) bool {
+	key := fmt.Sprintf("%s-lastpart", job.ID)
+	ok, err := b.Workers.RedisClient.SetNX(key, owner, 90*24*time.Hour).Result()
+	b.checkErr(job, err)
+	if ok {
+		return true
+	}
+	current, err := b.Workers.RedisClient.Get(key).Result()
+	b.checkErr(job, err)
+	return current == owner
 }
 
 // Process processes the messages sent to batch worker queue
@@ -239,7 +363,7 @@ func (b *CreateBatchesWorker) Process(message *workers.Msg) {
 	var msg BatchPart
 	data := message.Args().ToJson()
 	err := json.Unmarshal([]byte(data), &msg)
-	checkErr(b.Logger, err)
+	checkErr(b.Logger, NewPoisonError(err))
 
 	l := b.Logger.With(
 		zap.String("worker", nameCreateBatches),
@@ -248,16 +372,25 @@ func (b *CreateBatchesWorker) Process(message *workers.Msg) {
 	)
 
 	err = b.Workers.MarathonDB.Model(&msg.Job).Column("job.status", "App").Where("job.id = ?", msg.Job.ID).Select()
+	if err == pg.ErrNoRows {
+		err = NewPoisonError(fmt.Errorf("job %s does not exist", msg.Job.ID.String()))
+	}
 	checkErr(l, err)
-	if msg.Job.Status == stoppedJobStatus {
+	if msg.Job.Status == model.JobStatusStopped {
 		l.Info("stopped job")
 		return
 	}
+	if msg.Job.Status == model.JobStatusFailed {
+		l.Info("failed job")
+		return
+	}
 	l.Info("starting")
+	b.Workers.TransitionJob(&msg.Job, model.JobStatusRunning, nameCreateBatches)
 
 	// if is the first element
 	if msg.Part == 0 {
 		msg.Job.TagRunning(b.Workers.MarathonDB, nameCreateBatches, "starting")
+		b.Workers.NotifyJobEvent(&msg.Job, model.WebhookEventStarted, "", "")
 	}
 
 	start := time.Now()
@@ -265,38 +398,47 @@ func (b *CreateBatchesWorker) Process(message *workers.Msg) {
 	labels := msg.Job.Labels()
 	labels = append(labels, fmt.Sprintf("error:%t", err != nil))
 	b.Workers.Statsd.Timing("get_csv_from_s3", time.Now().Sub(start), labels, 1)
-	b.checkErr(&msg.Job, err)
+	b.checkErr(&msg.Job, s3Error(err))
 
-	ids := b.getIDs(buffer, &msg)
+	// a part may have no whole line, like a short last part, the job is stopped only when the whole csv has no users
+	lines := b.getLines(buffer, &msg)
 
-	if len(ids) == 0 {
-		_, err := b.Workers.MarathonDB.Model(&msg.Job).Set("status = 'stopped', updated_at = ?", time.Now().UnixNano()).Where("id = ?", msg.Job.ID).Update()
-		b.checkErr(&msg.Job, err)
+	// pull from db, send to control and send to kafta, a retry of the part skips it if the batches were created
+	checkpoint, err := b.Workers.GetCheckpoint(msg.Job.ID, message)
+	b.checkErr(&msg.Job, err)
+	if !checkpoint.Done(0) {
+		b.processLines(lines, &msg)
+		b.checkErr(&msg.Job, checkpoint.Advance(false))
 	}
-
-	// pull from db, send to control and send to kafta
-	b.processIDs(ids, &msg)
+	partStage := fmt.Sprintf("%s.%d", StageCreateBatches, msg.Part+1)
+	partDescription := fmt.Sprintf("create batches of part %d", msg.Part+1)
+	b.Workers.setJobStageProgress(msg.Job.ID.String(), partStage, partDescription, len(lines), len(lines))
 
 	completedParts := b.setAsComplete(msg.Part, &msg.Job)
 
 	if completedParts == msg.TotalParts {
-		ids = b.getSplitedIds(msg.TotalParts, &msg.Job)
-		b.processIDs(ids, &msg)
-		msg.Job.TagSuccess(b.Workers.MarathonDB, nameCreateBatches, "finished")
-		// TODO: schedule a job to run after send all messages. This job will check
-		// for errors and delete waste if a error happen
+		if b.ownsLastPart(&msg.Job, checkpoint.Key) {
+			lines = b.getSplitedLines(msg.TotalParts, &msg.Job)
+			b.processLines(lines, &msg)
+			if !b.hasUsers(&msg.Job) && b.Workers.TransitionJob(&msg.Job, model.JobStatusStopped, "the csv has no user ids") {
+				b.Workers.NotifyJobEvent(&msg.Job, model.WebhookEventStopped, "", "")
+			}
+			msg.Job.TagSuccess(b.Workers.MarathonDB, nameCreateBatches, "finished")
+			// TODO: schedule a job to run after send all messages. This job will check
+			// for errors and delete waste if a error happen
+		}
 	} else {
 		str := fmt.Sprintf("complete part %d of %d", completedParts, msg.TotalParts)
 		msg.Job.TagRunning(b.Workers.MarathonDB, nameCreateBatches, str)
 	}
-	ids = nil
+	b.Workers.setJobStageProgress(msg.Job.ID.String(), StageCreateBatches, "create batches", completedParts, msg.TotalParts)
+	lines = nil
 
 	l.Info("finished")
 }
 
 func (b *CreateBatchesWorker) checkErr(job *model.Job, err error) {
 	if err != nil {
-		job.TagError(b.Workers.MarathonDB, nameCreateBatches, err.Error())
-		checkErr(b.Logger, err)
+		checkErr(b.Logger, withJob(job.ID, err))
 	}
 }
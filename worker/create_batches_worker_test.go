@@ -81,12 +81,20 @@ a04087d6-4d95-4d99-901f-a1ff8578a2bf
 dc2be5c1-2b6d-47d6-9a45-c188fd96d124`)
 		fakeData6 := []byte(`userIds
 stange-token`)
+		fakeData7 := []byte(`userids,reward,friend
+9e558649-9c23-469d-a11c-59b05813e3d5,100 gems,John
+57be9009-e616-42c6-9cfe-505508ede2d0,"1,000 coins",Mary`)
 		fakeS3.PutObject("test/jobs/obj1.csv", &fakeData1)
 		fakeS3.PutObject("test/jobs/obj2.csv", &fakeData2)
 		fakeS3.PutObject("test/jobs/obj3.csv", &fakeData3)
 		fakeS3.PutObject("test/jobs/obj4.csv", &fakeData4)
 		fakeS3.PutObject("test/jobs/obj5.csv", &fakeData5)
 		fakeS3.PutObject("test/jobs/obj6.csv", &fakeData6)
+		fakeS3.PutObject("test/jobs/obj7.csv", &fakeData7)
+		fakeData8 := []byte("userids,reward,friend\r\n" +
+			"9e558649-9c23-469d-a11c-59b05813e3d5,\"100\r\ngems\",John\r\n" +
+			"57be9009-e616-42c6-9cfe-505508ede2d0,\"1,000 coins\",\"Ma\rry\"\r\n")
+		fakeS3.PutObject("test/jobs/obj8.csv", &fakeData8)
 		app = CreateTestApp(w.MarathonDB)
 		defaults := map[string]interface{}{
 			"user_name":   "Someone",
@@ -290,6 +298,98 @@ stange-token`)
 			Expect(len(wMessage1.Users)).To(BeEquivalentTo(10))
 		})
 
+		It("should send the extra csv columns as user fields to process_batches_worker", func() {
+			a := CreateTestApp(w.MarathonDB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(w.MarathonDB, a.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"filters": map[string]interface{}{},
+				"csvPath": "test/jobs/obj7.csv",
+			})
+
+			_, err := w.CreateCSVSplitJob(j)
+			Expect(err).NotTo(HaveOccurred())
+
+			jobData, err := w.RedisClient.LPop("queue:csv_split_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(jobData))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(func() { createCSVSplitWorker.Process(msg) }).ShouldNot(Panic())
+
+			jobData, err = w.RedisClient.LPop("queue:create_batches_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			msg, err = workers.NewMsg(string(jobData))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+
+			job1, err := w.RedisClient.LPop("queue:process_batch_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			j1 := map[string]interface{}{}
+			err = json.Unmarshal([]byte(job1), &j1)
+			Expect(err).NotTo(HaveOccurred())
+			wMessage1, err := worker.ParseProcessBatchWorkerMessageArray(j1["args"].([]interface{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wMessage1.Users).To(HaveLen(2))
+			fields := map[string]map[string]string{}
+			for _, user := range wMessage1.Users {
+				fields[user.UserID] = user.Fields
+			}
+			Expect(fields["9e558649-9c23-469d-a11c-59b05813e3d5"]).To(Equal(map[string]string{
+				"reward": "100 gems",
+				"friend": "John",
+			}))
+			Expect(fields["57be9009-e616-42c6-9cfe-505508ede2d0"]).To(Equal(map[string]string{
+				"reward": "1,000 coins",
+				"friend": "Mary",
+			}))
+		})
+
+		It("should keep the line breaks inside quoted csv fields", func() {
+			a := CreateTestApp(w.MarathonDB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(w.MarathonDB, a.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"filters": map[string]interface{}{},
+				"csvPath": "test/jobs/obj8.csv",
+			})
+
+			_, err := w.CreateCSVSplitJob(j)
+			Expect(err).NotTo(HaveOccurred())
+
+			jobData, err := w.RedisClient.LPop("queue:csv_split_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			msg, err := workers.NewMsg(string(jobData))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(func() { createCSVSplitWorker.Process(msg) }).ShouldNot(Panic())
+
+			jobData, err = w.RedisClient.LPop("queue:create_batches_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			msg, err = workers.NewMsg(string(jobData))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+
+			job1, err := w.RedisClient.LPop("queue:process_batch_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			j1 := map[string]interface{}{}
+			err = json.Unmarshal([]byte(job1), &j1)
+			Expect(err).NotTo(HaveOccurred())
+			wMessage1, err := worker.ParseProcessBatchWorkerMessageArray(j1["args"].([]interface{}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wMessage1.Users).To(HaveLen(2))
+			fields := map[string]map[string]string{}
+			for _, user := range wMessage1.Users {
+				fields[user.UserID] = user.Fields
+			}
+			Expect(fields["9e558649-9c23-469d-a11c-59b05813e3d5"]).To(Equal(map[string]string{
+				"reward": "100\ngems",
+				"friend": "John",
+			}))
+			Expect(fields["57be9009-e616-42c6-9cfe-505508ede2d0"]).To(Equal(map[string]string{
+				"reward": "1,000 coins",
+				"friend": "Ma\rry",
+			}))
+		})
+
 		It("should create batches with the right number of tokens if a controlGroup is specified", func() {
 			a := CreateTestApp(w.MarathonDB, map[string]interface{}{"name": "testapp"})
 			j := CreateTestJob(w.MarathonDB, a.ID, template.Name, map[string]interface{}{
@@ -791,6 +891,83 @@ stange-token`)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(updatedJob.Status).To(Equal("stopped"))
 		})
+
+		It("should not stop the job if a part of the csv has no whole line", func() {
+			a := CreateTestApp(w.MarathonDB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(w.MarathonDB, a.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"filters": map[string]interface{}{},
+				"csvPath": "test/jobs/obj1.csv",
+			})
+			err := w.MarathonDB.Model(j).Column("job.*", "App").Where("job.id = ?", j.ID).Select()
+			Expect(err).NotTo(HaveOccurred())
+
+			// the first part ends in the middle of the first user id
+			totalSize := 8 + 10*37 - 1
+			parts := []worker.BatchPart{
+				{Start: 0, Size: 20, TotalParts: 2, TotalSize: totalSize, Part: 0, Job: *j},
+				{Start: 20, Size: totalSize - 20, TotalParts: 2, TotalSize: totalSize, Part: 1, Job: *j},
+			}
+			for _, part := range parts {
+				msgB, err := json.Marshal(map[string]interface{}{"args": part})
+				Expect(err).NotTo(HaveOccurred())
+				msg, err := workers.NewMsg(string(msgB))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(func() { createBatchesWorker.Process(msg) }).ShouldNot(Panic())
+
+				updatedJob := &model.Job{ID: j.ID}
+				err = w.MarathonDB.Model(updatedJob).Column("job.*").Where("job.id = ?", updatedJob.ID).Select()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(updatedJob.Status).NotTo(Equal(model.JobStatusStopped))
+			}
+
+			updatedJob := &model.Job{ID: j.ID}
+			err = w.MarathonDB.Model(updatedJob).Column("job.*").Where("job.id = ?", updatedJob.ID).Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updatedJob.TotalUsers).To(Equal(10))
+		})
+
+		It("should join the lines cut between the parts only once when parts complete at the same time", func() {
+			a := CreateTestApp(w.MarathonDB, map[string]interface{}{"name": "testapp"})
+			j := CreateTestJob(w.MarathonDB, a.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"filters": map[string]interface{}{},
+				"csvPath": "test/jobs/obj1.csv",
+			})
+			err := w.MarathonDB.Model(j).Column("job.*", "App").Where("job.id = ?", j.ID).Select()
+			Expect(err).NotTo(HaveOccurred())
+
+			totalSize := 8 + 10*37 - 1
+			parts := []worker.BatchPart{
+				{Start: 0, Size: 100, TotalParts: 2, TotalSize: totalSize, Part: 0, Job: *j},
+				{Start: 100, Size: totalSize - 100, TotalParts: 2, TotalSize: totalSize, Part: 1, Job: *j},
+			}
+			process := func(part worker.BatchPart) {
+				msgB, err := json.Marshal(map[string]interface{}{"args": part})
+				Expect(err).NotTo(HaveOccurred())
+				msg, err := workers.NewMsg(string(msgB))
+				Expect(err).NotTo(HaveOccurred())
+				createBatchesWorker.Process(msg)
+			}
+			process(parts[0])
+			process(parts[1])
+			batches, err := w.RedisClient.LLen("queue:process_batch_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+
+			// the first part is retried and sees every part completed with the line cut between the parts
+			// in redis, like when both parts complete at the same time, it must not join the line again
+			err = w.RedisClient.Set(j.ID.String()+"-INI-1", "-9b31-683ad3aae920", time.Hour).Err()
+			Expect(err).NotTo(HaveOccurred())
+			process(parts[0])
+			res, err := w.RedisClient.LLen("queue:process_batch_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(batches))
+
+			updatedJob := &model.Job{ID: j.ID}
+			err = w.MarathonDB.Model(updatedJob).Column("job.*").Where("job.id = ?", updatedJob.ID).Select()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updatedJob.TotalUsers).To(Equal(10))
+		})
 	})
 
 	// Describe("Read CSV from S3", func() {
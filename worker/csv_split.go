@@ -28,6 +28,7 @@ package worker
 import (
 	"encoding/json"
 	"math"
+	"strings"
 
 	"github.com/jrallison/go-workers"
 	"github.com/satori/go.uuid"
@@ -44,6 +45,8 @@ type BatchPart struct {
 	TotalSize  int
 	Part       int
 	Job        model.Job
+	// Header is the CSV header when it has columns other than the user id
+	Header []string
 }
 
 const nameSCVSplit = "csv_split_worker"
@@ -53,6 +56,9 @@ const nameSCVSplit = "csv_split_worker"
 // memory use, reduce the number of workers and vice-versa.
 const partSize = 10 * 1024 * 1024
 
+// headerSize is the size of the chunk read to get the CSV header
+const headerSize = 64 * 1024
+
 // CSVSplitWorker is the CSVSplitWorker struct
 type CSVSplitWorker struct {
 	Workers *Worker
@@ -73,7 +79,7 @@ func NewCSVSplitWorker(workers *Worker) *CSVSplitWorker {
 func (b *CSVSplitWorker) Process(message *workers.Msg) {
 	var id uuid.UUID
 	err := json.Unmarshal([]byte(message.Args().ToJson()), &id)
-	checkErr(b.Logger, err)
+	checkErr(b.Logger, NewPoisonError(err))
 
 	isReexecution := checkIsReexecution(id, b.Workers.RedisClient, b.Logger)
 	l := b.Logger.With(
@@ -87,41 +93,100 @@ func (b *CSVSplitWorker) Process(message *workers.Msg) {
 	checkErr(l, err)
 	job.TagRunning(b.Workers.MarathonDB, nameSCVSplit, "starting")
 
-	if job.Status == stoppedJobStatus {
+	if job.Status == model.JobStatusStopped {
 		l.Info("stopped job")
 		return
 	}
+	if job.Status == model.JobStatusFailed {
+		l.Info("failed job")
+		return
+	}
+	b.Workers.TransitionJob(job, model.JobStatusRunning, nameSCVSplit)
+	b.Workers.NotifyJobEvent(job, model.WebhookEventStarted, "", "")
 
-	// get file information
-	totalSize, _, err := b.Workers.S3Client.DownloadChunk(0, 1, job.CSVPath)
+	parts, err := b.Workers.csvParts(job)
 	b.checkErr(job, err)
+	totalParts := len(parts)
+	b.Workers.setJobStageProgress(job.ID.String(), StageSplitCSV, "split csv", 0, totalParts)
 
-	start := 0
-	totalParts := int(math.Ceil(float64(totalSize) / float64(partSize)))
+	// a retry of the message doesn't enqueue the parts created before it failed again
+	checkpoint, err := b.Workers.GetCheckpoint(job.ID, message)
+	b.checkErr(job, err)
+	for i, part := range parts {
+		if checkpoint.Done(i) {
+			continue
+		}
+		_, err := b.Workers.CreateBatchesJob(part)
+		b.checkErr(job, err)
+		b.checkErr(job, checkpoint.Advance(false))
+		b.Workers.Statsd.Incr("csv_job_part", job.Labels(), 1)
+		b.Workers.setJobStageProgress(job.ID.String(), StageSplitCSV, "split csv", i+1, totalParts)
+	}
+	job.TagSuccess(b.Workers.MarathonDB, nameSCVSplit, "finished")
+}
+
+// csvParts returns the parts the CSV of the job is split in, each one is processed by a create batches message
+func (w *Worker) csvParts(job *model.Job) ([]*BatchPart, error) {
+	totalSize, _, err := w.S3Client.DownloadChunk(0, 1, job.CSVPath)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	header, err := w.readCSVHeader(totalSize, job)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < totalParts; i++ {
+	totalParts := int(math.Ceil(float64(totalSize) / float64(partSize)))
+	parts := make([]*BatchPart, totalParts)
+	start := 0
+	for i := range parts {
 		size := totalSize - start
 		if size > partSize {
 			size = partSize
 		}
-		_, err := b.Workers.CreateBatchesJob(&BatchPart{
+		parts[i] = &BatchPart{
 			Start:      start,
 			Size:       size,
 			TotalParts: totalParts,
 			TotalSize:  totalSize,
 			Part:       i,
 			Job:        *job,
-		})
-		b.checkErr(job, err)
+			Header:     header,
+		}
 		start += size
-		b.Workers.Statsd.Incr("csv_job_part", job.Labels(), 1)
 	}
-	job.TagSuccess(b.Workers.MarathonDB, nameSCVSplit, "finished")
+	return parts, nil
+}
+
+// readCSVHeader returns the CSV header if it has more than the user id column, the
+// first column is always the user id and the others are per user template variables
+func (w *Worker) readCSVHeader(totalSize int, job *model.Job) ([]string, error) {
+	size := totalSize
+	if size > headerSize {
+		size = headerSize
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	_, buffer, err := w.S3Client.DownloadChunk(0, int64(size), job.CSVPath)
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	header, err := decodeCSVRecord(splitCSVPart(buffer.Bytes(), 0, 1)[0])
+	if err != nil {
+		return nil, NewPermanentError(err)
+	}
+	if len(header) < 2 {
+		return nil, nil
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	return header, nil
 }
 
 func (b *CSVSplitWorker) checkErr(job *model.Job, err error) {
 	if err != nil {
-		job.TagError(b.Workers.MarathonDB, nameSCVSplit, err.Error())
-		checkErr(b.Logger, err)
+		checkErr(b.Logger, withJob(job.ID, err))
 	}
 }
@@ -111,6 +111,10 @@ var _ = Describe("CSVSplit Worker", func() {
 			Expect(msg.Size).To(Equal(20000000 - 10485760))
 			Expect(msg.Part).To(Equal(1))
 			Expect(msg.Job.ID).To(Equal(j.ID))
+
+			dbJob := &model.Job{ID: j.ID}
+			Expect(w.MarathonDB.Select(dbJob)).To(Succeed())
+			Expect(dbJob.Status).To(Equal(model.JobStatusRunning))
 		})
 
 		It("should panic if is incorrect file content", func() {
@@ -159,6 +163,18 @@ var _ = Describe("CSVSplit Worker", func() {
 			msg, err := workers.NewMsg(string(jobData))
 			Expect(err).NotTo(HaveOccurred())
 			Expect(func() { createCSVSplitWorker.Process(msg) }).Should(Panic())
+
+			// the missing file is a permanent error, the middleware fails the job instead of retrying
+			Expect(func() {
+				w.Errors.Call("csv_split_worker", msg, func() bool {
+					createCSVSplitWorker.Process(msg)
+					return true
+				})
+			}).ShouldNot(Panic())
+
+			dbJob := &model.Job{ID: j.ID}
+			Expect(w.MarathonDB.Select(dbJob)).To(Succeed())
+			Expect(dbJob.Status).To(Equal(model.JobStatusFailed))
 		})
 
 		It("should panic job id don`t exist", func() {
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// DeliveryReporter folds the delivery results confirmed by the push producer into
+// the job failed tokens and into the batch failure accounting of the workers. The
+// failures are cached and flushed every workers.deliveryReporter.flushInterval, so
+// an unhealthy broker does not turn into one database write per failed message
+type DeliveryReporter struct {
+	Logger        zap.Logger
+	Workers       *Worker
+	FlushInterval time.Duration
+	failures      map[string]*batchFailures
+	mutex         sync.Mutex
+	stop          chan struct{}
+}
+
+// batchFailures are the delivery failures of a batch not flushed yet
+type batchFailures struct {
+	info  messages.DeliveryInfo
+	count int
+}
+
+// NewDeliveryReporter gets a new DeliveryReporter
+func NewDeliveryReporter(workers *Worker) *DeliveryReporter {
+	r := &DeliveryReporter{
+		Logger:        workers.Logger.With(zap.String("source", "deliveryReporter")),
+		Workers:       workers,
+		FlushInterval: workers.Config.GetDuration("workers.deliveryReporter.flushInterval"),
+		failures:      map[string]*batchFailures{},
+	}
+	return r
+}
+
+// ReportDelivery caches a message that failed to be delivered, the next flush moves it
+// from the job completed tokens to its failed tokens
+func (r *DeliveryReporter) ReportDelivery(info *messages.DeliveryInfo, err error) {
+	if err == nil || info == nil {
+		return
+	}
+	key := fmt.Sprintf("%s-%s", info.JobID, info.BatchID)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if f, ok := r.failures[key]; ok {
+		f.count++
+		return
+	}
+	r.failures[key] = &batchFailures{info: *info, count: 1}
+}
+
+// Start flushes the cached failures every FlushInterval until Stop is called
+func (r *DeliveryReporter) Start() {
+	r.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(r.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Flush()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic flushes and flushes the failures still cached
+func (r *DeliveryReporter) Stop() {
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	r.Flush()
+}
+
+// Flush writes the cached failures with one update per job, a batch is considered failed once
+// its delivery failures go over workers.processBatch.maxUserFailureInBatch
+func (r *DeliveryReporter) Flush() {
+	r.mutex.Lock()
+	failures := r.failures
+	r.failures = map[string]*batchFailures{}
+	r.mutex.Unlock()
+
+	byJob := map[string][]*batchFailures{}
+	for _, f := range failures {
+		byJob[f.info.JobID] = append(byJob[f.info.JobID], f)
+	}
+	for jobID, batches := range byJob {
+		r.flushJob(jobID, batches)
+	}
+}
+
+// flushJob runs in the flush goroutine, so errors are logged instead of panicking
+func (r *DeliveryReporter) flushJob(jobIDStr string, batches []*batchFailures) {
+	l := r.Logger.With(
+		zap.String("operation", "flushDeliveryFailures"),
+		zap.String("jobId", jobIDStr),
+	)
+	jobID, err := uuid.FromString(jobIDStr)
+	if err != nil {
+		log.E(l, "Invalid job id in delivery info.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return
+	}
+	total := 0
+	for _, f := range batches {
+		total += f.count
+	}
+
+	job := model.Job{}
+	_, err = r.Workers.MarathonDB.Model(&job).
+		Set("completed_tokens = completed_tokens - ?", total).
+		Set("failed_tokens = failed_tokens + ?", total).
+		Where("id = ?", jobID).
+		Returning("*").
+		Update()
+	if err != nil {
+		log.E(l, "Failed to update job failed tokens.", func(cm log.CM) {
+			cm.Write(zap.Int("failures", total), zap.Error(err))
+		})
+		return
+	}
+	r.Workers.PublishJobProgress(model.NewJobCountersProgress(&job))
+
+	for _, f := range batches {
+		failed, err := r.Workers.IncrBatchFailures(&f.info, f.count)
+		if err != nil {
+			log.E(l, "Failed to update batch failures.", func(cm log.CM) {
+				cm.Write(zap.String("batchId", f.info.BatchID), zap.Error(err))
+			})
+			continue
+		}
+		if !failed {
+			continue
+		}
+		log.I(l, "Delivery failures over threshold, considering batch as failed.", func(cm log.CM) {
+			cm.Write(zap.String("batchId", f.info.BatchID))
+		})
+		err = r.Workers.IncrFailedBatches(jobID, job.TotalBatches, f.info.AppName)
+		if err != nil {
+			log.E(l, "Failed to increment failed batches.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+	}
+}
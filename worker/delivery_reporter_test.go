@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/messages"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Delivery Reporter", func() {
+	var reporter *worker.DeliveryReporter
+	var job *model.Job
+	var delivery *messages.DeliveryInfo
+
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()),
+		zap.FatalLevel,
+	)
+	w := worker.NewWorker(logger, GetConfPath())
+
+	BeforeEach(func() {
+		w.RedisClient.FlushAll()
+		reporter = worker.NewDeliveryReporter(w)
+		app := CreateTestApp(w.MarathonDB)
+		template := CreateTestTemplate(w.MarathonDB, app.ID)
+		job = CreateTestJob(w.MarathonDB, app.ID, template.Name)
+		_, err := w.MarathonDB.Model(&model.Job{}).
+			Set("total_batches = 100").
+			Set("completed_tokens = 100").
+			Where("id = ?", job.ID).Update()
+		Expect(err).NotTo(HaveOccurred())
+		delivery = &messages.DeliveryInfo{
+			JobID:     job.ID.String(),
+			BatchID:   uuid.NewV4().String(),
+			BatchSize: 100,
+			AppName:   app.Name,
+		}
+	})
+
+	Describe("Report delivery", func() {
+		It("should not change the job if the message was delivered", func() {
+			reporter.ReportDelivery(delivery, nil)
+			reporter.Flush()
+
+			dbJob := &model.Job{ID: job.ID}
+			err := w.MarathonDB.Select(dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.CompletedTokens).To(Equal(100))
+			Expect(dbJob.FailedTokens).To(Equal(0))
+		})
+
+		It("should move a failed message from completed to failed tokens", func() {
+			reporter.ReportDelivery(delivery, errors.New("kafka: broker not available"))
+			reporter.ReportDelivery(delivery, errors.New("kafka: broker not available"))
+
+			// the failures are only written when they are flushed
+			dbJob := &model.Job{ID: job.ID}
+			Expect(w.MarathonDB.Select(dbJob)).To(Succeed())
+			Expect(dbJob.FailedTokens).To(Equal(0))
+			reporter.Flush()
+
+			err := w.MarathonDB.Select(dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.CompletedTokens).To(Equal(98))
+			Expect(dbJob.FailedTokens).To(Equal(2))
+
+			_, err = w.RedisClient.Get(fmt.Sprintf("%s-failedbatches", job.ID.String())).Result()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should increment failed batches once when the batch failures go over the threshold", func() {
+			// maxUserFailureInBatch is 0.05, so the sixth failure fails the batch
+			for i := 0; i < 10; i++ {
+				reporter.ReportDelivery(delivery, errors.New("kafka: broker not available"))
+			}
+			reporter.Flush()
+
+			failedBatches, err := w.RedisClient.Get(fmt.Sprintf("%s-failedbatches", job.ID.String())).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(failedBatches).To(Equal("1"))
+
+			dbJob := &model.Job{ID: job.ID}
+			err = w.MarathonDB.Select(dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.FailedTokens).To(Equal(10))
+		})
+
+		It("should ignore deliveries with an invalid job id", func() {
+			delivery.JobID = "not-uuid"
+			Expect(func() {
+				reporter.ReportDelivery(delivery, errors.New("error"))
+				reporter.Flush()
+			}).NotTo(Panic())
+		})
+	})
+})
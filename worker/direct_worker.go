@@ -35,6 +35,7 @@ import (
 	workers "github.com/jrallison/go-workers"
 	uuid "github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
 )
@@ -48,6 +49,29 @@ type DirectPartMsg struct {
 
 const nameDirectWorker = "direct_worker"
 
+// directPagesKey is the hash with the size and the biggest seq id of the pages the push db is read in for the job
+func directPagesKey(jobID uuid.UUID) string {
+	return fmt.Sprintf("%s-directpages", jobID.String())
+}
+
+// completedPagesKey is the set of the smallest seq ids of the pages of the job that were sent
+func completedPagesKey(jobID uuid.UUID) string {
+	return fmt.Sprintf("%s-completedpages", jobID.String())
+}
+
+// directPages returns the pages of size seq ids the push db is read in, up to maxSeqID
+func directPages(jobID uuid.UUID, size, maxSeqID uint64) []*DirectPartMsg {
+	pages := []*DirectPartMsg{}
+	for i := uint64(0); i < maxSeqID+1; i += size {
+		pages = append(pages, &DirectPartMsg{
+			SmallestSeqID: i,
+			BiggestSeqID:  i + size,
+			JobUUID:       jobID,
+		})
+	}
+	return pages
+}
+
 // DirectWorker is the DirectWorker struct
 type DirectWorker struct {
 	Logger  zap.Logger
@@ -64,48 +88,20 @@ func NewDirectWorker(workers *Worker) *DirectWorker {
 	return b
 }
 
-func (b *DirectWorker) sendToKafka(service, topic string, msg, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, deviceToken string, expiresAt int64, templateName string) error {
-	pushExpiry := expiresAt / 1000000000 // convert from nanoseconds to seconds
-	switch service {
-	case "apns":
-		err := b.Workers.Kafka.SendAPNSPush(topic, deviceToken, msg, messageMetadata, pushMetadata, pushExpiry, templateName)
-		if err != nil {
-			return err
-		}
-	case "gcm":
-		err := b.Workers.Kafka.SendGCMPush(topic, deviceToken, msg, messageMetadata, pushMetadata, pushExpiry, templateName)
-		if err != nil {
-			return err
-		}
-	default:
-		panic("service should be in ['apns', 'gcm']")
-	}
-	return nil
-}
-
-func (b *DirectWorker) addCompletedTokens(job *model.Job, nTokens int) error {
-	_, err := b.Workers.MarathonDB.Model(&job).Set("completed_tokens = completed_tokens + ?", nTokens).Where("id = ?", job.ID).Update()
-	return err
-}
-
-func (b *DirectWorker) addCompletedBatch(job *model.Job) error {
-	_, err := b.Workers.MarathonDB.Model(&job).Set("completed_batches = completed_batches + 1").Where("id = ?", job.ID).Update()
-	return err
-}
-
-func (b *DirectWorker) checkComplete(job *model.Job) (bool, error) {
-	err := b.Workers.MarathonDB.Model(&job).Where("id = ?", job.ID).Select()
-	return job.CompletedBatches == job.TotalBatches, err
+// setAsComplete records the page as sent, the recovery of stuck jobs doesn't enqueue it again
+func (b *DirectWorker) setAsComplete(job *model.Job, msg *DirectPartMsg) {
+	b.checkErr(job, b.Workers.RedisClient.SAdd(completedPagesKey(job.ID), msg.SmallestSeqID).Err())
 }
 
 func (b *DirectWorker) getQuery(job *model.Job) string {
 	filters := job.Filters
 	whereClause := GetWhereClauseFromFilters(filters)
-	query := fmt.Sprintf("SELECT user_id, token, locale, tz FROM %s WHERE seq_id >= ? AND seq_id < ?", GetPushDBTableName(job.App.Name, job.Service))
+	query := fmt.Sprintf("SELECT user_id, token, locale, region, tz FROM %s WHERE seq_id >= ? AND seq_id < ?", GetPushDBTableName(job.App.Name, job.Service))
 	if (whereClause) != "" {
 		query = fmt.Sprintf("%s AND %s", query, whereClause)
 	}
-	return query
+	// the retries of a part resume from a checkpoint, so the users must come in the same order
+	return fmt.Sprintf("%s ORDER BY seq_id", query)
 }
 
 // Process processes the messages sent to batch worker queue and send them to kafka
@@ -118,7 +114,7 @@ func (b *DirectWorker) Process(message *workers.Msg) {
 	var msg DirectPartMsg
 	data := message.Args().ToJson()
 	err := json.Unmarshal([]byte(data), &msg)
-	checkErr(l, err)
+	checkErr(l, NewPoisonError(err))
 
 	job, err := b.Workers.GetJob(msg.JobUUID)
 	checkErr(l, err)
@@ -130,41 +126,45 @@ func (b *DirectWorker) Process(message *workers.Msg) {
 	}
 
 	switch job.Status {
-	case "circuitbreak":
+	case model.JobStatusCircuitBreak:
 		log.I(l, "circuit break")
 		return
-	case "paused":
+	case model.JobStatusPaused:
 		log.I(l, "paused")
 		return
-	case "stopped":
+	case model.JobStatusStopped:
 		log.I(l, "stopped")
 		return
+	case model.JobStatusFailed:
+		log.I(l, "failed")
+		return
 	default:
 		log.D(l, "valid")
 	}
+	b.Workers.TransitionJob(job, model.JobStatusRunning, nameDirectWorker)
+	b.Workers.NotifyJobEvent(job, model.WebhookEventStarted, "", "")
 
 	templatesByNameAndLocale, err := job.GetJobTemplatesByNameAndLocale(b.Workers.MarathonDB)
 	b.checkErr(job, err)
 
-	topicTemplate := b.Workers.Config.GetString("workers.topicTemplate")
-	topic := BuildTopicName(job.App.Name, job.Service, topicTemplate)
+	topic := b.Workers.GetTopic(job.App.Name, job.Service)
 
 	var users []User
 	start := time.Now()
 	_, err = b.Workers.PushDB.Query(&users, b.getQuery(job), msg.SmallestSeqID, msg.BiggestSeqID)
 	b.Workers.Statsd.Timing("get_from_pg", time.Now().Sub(start), job.Labels(), 1)
-
-	successfulUsers := len(users)
+	b.checkErr(job, err)
 
 	// create a controll group if needed
 	controlGroupSize := int(math.Ceil(float64(len(users)) * job.ControlGroup))
 	if controlGroupSize > 0 {
 		if controlGroupSize >= len(users) {
-			panic("control group size cannot be higher than number of users")
+			b.checkErr(job, NewPermanentError(fmt.Errorf("control group size cannot be higher than number of users")))
 		}
-		// shuffle slice in place
+		// shuffle slice in place, seeded by the part so its retries pick the same control group
+		shuffle := rand.New(rand.NewSource(int64(msg.SmallestSeqID)))
 		for i := range users {
-			j := rand.Intn(i + 1)
+			j := shuffle.Intn(i + 1)
 			users[i], users[j] = users[j], users[i]
 		}
 		// grab control group
@@ -178,7 +178,23 @@ func (b *DirectWorker) Process(message *workers.Msg) {
 		users = append(users[:len(users)-controlGroupSize], users[len(users):]...)
 	}
 
-	for _, user := range users {
+	pushOptions := job.EffectivePushOptions()
+	// the retries of the message keep its batch id, so all its failures share one counter
+	delivery := &messages.DeliveryInfo{
+		JobID:     job.ID.String(),
+		BatchID:   messageID(message),
+		BatchSize: len(users),
+		AppName:   job.App.Name,
+	}
+	checkpoint, err := b.Workers.GetCheckpoint(job.ID, message)
+	b.checkErr(job, err)
+	// the checkpoint is saved with the job counters at the end of the page, or when a panic interrupts it
+	checkpoint.SaveEvery = b.Workers.Config.GetInt("workers.processBatch.checkpointInterval")
+	defer checkpoint.Flush()
+	for i, user := range users {
+		if checkpoint.Done(i) {
+			continue
+		}
 		templateName := job.TemplateName
 		templateNames := strings.Split(job.TemplateName, ",")
 
@@ -189,23 +205,15 @@ func (b *DirectWorker) Process(message *workers.Msg) {
 			})
 		}
 
-		templatesByLocale := templatesByNameAndLocale[templateName]
-		var template model.Template
-		if val, ok := templatesByLocale[strings.ToLower(user.Locale)]; ok {
-			template = val
-		} else if val, ok := templatesByLocale["en"]; ok {
-			template = val
-		} else {
-			b.checkErr(job, fmt.Errorf("there is no template for the given locale or 'en'"))
+		// the users whose message can't be built are failed tokens of the page
+		msg, err := buildUserMessage(job, templatesByNameAndLocale[templateName], &user)
+		if err != nil {
+			log.W(l, "Failed to build message.", func(cm log.CM) {
+				cm.Write(zap.String("userId", user.UserID), zap.String("locale", user.Locale), zap.Error(err))
+			})
+			b.checkErr(job, checkpoint.Advance(true))
+			continue
 		}
-
-		msgStr, msgErr := BuildMessageFromTemplate(template, job.Context)
-		b.checkErr(job, msgErr)
-
-		var msg map[string]interface{}
-		err = json.Unmarshal([]byte(msgStr), &msg)
-
-		b.checkErr(job, err)
 		pushMetadata := map[string]interface{}{
 			"userId":       user.UserID,
 			"pushTime":     time.Now().Unix(),
@@ -222,19 +230,26 @@ func (b *DirectWorker) Process(message *workers.Msg) {
 			}
 		}
 
-		err = b.sendToKafka(job.Service, topic, msg, job.Metadata, pushMetadata, user.Token, job.ExpiresAt, templateName)
-		if err != nil {
-			successfulUsers--
-		}
+		err = b.Workers.SendPush(job, topic, user.Token, msg, pushMetadata, templateName, pushOptions, delivery)
+		b.checkErr(job, checkpoint.Advance(err != nil))
+	}
+	if checkpoint.Counted {
+		b.setAsComplete(job, &msg)
+		return
 	}
 
-	// ignore errors
-	b.addCompletedTokens(job, successfulUsers)
-	b.addCompletedBatch(job)
-	complete, _ := b.checkComplete(job)
-	if complete {
+	// the page and its tokens are added to the job counters once, even if the message is retried
+	_, err = b.Workers.CountBatch(job, delivery.BatchID, len(users)-checkpoint.Failed, checkpoint.Failed)
+	b.checkErr(job, err)
+	b.Workers.PublishJobProgress(model.NewJobCountersProgress(job))
+	b.Workers.setJobStageProgress(job.ID.String(), StageDirectSendMessages, "send messages", job.CompletedBatches, job.TotalBatches)
+	b.checkErr(job, checkpoint.MarkCounted(nil))
+	b.setAsComplete(job, &msg)
+	if job.CompletedBatches >= job.TotalBatches && job.CompletedAt == 0 {
 		job.CompletedAt = time.Now().UnixNano()
-		_, err = b.Workers.MarathonDB.Model(&job).Column("completed_at").Update()
+		_, err = b.Workers.MarathonDB.Model(job).Column("completed_at").Update()
+		b.Workers.TransitionJob(job, model.JobStatusCompleted, "finished all batches")
+		b.Workers.PublishJobProgress(model.NewJobCountersProgress(job))
 
 		at := time.Now().Add(b.Workers.Config.GetDuration("workers.processBatch.intervalToSendCompletedJob")).UnixNano()
 		_, err = b.Workers.ScheduleJobCompletedJob(job.ID.String(), at)
@@ -243,7 +258,6 @@ func (b *DirectWorker) Process(message *workers.Msg) {
 
 func (b *DirectWorker) checkErr(job *model.Job, err error) {
 	if err != nil {
-		job.TagError(b.Workers.MarathonDB, nameDirectWorker, err.Error())
-		checkErr(b.Logger, err)
+		checkErr(b.Logger, withJob(job.ID, err))
 	}
 }
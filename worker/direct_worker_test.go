@@ -36,7 +36,7 @@ import (
 var _ = Describe("Complete Test", func() {
 	var app *model.App
 	var template *model.Template
-	var producer *FakeKafkaProducer
+	var producer *FakePushProducer
 	config := GetConf()
 
 	logger := zap.New(
@@ -97,8 +97,8 @@ var _ = Describe("Complete Test", func() {
 
 		w.RedisClient.FlushAll()
 		w.S3Client = NewFakeS3(w.Config)
-		producer = NewFakeKafkaProducer()
-		w.Kafka = producer
+		producer = NewFakePushProducer()
+		w.Producer = producer
 	})
 
 	Describe("Process", func() {
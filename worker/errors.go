@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jrallison/go-workers"
+	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	"gopkg.in/redis.v5"
+)
+
+// ErrorKind tells the ErrorMiddleware what to do with a message whose Process call failed
+type ErrorKind int
+
+const (
+	// ErrorRetryable is the kind of the errors that may go away by themselves, like database timeouts,
+	// the message is retried by go-workers
+	ErrorRetryable ErrorKind = iota
+	// ErrorPermanent is the kind of the errors that retrying won't fix, like broken templates,
+	// the job of the message is failed
+	ErrorPermanent
+	// ErrorPoison is the kind of the errors of messages the workers can't handle at all,
+	// the message is moved to the dead letter queue
+	ErrorPoison
+)
+
+// goWorkersMaxRetries is the number of retries go-workers uses when the message does not set it
+const goWorkersMaxRetries = 25
+
+// deadLetterKey returns the redis list that keeps the poison messages of the queue
+func deadLetterKey(queue string) string {
+	return fmt.Sprintf("marathon:deadletter:%s", queue)
+}
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorPermanent:
+		return "permanent"
+	case ErrorPoison:
+		return "poison"
+	default:
+		return "retryable"
+	}
+}
+
+// WorkerError is the error the workers panic with, the ErrorMiddleware handles it according to its kind
+type WorkerError struct {
+	Kind  ErrorKind
+	JobID uuid.UUID
+	Err   error
+}
+
+func (e *WorkerError) Error() string {
+	return e.Err.Error()
+}
+
+func newWorkerError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	if werr, ok := err.(*WorkerError); ok {
+		return &WorkerError{Kind: kind, JobID: werr.JobID, Err: werr.Err}
+	}
+	return &WorkerError{Kind: kind, Err: err}
+}
+
+// NewRetryableError returns err as a retryable WorkerError, or nil if err is nil
+func NewRetryableError(err error) error {
+	return newWorkerError(ErrorRetryable, err)
+}
+
+// NewPermanentError returns err as a permanent WorkerError, or nil if err is nil
+func NewPermanentError(err error) error {
+	return newWorkerError(ErrorPermanent, err)
+}
+
+// NewPoisonError returns err as a poison WorkerError, or nil if err is nil
+func NewPoisonError(err error) error {
+	return newWorkerError(ErrorPoison, err)
+}
+
+// ErrorKindOf returns the kind of err, errors that are not WorkerErrors are retryable
+func ErrorKindOf(err error) ErrorKind {
+	if werr, ok := err.(*WorkerError); ok {
+		return werr.Kind
+	}
+	return ErrorRetryable
+}
+
+// asWorkerError returns err as a WorkerError, errors without a kind are retryable
+func asWorkerError(err error) *WorkerError {
+	if werr, ok := err.(*WorkerError); ok {
+		return werr
+	}
+	return &WorkerError{Kind: ErrorRetryable, Err: err}
+}
+
+// withJob returns err as a WorkerError of the job, permanent errors fail it
+func withJob(jobID uuid.UUID, err error) *WorkerError {
+	werr := asWorkerError(err)
+	return &WorkerError{Kind: werr.Kind, JobID: jobID, Err: werr.Err}
+}
+
+// ErrorMiddleware is the go-workers middleware that handles the WorkerErrors the workers panic with:
+// retryable errors are raised again for the go-workers retries, permanent errors and retryable errors
+// without retries left fail the job of the message and poison messages go to the dead letter queue
+type ErrorMiddleware struct {
+	Workers *Worker
+}
+
+// NewErrorMiddleware returns a new ErrorMiddleware
+func NewErrorMiddleware(workers *Worker) *ErrorMiddleware {
+	return &ErrorMiddleware{
+		Workers: workers,
+	}
+}
+
+// Call implements the go-workers Action interface, panics other than WorkerErrors are raised again
+func (m *ErrorMiddleware) Call(queue string, message *workers.Msg, next func() bool) (acknowledge bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		werr, ok := r.(*WorkerError)
+		if !ok {
+			panic(r)
+		}
+		acknowledge = m.handle(queue, message, werr)
+	}()
+	return next()
+}
+
+func (m *ErrorMiddleware) handle(queue string, message *workers.Msg, err *WorkerError) bool {
+	kind := err.Kind
+	if kind == ErrorRetryable && !hasRetriesLeft(message) {
+		kind = ErrorPermanent
+	}
+	l := m.Workers.Logger.With(
+		zap.String("source", "errorMiddleware"),
+		zap.String("queue", queue),
+		zap.String("jobID", err.JobID.String()),
+		zap.String("kind", kind.String()),
+		zap.Error(err.Err),
+	)
+	m.Workers.Statsd.Incr("worker_error", []string{
+		fmt.Sprintf("queue:%s", queue),
+		fmt.Sprintf("kind:%s", kind.String()),
+	}, 1)
+
+	switch kind {
+	case ErrorRetryable:
+		log.W(l, "Retrying message.")
+		panic(err)
+	case ErrorPoison:
+		if dlErr := m.Workers.DeadLetter(queue, message, err); dlErr != nil {
+			log.E(l, "Failed to move message to the dead letter queue, retrying it.", func(cm log.CM) {
+				cm.Write(zap.String("deadLetterError", dlErr.Error()))
+			})
+			panic(err)
+		}
+		log.W(l, "Moved message to the dead letter queue.")
+	default:
+		if err.JobID == uuid.Nil {
+			log.W(l, "Dropped message.")
+			return true
+		}
+		if failErr := m.Workers.FailJob(err.JobID, queue, err.Err); failErr != nil {
+			log.E(l, "Failed to fail job, retrying message.", func(cm log.CM) {
+				cm.Write(zap.String("failJobError", failErr.Error()))
+			})
+			panic(err)
+		}
+		log.W(l, "Failed job.")
+	}
+	return true
+}
+
+// hasRetriesLeft tells whether go-workers will retry the message, it follows the go-workers retry middleware
+func hasRetriesLeft(message *workers.Msg) bool {
+	var options struct {
+		Retry      interface{} `json:"retry"`
+		RetryCount int         `json:"retry_count"`
+		RetryMax   *int        `json:"retry_max"`
+	}
+	if err := json.Unmarshal([]byte(message.ToJson()), &options); err != nil {
+		return false
+	}
+	retry := false
+	max := goWorkersMaxRetries
+	switch value := options.Retry.(type) {
+	case bool:
+		retry = value
+	case float64:
+		retry = true
+		max = int(value)
+	}
+	if options.RetryMax != nil {
+		max = *options.RetryMax
+	}
+	return retry && options.RetryCount < max
+}
+
+// DeadLetter moves the message to the dead letter queue of its queue, the newest
+// workers.deadLetter.maxLength messages are kept
+func (w *Worker) DeadLetter(queue string, message *workers.Msg, cause error) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"queue":    queue,
+		"error":    cause.Error(),
+		"failedAt": time.Now().UnixNano(),
+		"message":  json.RawMessage(message.ToJson()),
+	})
+	if err != nil {
+		return err
+	}
+	key := deadLetterKey(queue)
+	maxLength := int64(w.Config.GetInt("workers.deadLetter.maxLength"))
+	_, err = w.RedisClient.Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.LPush(key, string(data))
+		pipe.LTrim(key, 0, maxLength-1)
+		return nil
+	})
+	return err
+}
+
+// FailJob tags the error in the job, moves it to failed and notifies the failed job webhooks
+func (w *Worker) FailJob(jobID uuid.UUID, source string, cause error) error {
+	job, err := w.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	job.TagError(w.MarathonDB, source, cause.Error())
+	if w.TransitionJob(job, model.JobStatusFailed, cause.Error()) {
+		w.NotifyJobEvent(job, model.WebhookEventFailed, source, cause.Error())
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	workers "github.com/jrallison/go-workers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Errors", func() {
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()),
+		zap.FatalLevel,
+	)
+	w := worker.NewWorker(logger, GetConfPath())
+
+	newMessage := func(content map[string]interface{}) *workers.Msg {
+		data, err := json.Marshal(content)
+		Expect(err).NotTo(HaveOccurred())
+		message, err := workers.NewMsg(string(data))
+		Expect(err).NotTo(HaveOccurred())
+		return message
+	}
+
+	BeforeEach(func() {
+		w.RedisClient.FlushAll()
+	})
+
+	Describe("Error kinds", func() {
+		It("should keep nil errors nil", func() {
+			Expect(worker.NewRetryableError(nil)).To(BeNil())
+			Expect(worker.NewPermanentError(nil)).To(BeNil())
+			Expect(worker.NewPoisonError(nil)).To(BeNil())
+		})
+
+		It("should consider errors without a kind retryable", func() {
+			err := fmt.Errorf("connection reset")
+			Expect(worker.ErrorKindOf(err)).To(Equal(worker.ErrorRetryable))
+			Expect(worker.ErrorKindOf(worker.NewPermanentError(err))).To(Equal(worker.ErrorPermanent))
+			Expect(worker.ErrorKindOf(worker.NewPoisonError(err))).To(Equal(worker.ErrorPoison))
+			Expect(worker.NewPoisonError(err).Error()).To(Equal("connection reset"))
+		})
+	})
+
+	Describe("ErrorMiddleware", func() {
+		It("should acknowledge the messages processed without errors", func() {
+			message := newMessage(map[string]interface{}{"args": []string{"a"}})
+			Expect(w.Errors.Call("csv_split_worker", message, func() bool { return true })).To(BeTrue())
+		})
+
+		It("should raise again the panics that are not worker errors", func() {
+			message := newMessage(map[string]interface{}{"args": []string{"a"}})
+			Expect(func() {
+				w.Errors.Call("csv_split_worker", message, func() bool { panic("unexpected") })
+			}).To(Panic())
+		})
+
+		It("should raise again retryable errors of messages with retries left", func() {
+			message := newMessage(map[string]interface{}{"args": []string{"a"}, "retry": true})
+			Expect(func() {
+				w.Errors.Call("csv_split_worker", message, func() bool {
+					panic(worker.NewRetryableError(fmt.Errorf("timeout")))
+				})
+			}).To(Panic())
+		})
+
+		It("should drop messages without retries and without job", func() {
+			message := newMessage(map[string]interface{}{"args": []string{"a"}})
+			Expect(w.Errors.Call("csv_split_worker", message, func() bool {
+				panic(worker.NewRetryableError(fmt.Errorf("timeout")))
+			})).To(BeTrue())
+			Expect(w.RedisClient.LLen("marathon:deadletter:csv_split_worker").Val()).To(BeEquivalentTo(0))
+		})
+
+		It("should move poison messages to the dead letter queue", func() {
+			w.Config.Set("workers.deadLetter.maxLength", 2)
+			defer w.Config.Set("workers.deadLetter.maxLength", 10000)
+			for i := 0; i < 3; i++ {
+				message := newMessage(map[string]interface{}{"args": []int{i}, "retry": true})
+				Expect(w.Errors.Call("csv_split_worker", message, func() bool {
+					panic(worker.NewPoisonError(fmt.Errorf("invalid message %d", i)))
+				})).To(BeTrue())
+			}
+
+			deadLetters, err := w.RedisClient.LRange("marathon:deadletter:csv_split_worker", 0, -1).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deadLetters).To(HaveLen(2))
+			var deadLetter map[string]interface{}
+			Expect(json.Unmarshal([]byte(deadLetters[0]), &deadLetter)).To(Succeed())
+			Expect(deadLetter["error"]).To(Equal("invalid message 2"))
+		})
+
+		It("should fail the job of permanent errors", func() {
+			app := CreateTestApp(w.MarathonDB)
+			template := CreateTestTemplate(w.MarathonDB, app.ID)
+			job := CreateTestJob(w.MarathonDB, app.ID, template.Name)
+			message := newMessage(map[string]interface{}{"args": []string{job.ID.String()}, "retry": true})
+
+			Expect(w.Errors.Call("csv_split_worker", message, func() bool {
+				w.TransitionJob(job, model.JobStatusRunning, "csv_split_worker")
+				panic(&worker.WorkerError{
+					Kind:  worker.ErrorPermanent,
+					JobID: job.ID,
+					Err:   fmt.Errorf("broken template"),
+				})
+			})).To(BeTrue())
+
+			dbJob := &model.Job{ID: job.ID}
+			Expect(w.MarathonDB.Select(dbJob)).To(Succeed())
+			Expect(dbJob.Status).To(Equal(model.JobStatusFailed))
+		})
+	})
+})
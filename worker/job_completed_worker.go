@@ -87,10 +87,10 @@ func (b *JobCompletedWorker) updateJobControlGroupCSVPath(job *model.Job, csvPat
 // Process processes the messages sent to worker queue
 func (b *JobCompletedWorker) Process(message *workers.Msg) {
 	arr, err := message.Args().Array()
-	checkErr(b.Logger, err)
+	checkErr(b.Logger, NewPoisonError(err))
 	jobID := arr[0]
 	id, err := uuid.FromString(jobID.(string))
-	checkErr(b.Logger, err)
+	checkErr(b.Logger, NewPoisonError(err))
 	l := b.Logger.With(
 		zap.String("jobID", id.String()),
 		zap.String("worker", nameJobCompleted),
@@ -102,21 +102,25 @@ func (b *JobCompletedWorker) Process(message *workers.Msg) {
 
 	job.TagRunning(b.Workers.MarathonDB, nameJobCompleted, "starting")
 
-	if b.Workers.SendgridClient != nil {
+	// a retry of the message doesn't send the email again
+	checkpoint, err := b.Workers.GetCheckpoint(job.ID, message)
+	b.checkErr(job, err)
+	if b.Workers.SendgridClient != nil && !checkpoint.Done(0) {
 		err = email.SendJobCompletedEmail(b.Workers.SendgridClient, job, job.App.Name)
 		b.checkErr(job, err)
+		b.checkErr(job, checkpoint.Advance(false))
 	}
 
 	job.TagRunning(b.Workers.MarathonDB, nameJobCompleted, "sending control group")
 	b.flushControlGroup(job)
 
 	job.TagSuccess(b.Workers.MarathonDB, nameJobCompleted, "finished")
+	b.Workers.NotifyJobEvent(job, model.WebhookEventCompleted, "", "")
 	log.I(l, "finished")
 }
 
 func (b *JobCompletedWorker) checkErr(job *model.Job, err error) {
 	if err != nil {
-		job.TagError(b.Workers.MarathonDB, nameJobCompleted, err.Error())
-		checkErr(b.Logger, err)
+		checkErr(b.Logger, withJob(job.ID, err))
 	}
 }
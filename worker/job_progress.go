@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	redis "gopkg.in/redis.v5"
+)
+
+// publishJobProgress publishes the progress update to the job progress channel,
+// only the subscribers connected when it is published receive it
+func publishJobProgress(client *redis.Client, progress *model.JobProgress) error {
+	b, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return client.Publish(model.JobProgressChannel(progress.JobID), string(b)).Err()
+}
+
+// PublishJobProgress publishes the progress update of a job, errors are logged and never fail the caller
+func (w *Worker) PublishJobProgress(progress *model.JobProgress) {
+	if w.RedisClient == nil {
+		return
+	}
+	if err := publishJobProgress(w.RedisClient, progress); err != nil {
+		log.E(w.Logger, "Failed to publish job progress.", func(cm log.CM) {
+			cm.Write(zap.String("jobID", progress.JobID), zap.Error(err))
+		})
+	}
+}
+
+// GetJobStagesProgress returns the progress of the stages of the job written by StageStatus
+func GetJobStagesProgress(client *redis.Client, jobID string) (map[string]model.StageProgress, error) {
+	stageKeys, err := client.HGetAll(jobID).Result()
+	if err != nil {
+		return nil, err
+	}
+	stages := map[string]model.StageProgress{}
+	for stage, stageKey := range stageKeys {
+		if !strings.HasPrefix(stageKey, jobID+"-") {
+			continue
+		}
+		values, err := client.HGetAll(stageKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		stages[stage] = parseStageProgress(values)
+	}
+	return stages, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/topfreegames/marathon/messages"
+	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/templating"
+)
+
+// APNSMaxPayloadSize is the maximum size in bytes of the payload accepted by APNs
+const APNSMaxPayloadSize = 4096
+
+// previewToken is the token of the messages built by a preview
+const previewToken = "preview"
+
+// Preview is a template built for one user as the workers would build it
+type Preview struct {
+	Valid            bool            `json:"valid"`
+	Errors           []string        `json:"errors"`
+	MissingVariables []string        `json:"missingVariables"`
+	APNS             json.RawMessage `json:"apns,omitempty"`
+	APNSPayloadSize  int             `json:"apnsPayloadSize"`
+	GCM              json.RawMessage `json:"gcm,omitempty"`
+}
+
+// PreviewTemplate builds the APNS and GCM messages of the template for the user with the job
+// context, metadata and push options, and reports the problems that would break the job
+func PreviewTemplate(template model.Template, job *model.Job, user *User) *Preview {
+	preview := &Preview{
+		Errors:           []string{},
+		MissingVariables: []string{},
+	}
+	defer func() {
+		preview.Valid = len(preview.Errors) == 0 && len(preview.MissingVariables) == 0
+	}()
+
+	missing, err := templating.MissingVariables(template.Engine, template.Body, templateData(template, job.Context, user))
+	if err != nil {
+		preview.Errors = append(preview.Errors, fmt.Sprintf("invalid template: %s", err.Error()))
+		return preview
+	}
+	preview.MissingVariables = missing
+
+	msgStr, err := BuildMessageFromTemplate(template, job.Context, user)
+	if err != nil {
+		preview.Errors = append(preview.Errors, fmt.Sprintf("failed to render template: %s", err.Error()))
+		return preview
+	}
+	var msg map[string]interface{}
+	err = json.Unmarshal([]byte(msgStr), &msg)
+	if err != nil {
+		preview.Errors = append(preview.Errors, fmt.Sprintf("invalid JSON: %s", err.Error()))
+		return preview
+	}
+
+	apns, err := previewMessage("apns", msgStr, template, job, user)
+	if err != nil {
+		preview.Errors = append(preview.Errors, fmt.Sprintf("failed to build apns message: %s", err.Error()))
+	} else {
+		preview.APNS = json.RawMessage(apns)
+		var apnsMessage messages.APNSMessage
+		json.Unmarshal([]byte(apns), &apnsMessage)
+		payload, _ := json.Marshal(apnsMessage.Payload)
+		preview.APNSPayloadSize = len(payload)
+		if preview.APNSPayloadSize > APNSMaxPayloadSize {
+			preview.Errors = append(preview.Errors, fmt.Sprintf(
+				"apns payload has %d bytes, more than the limit of %d bytes", preview.APNSPayloadSize, APNSMaxPayloadSize,
+			))
+		}
+	}
+
+	gcm, err := previewMessage("gcm", msgStr, template, job, user)
+	if err != nil {
+		preview.Errors = append(preview.Errors, fmt.Sprintf("failed to build gcm message: %s", err.Error()))
+	} else {
+		preview.GCM = json.RawMessage(gcm)
+	}
+	return preview
+}
+
+// previewMessage builds the message of the service, each one gets its own copy of the
+// rendered template because the builders can change it
+func previewMessage(service, msgStr string, template model.Template, job *model.Job, user *User) (string, error) {
+	var payload map[string]interface{}
+	err := json.Unmarshal([]byte(msgStr), &payload)
+	if err != nil {
+		return "", err
+	}
+	return messages.Build(&messages.PushMessage{
+		Service:         service,
+		Token:           previewToken,
+		Payload:         payload,
+		MessageMetadata: job.Metadata,
+		PushMetadata: map[string]interface{}{
+			"userId":       user.UserID,
+			"templateName": template.Name,
+			"pushType":     "preview",
+		},
+		PushExpiry:   job.ExpiresAt / 1000000000,
+		TemplateName: template.Name,
+		Options:      job.EffectivePushOptions(),
+	})
+}
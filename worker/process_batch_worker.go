@@ -23,18 +23,17 @@
 package worker
 
 import (
-	"encoding/json"
 	"fmt"
-	"math/rand"
 	"strings"
 	"time"
 
 	workers "github.com/jrallison/go-workers"
 	uuid "github.com/satori/go.uuid"
-	"github.com/topfreegames/marathon/email"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
+	"gopkg.in/redis.v5"
 )
 
 const nameProcessBatchWorker = "process_batch_worker"
@@ -56,64 +55,21 @@ func NewProcessBatchWorker(workers *Worker) *ProcessBatchWorker {
 }
 
 func (b *ProcessBatchWorker) incrFailedBatches(jobID uuid.UUID, totalBatches int, appName string) {
-	failedJobs, err := b.Workers.RedisClient.Incr(fmt.Sprintf("%s-failedbatches", jobID.String())).Result()
+	err := b.Workers.IncrFailedBatches(jobID, totalBatches, appName)
 	checkErr(b.Logger, err)
-	ttl, err := b.Workers.RedisClient.TTL(fmt.Sprintf("%s-failedbatches", jobID.String())).Result()
-	checkErr(b.Logger, err)
-	if ttl < 0 {
-		b.Workers.RedisClient.Expire(fmt.Sprintf("%s-failedbatches", jobID.String()), 7*24*time.Hour)
-	}
-	if float64(failedJobs)/float64(totalBatches) >= b.Workers.Config.GetFloat64("workers.processBatch.maxBatchFailure") {
-		job := model.Job{}
-		_, err := b.Workers.MarathonDB.Model(&job).Set("status = 'circuitbreak'").Where("id = ?", jobID).Returning("*").Update()
-		checkErr(b.Logger, err)
-		changedStatus, err := b.Workers.RedisClient.SetNX(fmt.Sprintf("%s-circuitbreak", jobID.String()), 1, 1*time.Minute).Result()
-		checkErr(b.Logger, err)
-		if changedStatus && b.Workers.SendgridClient != nil {
-			var expireAt int64
-			if ttl > 0 {
-				expireAt = time.Now().Add(ttl).UnixNano()
-			} else {
-				expireAt = time.Now().Add(7 * 24 * time.Hour).UnixNano()
-			}
-			email.SendCircuitBreakJobEmail(b.Workers.SendgridClient, &job, appName, expireAt)
-		}
-	}
-}
-
-func (b *ProcessBatchWorker) sendToKafka(service, topic string, msg, messageMetadata map[string]interface{}, pushMetadata map[string]interface{}, deviceToken string, expiresAt int64, templateName string) error {
-	pushExpiry := expiresAt / 1000000000 // convert from nanoseconds to seconds
-	switch service {
-	case "apns":
-		err := b.Workers.Kafka.SendAPNSPush(topic, deviceToken, msg, messageMetadata, pushMetadata, pushExpiry, templateName)
-		if err != nil {
-			return err
-		}
-	case "gcm":
-		err := b.Workers.Kafka.SendGCMPush(topic, deviceToken, msg, messageMetadata, pushMetadata, pushExpiry, templateName)
-		if err != nil {
-			return err
-		}
-	default:
-		panic("service should be in ['apns', 'gcm']")
-	}
-	return nil
 }
 
-func (b *ProcessBatchWorker) updateJobUsersInfo(jobID uuid.UUID, numUsers int) error {
-	job := model.Job{}
-	_, err := b.Workers.MarathonDB.Model(&job).Set("completed_tokens = completed_tokens + ?", numUsers).Where("id = ?", jobID).Update()
-	return err
-}
-
-func (b *ProcessBatchWorker) updateJobBatchesInfo(jobID uuid.UUID) error {
-	job := model.Job{}
-	_, err := b.Workers.MarathonDB.Model(&job).Set("completed_batches = completed_batches + 1").Where("id = ?", jobID).Returning("*").Update()
+// updateJobBatchesInfo adds the batch and its tokens to the job counters, once even if the message is retried
+func (b *ProcessBatchWorker) updateJobBatchesInfo(job *model.Job, batchID string, numUsers, numFailed int) error {
+	counted, err := b.Workers.CountBatch(job, batchID, numUsers, numFailed)
 	if err != nil {
 		return err
 	}
-	if job.TotalBatches != 0 && job.CompletedBatches == 1 && job.CompletedAt == 0 {
+	b.Workers.PublishJobProgress(model.NewJobCountersProgress(job))
+	b.Workers.setJobStageProgress(job.ID.String(), StageSendMessages, "send messages", job.CompletedBatches, job.TotalBatches)
+	if counted && job.TotalBatches != 0 && job.CompletedBatches == 1 && job.CompletedAt == 0 {
 		job.TagRunning(b.Workers.MarathonDB, "process_batche_worker", "starting")
+		b.Workers.NotifyJobEvent(job, model.WebhookEventStarted, "", "")
 	}
 	if job.TotalBatches != 0 && job.CompletedBatches >= job.TotalBatches && job.CompletedAt == 0 {
 		l := b.Logger.With(
@@ -126,12 +82,14 @@ func (b *ProcessBatchWorker) updateJobBatchesInfo(jobID uuid.UUID) error {
 		log.I(l, "Finished all batches")
 		job.TagSuccess(b.Workers.MarathonDB, "process_batche_worker", "Finished all batches")
 		job.CompletedAt = time.Now().UnixNano()
-		_, err = b.Workers.MarathonDB.Model(&job).Column("completed_at").Update()
+		_, err = b.Workers.MarathonDB.Model(job).Column("completed_at").Update()
 		if err != nil {
 			return err
 		}
+		b.Workers.TransitionJob(job, model.JobStatusCompleted, "Finished all batches")
+		b.Workers.PublishJobProgress(model.NewJobCountersProgress(job))
 		at := time.Now().Add(b.Workers.Config.GetDuration("workers.processBatch.intervalToSendCompletedJob")).UnixNano()
-		_, err = b.Workers.ScheduleJobCompletedJob(jobID.String(), at)
+		_, err = b.Workers.ScheduleJobCompletedJob(job.ID.String(), at)
 	}
 	return err
 }
@@ -146,22 +104,14 @@ func (b *ProcessBatchWorker) moveJobToPausedQueue(jobID uuid.UUID, message *work
 	}
 }
 
-func (b *ProcessBatchWorker) checkErrWithReEnqueue(parsed *BatchWorkerMessage, l zap.Logger, err error) {
+func (b *ProcessBatchWorker) checkErr(job *model.Job, err error) {
 	if err != nil {
-		at := time.Now().Add(time.Duration(rand.Intn(100)) * time.Second).UnixNano()
-		b.Workers.ScheduleProcessBatchJob(
-			parsed.JobID.String(),
-			parsed.AppName,
-			&parsed.Users,
-			at,
-		)
+		checkErr(b.Logger, withJob(job.ID, err))
 	}
-	checkErr(l, err)
 }
 
 // Process processes the messages sent to batch worker queue and send them to kafka
 func (b *ProcessBatchWorker) Process(message *workers.Msg) {
-	batchErrorCounter := 0
 	l := b.Logger.With(
 		zap.String("source", "processBatchWorker"),
 		zap.String("operation", "process"),
@@ -169,13 +119,13 @@ func (b *ProcessBatchWorker) Process(message *workers.Msg) {
 	)
 	log.I(l, "starting")
 	arr, err := message.Args().Array()
-	checkErr(l, err)
+	checkErr(l, NewPoisonError(err))
 	parsed, err := ParseProcessBatchWorkerMessageArray(arr)
-	checkErr(l, err)
+	checkErr(l, NewPoisonError(err))
 	log.D(l, "Parsed message info successfully.")
 
 	job, err := b.Workers.GetJob(parsed.JobID)
-	b.checkErrWithReEnqueue(parsed, l, err)
+	checkErr(l, err)
 
 	log.D(l, "Retrieved job successfully.")
 	b.Workers.Statsd.Incr("starting_process_batch_worker", job.Labels(), 1)
@@ -186,36 +136,61 @@ func (b *ProcessBatchWorker) Process(message *workers.Msg) {
 	}
 
 	switch job.Status {
-	case "circuitbreak":
+	case model.JobStatusCircuitBreak:
 		log.I(l, "circuit break")
 		b.moveJobToPausedQueue(job.ID, message)
 		return
-	case "paused":
+	case model.JobStatusPaused:
 		log.I(l, "paused")
 		b.moveJobToPausedQueue(job.ID, message)
 		return
-	case "stopped":
+	case model.JobStatusStopped:
 		log.I(l, "stopped")
 		return
+	case model.JobStatusFailed:
+		// failed jobs are final, the batches left are dropped
+		log.I(l, "failed")
+		return
 	default:
 		log.D(l, "valid")
 	}
+	b.Workers.TransitionJob(job, model.JobStatusRunning, "process_batche_worker")
 
 	templatesByNameAndLocale, err := job.GetJobTemplatesByNameAndLocale(b.Workers.MarathonDB)
 	if err != nil {
 		b.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
 	}
-	b.checkErrWithReEnqueue(parsed, l, err)
+	b.checkErr(job, err)
 	log.D(l, "Retrieved templatesByNameAndLocale successfully.", func(cm log.CM) {
 		cm.Write(zap.Object("templatesByNameAndLocale", templatesByNameAndLocale))
 	})
 
-	topicTemplate := b.Workers.Config.GetString("workers.topicTemplate")
-	topic := BuildTopicName(parsed.AppName, job.Service, topicTemplate)
+	topic := b.Workers.GetTopic(parsed.AppName, job.Service)
 	log.D(l, "Built topic name successfully.", func(cm log.CM) {
 		cm.Write(zap.String("topic", topic))
 	})
-	for _, user := range parsed.Users {
+	pushOptions := job.EffectivePushOptions()
+	// the retries of the message keep its batch id, so all its failures share one counter
+	delivery := &messages.DeliveryInfo{
+		JobID:     job.ID.String(),
+		BatchID:   messageID(message),
+		BatchSize: len(parsed.Users),
+		AppName:   parsed.AppName,
+	}
+	checkpoint, err := b.Workers.GetCheckpoint(job.ID, message)
+	b.checkErr(job, err)
+	// the checkpoint is saved with the job counters at the end of the batch, or when a panic interrupts it
+	checkpoint.SaveEvery = b.Workers.Config.GetInt("workers.processBatch.checkpointInterval")
+	defer checkpoint.Flush()
+	if checkpoint.Sent > 0 {
+		log.I(l, "resuming from checkpoint", func(cm log.CM) {
+			cm.Write(zap.Int("sent", checkpoint.Sent), zap.Bool("counted", checkpoint.Counted))
+		})
+	}
+	for i, user := range parsed.Users {
+		if checkpoint.Done(i) {
+			continue
+		}
 		templateName := job.TemplateName
 		templateNames := strings.Split(job.TemplateName, ",")
 
@@ -226,28 +201,16 @@ func (b *ProcessBatchWorker) Process(message *workers.Msg) {
 			})
 		}
 
-		templatesByLocale := templatesByNameAndLocale[templateName]
-		var template model.Template
-		if val, ok := templatesByLocale[strings.ToLower(user.Locale)]; ok {
-			template = val
-		} else if val, ok := templatesByLocale["en"]; ok {
-			template = val
-		} else {
-			b.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
-			checkErr(l, fmt.Errorf("there is no template for the given locale or 'en'"))
-		}
-
-		msgStr, msgErr := BuildMessageFromTemplate(template, job.Context)
-		if msgErr != nil {
-			b.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
-		}
-		checkErr(l, msgErr)
-		var msg map[string]interface{}
-		err = json.Unmarshal([]byte(msgStr), &msg)
+		// the users whose message can't be built are failed tokens of the batch, the circuit
+		// break decides if the job goes on when there are too many of them
+		msg, err := buildUserMessage(job, templatesByNameAndLocale[templateName], &user)
 		if err != nil {
-			b.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
+			log.W(l, "Failed to build message.", func(cm log.CM) {
+				cm.Write(zap.String("userId", user.UserID), zap.String("locale", user.Locale), zap.Error(err))
+			})
+			b.checkErr(job, checkpoint.Advance(true))
+			continue
 		}
-		checkErr(l, err)
 		pushMetadata := map[string]interface{}{
 			"userId":       user.UserID,
 			"pushTime":     time.Now().Unix(),
@@ -264,10 +227,9 @@ func (b *ProcessBatchWorker) Process(message *workers.Msg) {
 			}
 		}
 
-		err = b.sendToKafka(job.Service, topic, msg, job.Metadata, pushMetadata, user.Token, job.ExpiresAt, templateName)
+		err = b.Workers.SendPush(job, topic, user.Token, msg, pushMetadata, templateName, pushOptions, delivery)
 		if err != nil {
-			batchErrorCounter = batchErrorCounter + 1
-			log.E(l, "Failed to send message to Kafka.", func(cm log.CM) {
+			log.E(l, "Failed to send message.", func(cm log.CM) {
 				cm.Write(
 					zap.String("service", job.Service),
 					zap.String("topic", topic),
@@ -279,17 +241,35 @@ func (b *ProcessBatchWorker) Process(message *workers.Msg) {
 				)
 			})
 		}
+		b.checkErr(job, checkpoint.Advance(err != nil))
 	}
 	log.D(l, "Sent push to pusher for batch users.")
-	err = b.updateJobBatchesInfo(parsed.JobID)
-	checkErr(l, err)
-	log.D(l, "Updated job batches info successfully.")
-	err = b.updateJobUsersInfo(parsed.JobID, len(parsed.Users)-batchErrorCounter)
-	checkErr(l, err)
-	log.D(l, "Updated job users info successfully.")
-	if float64(batchErrorCounter)/float64(len(parsed.Users)) > b.Workers.Config.GetFloat64("workers.processBatch.maxUserFailureInBatch") {
-		b.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
-		checkErr(l, fmt.Errorf("failed to send message to several users, considering batch as failed"))
+	batchErrorCounter := checkpoint.Failed
+	batchFailed := false
+	if !checkpoint.Counted {
+		err = b.updateJobBatchesInfo(job, delivery.BatchID, len(parsed.Users)-batchErrorCounter, batchErrorCounter)
+		b.checkErr(job, err)
+		log.D(l, "Updated job batches info successfully.")
+		// the failures share the counter with the ones confirmed later by the producer, they are
+		// added with the checkpoint so a retry of the message doesn't add them again
+		var failures *redis.IntCmd
+		err = checkpoint.MarkCounted(func(pipe *redis.Pipeline) {
+			if batchErrorCounter > 0 && delivery.BatchSize > 0 {
+				failures = pipe.IncrBy(batchFailuresKey(delivery), int64(batchErrorCounter))
+				pipe.Expire(batchFailuresKey(delivery), 7*24*time.Hour)
+			}
+		})
+		b.checkErr(job, err)
+		if failures != nil && b.Workers.batchFailedWith(delivery, failures.Val(), batchErrorCounter) {
+			batchFailed = true
+			b.incrFailedBatches(job.ID, job.TotalBatches, parsed.AppName)
+		}
+	}
+	if batchFailed {
+		// the message is acked on purpose: the batch already counts towards the circuit break
+		// and retrying it would only send the pushes again
+		log.W(l, "Failed to send message to several users, considering batch as failed.")
+		return
 	}
 	log.I(l, "finished")
 }
@@ -33,6 +33,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	uuid "github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/extensions"
 	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	. "github.com/topfreegames/marathon/testing"
@@ -63,7 +64,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 	var jobWithManyTemplates *model.Job
 	var gcmJob *model.Job
 	var users []worker.User
-	var mockKafkaProducer *FakeKafkaProducer
+	var mockPushProducer *FakePushProducer
 
 	logger := zap.New(
 		zap.NewJSONEncoder(zap.NoTime()),
@@ -72,8 +73,8 @@ var _ = Describe("ProcessBatch Worker", func() {
 	w := worker.NewWorker(logger, GetConfPath())
 
 	BeforeEach(func() {
-		mockKafkaProducer = NewFakeKafkaProducer()
-		w.Kafka = mockKafkaProducer
+		mockPushProducer = NewFakePushProducer()
+		w.Producer = mockPushProducer
 		processBatchWorker = worker.NewProcessBatchWorker(w)
 		w.RedisClient.FlushAll()
 		templateName1 := "village-like"
@@ -171,7 +172,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 			processBatchWorker.Process(message)
 
 			for idx := range users {
-				m := mockKafkaProducer.GCMMessages[idx]
+				m := mockPushProducer.GCMMessages[idx]
 				var gcmMessage messages.GCMMessage
 				err = json.Unmarshal([]byte(m), &gcmMessage)
 				Expect(err).NotTo(HaveOccurred())
@@ -184,6 +185,118 @@ var _ = Describe("ProcessBatch Worker", func() {
 			}
 		})
 
+		It("should send FCM v1 messages when the app message format is v1", func() {
+			_, err := w.MarathonDB.Model(&model.App{}).Set("message_format = ?", "v1").Where("id = ?", app.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			appName := strings.Split(app.BundleID, ".")[2]
+
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				gcmJob.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			for idx := range users {
+				m := mockPushProducer.GCMMessages[idx]
+				var fcmMessage messages.FCMMessage
+				err = json.Unmarshal([]byte(m), &fcmMessage)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fcmMessage.Message.Token).To(Equal(users[idx].Token))
+				Expect(fcmMessage.Message.Data["alert"]).To(Equal("Everyone just liked your village!"))
+				Expect(fcmMessage.Message.Data["templateName"]).To(Equal(gcmJob.TemplateName))
+				Expect(fcmMessage.ValidateOnly).To(BeFalse())
+			}
+		})
+
+		It("should send APNS messages with headers when the job push options are v1", func() {
+			pushOptions := &messages.PushOptions{
+				Format: messages.FormatV1,
+				APNS:   &messages.APNSOptions{Priority: 5, CollapseID: "village"},
+			}
+			_, err := w.MarathonDB.Model(&model.Job{}).Set("push_options = ?", pushOptions).Where("id = ?", job.ID).Update()
+			Expect(err).NotTo(HaveOccurred())
+			appName := strings.Split(app.BundleID, ".")[2]
+
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				job.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+			processBatchWorker.Process(message)
+
+			for idx := range users {
+				m := mockPushProducer.APNSMessages[idx]
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(m), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(apnsMessage.DeviceToken).To(Equal(users[idx].Token))
+				Expect(apnsMessage.Headers).NotTo(BeNil())
+				Expect(apnsMessage.Headers.Priority).To(Equal(5))
+				Expect(apnsMessage.Headers.CollapseID).To(Equal("village"))
+			}
+		})
+
+		It("should send messages of other channels with their builders", func() {
+			emailJob := CreateTestJob(w.MarathonDB, app.ID, template.Name, map[string]interface{}{
+				"context": context,
+				"service": "email",
+			})
+			appName := strings.Split(app.BundleID, ".")[2]
+
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				emailJob.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+			processBatchWorker.Process(message)
+
+			Expect(mockPushProducer.Messages["email"]).To(HaveLen(len(users)))
+			Expect(mockPushProducer.APNSMessages).To(BeEmpty())
+			Expect(mockPushProducer.GCMMessages).To(BeEmpty())
+			for idx := range users {
+				var emailMessage messages.EmailMessage
+				err = json.Unmarshal([]byte(mockPushProducer.Messages["email"][idx]), &emailMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(emailMessage.To).To(Equal(users[idx].Token))
+				Expect(emailMessage.Data["alert"]).To(Equal("Everyone just liked your village!"))
+			}
+		})
+
+		It("should use the topic template of the service if there is one", func() {
+			Expect(w.GetTopic("app", "email")).To(Equal("app-email-mail"))
+			Expect(w.GetTopic("app", "gcm")).To(Equal("app-gcm-c"))
+		})
+
 		It("should process when service is apns and increment job completed batches", func() {
 			_, err := w.MarathonDB.Model(&model.Job{}).Set("service = apns").Where("id = ?", job.ID).Update()
 			appName := strings.Split(app.BundleID, ".")[2]
@@ -205,7 +318,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 			processBatchWorker.Process(message)
 
 			for idx := range users {
-				m := mockKafkaProducer.APNSMessages[idx]
+				m := mockPushProducer.APNSMessages[idx]
 				var apnsMessage messages.APNSMessage
 				err = json.Unmarshal([]byte(m), &apnsMessage)
 				Expect(err).NotTo(HaveOccurred())
@@ -239,7 +352,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 			processBatchWorker.Process(message)
 
 			for idx := range users {
-				m := mockKafkaProducer.APNSMessages[idx]
+				m := mockPushProducer.APNSMessages[idx]
 				var apnsMessage messages.APNSMessage
 				err = json.Unmarshal([]byte(m), &apnsMessage)
 				Expect(err).NotTo(HaveOccurred())
@@ -494,7 +607,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 			processBatchWorker.Process(message)
 
 			for idx := range users {
-				m := mockKafkaProducer.APNSMessages[idx]
+				m := mockPushProducer.APNSMessages[idx]
 
 				var apnsMessage messages.APNSMessage
 				err = json.Unmarshal([]byte(m), &apnsMessage)
@@ -505,6 +618,146 @@ var _ = Describe("ProcessBatch Worker", func() {
 			}
 		})
 
+		It("should fall back to the language template for a regional locale", func() {
+			users[0].Locale = "pt_BR"
+			users[1].Locale = "pt-br"
+			appName := strings.Split(app.BundleID, ".")[2]
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				job.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			Expect(mockPushProducer.APNSMessages).To(HaveLen(2))
+			for idx := range users {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(mockPushProducer.APNSMessages[idx]), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(apnsMessage.Payload.Aps["alert"]).To(Equal("Everyone curtiram sua vila!"))
+			}
+		})
+
+		It("should follow the app locale fallbacks and then the app default locale", func() {
+			_, err := w.MarathonDB.Exec(
+				`UPDATE apps SET locale_fallbacks = '{"es": ["fr"]}' WHERE id = ?`,
+				app.ID,
+			)
+			Expect(err).NotTo(HaveOccurred())
+			users[0].Locale = "es"
+			users[1].Locale = "de"
+			appName := strings.Split(app.BundleID, ".")[2]
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				job.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			Expect(mockPushProducer.APNSMessages).To(HaveLen(2))
+			alerts := []interface{}{}
+			for _, m := range mockPushProducer.APNSMessages {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(m), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				alerts = append(alerts, apnsMessage.Payload.Aps["alert"])
+			}
+			Expect(alerts).To(ConsistOf("Everyone a aimé ta ville!", "Everyone just liked your village!"))
+		})
+
+		It("should count the users without a template for their locale as failed tokens and send the others", func() {
+			_, err := w.MarathonDB.Exec("UPDATE apps SET default_locale = 'xx' WHERE id = ?", app.ID)
+			Expect(err).NotTo(HaveOccurred())
+			users[0].Locale = "de"
+			users[1].Locale = "en"
+			appName := strings.Split(app.BundleID, ".")[2]
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{job.ID, appName, compressedUsers},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(func() { processBatchWorker.Process(message) }).ShouldNot(Panic())
+
+			Expect(mockPushProducer.APNSMessages).To(HaveLen(1))
+			var apnsMessage messages.APNSMessage
+			Expect(json.Unmarshal([]byte(mockPushProducer.APNSMessages[0]), &apnsMessage)).To(Succeed())
+			Expect(apnsMessage.DeviceToken).To(Equal(users[1].Token))
+			dbJob := model.Job{
+				ID: job.ID,
+			}
+			err = w.MarathonDB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.CompletedTokens).To(Equal(1))
+			Expect(dbJob.FailedTokens).To(Equal(1))
+			Expect(dbJob.Status).NotTo(Equal(model.JobStatusFailed))
+		})
+
+		It("should process the message using the template version pinned by the job", func() {
+			template.Version = 1
+			_, err := template.SaveVersion(w.MarathonDB)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w.MarathonDB.Exec(
+				`UPDATE templates SET version = 2, body = '{"alert": "{{user_name}} just updated the template!"}' WHERE id = ?`,
+				template.ID,
+			)
+			Expect(err).NotTo(HaveOccurred())
+			pinnedJob := CreateTestJob(w.MarathonDB, app.ID, template.Name, map[string]interface{}{
+				"context":          context,
+				"templateVersions": model.TemplateVersions{template.Name: {"en": 1}},
+			})
+
+			appName := strings.Split(app.BundleID, ".")[2]
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				pinnedJob.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			Expect(mockPushProducer.APNSMessages).To(HaveLen(len(users)))
+			for _, m := range mockPushProducer.APNSMessages {
+				var apnsMessage messages.APNSMessage
+				err = json.Unmarshal([]byte(m), &apnsMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(apnsMessage.Payload.Aps["alert"]).To(Equal("Everyone just liked your village!"))
+			}
+		})
+
 		It("should process the message and put the right pushMetadata on it if apns push", func() {
 			userID := uuid.NewV4().String()
 			token := strings.Replace(uuid.NewV4().String(), "-", "", -1)
@@ -543,7 +796,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 				// "vendorId":       user.VendorID,
 			}
 
-			m := mockKafkaProducer.APNSMessages[0]
+			m := mockPushProducer.APNSMessages[0]
 			var apnsMessage messages.APNSMessage
 			err = json.Unmarshal([]byte(m), &apnsMessage)
 
@@ -588,7 +841,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 				// "tokenCreatedAt": createdAt.Unix(),
 			}
 
-			m := mockKafkaProducer.GCMMessages[0]
+			m := mockPushProducer.GCMMessages[0]
 			var gcmMessage messages.GCMMessage
 			err = json.Unmarshal([]byte(m), &gcmMessage)
 			Expect(err).NotTo(HaveOccurred())
@@ -597,6 +850,73 @@ var _ = Describe("ProcessBatch Worker", func() {
 			}
 		})
 
+		It("should render the user id, region and tz of the users with the rich engine", func() {
+			CreateTestTemplate(w.MarathonDB, app.ID, map[string]interface{}{
+				"engine": "rich",
+				"body": map[string]interface{}{
+					"alert": "{{ .user.userId }} {{ .user.region }} {{ .user.tz }}",
+				},
+				"locale": "en",
+				"name":   "rich-user",
+			})
+			richJob := CreateTestJob(w.MarathonDB, app.ID, "rich-user")
+			user := worker.User{
+				UserID: uuid.NewV4().String(),
+				Token:  strings.Replace(uuid.NewV4().String(), "-", "", -1),
+				Locale: "en",
+				Region: "BR",
+				Tz:     "-0300",
+			}
+			appName := strings.Split(app.BundleID, ".")[2]
+			compressedUsers, err := worker.CompressUsers(&[]worker.User{user})
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				richJob.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			Expect(mockPushProducer.APNSMessages).To(HaveLen(1))
+			var apnsMessage messages.APNSMessage
+			err = json.Unmarshal([]byte(mockPushProducer.APNSMessages[0]), &apnsMessage)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apnsMessage.Payload.Aps["alert"]).To(Equal(fmt.Sprintf("%s BR -0300", user.UserID)))
+		})
+
+		It("should key the kafka messages of the batch users by their user id", func() {
+			appName := strings.Split(app.BundleID, ".")[2]
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			messageObj := []interface{}{
+				job.ID,
+				appName,
+				compressedUsers,
+			}
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": messageObj,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			processBatchWorker.Process(message)
+
+			Expect(mockPushProducer.Pushes).To(HaveLen(len(users)))
+			for idx, push := range mockPushProducer.Pushes {
+				Expect(extensions.KafkaMessageKey(extensions.MessageKeyUserID, push)).To(Equal(users[idx].UserID))
+			}
+		})
+
 		It("should increment failedJobs", func() {
 			// unexistent template
 			w.MarathonDB.Exec("DELETE FROM templates;")
@@ -634,7 +954,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 			err = w.MarathonDB.Select(&dbJob)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(dbJob.CompletedBatches).To(Equal(0))
-			Expect(dbJob.Status).To(Equal(""))
+			Expect(dbJob.Status).To(Equal(model.JobStatusRunning))
 		})
 
 		It("should increment failedJobs and mark job status as circuitbreak", func() {
@@ -686,7 +1006,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 			Expect(ttl2).To(BeNumerically("~", time.Minute, 10))
 		})
 
-		It("should re-schedule job if error getting the job", func() {
+		It("should move the message to the dead letter queue if the job does not exist", func() {
 			// unexistent job
 			w.MarathonDB.Exec("DELETE FROM jobs;")
 			appName := strings.Split(app.BundleID, ".")[2]
@@ -707,22 +1027,60 @@ var _ = Describe("ProcessBatch Worker", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(func() { processBatchWorker.Process(message) }).Should(Panic())
+			Expect(func() {
+				w.Errors.Call("process_batch_worker", message, func() bool {
+					processBatchWorker.Process(message)
+					return true
+				})
+			}).ShouldNot(Panic())
 
-			res, err := w.RedisClient.ZCard("schedule").Result()
+			deadLetters, err := w.RedisClient.LRange("marathon:deadletter:process_batch_worker", 0, -1).Result()
 			Expect(err).NotTo(HaveOccurred())
-			Expect(res).To(BeEquivalentTo(1))
-			var data workers.EnqueueData
-			jobs, err := w.RedisClient.ZRange("schedule", 0, -1).Result()
-			bytes, err := RedisReplyToBytes(jobs[0], err)
+			Expect(deadLetters).To(HaveLen(1))
+			var deadLetter map[string]interface{}
+			Expect(json.Unmarshal([]byte(deadLetters[0]), &deadLetter)).To(Succeed())
+			Expect(deadLetter["queue"]).To(Equal("process_batch_worker"))
+			Expect(deadLetter["error"]).To(Equal(fmt.Sprintf("job %s does not exist", job.ID.String())))
+			Expect(deadLetter["message"].(map[string]interface{})["args"]).To(HaveLen(3))
+			Expect(w.RedisClient.ZCard("schedule").Val()).To(BeEquivalentTo(0))
+		})
+
+		It("should retry the message if error getting the templates", func() {
+			// unexistent template
+			w.MarathonDB.Exec("DELETE FROM templates;")
+			_, err := w.MarathonDB.Model(&model.Job{}).Set("total_batches = 100").Where("id = ?", job.ID).Update()
 			Expect(err).NotTo(HaveOccurred())
-			json.Unmarshal(bytes, &data)
-			at := time.Unix(0, int64(data.At*workers.NanoSecondPrecision))
-			Expect(at.Unix()).To(BeNumerically(">", time.Now().Unix()))
-			Expect(at.Unix()).To(BeNumerically("<", time.Now().Add(100*time.Second).Unix()))
-			Expect(data.Queue).To(Equal("process_batch_worker"))
+			appName := strings.Split(app.BundleID, ".")[2]
+
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
+			msgB, err := json.Marshal(map[string]interface{}{
+				"args":        []interface{}{job.ID, appName, compressedUsers},
+				"retry":       true,
+				"retry_count": 5,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(func() {
+				w.Errors.Call("process_batch_worker", message, func() bool {
+					processBatchWorker.Process(message)
+					return true
+				})
+			}).Should(Panic())
+
+			dbJob := model.Job{
+				ID: job.ID,
+			}
+			err = w.MarathonDB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.Status).To(Equal(model.JobStatusRunning))
+			Expect(w.RedisClient.ZCard("schedule").Val()).To(BeEquivalentTo(0))
 		})
 
-		It("should re-schedule job if error getting the templates", func() {
+		It("should fail the job if the message has no retries left", func() {
 			// unexistent template
 			w.MarathonDB.Exec("DELETE FROM templates;")
 			_, err := w.MarathonDB.Model(&model.Job{}).Set("total_batches = 100").Where("id = ?", job.ID).Update()
@@ -731,33 +1089,95 @@ var _ = Describe("ProcessBatch Worker", func() {
 
 			compressedUsers, err := worker.CompressUsers(&users)
 			Expect(err).NotTo(HaveOccurred())
-			messageObj := []interface{}{
-				job.ID,
-				appName,
-				compressedUsers,
+			msgB, err := json.Marshal(map[string]interface{}{
+				"args":        []interface{}{job.ID, appName, compressedUsers},
+				"retry":       true,
+				"retry_count": 25,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(func() {
+				w.Errors.Call("process_batch_worker", message, func() bool {
+					processBatchWorker.Process(message)
+					return true
+				})
+			}).ShouldNot(Panic())
+
+			dbJob := model.Job{
+				ID: job.ID,
 			}
+			err = w.MarathonDB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.Status).To(Equal(model.JobStatusFailed))
+		})
+
+		It("should resume from the checkpoint when the message is retried", func() {
+			appName := strings.Split(app.BundleID, ".")[2]
+
+			compressedUsers, err := worker.CompressUsers(&users)
+			Expect(err).NotTo(HaveOccurred())
 			msgB, err := json.Marshal(map[string][]interface{}{
-				"args": messageObj,
+				"args": []interface{}{gcmJob.ID, appName, compressedUsers},
 			})
 			Expect(err).NotTo(HaveOccurred())
 
 			message, err := workers.NewMsg(string(msgB))
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(func() { processBatchWorker.Process(message) }).Should(Panic())
+			// the first push was sent before the previous attempt failed
+			checkpoint, err := w.GetCheckpoint(gcmJob.ID, message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkpoint.Advance(false)).To(Succeed())
 
-			res, err := w.RedisClient.ZCard("schedule").Result()
+			processBatchWorker.Process(message)
+			Expect(mockPushProducer.GCMMessages).To(HaveLen(1))
+			var gcmMessage messages.GCMMessage
+			Expect(json.Unmarshal([]byte(mockPushProducer.GCMMessages[0]), &gcmMessage)).To(Succeed())
+			Expect(gcmMessage.To).To(Equal(users[1].Token))
+
+			// a retry after the batch was counted sends nothing and keeps the counters
+			processBatchWorker.Process(message)
+			Expect(mockPushProducer.GCMMessages).To(HaveLen(1))
+			dbJob := model.Job{
+				ID: gcmJob.ID,
+			}
+			err = w.MarathonDB.Select(&dbJob)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(res).To(BeEquivalentTo(1))
-			var data workers.EnqueueData
-			jobs, err := w.RedisClient.ZRange("schedule", 0, -1).Result()
-			bytes, err := RedisReplyToBytes(jobs[0], err)
+			Expect(dbJob.CompletedBatches).To(Equal(1))
+			Expect(dbJob.CompletedTokens).To(Equal(2))
+		})
+
+		It("should not count the batch again when the message is retried before its checkpoint is counted", func() {
+			appName := strings.Split(app.BundleID, ".")[2]
+
+			compressedUsers, err := worker.CompressUsers(&users)
 			Expect(err).NotTo(HaveOccurred())
-			json.Unmarshal(bytes, &data)
-			at := time.Unix(0, int64(data.At*workers.NanoSecondPrecision))
-			Expect(at.Unix()).To(BeNumerically(">", time.Now().Unix()))
-			Expect(at.Unix()).To(BeNumerically("<", time.Now().Add(100*time.Second).Unix()))
-			Expect(data.Queue).To(Equal("process_batch_worker"))
+			msgB, err := json.Marshal(map[string][]interface{}{
+				"args": []interface{}{gcmJob.ID, appName, compressedUsers},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			message, err := workers.NewMsg(string(msgB))
+			Expect(err).NotTo(HaveOccurred())
+			processBatchWorker.Process(message)
+
+			// the previous attempt updated the job counters and failed before marking the checkpoint
+			checkpoint, err := w.GetCheckpoint(gcmJob.ID, message)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.RedisClient.HSet(checkpoint.Key, "counted", "0").Err()).To(Succeed())
+
+			processBatchWorker.Process(message)
+			Expect(mockPushProducer.GCMMessages).To(HaveLen(2))
+			dbJob := model.Job{
+				ID: gcmJob.ID,
+			}
+			err = w.MarathonDB.Select(&dbJob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dbJob.CompletedBatches).To(Equal(1))
+			Expect(dbJob.CompletedTokens).To(Equal(2))
 		})
 
 		It("should not process job and add it to paused jobs list if job is paused", func() {
@@ -876,7 +1296,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 				"dryRun":       true,
 			}
 
-			m := mockKafkaProducer.APNSMessages[0]
+			m := mockPushProducer.APNSMessages[0]
 			var apnsMessage messages.APNSMessage
 			err = json.Unmarshal([]byte(m), &apnsMessage)
 
@@ -934,7 +1354,7 @@ var _ = Describe("ProcessBatch Worker", func() {
 				"dryRun":       true,
 			}
 
-			m := mockKafkaProducer.GCMMessages[0]
+			m := mockPushProducer.GCMMessages[0]
 			var gcmMessage messages.GCMMessage
 			err = json.Unmarshal([]byte(m), &gcmMessage)
 
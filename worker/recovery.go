@@ -0,0 +1,437 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jrallison/go-workers"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+)
+
+// recoveryLockKey is held by the worker process running the recovery, so only one runs it at a time
+const recoveryLockKey = "marathon:workers:recovery"
+
+// workersProcessKey returns the key that is set while the worker process is alive
+func workersProcessKey(processID string) string {
+	return fmt.Sprintf("marathon:workers:process:%s", processID)
+}
+
+// parseInProgressQueue returns the queue and the process of a go-workers in progress queue key,
+// these keys are named queue:<queue>:<process>:inprogress
+func parseInProgressQueue(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, "queue:") || !strings.HasSuffix(key, ":inprogress") {
+		return "", "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(key, "queue:"), ":inprogress")
+	i := strings.LastIndex(name, ":")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// startBackgroundTasks starts the heartbeat of this worker process and the periodic recovery of stuck jobs
+func (w *Worker) startBackgroundTasks() {
+	w.stop = make(chan struct{})
+	go w.every(w.Config.GetDuration("workers.recovery.heartbeat"), func() {
+		err := w.Heartbeat()
+		if err != nil {
+			log.E(w.Logger, "Failed to write worker heartbeat.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+	})
+	go w.every(w.Config.GetDuration("workers.recovery.interval"), func() {
+		_, err := w.RecoverStuckJobs()
+		if err != nil {
+			log.E(w.Logger, "Failed to recover stuck jobs.", func(cm log.CM) {
+				cm.Write(zap.Error(err))
+			})
+		}
+	})
+}
+
+// stopBackgroundTasks stops the background tasks and removes the heartbeat of this worker process
+func (w *Worker) stopBackgroundTasks() {
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	w.stop = nil
+	w.RedisClient.Del(workersProcessKey(w.ProcessID))
+}
+
+// every runs f now and then once every interval until the background tasks are stopped
+func (w *Worker) every(interval time.Duration, f func()) {
+	stop := w.stop
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Heartbeat marks this worker process as alive for three workers.recovery.heartbeat intervals,
+// the recovery only takes the messages in progress of the processes that are not alive
+func (w *Worker) Heartbeat() error {
+	interval := w.Config.GetDuration("workers.recovery.heartbeat")
+	return w.RedisClient.Set(workersProcessKey(w.ProcessID), time.Now().UnixNano(), 3*interval).Err()
+}
+
+// GetStuckJobs returns the running jobs that did not progress for workers.recovery.stuckAfter,
+// the progress of a job is the last update of the job or of any of its stages
+func (w *Worker) GetStuckJobs() ([]*model.Job, error) {
+	now := time.Now()
+	threshold := now.Add(-w.Config.GetDuration("workers.recovery.stuckAfter")).UnixNano()
+	jobs := []*model.Job{}
+	err := w.MarathonDB.Model(&jobs).Where(
+		"status = ? AND COALESCE(completed_at, 0) = 0 AND updated_at < ? AND (COALESCE(expires_at, 0) = 0 OR expires_at > ?)",
+		model.JobStatusRunning, threshold, now.UnixNano(),
+	).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	stuck := []*model.Job{}
+	for _, job := range jobs {
+		stages, err := GetJobStagesProgress(w.RedisClient, job.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		progressedAt := job.UpdatedAt
+		for _, stage := range stages {
+			if stage.UpdatedAt > progressedAt {
+				progressedAt = stage.UpdatedAt
+			}
+		}
+		if progressedAt < threshold {
+			stuck = append(stuck, job)
+		}
+	}
+	return stuck, nil
+}
+
+// inProgressQueues returns the go-workers in progress queue keys of every worker process
+func (w *Worker) inProgressQueues() ([]string, error) {
+	keys := []string{}
+	var cursor uint64
+	for {
+		page, next, err := w.RedisClient.Scan(cursor, "queue:*:inprogress", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// queuedMessage is the part of a go-workers message the recovery reads
+type queuedMessage struct {
+	Queue string          `json:"queue"`
+	Args  json.RawMessage `json:"args"`
+}
+
+// messageJobID returns the id of the job of a go-workers message: the first of its args, the args
+// themselves when they are the id or the job of the create batches and direct messages
+func messageJobID(message string) string {
+	var msg queuedMessage
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		return ""
+	}
+	var id string
+	if err := json.Unmarshal(msg.Args, &id); err == nil {
+		return id
+	}
+	var args []interface{}
+	if err := json.Unmarshal(msg.Args, &args); err == nil {
+		if len(args) > 0 {
+			id, _ = args[0].(string)
+		}
+		return id
+	}
+	var part struct {
+		Job     model.Job
+		JobUUID uuid.UUID
+	}
+	if err := json.Unmarshal(msg.Args, &part); err != nil {
+		return ""
+	}
+	if part.JobUUID != uuid.Nil {
+		return part.JobUUID.String()
+	}
+	if part.Job.ID != uuid.Nil {
+		return part.Job.ID.String()
+	}
+	return ""
+}
+
+// messagePart returns the job and the csv part of a create batches message or the smallest seq id of
+// the page of a direct message
+func messagePart(message string) (string, int64, bool) {
+	var msg queuedMessage
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		return "", 0, false
+	}
+	switch msg.Queue {
+	case nameCreateBatches:
+		var part BatchPart
+		if err := json.Unmarshal(msg.Args, &part); err != nil || part.Job.ID == uuid.Nil {
+			return "", 0, false
+		}
+		return part.Job.ID.String(), int64(part.Part), true
+	case nameDirectWorker:
+		var page DirectPartMsg
+		if err := json.Unmarshal(msg.Args, &page); err != nil || page.JobUUID == uuid.Nil {
+			return "", 0, false
+		}
+		return page.JobUUID.String(), int64(page.SmallestSeqID), true
+	}
+	return "", 0, false
+}
+
+// pendingParts returns by job the csv parts and the push db pages that are waiting in the queues, the
+// retries or the scheduled messages, or that are in progress in the alive worker processes
+func (w *Worker) pendingParts(inProgress []string) (map[string]map[int64]bool, error) {
+	messages := []string{}
+	lists := []string{"queue:" + nameCreateBatches, "queue:" + nameDirectWorker}
+	for _, key := range inProgress {
+		queue, _, ok := parseInProgressQueue(key)
+		if ok && (queue == nameCreateBatches || queue == nameDirectWorker) {
+			lists = append(lists, key)
+		}
+	}
+	for _, key := range lists {
+		list, err := w.RedisClient.LRange(key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, list...)
+	}
+	for _, key := range []string{"retry", "schedule"} {
+		set, err := w.RedisClient.ZRange(key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, set...)
+	}
+
+	pending := map[string]map[int64]bool{}
+	for _, message := range messages {
+		jobID, part, ok := messagePart(message)
+		if !ok {
+			continue
+		}
+		if pending[jobID] == nil {
+			pending[jobID] = map[int64]bool{}
+		}
+		pending[jobID][part] = true
+	}
+	return pending, nil
+}
+
+// recoverMissingParts enqueues the csv parts or the push db pages of the job that were not completed
+// and are not pending, the messages of its parts may have been lost or acked without finishing them.
+// It returns the number of parts enqueued again
+func (w *Worker) recoverMissingParts(job *model.Job, pending map[int64]bool) (int, error) {
+	if job.CSVPath != "" {
+		completed, err := w.RedisClient.SMembers(completedPartsKey(job.ID)).Result()
+		if err != nil {
+			return 0, err
+		}
+		done := map[string]bool{}
+		for _, part := range completed {
+			done[part] = true
+		}
+		parts, err := w.csvParts(job)
+		if err != nil {
+			return 0, err
+		}
+		enqueued := 0
+		for _, part := range parts {
+			if done[strconv.Itoa(part.Part)] || pending[int64(part.Part)] {
+				continue
+			}
+			if _, err := w.CreateBatchesJob(part); err != nil {
+				return enqueued, err
+			}
+			enqueued++
+		}
+		return enqueued, nil
+	}
+
+	layout, err := w.RedisClient.HGetAll(directPagesKey(job.ID)).Result()
+	if err != nil || len(layout) == 0 {
+		return 0, err
+	}
+	size, err := strconv.ParseUint(layout["size"], 10, 64)
+	if err != nil || size == 0 {
+		return 0, err
+	}
+	maxSeqID, err := strconv.ParseUint(layout["maxSeqID"], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	completed, err := w.RedisClient.SMembers(completedPagesKey(job.ID)).Result()
+	if err != nil {
+		return 0, err
+	}
+	done := map[string]bool{}
+	for _, page := range completed {
+		done[page] = true
+	}
+	maxRetries := w.Config.GetInt("workers.direct.maxRetries")
+	enqueued := 0
+	for _, page := range directPages(job.ID, size, maxSeqID) {
+		if done[strconv.FormatUint(page.SmallestSeqID, 10)] || pending[int64(page.SmallestSeqID)] {
+			continue
+		}
+		_, err := workers.EnqueueWithOptions(nameDirectWorker, "Add", page, workers.EnqueueOptions{
+			Retry:      true,
+			RetryCount: maxRetries,
+		})
+		if err != nil {
+			return enqueued, err
+		}
+		enqueued++
+	}
+	return enqueued, nil
+}
+
+// RecoverStuckJobs enqueues again the messages of the stuck jobs that were left in the in progress
+// queues of worker processes that are not alive anymore, like the ones killed by a deploy before their
+// shutdown finished, and the csv parts or push db pages of the stuck jobs that were not completed and
+// are not pending anymore. The parts of a stuck job that were in progress are sent again, so some of
+// their messages may be delivered twice. It returns the number of messages enqueued again
+func (w *Worker) RecoverStuckJobs() (int, error) {
+	l := w.Logger.With(
+		zap.String("source", "worker"),
+		zap.String("operation", "recoverStuckJobs"),
+	)
+	interval := w.Config.GetDuration("workers.recovery.interval")
+	locked, err := w.RedisClient.SetNX(recoveryLockKey, w.ProcessID, interval).Result()
+	if err != nil || !locked {
+		return 0, err
+	}
+
+	jobs, err := w.GetStuckJobs()
+	if err != nil || len(jobs) == 0 {
+		return 0, err
+	}
+	stuck := map[string]bool{}
+	for _, job := range jobs {
+		stuck[job.ID.String()] = true
+	}
+	keys, err := w.inProgressQueues()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := map[string]int{}
+	total := 0
+	alive := []string{}
+	for _, key := range keys {
+		queue, process, ok := parseInProgressQueue(key)
+		if !ok || process == w.ProcessID {
+			alive = append(alive, key)
+			continue
+		}
+		isAlive, err := w.RedisClient.Exists(workersProcessKey(process)).Result()
+		if err != nil {
+			return total, err
+		}
+		if isAlive {
+			alive = append(alive, key)
+			continue
+		}
+		messages, err := w.RedisClient.LRange(key, 0, -1).Result()
+		if err != nil {
+			return total, err
+		}
+		for _, message := range messages {
+			jobID := messageJobID(message)
+			if !stuck[jobID] {
+				continue
+			}
+			removed, err := w.RedisClient.LRem(key, 1, message).Result()
+			if err != nil {
+				return total, err
+			}
+			if removed == 0 {
+				continue
+			}
+			err = w.RedisClient.LPush(fmt.Sprintf("queue:%s", queue), message).Err()
+			if err != nil {
+				return total, err
+			}
+			recovered[jobID]++
+			total++
+		}
+	}
+
+	pending, err := w.pendingParts(alive)
+	if err != nil {
+		return total, err
+	}
+	for _, job := range jobs {
+		enqueued, err := w.recoverMissingParts(job, pending[job.ID.String()])
+		if err != nil {
+			log.E(l, "Failed to recover the missing parts of stuck job.", func(cm log.CM) {
+				cm.Write(zap.String("jobID", job.ID.String()), zap.Error(err))
+			})
+		}
+		recovered[job.ID.String()] += enqueued
+		total += enqueued
+	}
+
+	for _, job := range jobs {
+		count := recovered[job.ID.String()]
+		if count == 0 {
+			log.W(l, "Stuck job has no messages to recover.", func(cm log.CM) {
+				cm.Write(zap.String("jobID", job.ID.String()))
+			})
+			w.Statsd.Incr("stuck_job", job.Labels(), 1)
+			continue
+		}
+		log.I(l, "Recovered stuck job.", func(cm log.CM) {
+			cm.Write(zap.String("jobID", job.ID.String()), zap.Int("messages", count))
+		})
+		w.Statsd.Count("recovered_job_messages", int64(count), job.Labels(), 1)
+	}
+	return total, nil
+}
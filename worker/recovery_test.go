@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Recovery", func() {
+	var app *model.App
+	var template *model.Template
+	var job *model.Job
+	var otherJob *model.Job
+
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()),
+		zap.FatalLevel,
+	)
+	w := worker.NewWorker(logger, GetConfPath())
+	deadQueue := "queue:process_batch_worker:dead-host:inprogress"
+	aliveQueue := "queue:process_batch_worker:alive-host:inprogress"
+
+	setRunning := func(j *model.Job, updatedAt time.Time) {
+		_, err := w.MarathonDB.Exec(
+			"UPDATE jobs SET status = ?, updated_at = ? WHERE id = ?",
+			model.JobStatusRunning, updatedAt.UnixNano(), j.ID,
+		)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		w.RedisClient.FlushAll()
+		app = CreateTestApp(w.MarathonDB)
+		template = CreateTestTemplate(w.MarathonDB, app.ID)
+		job = CreateTestJob(w.MarathonDB, app.ID, template.Name)
+		otherJob = CreateTestJob(w.MarathonDB, app.ID, template.Name)
+		setRunning(job, time.Now().Add(-time.Hour))
+		setRunning(otherJob, time.Now())
+	})
+
+	Describe("GetStuckJobs", func() {
+		It("should return the running jobs without recent progress", func() {
+			jobs, err := w.GetStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			ids := []string{}
+			for _, j := range jobs {
+				ids = append(ids, j.ID.String())
+			}
+			Expect(ids).To(ContainElement(job.ID.String()))
+			Expect(ids).NotTo(ContainElement(otherJob.ID.String()))
+		})
+
+		It("should not return jobs whose stages progressed recently", func() {
+			s, err := worker.NewStageStatus(w.RedisClient, job.ID.String(), worker.StageSendMessages, "send messages", 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.IncrProgress()).To(Succeed())
+
+			jobs, err := w.GetStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			for _, j := range jobs {
+				Expect(j.ID).NotTo(Equal(job.ID))
+			}
+		})
+	})
+
+	Describe("RecoverStuckJobs", func() {
+		It("should enqueue again the messages of stuck jobs left by dead processes", func() {
+			stuckMessage := fmt.Sprintf(`{"jid":"1","args":["%s","app"]}`, job.ID)
+			otherMessage := fmt.Sprintf(`{"jid":"2","args":["%s","app"]}`, otherJob.ID)
+			aliveMessage := fmt.Sprintf(`{"jid":"3","args":["%s","app"]}`, job.ID)
+			w.RedisClient.LPush(deadQueue, stuckMessage, otherMessage)
+			w.RedisClient.LPush(aliveQueue, aliveMessage)
+			w.RedisClient.Set("marathon:workers:process:alive-host", 1, time.Minute)
+
+			recovered, err := w.RecoverStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered).To(Equal(1))
+
+			queued, err := w.RedisClient.LRange("queue:process_batch_worker", 0, -1).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queued).To(Equal([]string{stuckMessage}))
+			Expect(w.RedisClient.LRange(deadQueue, 0, -1).Val()).To(Equal([]string{otherMessage}))
+			Expect(w.RedisClient.LRange(aliveQueue, 0, -1).Val()).To(Equal([]string{aliveMessage}))
+		})
+
+		It("should match the messages by the job in their first argument", func() {
+			otherMessage := fmt.Sprintf(`{"jid":"1","args":["%s","app",["%s"]]}`, otherJob.ID, job.ID)
+			w.RedisClient.LPush(deadQueue, otherMessage)
+
+			recovered, err := w.RecoverStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered).To(Equal(0))
+			Expect(w.RedisClient.LRange(deadQueue, 0, -1).Val()).To(Equal([]string{otherMessage}))
+		})
+
+		It("should enqueue the push db pages that were not sent and are not pending", func() {
+			w.RedisClient.HMSet(fmt.Sprintf("%s-directpages", job.ID), map[string]string{"size": "10", "maxSeqID": "29"})
+			w.RedisClient.SAdd(fmt.Sprintf("%s-completedpages", job.ID), 0)
+			pendingPage := fmt.Sprintf(
+				`{"jid":"1","queue":"direct_worker","args":{"SmallestSeqID":10,"BiggestSeqID":20,"JobUUID":"%s"}}`,
+				job.ID,
+			)
+			w.RedisClient.LPush("queue:direct_worker", pendingPage)
+
+			recovered, err := w.RecoverStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered).To(Equal(1))
+
+			queued, err := w.RedisClient.LRange("queue:direct_worker", 0, -1).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queued).To(HaveLen(2))
+			var page map[string]interface{}
+			Expect(json.Unmarshal([]byte(queued[0]), &page)).To(Succeed())
+			Expect(page["args"]).To(Equal(map[string]interface{}{
+				"SmallestSeqID": float64(20),
+				"BiggestSeqID":  float64(30),
+				"JobUUID":       job.ID.String(),
+			}))
+		})
+
+		It("should enqueue the csv parts that were not completed and are not pending", func() {
+			fakeS3 := NewFakeS3(w.Config)
+			w.S3Client = fakeS3
+			data := []byte("userids\n9e558649-9c23-469d-a11c-59b05813e3d5")
+			fakeS3.PutObject("test/jobs/recovery.csv", &data)
+			csvJob := CreateTestJob(w.MarathonDB, app.ID, template.Name, map[string]interface{}{
+				"csvPath": "test/jobs/recovery.csv",
+			})
+			setRunning(csvJob, time.Now().Add(-time.Hour))
+
+			recovered, err := w.RecoverStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered).To(Equal(1))
+
+			queued, err := w.RedisClient.LRange("queue:create_batches_worker", 0, -1).Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queued).To(HaveLen(1))
+			var part map[string]interface{}
+			Expect(json.Unmarshal([]byte(queued[0]), &part)).To(Succeed())
+			args := part["args"].(map[string]interface{})
+			Expect(args["Part"]).To(Equal(float64(0)))
+			Expect(args["Job"].(map[string]interface{})["id"]).To(Equal(csvJob.ID.String()))
+		})
+
+		It("should run in one worker process at a time", func() {
+			w.RedisClient.LPush(deadQueue, fmt.Sprintf(`{"jid":"1","args":["%s","app"]}`, job.ID))
+			w.RedisClient.Set("marathon:workers:recovery", "another-host", time.Minute)
+
+			recovered, err := w.RecoverStuckJobs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recovered).To(Equal(0))
+			Expect(w.RedisClient.LLen(deadQueue).Val()).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("Heartbeat", func() {
+		It("should mark the worker process as alive", func() {
+			Expect(w.Heartbeat()).To(Succeed())
+			key := fmt.Sprintf("marathon:workers:process:%s", w.ProcessID)
+			Expect(w.RedisClient.Exists(key).Val()).To(BeTrue())
+			Expect(w.RedisClient.TTL(key).Val()).To(BeNumerically(">", 0))
+		})
+	})
+})
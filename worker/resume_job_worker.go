@@ -30,6 +30,7 @@ import (
 	"github.com/jrallison/go-workers"
 	"github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
 )
 
@@ -52,10 +53,10 @@ func NewResumeJobWorker(workers *Worker) *ResumeJobWorker {
 // Process processes the messages sent to worker queue
 func (b *ResumeJobWorker) Process(message *workers.Msg) {
 	arr, err := message.Args().Array()
-	checkErr(b.Logger, err)
+	checkErr(b.Logger, NewPoisonError(err))
 	jobID := arr[0]
 	id, err := uuid.FromString(jobID.(string))
-	checkErr(b.Logger, err)
+	checkErr(b.Logger, NewPoisonError(err))
 	l := b.Logger.With(
 		zap.String("jobID", id.String()),
 	)
@@ -63,7 +64,7 @@ func (b *ResumeJobWorker) Process(message *workers.Msg) {
 
 	job, err := b.Workers.GetJob(id)
 	checkErr(l, err)
-	if job.Status == stoppedJobStatus {
+	if job.Status == model.JobStatusStopped {
 		l.Info("stopped job resume_job_worker")
 		err := b.Workers.RedisClient.Del(fmt.Sprintf("%s-pausedjobs", jobID.(string))).Err()
 		if err != nil && err != redis.Nil {
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/jrallison/go-workers"
+	"github.com/topfreegames/marathon/log"
+	"github.com/uber-go/zap"
+)
+
+// InFlightMiddleware is the go-workers middleware that keeps track of the Process calls in progress,
+// the shutdown waits for them before the worker exits
+type InFlightMiddleware struct {
+	wg    sync.WaitGroup
+	count int64
+}
+
+// NewInFlightMiddleware returns a new InFlightMiddleware
+func NewInFlightMiddleware() *InFlightMiddleware {
+	return &InFlightMiddleware{}
+}
+
+// Call implements the go-workers Action interface, the call is tracked even when the worker panics
+func (m *InFlightMiddleware) Call(queue string, message *workers.Msg, next func() bool) bool {
+	m.wg.Add(1)
+	atomic.AddInt64(&m.count, 1)
+	defer func() {
+		atomic.AddInt64(&m.count, -1)
+		m.wg.Done()
+	}()
+	return next()
+}
+
+// Count returns the number of Process calls in progress
+func (m *InFlightMiddleware) Count() int64 {
+	return atomic.LoadInt64(&m.count)
+}
+
+// Wait waits for the Process calls in progress and returns false if they did not finish before the timeout
+func (m *InFlightMiddleware) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// waitForSignal blocks until the process receives SIGINT or SIGTERM
+func (w *Worker) waitForSignal() {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigchan
+	signal.Stop(sigchan)
+	log.I(w.Logger, "Received signal, shutting down workers.", func(cm log.CM) {
+		cm.Write(zap.String("signal", sig.String()))
+	})
+}
+
+// Shutdown stops fetching messages and waits up to workers.shutdownTimeout for the Process calls in progress.
+// The messages of the calls that do not finish stay in the in progress queue of this process, go-workers
+// enqueues them again when a worker with the same process id starts and RecoverStuckJobs does it for the
+// jobs that stop progressing. It returns true if every call finished
+func (w *Worker) Shutdown() bool {
+	l := w.Logger.With(
+		zap.String("source", "worker"),
+		zap.String("operation", "shutdown"),
+	)
+	timeout := w.Config.GetDuration("workers.shutdownTimeout")
+	stopped := make(chan struct{})
+	go func() {
+		workers.Quit()
+		close(stopped)
+	}()
+
+	deadline := time.Now().Add(timeout)
+	drained := w.InFlight.Wait(timeout)
+	if drained {
+		select {
+		case <-stopped:
+		case <-time.After(deadline.Sub(time.Now())):
+			drained = false
+		}
+	}
+	w.stopBackgroundTasks()
+	if !drained {
+		log.W(l, "Shutdown timed out, the messages in progress will be recovered.", func(cm log.CM) {
+			cm.Write(zap.Int64("inFlight", w.InFlight.Count()), zap.Duration("timeout", timeout))
+		})
+		return false
+	}
+
+	// every Process call finished, so no message is sent to the producer anymore
+	if w.Producer != nil {
+		w.Producer.Close()
+	}
+	// the producer returned every delivery result, so the cached failures are complete
+	if w.DeliveryReporter != nil {
+		w.DeliveryReporter.Stop()
+	}
+	log.I(l, "Workers shut down gracefully.")
+	return true
+}
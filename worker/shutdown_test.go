@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/worker"
+)
+
+var _ = Describe("Shutdown", func() {
+	Describe("InFlightMiddleware", func() {
+		It("should wait for the calls in progress", func() {
+			m := worker.NewInFlightMiddleware()
+			release := make(chan struct{})
+			finished := make(chan bool)
+			go func() {
+				finished <- m.Call("process_batch_worker", nil, func() bool {
+					<-release
+					return true
+				})
+			}()
+
+			Eventually(m.Count).Should(BeEquivalentTo(1))
+			Expect(m.Wait(10 * time.Millisecond)).To(BeFalse())
+
+			close(release)
+			Expect(m.Wait(time.Second)).To(BeTrue())
+			Expect(<-finished).To(BeTrue())
+			Expect(m.Count()).To(BeEquivalentTo(0))
+		})
+
+		It("should stop tracking calls that panic", func() {
+			m := worker.NewInFlightMiddleware()
+			Expect(func() {
+				m.Call("csv_split_worker", nil, func() bool {
+					panic("worker panic")
+				})
+			}).To(Panic())
+			Expect(m.Count()).To(BeEquivalentTo(0))
+			Expect(m.Wait(10 * time.Millisecond)).To(BeTrue())
+		})
+	})
+})
@@ -3,10 +3,24 @@ package worker
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
 	redis "gopkg.in/redis.v5"
 )
 
+// Stages of the job pipelines, CSV jobs are split in parts, each part is split in batches
+// and the batches are sent, direct jobs only send the batches read from the push db
+const (
+	StageSplitCSV           = "1"
+	StageCreateBatches      = "2"
+	StageSendMessages       = "3"
+	StageDirectSendMessages = "1"
+)
+
 // StageStatus holds information about a stage from a worker pipeline in Redis
 type StageStatus struct {
 	JobID       string
@@ -18,6 +32,9 @@ type StageStatus struct {
 	MaxProgress     int
 	CurrentProgress int
 	Completed       bool
+	StartedAt       int64
+	UpdatedAt       int64
+	CompletedAt     int64
 
 	SubStageStatus []*StageStatus
 }
@@ -31,6 +48,7 @@ func NewStageStatus(client *redis.Client,
 		return nil, errors.New("can't create a stage with 0 maxProgress")
 	}
 
+	now := time.Now().UnixNano()
 	ss := &StageStatus{
 		JobID:       jobID,
 		Stage:       stage,
@@ -41,6 +59,8 @@ func NewStageStatus(client *redis.Client,
 		MaxProgress:     maxProgress,
 		CurrentProgress: 0,
 		Completed:       false,
+		StartedAt:       now,
+		UpdatedAt:       now,
 
 		SubStageStatus: make([]*StageStatus, 0),
 	}
@@ -49,7 +69,55 @@ func NewStageStatus(client *redis.Client,
 	ss.Client.HSet(ss.JobID, ss.Stage, ss.StageKey)
 	ss.Client.HSet(ss.StageKey, "max", maxProgress)
 	ss.Client.HSet(ss.StageKey, "current", 0)
+	ss.Client.HSet(ss.StageKey, "startedAt", now)
+	ss.Client.HSet(ss.StageKey, "updatedAt", now)
+	ss.Client.HDel(ss.StageKey, "completedAt")
+	ss.publish()
+
+	return ss, nil
+}
+
+// GetStageStatus returns the stage of the job, creating it when it does not exist yet.
+// Unlike NewStageStatus it keeps the progress of an existing stage, so the workers that
+// process different parts of the same job share it
+func GetStageStatus(client *redis.Client,
+	jobID, stage, description string,
+	maxProgress int) (*StageStatus, error) {
+
+	if maxProgress == 0 {
+		return nil, errors.New("can't create a stage with 0 maxProgress")
+	}
+
+	now := time.Now().UnixNano()
+	stageKey := fmt.Sprintf("%s-%s", jobID, stage)
+	client.HSetNX(stageKey, "description", description)
+	client.HSetNX(stageKey, "max", maxProgress)
+	client.HSetNX(stageKey, "current", 0)
+	client.HSetNX(stageKey, "startedAt", now)
+	client.HSetNX(stageKey, "updatedAt", now)
+	client.HSet(jobID, stage, stageKey)
 
+	values, err := client.HGetAll(stageKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	progress := parseStageProgress(values)
+	ss := &StageStatus{
+		JobID:       jobID,
+		Stage:       stage,
+		StageKey:    stageKey,
+		Description: progress.Description,
+		Client:      client,
+
+		MaxProgress:     progress.Max,
+		CurrentProgress: progress.Current,
+		Completed:       progress.Current >= progress.Max,
+		StartedAt:       progress.StartedAt,
+		UpdatedAt:       progress.UpdatedAt,
+		CompletedAt:     progress.CompletedAt,
+
+		SubStageStatus: make([]*StageStatus, 0),
+	}
 	return ss, nil
 }
 
@@ -71,16 +139,104 @@ func (s *StageStatus) NewSubStage(
 	return ss, err
 }
 
+// GetSubStage returns the sub stage number n (starting at 1) of the stage, creating it when it
+// does not exist yet. Workers use it instead of NewSubStage when each one processes one sub stage
+func (s *StageStatus) GetSubStage(
+	n int,
+	description string,
+	maxProgress int,
+) (*StageStatus, error) {
+	return GetStageStatus(s.Client, s.JobID, fmt.Sprintf("%s.%d", s.Stage, n), description, maxProgress)
+}
+
 // IncrProgress increments the StageStatus progress by 1 unit
 func (s *StageStatus) IncrProgress() error {
+	return s.IncrProgressBy(1)
+}
+
+// IncrProgressBy increments the StageStatus progress by n units
+func (s *StageStatus) IncrProgressBy(n int) error {
 	if s.Completed {
 		return errors.New("stage is already finished")
 	}
 
-	val := s.Client.HIncrBy(s.StageKey, "current", 1).Val()
-	if val == int64(s.MaxProgress) {
-		s.Completed = true
+	val := s.Client.HIncrBy(s.StageKey, "current", int64(n)).Val()
+	s.CurrentProgress = int(val)
+	s.touch()
+	s.publish()
+
+	return nil
+}
+
+// SetProgress sets the StageStatus progress and max progress, it is used by the stages
+// whose progress is counted somewhere else, like the completed batches of the job
+func (s *StageStatus) SetProgress(current, maxProgress int) error {
+	if maxProgress == 0 {
+		return errors.New("can't set a stage with 0 maxProgress")
 	}
 
+	s.CurrentProgress = current
+	s.MaxProgress = maxProgress
+	s.Client.HSet(s.StageKey, "current", current)
+	s.Client.HSet(s.StageKey, "max", maxProgress)
+	s.touch()
+	s.publish()
+
 	return nil
 }
+
+// touch updates the time of the last progress of the stage and completes it when it reaches its max progress
+func (s *StageStatus) touch() {
+	s.UpdatedAt = time.Now().UnixNano()
+	s.Client.HSet(s.StageKey, "updatedAt", s.UpdatedAt)
+	if s.CurrentProgress >= s.MaxProgress && !s.Completed {
+		s.Completed = true
+		s.CompletedAt = s.UpdatedAt
+		s.Client.HSet(s.StageKey, "completedAt", s.CompletedAt)
+	}
+}
+
+// publish publishes the stage progress to the job progress channel
+func (s *StageStatus) publish() error {
+	return publishJobProgress(s.Client, &model.JobProgress{
+		JobID: s.JobID,
+		Stages: map[string]model.StageProgress{
+			s.Stage: {
+				Description: s.Description,
+				Current:     s.CurrentProgress,
+				Max:         s.MaxProgress,
+				StartedAt:   s.StartedAt,
+				UpdatedAt:   s.UpdatedAt,
+				CompletedAt: s.CompletedAt,
+			},
+		},
+		UpdatedAt: time.Now().UnixNano(),
+	})
+}
+
+// setJobStageProgress sets the progress of a stage of the job, errors are logged and never fail the caller
+func (w *Worker) setJobStageProgress(jobID, stage, description string, current, maxProgress int) {
+	if w.RedisClient == nil || maxProgress <= 0 {
+		return
+	}
+	ss, err := GetStageStatus(w.RedisClient, jobID, stage, description, maxProgress)
+	if err == nil {
+		err = ss.SetProgress(current, maxProgress)
+	}
+	if err != nil {
+		log.E(w.Logger, "Failed to set job stage progress.", func(cm log.CM) {
+			cm.Write(zap.String("jobID", jobID), zap.String("stage", stage), zap.Error(err))
+		})
+	}
+}
+
+// parseStageProgress returns the progress of a stage from the fields of its redis hash
+func parseStageProgress(values map[string]string) model.StageProgress {
+	progress := model.StageProgress{Description: values["description"]}
+	progress.Current, _ = strconv.Atoi(values["current"])
+	progress.Max, _ = strconv.Atoi(values["max"])
+	progress.StartedAt, _ = strconv.ParseInt(values["startedAt"], 10, 64)
+	progress.UpdatedAt, _ = strconv.ParseInt(values["updatedAt"], 10, 64)
+	progress.CompletedAt, _ = strconv.ParseInt(values["completedAt"], 10, 64)
+	return progress
+}
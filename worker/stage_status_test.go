@@ -1,12 +1,14 @@
 package worker_test
 
 import (
+	"encoding/json"
 	"strconv"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/spf13/viper"
 	"github.com/topfreegames/marathon/extensions"
+	"github.com/topfreegames/marathon/model"
 	. "github.com/topfreegames/marathon/testing"
 	"github.com/topfreegames/marathon/worker"
 	"github.com/uber-go/zap"
@@ -209,4 +211,139 @@ var _ = Describe("ProcessBatch Worker", func() {
 			Expect(stageStats["current"]).To(BeEquivalentTo("1"))
 		})
 	})
+
+	Describe("stage status progress", func() {
+		It("should be published to the job progress channel", func() {
+			pubsub, err := redisClient.Subscribe(model.JobProgressChannel("job2"))
+			Expect(err).NotTo(HaveOccurred())
+			defer pubsub.Close()
+
+			ss, err := worker.NewStageStatus(redisClient, "job2", "1", "first stage", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss.IncrProgress()).To(Succeed())
+
+			for _, current := range []int{0, 1} {
+				msg, err := pubsub.ReceiveMessage()
+				Expect(err).NotTo(HaveOccurred())
+				var progress model.JobProgress
+				Expect(json.Unmarshal([]byte(msg.Payload), &progress)).To(Succeed())
+				Expect(progress.JobID).To(Equal("job2"))
+				Expect(progress.Counters).To(BeNil())
+				Expect(progress.Stages).To(HaveKey("1"))
+				Expect(progress.Stages["1"].Description).To(Equal("first stage"))
+				Expect(progress.Stages["1"].Current).To(Equal(current))
+				Expect(progress.Stages["1"].Max).To(Equal(2))
+				Expect(progress.Stages["1"].StartedAt).To(BeNumerically(">", 0))
+			}
+		})
+
+		It("should be read with GetJobStagesProgress", func() {
+			redisClient.Del("job3")
+			s1, err := worker.NewStageStatus(redisClient, "job3", "1", "stage 1", 2)
+			Expect(err).NotTo(HaveOccurred())
+			s1_1, err := s1.NewSubStage("stage 1.1", 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s1_1.IncrProgress()).To(Succeed())
+
+			stages, err := worker.GetJobStagesProgress(redisClient, "job3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stages).To(HaveLen(2))
+			Expect(stages["1"].Description).To(Equal("stage 1"))
+			Expect(stages["1"].Current).To(Equal(0))
+			Expect(stages["1"].Max).To(Equal(2))
+			Expect(stages["1.1"].Description).To(Equal("stage 1.1"))
+			Expect(stages["1.1"].Current).To(Equal(1))
+			Expect(stages["1.1"].Max).To(Equal(3))
+			Expect(stages["1.1"].StartedAt).To(BeNumerically(">", 0))
+			Expect(stages["1.1"].UpdatedAt).To(BeNumerically(">=", stages["1.1"].StartedAt))
+			Expect(stages["1.1"].CompletedAt).To(BeEquivalentTo(0))
+		})
+	})
+
+	Describe("shared stage status", func() {
+		It("should keep the progress of an existing stage", func() {
+			redisClient.Del("job4", "job4-2")
+			s1, err := worker.GetStageStatus(redisClient, "job4", "2", "create batches", 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s1.IncrProgress()).To(Succeed())
+
+			s2, err := worker.GetStageStatus(redisClient, "job4", "2", "create batches", 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s2.CurrentProgress).To(Equal(1))
+			Expect(s2.MaxProgress).To(Equal(3))
+			Expect(s2.StartedAt).To(Equal(s1.StartedAt))
+			Expect(s2.IncrProgressBy(2)).To(Succeed())
+			Expect(s2.Completed).To(BeTrue())
+
+			stageStats := redisClient.HGetAll("job4-2").Val()
+			Expect(stageStats["current"]).To(Equal("3"))
+			Expect(stageStats["completedAt"]).NotTo(BeEmpty())
+
+			s3, err := worker.GetStageStatus(redisClient, "job4", "2", "create batches", 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s3.Completed).To(BeTrue())
+			Expect(s3.IncrProgress()).NotTo(Succeed())
+		})
+
+		It("should create sub stages by number", func() {
+			redisClient.Del("job4", "job4-2", "job4-2.3")
+			s, err := worker.GetStageStatus(redisClient, "job4", "2", "create batches", 3)
+			Expect(err).NotTo(HaveOccurred())
+			sub, err := s.GetSubStage(3, "part 3", 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sub.Stage).To(Equal("2.3"))
+			Expect(redisClient.HGet("job4", "2.3").Val()).To(Equal("job4-2.3"))
+		})
+
+		It("should set absolute progress", func() {
+			redisClient.Del("job4", "job4-3")
+			s, err := worker.GetStageStatus(redisClient, "job4", "3", "send messages", 4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.SetProgress(2, 5)).To(Succeed())
+			Expect(s.SetProgress(2, 5)).To(Succeed())
+
+			stageStats := redisClient.HGetAll("job4-3").Val()
+			Expect(stageStats["current"]).To(Equal("2"))
+			Expect(stageStats["max"]).To(Equal("5"))
+			Expect(stageStats["completedAt"]).To(BeEmpty())
+			Expect(s.SetProgress(0, 0)).NotTo(Succeed())
+		})
+	})
+
+	Describe("stage tree", func() {
+		It("should nest sub stages and order them numerically", func() {
+			tree := model.BuildStageTree(map[string]model.StageProgress{
+				"2.10": {Description: "part 10", Current: 1, Max: 1},
+				"1":    {Description: "split csv", Current: 1, Max: 1},
+				"2":    {Description: "create batches", Current: 2, Max: 10},
+				"2.9":  {Description: "part 9", Current: 1, Max: 1},
+				"3.1":  {Description: "orphan", Current: 0, Max: 1},
+			})
+			Expect(tree).To(HaveLen(3))
+			Expect(tree[0].Stage).To(Equal("1"))
+			Expect(tree[1].Stage).To(Equal("2"))
+			Expect(tree[1].Percent).To(Equal(float64(20)))
+			Expect(tree[1].SubStages).To(HaveLen(2))
+			Expect(tree[1].SubStages[0].Stage).To(Equal("2.9"))
+			Expect(tree[1].SubStages[1].Stage).To(Equal("2.10"))
+			Expect(tree[2].Stage).To(Equal("3.1"))
+		})
+
+		It("should estimate the completion time from the elapsed time", func() {
+			node := model.NewStageNode("3", model.StageProgress{
+				Current: 25, Max: 100, StartedAt: 1000, UpdatedAt: 2000,
+			})
+			Expect(node.Percent).To(Equal(float64(25)))
+			Expect(node.ETA).To(BeEquivalentTo(5000))
+
+			node = model.NewStageNode("3", model.StageProgress{Current: 0, Max: 100, StartedAt: 1000, UpdatedAt: 1000})
+			Expect(node.ETA).To(BeEquivalentTo(0))
+
+			node = model.NewStageNode("3", model.StageProgress{
+				Current: 120, Max: 100, StartedAt: 1000, UpdatedAt: 2000, CompletedAt: 1500,
+			})
+			Expect(node.Percent).To(Equal(float64(100)))
+			Expect(node.ETA).To(BeEquivalentTo(1500))
+		})
+	})
 })
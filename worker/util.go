@@ -36,16 +36,16 @@ import (
 	// pg "gopkg.in/pg.v5"
 	"gopkg.in/redis.v5"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
 	raven "github.com/getsentry/raven-go"
 	uuid "github.com/satori/go.uuid"
 	"github.com/topfreegames/marathon/log"
 	"github.com/topfreegames/marathon/model"
+	"github.com/topfreegames/marathon/templating"
 	"github.com/uber-go/zap"
-	"github.com/valyala/fasttemplate"
 )
 
-const stoppedJobStatus = "stopped"
-
 // User is the struct that will keep users before sending them to send batches worker
 type User struct {
 	UserID string `json:"user_id,omitempty" sql:"user_id"`
@@ -53,6 +53,8 @@ type User struct {
 	Locale string `json:"locale,omitempty" sql:"locale"`
 	Region string `json:"region,omitempty" sql:"region"`
 	Tz     string `json:"tz,omitempty" sql:"tz"`
+	// Fields are the extra columns of the job CSV, available to the templates
+	Fields map[string]string `json:"fields,omitempty" sql:"-"`
 	// CreatedAt pg.NullTime `json:"created_at,omitempty" sql:"created_at"`
 	// Fiu       string      `json:"fiu,omitempty" sql:"fiu"`
 	// Adid      string      `json:"adid,omitempty" sql:"adid"`
@@ -128,13 +130,28 @@ func markProcessedPage(page int, jobID uuid.UUID, redisClient *redis.Client) {
 	redisClient.SAdd(fmt.Sprintf("%s-processedpages", jobID.String()), page)
 }
 
+// checkErr panics with err as a WorkerError, the ErrorMiddleware recovers it and retries the
+// message, fails its job or moves it to the dead letter queue according to the error kind
 func checkErr(l zap.Logger, err error) {
 	if err != nil {
-		raven.CaptureErrorAndWait(err, nil)
-		log.P(l, "Worker panic.", func(cm log.CM) {
-			cm.Write(zap.Error(err))
+		werr := asWorkerError(err)
+		raven.CaptureErrorAndWait(werr.Err, map[string]string{"kind": werr.Kind.String()})
+		log.E(l, "Worker error.", func(cm log.CM) {
+			cm.Write(zap.Error(werr.Err), zap.String("kind", werr.Kind.String()))
 		})
+		panic(werr)
+	}
+}
+
+// s3Error returns the S3 errors of missing CSV files as permanent errors, retrying won't make them appear
+func s3Error(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket:
+			return NewPermanentError(err)
+		}
 	}
+	return err
 }
 
 // GetWhereClauseFromFilters returns a string cointaining the where clause to use in the query
@@ -153,15 +170,23 @@ func GetWhereClauseFromFilters(filters map[string]interface{}) string {
 			connector = " AND "
 		}
 		strVal := val.(string)
+		column := fmt.Sprintf("\"%s\"", key)
+		// locales and regions are compared normalized, whatever their case in the push db. The push db
+		// tables need an index on this same expression, see docs/hosting.md, or the filter scans the table
+		// and the planner can't estimate the job audience
+		if key == "locale" || key == "region" {
+			column = fmt.Sprintf("lower(replace(%s, '_', '-'))", column)
+			strVal = model.NormalizeLocales(strVal)
+		}
 		if strings.Contains(strVal, ",") {
 			filterArray := []string{}
 			vals := strings.Split(strVal, ",")
 			for _, fVal := range vals {
-				filterArray = append(filterArray, fmt.Sprintf("\"%s\"%s'%s'", key, operator, fVal))
+				filterArray = append(filterArray, fmt.Sprintf("%s%s'%s'", column, operator, fVal))
 			}
 			queryFilters = append(queryFilters, fmt.Sprintf("(%s)", strings.Join(filterArray, connector)))
 		} else {
-			queryFilters = append(queryFilters, fmt.Sprintf("\"%s\"%s'%s'", key, operator, val))
+			queryFilters = append(queryFilters, fmt.Sprintf("%s%s'%s'", column, operator, strVal))
 		}
 	}
 	return strings.Join(queryFilters, " AND ")
@@ -187,12 +212,15 @@ type BatchWorkerMessage struct {
 	Users   []User
 }
 
-// TODO remove this hacky code
+// cleanUpUserInfo keeps the user fields the process batch worker uses to send and render the pushes
 func cleanUpUserInfo(user *User) *User {
 	return &User{
-		// UserID: user.UserID,
+		UserID: user.UserID,
 		Token:  user.Token,
 		Locale: user.Locale,
+		Region: user.Region,
+		Tz:     user.Tz,
+		Fields: user.Fields,
 	}
 }
 
@@ -204,7 +232,7 @@ func CompressUsers(users *[]User) (string, error) {
 	for idx, u := range *users {
 		cleanUsers[idx] = cleanUpUserInfo(&u)
 	}
-	usersBytes, err := json.Marshal(*users)
+	usersBytes, err := json.Marshal(cleanUsers)
 	if err != nil {
 		return "", err
 	}
@@ -228,7 +256,7 @@ func CompressUsers(users *[]User) (string, error) {
 func ParseProcessBatchWorkerMessageArray(arr []interface{}) (*BatchWorkerMessage, error) {
 	// arr is of the following format
 	// [jobId, appName, users]
-	// users is an array of jsons { user_id: uuid, token: string, locale: string } compressed with zlib
+	// users is an array of jsons { user_id: uuid, token: string, locale: string, region: string, tz: string, fields: {} } compressed with zlib
 	if len(arr) != 3 {
 		return nil, fmt.Errorf(InvalidMessageArray)
 	}
@@ -270,15 +298,32 @@ func ParseProcessBatchWorkerMessageArray(arr []interface{}) (*BatchWorkerMessage
 	return message, nil
 }
 
-// BuildMessageFromTemplate build a message using a template and the context
-func BuildMessageFromTemplate(template model.Template, context map[string]interface{}) (string, error) {
-	body, err := json.Marshal(template.Body)
+// BuildMessageFromTemplate build a message using a template, the context and the user
+// that will receive it, the user CSV fields override the context and the defaults
+func BuildMessageFromTemplate(template model.Template, context map[string]interface{}, user *User) (string, error) {
+	return templating.Render(template.Engine, template.Body, templateData(template, context, user))
+}
+
+// buildUserMessage builds the message of the user with the template of the first locale of its chain
+func buildUserMessage(job *model.Job, templatesByLocale map[string]model.Template, user *User) (map[string]interface{}, error) {
+	template, ok := job.App.SelectTemplate(templatesByLocale, user.Locale)
+	if !ok {
+		return nil, fmt.Errorf("there is no template for the locale %s or its fallbacks", user.Locale)
+	}
+	msgStr, err := BuildMessageFromTemplate(template, job.Context, user)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	bodyString := string(body)
-	t := fasttemplate.New(bodyString, "{{", "}}")
+	var msg map[string]interface{}
+	err = json.Unmarshal([]byte(msgStr), &msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
 
+// templateData returns the variables available to the template when it is built for the user
+func templateData(template model.Template, context map[string]interface{}, user *User) map[string]interface{} {
 	substitutions := make(map[string]interface{})
 	for k, v := range template.Defaults {
 		substitutions[k] = v
@@ -286,8 +331,30 @@ func BuildMessageFromTemplate(template model.Template, context map[string]interf
 	for k, v := range context {
 		substitutions[k] = v
 	}
-	message := t.ExecuteString(substitutions)
-	return message, nil
+	if user != nil {
+		for k, v := range user.Fields {
+			substitutions[k] = v
+		}
+		if template.Engine == templating.EngineRich {
+			substitutions["user"] = user.TemplateVariables()
+		}
+	}
+	return substitutions
+}
+
+// TemplateVariables returns the user fields available to the templates as .user
+func (u *User) TemplateVariables() map[string]interface{} {
+	fields := map[string]interface{}{}
+	for k, v := range u.Fields {
+		fields[k] = v
+	}
+	return map[string]interface{}{
+		"userId": u.UserID,
+		"locale": u.Locale,
+		"region": u.Region,
+		"tz":     u.Tz,
+		"fields": fields,
+	}
 }
 
 // RandomElementFromSlice gets a random element from a slice
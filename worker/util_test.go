@@ -94,7 +94,7 @@ var _ = Describe("Worker Util", func() {
 	Describe("Build message from template", func() {
 		It("should make correct substitutions using defaults", func() {
 			context := map[string]interface{}{}
-			msgString, err := worker.BuildMessageFromTemplate(template, context)
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil)
 			Expect(err).NotTo(HaveOccurred())
 			var msg map[string]interface{}
 			err = json.Unmarshal([]byte(msgString), &msg)
@@ -110,7 +110,7 @@ var _ = Describe("Worker Util", func() {
 				"user_name":   "Camila",
 				"object_name": "building",
 			}
-			msgString, err := worker.BuildMessageFromTemplate(template, context)
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil)
 			Expect(err).NotTo(HaveOccurred())
 			var msg map[string]interface{}
 			err = json.Unmarshal([]byte(msgString), &msg)
@@ -125,7 +125,7 @@ var _ = Describe("Worker Util", func() {
 			context := map[string]interface{}{
 				"user_name": "Camila",
 			}
-			msgString, err := worker.BuildMessageFromTemplate(template, context)
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil)
 			Expect(err).NotTo(HaveOccurred())
 			var msg map[string]interface{}
 			err = json.Unmarshal([]byte(msgString), &msg)
@@ -135,6 +135,52 @@ var _ = Describe("Worker Util", func() {
 			Expect(msg["alert"]).NotTo(ContainSubstring("{{user_name}}"))
 			Expect(msg["alert"]).NotTo(ContainSubstring("{{object_name}}"))
 		})
+
+		It("should keep the message valid if a value has quotes", func() {
+			context := map[string]interface{}{
+				"user_name": `Camila "the builder"`,
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, context, nil)
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal(`Camila "the builder" just liked your village!`))
+		})
+
+		It("should replace the template variables with the user fields", func() {
+			user := &worker.User{
+				UserID: "user-id",
+				Fields: map[string]string{"object_name": "castle"},
+			}
+			context := map[string]interface{}{
+				"user_name":   "Everyone",
+				"object_name": "house",
+			}
+			msgString, err := worker.BuildMessageFromTemplate(template, context, user)
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal("Everyone just liked your castle!"))
+		})
+
+		It("should render rich templates with the user variables", func() {
+			template.Engine = "rich"
+			template.Body = map[string]interface{}{
+				"alert": "{{ .user_name | upper }} just liked your {{ .object_name }} in {{ .user.region }}!",
+			}
+			user := &worker.User{UserID: "user-id", Locale: "en", Region: "br"}
+			msgString, err := worker.BuildMessageFromTemplate(template, map[string]interface{}{}, user)
+			Expect(err).NotTo(HaveOccurred())
+			var msg map[string]interface{}
+			err = json.Unmarshal([]byte(msgString), &msg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(msg["alert"]).To(Equal("SOMEONE just liked your village in br!"))
+		})
 	})
 
 	Describe("Parse ProcessBatchWorker message array", func() {
@@ -215,7 +261,7 @@ var _ = Describe("Worker Util", func() {
 				"region": "US",
 			}
 			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("\"region\"='US'"))
+			Expect(where).To(Equal("lower(replace(\"region\", '_', '-'))='us'"))
 		})
 
 		It("should succeedd with one comma separated filter", func() {
@@ -223,7 +269,7 @@ var _ = Describe("Worker Util", func() {
 				"region": "US,CA",
 			}
 			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("(\"region\"='US' OR \"region\"='CA')"))
+			Expect(where).To(Equal("(lower(replace(\"region\", '_', '-'))='us' OR lower(replace(\"region\", '_', '-'))='ca')"))
 		})
 
 		It("should succeed with one negative simple filter", func() {
@@ -231,7 +277,7 @@ var _ = Describe("Worker Util", func() {
 				"NOTregion": "US",
 			}
 			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("\"region\"!='US'"))
+			Expect(where).To(Equal("lower(replace(\"region\", '_', '-'))!='us'"))
 		})
 
 		It("should succeed with one negative comma separated filter", func() {
@@ -239,7 +285,7 @@ var _ = Describe("Worker Util", func() {
 				"NOTregion": "US,CA",
 			}
 			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(Equal("(\"region\"!='US' AND \"region\"!='CA')"))
+			Expect(where).To(Equal("(lower(replace(\"region\", '_', '-'))!='us' AND lower(replace(\"region\", '_', '-'))!='ca')"))
 		})
 
 		It("should succeed with multiple filters", func() {
@@ -248,8 +294,8 @@ var _ = Describe("Worker Util", func() {
 				"locale":    "en,fr",
 			}
 			where := worker.GetWhereClauseFromFilters(filters)
-			Expect(where).To(ContainSubstring("(\"locale\"='en' OR \"locale\"='fr')"))
-			Expect(where).To(ContainSubstring("(\"region\"!='US' AND \"region\"!='CA')"))
+			Expect(where).To(ContainSubstring("(lower(replace(\"locale\", '_', '-'))='en' OR lower(replace(\"locale\", '_', '-'))='fr')"))
+			Expect(where).To(ContainSubstring("(lower(replace(\"region\", '_', '-'))!='us' AND lower(replace(\"region\", '_', '-'))!='ca')"))
 			Expect(where).To(ContainSubstring(") AND ("))
 		})
 	})
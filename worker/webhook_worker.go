@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jrallison/go-workers"
+	"github.com/satori/go.uuid"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/model"
+	"github.com/uber-go/zap"
+	pg "gopkg.in/pg.v5"
+)
+
+const nameWebhookWorker = "webhook_worker"
+
+// webhookMaxResponseSize is the most of the webhook response that is read before closing it
+const webhookMaxResponseSize = 64 * 1024
+
+// WebhookWorker is the WebhookWorker struct, it posts the deliveries to the webhooks
+type WebhookWorker struct {
+	Workers *Worker
+	Logger  zap.Logger
+	Client  *http.Client
+}
+
+// NewWebhookWorker gets a new WebhookWorker
+func NewWebhookWorker(workers *Worker) *WebhookWorker {
+	b := &WebhookWorker{
+		Logger:  workers.Logger.With(zap.String("worker", "WebhookWorker")),
+		Workers: workers,
+		Client: &http.Client{
+			Timeout: workers.Config.GetDuration("workers.webhook.timeout"),
+		},
+	}
+	b.Logger.Debug("Configured WebhookWorker successfully.")
+	return b
+}
+
+// Process processes the messages sent to worker queue, failed deliveries panic so
+// go-workers retries them with backoff until workers.webhook.maxRetries
+func (b *WebhookWorker) Process(message *workers.Msg) {
+	arr, err := message.Args().Array()
+	checkErr(b.Logger, NewPoisonError(err))
+	id, err := uuid.FromString(arr[0].(string))
+	checkErr(b.Logger, NewPoisonError(err))
+	l := b.Logger.With(
+		zap.String("deliveryID", id.String()),
+		zap.String("worker", nameWebhookWorker),
+	)
+	log.I(l, "starting")
+
+	delivery := &model.WebhookDelivery{ID: id}
+	err = b.Workers.MarathonDB.Select(delivery)
+	if err == pg.ErrNoRows {
+		log.I(l, "delivery not found, its webhook was deleted")
+		return
+	}
+	checkErr(l, err)
+	webhook := &model.Webhook{ID: delivery.WebhookID}
+	err = b.Workers.MarathonDB.Select(webhook)
+	checkErr(l, err)
+
+	maxRetries := b.Workers.Config.GetInt("workers.webhook.maxRetries")
+	if webhook.Enabled {
+		delivery.ResponseStatus, err = b.deliver(webhook, delivery)
+	} else {
+		delivery.ResponseStatus, err = 0, fmt.Errorf("webhook is disabled")
+		maxRetries = 0
+	}
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now().UnixNano()
+	switch {
+	case err == nil:
+		delivery.Status = model.WebhookDeliverySuccess
+		delivery.Error = ""
+		delivery.DeliveredAt = delivery.UpdatedAt
+	case delivery.Attempts > maxRetries:
+		delivery.Status = model.WebhookDeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = model.WebhookDeliveryRetrying
+		delivery.Error = err.Error()
+	}
+	_, updateErr := b.Workers.MarathonDB.Model(delivery).
+		Column("status", "attempts", "response_status", "error", "updated_at", "delivered_at").
+		Update()
+	checkErr(l, updateErr)
+
+	if delivery.Status == model.WebhookDeliveryRetrying {
+		checkErr(l, err)
+	}
+	log.I(l, "finished", func(cm log.CM) {
+		cm.Write(zap.String("status", delivery.Status), zap.Int("attempts", delivery.Attempts))
+	})
+}
+
+// deliver posts the payload of the delivery to the webhook and returns the response status,
+// any status other than 2xx is an error
+func (b *WebhookWorker) deliver(webhook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "marathon-webhooks")
+	req.Header.Set("X-Marathon-Event", delivery.Event)
+	req.Header.Set("X-Marathon-Delivery", delivery.ID.String())
+	req.Header.Set("X-Marathon-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Marathon-Signature", webhook.Sign(timestamp, body))
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, webhookMaxResponseSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// NotifyJobEvent creates a delivery of the event to every enabled webhook of the job app that
+// subscribes to it, the started event is only sent once per job. Errors are logged and never
+// fail the caller, stage and message describe the failed stage of failed events
+func (w *Worker) NotifyJobEvent(job *model.Job, event, stage, message string) {
+	l := w.Logger.With(
+		zap.String("source", "webhooks"),
+		zap.String("operation", "notifyJobEvent"),
+		zap.String("jobID", job.ID.String()),
+		zap.String("event", event),
+	)
+	if event == model.WebhookEventStarted && w.RedisClient != nil {
+		first, err := w.RedisClient.SetNX(fmt.Sprintf("%s-webhook-started", job.ID.String()), 1, 7*24*time.Hour).Result()
+		if err != nil || !first {
+			return
+		}
+	}
+
+	webhooks := []model.Webhook{}
+	err := w.MarathonDB.Model(&webhooks).Where("app_id = ? AND enabled = TRUE AND ? = ANY(events)", job.AppID, event).Select()
+	if err != nil {
+		log.E(l, "Failed to get webhooks.", func(cm log.CM) {
+			cm.Write(zap.Error(err))
+		})
+		return
+	}
+	for _, webhook := range webhooks {
+		now := time.Now().UnixNano()
+		delivery := &model.WebhookDelivery{
+			ID:        uuid.NewV4(),
+			WebhookID: webhook.ID,
+			AppID:     job.AppID,
+			JobID:     job.ID,
+			Event:     event,
+			Status:    model.WebhookDeliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		delivery.Payload = model.NewWebhookPayload(delivery.ID, event, job, stage, message)
+		err := w.MarathonDB.Insert(delivery)
+		if err == nil {
+			_, err = w.CreateWebhookJob(delivery.ID)
+		}
+		if err != nil {
+			log.E(l, "Failed to create webhook delivery.", func(cm log.CM) {
+				cm.Write(zap.String("webhookID", webhook.ID.String()), zap.Error(err))
+			})
+		}
+	}
+}
+
+// CreateWebhookJob creates a new WebhookWorker job
+func (w *Worker) CreateWebhookJob(deliveryID uuid.UUID) (string, error) {
+	maxRetries := w.Config.GetInt("workers.webhook.maxRetries")
+	return workers.EnqueueWithOptions(nameWebhookWorker, "Add", []interface{}{deliveryID.String()}, workers.EnqueueOptions{
+		Retry:      true,
+		RetryCount: maxRetries,
+	})
+}
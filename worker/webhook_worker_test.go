@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2016 TFG Co <backend@tfgco.com>
+ * Author: TFG Co <backend@tfgco.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package worker_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	workers "github.com/jrallison/go-workers"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/topfreegames/marathon/model"
+	. "github.com/topfreegames/marathon/testing"
+	"github.com/topfreegames/marathon/worker"
+	"github.com/uber-go/zap"
+)
+
+var _ = Describe("Webhook Worker", func() {
+	var webhookWorker *worker.WebhookWorker
+	var app *model.App
+	var job *model.Job
+	var server *httptest.Server
+	var requests []*http.Request
+	var bodies [][]byte
+	var responseStatus int
+
+	logger := zap.New(
+		zap.NewJSONEncoder(zap.NoTime()), // drop timestamps in tests
+		zap.FatalLevel,
+	)
+	w := worker.NewWorker(logger, GetConfPath())
+
+	BeforeEach(func() {
+		w.RedisClient.FlushAll()
+		webhookWorker = worker.NewWebhookWorker(w)
+		requests = []*http.Request{}
+		bodies = [][]byte{}
+		responseStatus = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+			rw.WriteHeader(responseStatus)
+		}))
+
+		app = CreateTestApp(w.MarathonDB)
+		template := CreateTestTemplate(w.MarathonDB, app.ID)
+		job = CreateTestJob(w.MarathonDB, app.ID, template.Name)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	deliveries := func(webhook *model.Webhook) []model.WebhookDelivery {
+		result := []model.WebhookDelivery{}
+		err := w.MarathonDB.Model(&result).Where("webhook_id = ?", webhook.ID).Order("created_at ASC").Select()
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	process := func(delivery model.WebhookDelivery) {
+		msgB, err := json.Marshal(map[string][]interface{}{
+			"args": []interface{}{delivery.ID.String()},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		message, err := workers.NewMsg(string(msgB))
+		Expect(err).NotTo(HaveOccurred())
+		webhookWorker.Process(message)
+	}
+
+	Describe("NotifyJobEvent", func() {
+		It("should create and enqueue a delivery for each enabled webhook subscribed to the event", func() {
+			subscribed := CreateTestWebhook(w.MarathonDB, app.ID, map[string]interface{}{
+				"events": []string{model.WebhookEventFailed},
+			})
+			other := CreateTestWebhook(w.MarathonDB, app.ID, map[string]interface{}{
+				"events": []string{model.WebhookEventCompleted},
+			})
+			disabled := CreateTestWebhook(w.MarathonDB, app.ID, map[string]interface{}{
+				"events":  []string{model.WebhookEventFailed},
+				"enabled": false,
+			})
+
+			w.NotifyJobEvent(job, model.WebhookEventFailed, "csv_split_worker", "some error")
+
+			Expect(deliveries(other)).To(BeEmpty())
+			Expect(deliveries(disabled)).To(BeEmpty())
+			result := deliveries(subscribed)
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].Event).To(Equal(model.WebhookEventFailed))
+			Expect(result[0].JobID).To(Equal(job.ID))
+			Expect(result[0].Status).To(Equal(model.WebhookDeliveryPending))
+			Expect(result[0].Payload["stage"]).To(Equal(map[string]interface{}{
+				"name":    "csv_split_worker",
+				"message": "some error",
+			}))
+
+			queued, err := w.RedisClient.LLen("queue:webhook_worker").Result()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(queued).To(BeEquivalentTo(1))
+		})
+
+		It("should send the started event only once per job", func() {
+			webhook := CreateTestWebhook(w.MarathonDB, app.ID)
+			w.NotifyJobEvent(job, model.WebhookEventStarted, "", "")
+			w.NotifyJobEvent(job, model.WebhookEventStarted, "", "")
+			Expect(deliveries(webhook)).To(HaveLen(1))
+		})
+	})
+
+	Describe("Process", func() {
+		It("should post the signed payload and mark the delivery as successful", func() {
+			webhook := CreateTestWebhook(w.MarathonDB, app.ID, map[string]interface{}{"url": server.URL})
+			w.NotifyJobEvent(job, model.WebhookEventCompleted, "", "")
+			delivery := deliveries(webhook)[0]
+
+			Expect(func() { process(delivery) }).NotTo(Panic())
+			Expect(requests).To(HaveLen(1))
+			req := requests[0]
+			Expect(req.Method).To(Equal(http.MethodPost))
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(req.Header.Get("X-Marathon-Event")).To(Equal(model.WebhookEventCompleted))
+			Expect(req.Header.Get("X-Marathon-Delivery")).To(Equal(delivery.ID.String()))
+
+			mac := hmac.New(sha256.New, []byte(webhook.Secret))
+			mac.Write([]byte(req.Header.Get("X-Marathon-Timestamp") + "."))
+			mac.Write(bodies[0])
+			Expect(req.Header.Get("X-Marathon-Signature")).To(Equal("sha256=" + hex.EncodeToString(mac.Sum(nil))))
+
+			var payload map[string]interface{}
+			err := json.Unmarshal(bodies[0], &payload)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(payload["event"]).To(Equal(model.WebhookEventCompleted))
+			Expect(payload["job"].(map[string]interface{})["id"]).To(Equal(job.ID.String()))
+
+			delivery = deliveries(webhook)[0]
+			Expect(delivery.Status).To(Equal(model.WebhookDeliverySuccess))
+			Expect(delivery.Attempts).To(Equal(1))
+			Expect(delivery.ResponseStatus).To(Equal(http.StatusOK))
+			Expect(delivery.DeliveredAt).NotTo(BeZero())
+		})
+
+		It("should panic to be retried while there are retries left and then mark the delivery as failed", func() {
+			w.Config.Set("workers.webhook.maxRetries", 1)
+			defer w.Config.Set("workers.webhook.maxRetries", 8)
+			responseStatus = http.StatusInternalServerError
+			webhook := CreateTestWebhook(w.MarathonDB, app.ID, map[string]interface{}{"url": server.URL})
+			w.NotifyJobEvent(job, model.WebhookEventCompleted, "", "")
+			delivery := deliveries(webhook)[0]
+
+			Expect(func() { process(delivery) }).To(Panic())
+			delivery = deliveries(webhook)[0]
+			Expect(delivery.Status).To(Equal(model.WebhookDeliveryRetrying))
+			Expect(delivery.Attempts).To(Equal(1))
+			Expect(delivery.ResponseStatus).To(Equal(http.StatusInternalServerError))
+			Expect(delivery.Error).To(ContainSubstring("500"))
+
+			Expect(func() { process(delivery) }).NotTo(Panic())
+			delivery = deliveries(webhook)[0]
+			Expect(delivery.Status).To(Equal(model.WebhookDeliveryFailed))
+			Expect(delivery.Attempts).To(Equal(2))
+			Expect(requests).To(HaveLen(2))
+		})
+
+		It("should not post to disabled webhooks", func() {
+			webhook := CreateTestWebhook(w.MarathonDB, app.ID, map[string]interface{}{"url": server.URL})
+			w.NotifyJobEvent(job, model.WebhookEventCompleted, "", "")
+			_, err := w.MarathonDB.Exec("UPDATE webhooks SET enabled = FALSE WHERE id = ?", webhook.ID)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(func() { process(deliveries(webhook)[0]) }).NotTo(Panic())
+			Expect(requests).To(BeEmpty())
+			Expect(deliveries(webhook)[0].Status).To(Equal(model.WebhookDeliveryFailed))
+		})
+	})
+})
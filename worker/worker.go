@@ -23,10 +23,12 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,10 +37,14 @@ import (
 	"github.com/jrallison/go-workers"
 	"github.com/satori/go.uuid"
 	"github.com/spf13/viper"
+	"github.com/topfreegames/marathon/email"
 	"github.com/topfreegames/marathon/extensions"
 	"github.com/topfreegames/marathon/interfaces"
+	"github.com/topfreegames/marathon/log"
+	"github.com/topfreegames/marathon/messages"
 	"github.com/topfreegames/marathon/model"
 	"github.com/uber-go/zap"
+	pg "gopkg.in/pg.v5"
 	redis "gopkg.in/redis.v5"
 )
 
@@ -55,7 +61,17 @@ type Worker struct {
 	RedisClient               *redis.Client
 	ConfigPath                string
 	SendgridClient            *extensions.SendgridClient
-	Kafka                     interfaces.PushProducer
+	Producer                  interfaces.PushProducer
+	// DeliveryReporter accounts the delivery failures of the kafka producer
+	DeliveryReporter *DeliveryReporter
+	// ProcessID identifies this worker process in the go-workers in progress queues
+	ProcessID string
+	// InFlight tracks the Process calls in progress for the graceful shutdown
+	InFlight *InFlightMiddleware
+	// Errors retries, dead-letters or fails the job of the messages whose Process call failed
+	Errors *ErrorMiddleware
+
+	stop chan struct{}
 }
 
 // NewWorker returns a configured worker
@@ -92,7 +108,7 @@ func (w *Worker) configure() {
 	w.configureMarathonDatabase()
 	w.configureS3Client()
 	w.configureSendgrid()
-	w.configureKafkaProducer()
+	w.configurePushProducer()
 }
 
 func (w *Worker) loadConfigurationDefaults() {
@@ -104,6 +120,18 @@ func (w *Worker) loadConfigurationDefaults() {
 	w.Config.SetDefault("database.url", "postgres://localhost:5432/marathon?sslmode=disable")
 	w.Config.SetDefault("workers.statsd.host", "127.0.0.1:8125")
 	w.Config.SetDefault("workers.statsd.prefix", "marathon.")
+	w.Config.SetDefault("workers.webhook.concurrency", 5)
+	w.Config.SetDefault("workers.webhook.maxRetries", 8)
+	w.Config.SetDefault("workers.webhook.timeout", "10s")
+	w.Config.SetDefault("workers.deliveryReporter.flushInterval", "5s")
+	w.Config.SetDefault("workers.shutdownTimeout", "30s")
+	w.Config.SetDefault("workers.recovery.interval", "5m")
+	w.Config.SetDefault("workers.recovery.heartbeat", "30s")
+	w.Config.SetDefault("workers.recovery.stuckAfter", "30m")
+	w.Config.SetDefault("workers.processBatch.maxRetries", 5)
+	w.Config.SetDefault("workers.processBatch.checkpointInterval", 100)
+	w.Config.SetDefault("workers.checkpointExpiration", "24h")
+	w.Config.SetDefault("workers.deadLetter.maxLength", 10000)
 }
 
 func (w *Worker) configureSendgrid() {
@@ -158,12 +186,13 @@ func (w *Worker) configureRedis() {
 	if err != nil {
 		panic(err)
 	}
+	w.ProcessID = hostname
 
 	workers.Configure(map[string]string{
 		"server":   fmt.Sprintf("%s:%d", redisHost, redisPort),
 		"database": redisDatabase,
 		"pool":     redisPoolsize,
-		"process":  hostname,
+		"process":  w.ProcessID,
 		"password": redisPassword,
 	})
 	r, err := extensions.NewRedis("workers", w.Config, w.Logger)
@@ -178,12 +207,15 @@ func (w *Worker) configureS3Client() {
 }
 
 func (w *Worker) configureWorkers() {
+	w.InFlight = NewInFlightMiddleware()
+	w.Errors = NewErrorMiddleware(w)
 	p := NewProcessBatchWorker(w)
 	k := NewCSVSplitWorker(w)
 	c := NewCreateBatchesWorker(w)
 	r := NewResumeJobWorker(w)
 	j := NewJobCompletedWorker(w)
 	directWorker := NewDirectWorker(w)
+	webhookWorker := NewWebhookWorker(w)
 
 	createCSVSplitWorkerConcurrency := w.Config.GetInt("workers.csvSplitWorker.concurrency")
 	processBatchWorkerConcurrency := w.Config.GetInt("workers.processBatch.concurrency")
@@ -192,6 +224,7 @@ func (w *Worker) configureWorkers() {
 	createBatchesWorkerConcurrency := w.Config.GetInt("workers.createBatches.concurrency")
 
 	jobDirectWorkerConcurrency := w.Config.GetInt("workers.direct.concurrency")
+	webhookWorkerConcurrency := w.Config.GetInt("workers.webhook.concurrency")
 
 	workers.Process("csv_split_worker", k.Process, createCSVSplitWorkerConcurrency)
 	workers.Process("create_batches_worker", c.Process, createBatchesWorkerConcurrency)
@@ -200,6 +233,7 @@ func (w *Worker) configureWorkers() {
 	workers.Process("job_completed_worker", j.Process, jobCompletedWorkerConcurrency)
 
 	workers.Process("direct_worker", directWorker.Process, jobDirectWorkerConcurrency)
+	workers.Process(nameWebhookWorker, webhookWorker.Process, webhookWorkerConcurrency)
 }
 
 func (w *Worker) configureSentry() {
@@ -212,12 +246,15 @@ func (w *Worker) configureSentry() {
 	l.Info("Configured sentry successfully.")
 }
 
-func (w *Worker) configureKafkaProducer() {
-	var kafka *extensions.KafkaProducer
-	var err error
-	kafka, err = extensions.NewKafkaProducer(w.Config, w.Logger, w.Statsd)
+func (w *Worker) configurePushProducer() {
+	producer, err := extensions.NewPushProducer(w.Config, w.Logger, w.Statsd)
 	checkErr(w.Logger, err)
-	w.Kafka = kafka
+	if kafka, ok := producer.(*extensions.KafkaProducer); ok {
+		w.DeliveryReporter = NewDeliveryReporter(w)
+		w.DeliveryReporter.Start()
+		kafka.DeliveryReporter = w.DeliveryReporter
+	}
+	w.Producer = producer
 }
 
 // CreateCSVSplitJob creates a new CSVSplitWorker job
@@ -279,7 +316,6 @@ func (w *Worker) createDirectBatchesJobWithOption(job *model.Job, options worker
 	var testBatchSize uint64
 	var maxSeqID uint64
 	var rownsEstimative uint64
-	var i uint64
 
 	job.GetJobInfoAndApp(w.MarathonDB)
 	tableName := GetPushDBTableName(job.App.Name, job.Service)
@@ -298,17 +334,20 @@ func (w *Worker) createDirectBatchesJobWithOption(job *model.Job, options worker
 	}
 	testBatchSize = (200000 * maxSeqID) / rownsEstimative
 
-	for i = 0; i < maxSeqID+1; {
-		_, err = workers.EnqueueWithOptions("direct_worker", "Add",
-			DirectPartMsg{
-				SmallestSeqID: i,
-				BiggestSeqID:  i + testBatchSize,
-				JobUUID:       job.ID,
-			}, options)
+	// the recovery of stuck jobs enqueues again the pages that were not sent
+	err = w.RedisClient.HMSet(directPagesKey(job.ID), map[string]string{
+		"size":     strconv.FormatUint(testBatchSize, 10),
+		"maxSeqID": strconv.FormatUint(maxSeqID, 10),
+	}).Err()
+	if err != nil {
+		return err
+	}
+	pages := directPages(job.ID, testBatchSize, maxSeqID)
+	for _, page := range pages {
+		_, err = workers.EnqueueWithOptions("direct_worker", "Add", page, options)
 		if err != nil {
 			return err
 		}
-		i += testBatchSize
 	}
 
 	_, err = w.MarathonDB.Model(job).Set("total_tokens = ?", rownsEstimative).Where("id = ?", job.ID).Update()
@@ -316,8 +355,7 @@ func (w *Worker) createDirectBatchesJobWithOption(job *model.Job, options worker
 		return err
 	}
 
-	batches := i / testBatchSize
-	_, err = w.MarathonDB.Model(job).Set("total_batches = ?", batches).Where("id = ?", job.ID).Update()
+	_, err = w.MarathonDB.Model(job).Set("total_batches = ?", len(pages)).Where("id = ?", job.ID).Update()
 	if err != nil {
 		return err
 	}
@@ -331,11 +369,15 @@ func (w *Worker) CreateProcessBatchJob(jobID string, appName string, users *[]Us
 	if err != nil {
 		return "", err
 	}
-	return workers.Enqueue(
+	maxRetries := w.Config.GetInt("workers.processBatch.maxRetries")
+	return workers.EnqueueWithOptions(
 		"process_batch_worker",
 		"Add",
 		[]interface{}{jobID, appName, compressedUsers},
-	)
+		workers.EnqueueOptions{
+			Retry:      true,
+			RetryCount: maxRetries,
+		})
 }
 
 // CreateResumeJob creates a new ResumeJobWorker job
@@ -377,12 +419,15 @@ func (w *Worker) ScheduleProcessBatchJob(jobID string, appName string, users *[]
 	if err != nil {
 		return "", err
 	}
+	maxRetries := w.Config.GetInt("workers.processBatch.maxRetries")
 	return workers.EnqueueWithOptions(
 		"process_batch_worker",
 		"Add",
 		[]interface{}{jobID, appName, compressedUsers},
 		workers.EnqueueOptions{
-			At: float64(at) / workers.NanoSecondPrecision,
+			Retry:      true,
+			RetryCount: maxRetries,
+			At:         float64(at) / workers.NanoSecondPrecision,
 		})
 }
 
@@ -429,7 +474,12 @@ func (w *Worker) Start() {
 			panic(err)
 		}
 	}()
-	workers.Run()
+	workers.Middleware.Append(w.InFlight)
+	workers.Middleware.Append(w.Errors)
+	workers.Start()
+	w.startBackgroundTasks()
+	w.waitForSignal()
+	w.Shutdown()
 }
 
 // SendControlGroupToRedis send a sequency of users ids to redis
@@ -450,5 +500,155 @@ func (w *Worker) GetJob(jobID uuid.UUID) (*model.Job, error) {
 		ID: jobID,
 	}
 	err := job.GetJobInfoAndApp(w.MarathonDB)
+	if err == pg.ErrNoRows {
+		// the messages of deleted jobs can't ever be processed
+		err = NewPoisonError(fmt.Errorf("job %s does not exist", jobID.String()))
+	}
 	return &job, err
 }
+
+// GetTopic returns the topic the messages of the given app and service are sent to,
+// workers.topicTemplates.<service> has precedence over workers.topicTemplate
+func (w *Worker) GetTopic(appName, service string) string {
+	topicTemplate := w.Config.GetString(fmt.Sprintf("workers.topicTemplates.%s", service))
+	if topicTemplate == "" {
+		topicTemplate = w.Config.GetString("workers.topicTemplate")
+	}
+	return BuildTopicName(appName, service, topicTemplate)
+}
+
+// SendPush builds the message of one user and sends it with the push producer
+func (w *Worker) SendPush(job *model.Job, topic, token string, payload, pushMetadata map[string]interface{}, templateName string, options *messages.PushOptions, delivery *messages.DeliveryInfo) error {
+	return w.Producer.Send(context.Background(), &messages.PushMessage{
+		Topic:           topic,
+		Service:         job.Service,
+		Token:           token,
+		Payload:         payload,
+		MessageMetadata: job.Metadata,
+		PushMetadata:    pushMetadata,
+		PushExpiry:      job.ExpiresAt / 1000000000, // convert from nanoseconds to seconds
+		TemplateName:    templateName,
+		Options:         options,
+		Delivery:        delivery,
+	})
+}
+
+// IncrFailedBatches counts a failed batch of the job and sets the job status to
+// circuitbreak when the failed batches reach workers.processBatch.maxBatchFailure
+func (w *Worker) IncrFailedBatches(jobID uuid.UUID, totalBatches int, appName string) error {
+	failedJobs, err := w.RedisClient.Incr(fmt.Sprintf("%s-failedbatches", jobID.String())).Result()
+	if err != nil {
+		return err
+	}
+	ttl, err := w.RedisClient.TTL(fmt.Sprintf("%s-failedbatches", jobID.String())).Result()
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		w.RedisClient.Expire(fmt.Sprintf("%s-failedbatches", jobID.String()), 7*24*time.Hour)
+	}
+	if float64(failedJobs)/float64(totalBatches) >= w.Config.GetFloat64("workers.processBatch.maxBatchFailure") {
+		job := model.Job{ID: jobID}
+		err := model.TransitionJob(w.MarathonDB, &job, model.JobStatusCircuitBreak, "too many failed batches")
+		if model.IsInvalidJobTransition(err) {
+			if job.Status != model.JobStatusCircuitBreak {
+				return nil
+			}
+			// the job is already circuit broken, it is loaded to notify about it again after the notification expires
+			err = w.MarathonDB.Model(&job).Where("id = ?", jobID).Select()
+		}
+		if err != nil {
+			return err
+		}
+		changedStatus, err := w.RedisClient.SetNX(fmt.Sprintf("%s-circuitbreak", jobID.String()), 1, 1*time.Minute).Result()
+		if err != nil {
+			return err
+		}
+		if changedStatus && w.SendgridClient != nil {
+			var expireAt int64
+			if ttl > 0 {
+				expireAt = time.Now().Add(ttl).UnixNano()
+			} else {
+				expireAt = time.Now().Add(7 * 24 * time.Hour).UnixNano()
+			}
+			email.SendCircuitBreakJobEmail(w.SendgridClient, &job, appName, expireAt)
+		}
+		if changedStatus {
+			w.NotifyJobEvent(&job, model.WebhookEventCircuitBreak, "", "")
+			w.PublishJobProgress(model.NewJobCountersProgress(&job))
+		}
+	}
+	return nil
+}
+
+// TransitionJob changes the status of the job through the job state machine and returns true if it changed,
+// transitions the state machine does not allow are ignored and errors are logged and never fail the caller
+func (w *Worker) TransitionJob(job *model.Job, to, message string) bool {
+	if job.Status == to {
+		return false
+	}
+	err := model.TransitionJob(w.MarathonDB, job, to, message)
+	if err != nil {
+		if model.IsInvalidJobTransition(err) {
+			log.I(w.Logger, "Ignored job status transition.", func(cm log.CM) {
+				cm.Write(zap.String("jobID", job.ID.String()), zap.String("reason", err.Error()))
+			})
+			return false
+		}
+		log.E(w.Logger, "Failed to change job status.", func(cm log.CM) {
+			cm.Write(zap.String("jobID", job.ID.String()), zap.String("status", to), zap.Error(err))
+		})
+		return false
+	}
+	return true
+}
+
+// batchFailuresKey is the number of failed messages of the batch of the delivery
+func batchFailuresKey(delivery *messages.DeliveryInfo) string {
+	return fmt.Sprintf("%s-%s-batchfailures", delivery.JobID, delivery.BatchID)
+}
+
+// IncrBatchFailures adds n failed messages to the batch of the delivery and returns
+// true only when this call makes the batch go over workers.processBatch.maxUserFailureInBatch
+func (w *Worker) IncrBatchFailures(delivery *messages.DeliveryInfo, n int) (bool, error) {
+	if delivery == nil || delivery.BatchSize == 0 {
+		return false, nil
+	}
+	key := batchFailuresKey(delivery)
+	failures, err := w.RedisClient.IncrBy(key, int64(n)).Result()
+	if err != nil {
+		return false, err
+	}
+	if failures == int64(n) {
+		w.RedisClient.Expire(key, 7*24*time.Hour)
+	}
+	return w.batchFailedWith(delivery, failures, n), nil
+}
+
+// batchFailedWith returns true if the last n of the failures of the batch of the delivery made
+// it go over workers.processBatch.maxUserFailureInBatch
+func (w *Worker) batchFailedWith(delivery *messages.DeliveryInfo, failures int64, n int) bool {
+	maxFailure := w.Config.GetFloat64("workers.processBatch.maxUserFailureInBatch")
+	before := float64(failures-int64(n)) / float64(delivery.BatchSize)
+	after := float64(failures) / float64(delivery.BatchSize)
+	return before <= maxFailure && after > maxFailure
+}
+
+// CountBatch adds a completed batch with its sent and failed tokens to the job counters in a single
+// statement that records the batch id, so a retry of the message doesn't count the batch again. It
+// returns false when the batch was already counted, the job is loaded with its counters either way
+func (w *Worker) CountBatch(job *model.Job, batchID string, completed, failed int) (bool, error) {
+	_, err := w.MarathonDB.QueryOne(job, `WITH batch AS (
+			INSERT INTO job_batches (job_id, batch_id, created_at) VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING RETURNING job_id
+		)
+		UPDATE jobs SET completed_batches = completed_batches + 1,
+			completed_tokens = completed_tokens + ?, failed_tokens = failed_tokens + ?
+		FROM batch WHERE jobs.id = batch.job_id RETURNING jobs.*`,
+		job.ID, batchID, time.Now().UnixNano(), completed, failed,
+	)
+	if err == pg.ErrNoRows {
+		return false, w.MarathonDB.Model(job).Where("id = ?", job.ID).Select()
+	}
+	return err == nil, err
+}